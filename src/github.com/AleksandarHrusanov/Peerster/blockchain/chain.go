@@ -0,0 +1,371 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/core"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+)
+
+// ErrNameRootMismatch - returned for blocks whose name root is not the one of the name table
+// resulting from applying them on top of their parent
+var ErrNameRootMismatch = errors.New("name root does not match the name table")
+
+// ErrTooManyOrphans - returned for blocks whose parent is unknown when too many blocks of their
+// origin, or in total, already wait for theirs
+var ErrTooManyOrphans = errors.New("too many blocks waiting for their parent")
+
+// AddConfirmedBlock - inserts the block of a confirmed TLC message into the block tree and moves
+// the canonical chain to the best branch, reorganising the name table if the branch changes
+func AddConfirmedBlock(gossiper *core.Gossiper, tlc *core.TLCMessage) {
+	// the Confirmed field of a confirmed TLC holds the ID of the original unconfirmed message
+	newBlock := &core.ChainBlock{Block: tlc.TxBlock, Header: tlc.TxBlock.Header(), Hash: tlc.TxBlock.Hash(),
+		Origin: tlc.Origin, TLCID: uint32(tlc.Confirmed)}
+	// the fitness sent along with the message cannot be verified, so it is derived from the block instead
+	newBlock.Fitness = BlockFitness(newBlock.Hash)
+	addBlock(gossiper, newBlock)
+}
+
+// inserts a block into the block tree, or keeps it aside until its parent is known, and moves the
+// canonical chain to the best branch. Returns false if the block was already known or was rejected.
+// The transactions of the blocks the canonical chain lost go back to the miners in proof-of-work
+// mode; in TLC mode, the node publishes its own again
+func addBlock(gossiper *core.Gossiper, newBlock *core.ChainBlock) bool {
+	added, applied, rolledBack := insertBlock(gossiper, newBlock)
+	if gossiper.LightClient || (len(applied) == 0 && len(rolledBack) == 0) {
+		return added
+	}
+	if gossiper.Mining != nil {
+		updatePendingTxs(gossiper, applied, rolledBack)
+		return added
+	}
+	for _, tx := range reorgedOutOwnTxs(gossiper, applied, rolledBack) {
+		helpers.ChainLog.Info("publishing again own transaction lost in a reorganisation", helpers.F("name", tx.Name))
+		go StubbornlySendTLC(gossiper, &core.BlockPublish{Transaction: tx}, gossiper.StubbornTimeout)
+	}
+	return added
+}
+
+// the transactions of this node in the blocks the canonical chain lost which are neither in the
+// blocks it gained nor made invalid by them, e.g. by a claim of the same name by another node
+func reorgedOutOwnTxs(gossiper *core.Gossiper, applied []*core.ChainBlock, rolledBack []*core.ChainBlock) []core.TxPublish {
+	included := make(map[[32]byte]bool)
+	for _, block := range applied {
+		included[block.Header.TxHash] = true
+	}
+	txs := make([]core.TxPublish, 0)
+	for _, block := range rolledBack {
+		tx := block.Block.Transaction
+		if strings.Compare(block.Origin, gossiper.Name) != 0 || included[block.Header.TxHash] {
+			continue
+		}
+		included[block.Header.TxHash] = true
+		if err := ValidateTx(gossiper, gossiper.Name, &tx); err != nil {
+			helpers.ChainLog.Warn("dropped own transaction lost in a reorganisation", helpers.F("name", tx.Name),
+				helpers.F("error", err))
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+// inserts a block under the chain lock. Returns whether it was added, and the blocks the canonical
+// chain gained and lost if it moved
+func insertBlock(gossiper *core.Gossiper, newBlock *core.ChainBlock) (bool, []*core.ChainBlock, []*core.ChainBlock) {
+	chain := gossiper.Blockchain
+	if gossiper.LightClient {
+		newBlock.Block = core.BlockPublish{}
+	}
+
+	chain.ChainLock.Lock()
+	defer chain.ChainLock.Unlock()
+
+	if _, known := chain.Blocks[newBlock.Hash]; known {
+		return false, nil, nil
+	}
+	prevHash := newBlock.Header.PrevHash
+	if _, parentKnown := chain.Blocks[prevHash]; !parentKnown && !isGenesisPrevHash(prevHash) {
+		// keep the block aside until its parent gets confirmed
+		for _, orphan := range chain.Orphans[prevHash] {
+			if orphan.Hash == newBlock.Hash {
+				return false, nil, nil
+			}
+		}
+		now := time.Now()
+		expireOrphansLocked(chain, now)
+		if !roomForOrphanLocked(chain, newBlock.Origin) {
+			helpers.PrintBlockRejected(hex.EncodeToString(newBlock.Hash[:]), ErrTooManyOrphans.Error())
+			return false, nil, nil
+		}
+		newBlock.Received = now
+		chain.Orphans[prevHash] = append(chain.Orphans[prevHash], newBlock)
+		return true, nil, nil
+	}
+
+	if !attachBlockLocked(gossiper, newBlock) {
+		return false, nil, nil
+	}
+	applied, rolledBack := switchToBestHeadLocked(gossiper)
+	return true, applied, rolledBack
+}
+
+// inserts a block whose parent is known in the block tree, together with every orphan waiting on it.
+// Blocks which do not pass the checks are rejected along with the orphans waiting on them, before
+// they are linked. Returns false if the given block was rejected
+func attachBlockLocked(gossiper *core.Gossiper, block *core.ChainBlock) bool {
+	chain := gossiper.Blockchain
+	toAttach := []*core.ChainBlock{block}
+	for len(toAttach) > 0 {
+		b := toAttach[0]
+		toAttach = toAttach[1:]
+		if _, known := chain.Blocks[b.Hash]; known {
+			continue
+		}
+		if err := checkBlockLocked(gossiper, b); err != nil {
+			rejectBlockLocked(chain, b, err)
+			if b == block {
+				return false
+			}
+			continue
+		}
+
+		if hasChildLocked(chain, b.Header.PrevHash) {
+			helpers.PrintForkDetected(hex.EncodeToString(b.Header.PrevHash[:]), hex.EncodeToString(b.Hash[:]))
+		}
+
+		// accumulate the fitness and height along the branch
+		b.Height = 1
+		if parent, ok := chain.Blocks[b.Header.PrevHash]; ok {
+			b.Height = parent.Height + 1
+			b.Fitness += parent.Fitness
+		}
+		chain.Blocks[b.Hash] = b
+		chain.Children[b.Header.PrevHash] = append(chain.Children[b.Header.PrevHash], b.Hash)
+
+		toAttach = append(toAttach, chain.Orphans[b.Hash]...)
+		delete(chain.Orphans, b.Hash)
+	}
+	return true
+}
+
+// checks that the block commits to the name table resulting from applying it on top of its parent.
+// Light clients do not hold the transactions, and blocks without a name root commit to nothing. In
+// proof-of-work mode, where miners validate every transaction they include, the transaction must
+// also be valid and the name root is required
+func checkBlockLocked(gossiper *core.Gossiper, block *core.ChainBlock) error {
+	strict := gossiper.Mining != nil
+	if gossiper.LightClient || (!strict && block.Header.NameRoot == [32]byte{}) {
+		return nil
+	}
+	table := nameTableAtLocked(gossiper, block.Header.PrevHash)
+	if strict {
+		tx := block.Block.Transaction
+		if err := validateTxLocked(table, txOrigin(&tx, block.Origin), &tx); err != nil {
+			return err
+		}
+	}
+	applyBlockLocked(table, block)
+	if computeNameRootLocked(table) != block.Header.NameRoot {
+		return ErrNameRootMismatch
+	}
+	return nil
+}
+
+// drops a block which did not pass the checks, and the orphans waiting on it
+func rejectBlockLocked(chain *core.SafeBlockchain, block *core.ChainBlock, reason error) {
+	helpers.PrintBlockRejected(hex.EncodeToString(block.Hash[:]), reason.Error())
+	dropped := [][32]byte{block.Hash}
+	for len(dropped) > 0 {
+		hash := dropped[0]
+		dropped = dropped[1:]
+		for _, orphan := range chain.Orphans[hash] {
+			dropped = append(dropped, orphan.Hash)
+		}
+		delete(chain.Orphans, hash)
+	}
+}
+
+// drops the blocks which waited for their parent for longer than OrphanTimeout. Their parent hash is
+// not authenticated, so a block may wait for a parent which never comes
+func expireOrphansLocked(chain *core.SafeBlockchain, now time.Time) {
+	for prevHash, orphans := range chain.Orphans {
+		kept := make([]*core.ChainBlock, 0, len(orphans))
+		for _, orphan := range orphans {
+			if now.Sub(orphan.Received) <= time.Duration(constants.OrphanTimeout)*time.Second {
+				kept = append(kept, orphan)
+			}
+		}
+		if len(kept) == 0 {
+			delete(chain.Orphans, prevHash)
+		} else {
+			chain.Orphans[prevHash] = kept
+		}
+	}
+}
+
+// whether another block of the given origin can be kept aside, within MaxOrphansPerOrigin and MaxOrphans
+func roomForOrphanLocked(chain *core.SafeBlockchain, origin string) bool {
+	total, fromOrigin := 0, 0
+	for _, orphans := range chain.Orphans {
+		total += len(orphans)
+		for _, orphan := range orphans {
+			if strings.Compare(orphan.Origin, origin) == 0 {
+				fromOrigin++
+			}
+		}
+	}
+	return total < constants.MaxOrphans && fromOrigin < constants.MaxOrphansPerOrigin
+}
+
+// returns a copy of the name table resulting from the branch ending at the given block
+func nameTableAtLocked(gossiper *core.Gossiper, hash [32]byte) *core.SafeNameTable {
+	gossiper.NameTable.NamesLock.Lock()
+	defer gossiper.NameTable.NamesLock.Unlock()
+	if hash == gossiper.Blockchain.Head {
+		return copyNameTableLocked(gossiper.NameTable)
+	}
+	table := &core.SafeNameTable{Names: make(map[string]*core.NameRecord)}
+	for _, b := range branchLocked(gossiper.Blockchain, [32]byte{}, hash) {
+		applyBlockLocked(table, b)
+	}
+	return table
+}
+
+// picks the best head of the block tree and, if it changed, updates the name table accordingly.
+// Returns the blocks the canonical chain gained and the ones it lost
+func switchToBestHeadLocked(gossiper *core.Gossiper) ([]*core.ChainBlock, []*core.ChainBlock) {
+	chain := gossiper.Blockchain
+
+	best := chain.Blocks[chain.Head]
+	for _, b := range chain.Blocks {
+		if isBetterHead(b, best) {
+			best = b
+		}
+	}
+	if best == nil || best.Hash == chain.Head {
+		return nil, nil
+	}
+
+	oldHead := chain.Head
+	ancestor := commonAncestorLocked(chain, oldHead, best.Hash)
+	rolledBack := heightOfLocked(chain, oldHead) - heightOfLocked(chain, ancestor)
+	oldBranch := branchLocked(chain, ancestor, oldHead)
+	newBranch := branchLocked(chain, ancestor, best.Hash)
+	chain.Head = best.Hash
+
+	// light clients do not hold the transactions, so they have no name table to update
+	if !gossiper.LightClient {
+		updateNameTableLocked(gossiper.NameTable, chain, ancestor, newBranch, rolledBack > 0)
+	}
+
+	if rolledBack > 0 {
+		event := core.ReorgEvent{OldHead: hex.EncodeToString(oldHead[:]), NewHead: hex.EncodeToString(best.Hash[:]),
+			CommonAncestor: hex.EncodeToString(ancestor[:]), RolledBack: rolledBack,
+			Applied: uint64(len(newBranch)), Time: time.Now()}
+		chain.Reorgs = append(chain.Reorgs, event)
+		if len(chain.Reorgs) > constants.MaxReorgEvents {
+			chain.Reorgs = chain.Reorgs[len(chain.Reorgs)-constants.MaxReorgEvents:]
+		}
+		helpers.PrintChainReorg(event.OldHead, event.NewHead, event.RolledBack, event.Applied)
+	}
+	return newBranch, oldBranch
+}
+
+// applies the blocks of the new branch to the name table, first rolling it back to the common
+// ancestor if the branch does not extend the previous head
+func updateNameTableLocked(table *core.SafeNameTable, chain *core.SafeBlockchain, ancestor [32]byte,
+	newBranch []*core.ChainBlock, rollBack bool) {
+	table.NamesLock.Lock()
+	defer table.NamesLock.Unlock()
+
+	if rollBack {
+		resetNameTableLocked(table)
+		for _, b := range branchLocked(chain, [32]byte{}, ancestor) {
+			applyBlockLocked(table, b)
+		}
+	}
+	for _, b := range newBranch {
+		applyBlockLocked(table, b)
+	}
+}
+
+// BlockFitness - the fitness of a block in TLC mode, derived from its hash so that every node
+// computes the same value for it: the first 24 bits of the hash scaled to [0, 1)
+func BlockFitness(hash [32]byte) float32 {
+	return float32(binary.BigEndian.Uint32(hash[:4])>>8) / (1 << 24)
+}
+
+// fork-choice rule: highest accumulated fitness, then longest branch, then smallest hash
+func isBetterHead(candidate *core.ChainBlock, current *core.ChainBlock) bool {
+	if current == nil {
+		return true
+	}
+	if candidate.Fitness != current.Fitness {
+		return candidate.Fitness > current.Fitness
+	}
+	if candidate.Height != current.Height {
+		return candidate.Height > current.Height
+	}
+	return bytes.Compare(candidate.Hash[:], current.Hash[:]) < 0
+}
+
+// returns the hash of the deepest block which is an ancestor of both given blocks
+func commonAncestorLocked(chain *core.SafeBlockchain, a [32]byte, b [32]byte) [32]byte {
+	for a != b {
+		if heightOfLocked(chain, a) >= heightOfLocked(chain, b) {
+			a = chain.Blocks[a].Header.PrevHash
+		} else {
+			b = chain.Blocks[b].Header.PrevHash
+		}
+	}
+	return a
+}
+
+// returns the blocks after 'from' up to and including 'to', in chain order
+func branchLocked(chain *core.SafeBlockchain, from [32]byte, to [32]byte) []*core.ChainBlock {
+	branch := make([]*core.ChainBlock, 0)
+	for to != from {
+		block, ok := chain.Blocks[to]
+		if !ok {
+			break
+		}
+		branch = append([]*core.ChainBlock{block}, branch...)
+		to = block.Header.PrevHash
+	}
+	return branch
+}
+
+// returns true if some block of the tree already extends the given block
+func hasChildLocked(chain *core.SafeBlockchain, hash [32]byte) bool {
+	return len(chain.Children[hash]) > 0
+}
+
+func heightOfLocked(chain *core.SafeBlockchain, hash [32]byte) uint64 {
+	if block, ok := chain.Blocks[hash]; ok {
+		return block.Height
+	}
+	return 0
+}
+
+func isGenesisPrevHash(prevHash [32]byte) bool {
+	return prevHash == [32]byte{}
+}
+
+// returns true if the block is the head of the canonical chain or one of its ancestors
+func isOnCanonicalChainLocked(chain *core.SafeBlockchain, hash [32]byte) bool {
+	current, ok := chain.Blocks[chain.Head]
+	for ok {
+		if current.Hash == hash {
+			return true
+		}
+		current, ok = chain.Blocks[current.Header.PrevHash]
+	}
+	return false
+}
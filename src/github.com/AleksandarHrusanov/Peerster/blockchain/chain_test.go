@@ -0,0 +1,211 @@
+package blockchain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/core"
+)
+
+// a gossiper holding only the block tree and the name table, watching the blocks of other nodes
+func testChainGossiper() *core.Gossiper {
+	return &core.Gossiper{Name: "N",
+		NameTable: &core.SafeNameTable{Names: make(map[string]*core.NameRecord)},
+		Blockchain: &core.SafeBlockchain{Blocks: make(map[[32]byte]*core.ChainBlock),
+			Orphans: make(map[[32]byte][]*core.ChainBlock), Children: make(map[[32]byte][][32]byte),
+			Reorgs: make([]core.ReorgEvent, 0)}}
+}
+
+// a block on top of the given parent claiming the given name, with the given fitness of its own
+func testBlock(prevHash [32]byte, name string, fitness float32) *core.ChainBlock {
+	block := core.BlockPublish{PrevHash: prevHash, Transaction: core.TxPublish{Name: name, Type: core.TxClaim,
+		MetafileHash: []byte(name)}}
+	return &core.ChainBlock{Block: block, Header: block.Header(), Hash: block.Hash(), Origin: "A", Fitness: fitness}
+}
+
+func TestBlockFitness(t *testing.T) {
+	tests := []struct {
+		name string
+		hash [32]byte
+		want float32
+	}{
+		{"zero hash", [32]byte{}, 0},
+		{"half", [32]byte{0x80}, 0.5},
+		{"only the first 24 bits count", [32]byte{0x40, 0, 0, 0xff, 0xff}, 0.25},
+		{"smallest step", [32]byte{0, 0, 1}, 1.0 / (1 << 24)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := BlockFitness(test.hash); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	var highest [32]byte
+	for i := range highest {
+		highest[i] = 0xff
+	}
+	if fitness := BlockFitness(highest); fitness >= 1 {
+		t.Errorf("fitness %v out of [0, 1)", fitness)
+	}
+}
+
+func TestIsBetterHead(t *testing.T) {
+	head := &core.ChainBlock{Hash: [32]byte{2}, Height: 3, Fitness: 1}
+	tests := []struct {
+		name      string
+		candidate *core.ChainBlock
+		current   *core.ChainBlock
+		want      bool
+	}{
+		{"no head yet", head, nil, true},
+		{"higher fitness", &core.ChainBlock{Hash: [32]byte{3}, Height: 1, Fitness: 1.5}, head, true},
+		{"lower fitness", &core.ChainBlock{Hash: [32]byte{1}, Height: 5, Fitness: 0.5}, head, false},
+		{"same fitness, longer", &core.ChainBlock{Hash: [32]byte{3}, Height: 4, Fitness: 1}, head, true},
+		{"same fitness, shorter", &core.ChainBlock{Hash: [32]byte{1}, Height: 2, Fitness: 1}, head, false},
+		{"tie, smaller hash", &core.ChainBlock{Hash: [32]byte{1}, Height: 3, Fitness: 1}, head, true},
+		{"tie, greater hash", &core.ChainBlock{Hash: [32]byte{3}, Height: 3, Fitness: 1}, head, false},
+		{"same block", head, head, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isBetterHead(test.candidate, test.current); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestForkChoice(t *testing.T) {
+	gossiper := testChainGossiper()
+	chain := gossiper.Blockchain
+
+	a1 := testBlock([32]byte{}, "a1", 0.2)
+	a2 := testBlock(a1.Hash, "a2", 0.2)
+	for _, b := range []*core.ChainBlock{a1, a2} {
+		if !addBlock(gossiper, b) {
+			t.Fatalf("block %s not added", b.Block.Transaction.Name)
+		}
+	}
+	if chain.Head != a2.Hash || a2.Height != 2 || a2.Fitness != 0.4 {
+		t.Fatalf("unexpected head at height %d with fitness %v", a2.Height, a2.Fitness)
+	}
+	if addBlock(gossiper, testBlock([32]byte{}, "a1", 0.2)) {
+		t.Error("known block added twice")
+	}
+
+	// a fitter branch from the genesis replaces the whole chain
+	b1 := testBlock([32]byte{}, "b1", 0.5)
+	addBlock(gossiper, b1)
+	if chain.Head != b1.Hash {
+		t.Fatal("canonical chain did not move to the fitter branch")
+	}
+	if len(chain.Reorgs) != 1 || chain.Reorgs[0].RolledBack != 2 || chain.Reorgs[0].Applied != 1 {
+		t.Errorf("unexpected reorganisations %+v", chain.Reorgs)
+	}
+	if _, ok := gossiper.NameTable.Names["a1"]; ok {
+		t.Error("name of the abandoned branch still in the name table")
+	}
+	if _, ok := gossiper.NameTable.Names["b1"]; !ok {
+		t.Error("name of the new branch missing from the name table")
+	}
+
+	// a block whose parent is unknown waits for it, then both extend the old branch past b1
+	a4 := testBlock(testBlock(a2.Hash, "a3", 0.1).Hash, "a4", 0.1)
+	addBlock(gossiper, a4)
+	if _, ok := chain.Blocks[a4.Hash]; ok || chain.Head != b1.Hash {
+		t.Fatal("block attached before its parent")
+	}
+	addBlock(gossiper, testBlock(a2.Hash, "a3", 0.1))
+	if chain.Head != a4.Hash || a4.Height != 4 || len(chain.Orphans) != 0 {
+		t.Fatalf("orphan not attached once its parent arrived, head at height %d", heightOfLocked(chain, chain.Head))
+	}
+	if ancestor := commonAncestorLocked(chain, a4.Hash, b1.Hash); ancestor != [32]byte{} {
+		t.Errorf("common ancestor %x, want the genesis", ancestor)
+	}
+	if ancestor := commonAncestorLocked(chain, a4.Hash, a2.Hash); ancestor != a2.Hash {
+		t.Errorf("common ancestor %x, want a2", ancestor)
+	}
+	for _, name := range []string{"a1", "a2", "a3", "a4"} {
+		if _, ok := gossiper.NameTable.Names[name]; !ok {
+			t.Errorf("name %s missing after switching back", name)
+		}
+	}
+	if _, ok := gossiper.NameTable.Names["b1"]; ok {
+		t.Error("name of the abandoned branch still in the name table")
+	}
+}
+
+func TestOrphanLimits(t *testing.T) {
+	gossiper := testChainGossiper()
+	chain := gossiper.Blockchain
+	// blocks of the given origin whose parents never come
+	orphan := func(origin string, i int) *core.ChainBlock {
+		block := testBlock([32]byte{byte(i), byte(i >> 8), 1}, "orphan of "+origin, 0.1)
+		block.Origin = origin
+		return block
+	}
+
+	for i := 0; i < constants.MaxOrphansPerOrigin; i++ {
+		if !addBlock(gossiper, orphan("X", i)) {
+			t.Fatalf("orphan %d of X refused", i)
+		}
+	}
+	if addBlock(gossiper, orphan("X", constants.MaxOrphansPerOrigin)) {
+		t.Error("orphan over the limit of its origin kept")
+	}
+	if !addBlock(gossiper, orphan("Y", 0)) {
+		t.Error("orphan of another origin refused")
+	}
+
+	// many origins together cannot go over the total either
+	for i := 1; i < constants.MaxOrphans; i++ {
+		addBlock(gossiper, orphan(string(rune('a'+i%26))+string(rune('a'+i/26)), 1000+i))
+	}
+	if addBlock(gossiper, orphan("Z", 0)) {
+		t.Error("orphan over the total limit kept")
+	}
+
+	// orphans waiting for longer than the timeout make room
+	expired := time.Now().Add(-time.Duration(constants.OrphanTimeout+1) * time.Second)
+	for _, orphans := range chain.Orphans {
+		for _, b := range orphans {
+			if b.Origin == "X" {
+				b.Received = expired
+			}
+		}
+	}
+	if !addBlock(gossiper, orphan("X", 5000)) {
+		t.Error("orphan refused after the old ones expired")
+	}
+	for _, orphans := range chain.Orphans {
+		for _, b := range orphans {
+			if b.Received.Equal(expired) {
+				t.Fatal("expired orphan still kept")
+			}
+		}
+	}
+}
+
+func TestReorgedOutOwnTxs(t *testing.T) {
+	gossiper := testChainGossiper()
+	gossiper.Name = "A"
+	gossiper.NameTable.Names["taken"] = &core.NameRecord{Name: "taken", Owner: "B", Holder: "B"}
+
+	mine := testBlock([32]byte{}, "mine", 0.1)
+	// claimed by B on the new branch meanwhile
+	taken := testBlock(mine.Hash, "taken", 0.1)
+	theirs := testBlock(taken.Hash, "theirs", 0.1)
+	theirs.Origin = "B"
+	// confirmed again on the new branch
+	again := testBlock(theirs.Hash, "again", 0.1)
+	againOnNewBranch := testBlock([32]byte{9}, "again", 0.1)
+
+	txs := reorgedOutOwnTxs(gossiper, []*core.ChainBlock{againOnNewBranch},
+		[]*core.ChainBlock{mine, taken, theirs, again, mine})
+	if len(txs) != 1 || txs[0].Name != "mine" {
+		t.Errorf("got transactions %+v, want only the claim of mine", txs)
+	}
+}
@@ -1,6 +1,10 @@
 package blockchain
 
-import "github.com/AleksandarHrusanov/Peerster/core"
+import (
+	"strings"
+
+	"github.com/AleksandarHrusanov/Peerster/core"
+)
 
 func CreateBlockPublish(fname string, numBytes int64, metahash []byte) *core.BlockPublish {
 	txPub := &core.TxPublish{Name: fname, Size: numBytes, MetafileHash: metahash}
@@ -8,12 +12,53 @@ func CreateBlockPublish(fname string, numBytes int64, metahash []byte) *core.Blo
 	return blockPub
 }
 
+// CreateBlockForSharedFile - creates a block claiming the name of a newly shared file, or updating
+// the name to the new version of the file if the gossiper already owns it
+func CreateBlockForSharedFile(gossiper *core.Gossiper, newFile *core.FileInformation) *core.BlockPublish {
+	blockPub := CreateBlockPublish(newFile.FileName, newFile.Size, newFile.MetaHash[:])
+	record := gossiper.GetNameRecord(newFile.FileName)
+	if record != nil && !record.Revoked && strings.Compare(record.Owner, gossiper.Name) == 0 {
+		blockPub.Transaction.Type = core.TxUpdate
+	}
+	return blockPub
+}
+
+// CreateBlockTransfer - creates a block handing the ownership of a name over to another node
+func CreateBlockTransfer(name string, newOwner string) *core.BlockPublish {
+	txPub := &core.TxPublish{Name: name, Type: core.TxTransfer, NewOwner: newOwner}
+	blockPub := &core.BlockPublish{Transaction: *txPub}
+	return blockPub
+}
+
+// CreateBlockRevoke - creates a block releasing a name
+func CreateBlockRevoke(name string) *core.BlockPublish {
+	txPub := &core.TxPublish{Name: name, Type: core.TxRevoke}
+	blockPub := &core.BlockPublish{Transaction: *txPub}
+	return blockPub
+}
+
+// CreateTLCMessage - creates an unconfirmed TLC message for the block, chaining it to the current head
 func CreateTLCMessage(gossiper *core.Gossiper, txBlock core.BlockPublish) *core.TLCMessage {
+	prepareBlock(gossiper, &txBlock)
 	gossiper.MongeringIDLock.Lock()
 	gossiper.CurrentMongeringID++
 	gossiper.TlcIDs[gossiper.CurrentMongeringID] = true
-	tlc := &core.TLCMessage{Origin: gossiper.Name, ID: gossiper.CurrentMongeringID, Confirmed: -1, TxBlock: txBlock}
+	tlc := &core.TLCMessage{Origin: gossiper.Name, ID: gossiper.CurrentMongeringID, Confirmed: -1, TxBlock: txBlock,
+		Fitness: BlockFitness(txBlock.Hash())}
 	gossiper.MongeringIDLock.Unlock()
 
 	return tlc
 }
+
+// chains the block to the current head and commits it to the name table resulting from applying it
+func prepareBlock(gossiper *core.Gossiper, block *core.BlockPublish) {
+	gossiper.Blockchain.ChainLock.Lock()
+	defer gossiper.Blockchain.ChainLock.Unlock()
+	gossiper.NameTable.NamesLock.Lock()
+	defer gossiper.NameTable.NamesLock.Unlock()
+
+	block.PrevHash = gossiper.Blockchain.Head
+	nextTable := copyNameTableLocked(gossiper.NameTable)
+	applyBlockLocked(nextTable, &core.ChainBlock{Block: *block, Origin: gossiper.Name})
+	block.NameRoot = computeNameRootLocked(nextTable)
+}
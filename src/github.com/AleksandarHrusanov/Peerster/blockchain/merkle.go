@@ -0,0 +1,156 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+
+	"github.com/AleksandarHrusanov/Peerster/core"
+)
+
+// The name table is committed to as a Merkle tree whose leaves are the names sorted alphabetically.
+// Leaves and internal nodes are hashed with different prefixes, and a node without a sibling is
+// promoted unchanged to the next level.
+
+func leafFromRecord(record *core.NameRecord) core.NameLeaf {
+	return core.NameLeaf{Name: record.Name, Owner: record.Owner, Holder: record.Holder,
+		MetafileHash: record.MetafileHash, Size: record.Size, Revoked: record.Revoked}
+}
+
+func hashNameLeaf(leaf *core.NameLeaf) (out [32]byte) {
+	h := sha256.New()
+	h.Write([]byte{0})
+	for _, field := range [][]byte{[]byte(leaf.Name), []byte(leaf.Owner), []byte(leaf.Holder), leaf.MetafileHash} {
+		binary.Write(h, binary.LittleEndian, uint32(len(field)))
+		h.Write(field)
+	}
+	binary.Write(h, binary.LittleEndian, leaf.Size)
+	binary.Write(h, binary.LittleEndian, leaf.Revoked)
+	copy(out[:], h.Sum(nil))
+	return
+}
+
+func hashMerkleNode(left [32]byte, right [32]byte) (out [32]byte) {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left[:])
+	h.Write(right[:])
+	copy(out[:], h.Sum(nil))
+	return
+}
+
+// returns the leaves of the name table sorted by name; the name table lock must be held
+func sortedNameLeavesLocked(table *core.SafeNameTable) []core.NameLeaf {
+	leaves := make([]core.NameLeaf, 0, len(table.Names))
+	for _, record := range table.Names {
+		leaves = append(leaves, leafFromRecord(record))
+	}
+	sort.Slice(leaves, func(i, j int) bool {
+		return leaves[i].Name < leaves[j].Name
+	})
+	return leaves
+}
+
+func hashNameLeaves(leaves []core.NameLeaf) [][32]byte {
+	hashes := make([][32]byte, len(leaves))
+	for i := range leaves {
+		hashes[i] = hashNameLeaf(&leaves[i])
+	}
+	return hashes
+}
+
+func nextMerkleLevel(level [][32]byte) [][32]byte {
+	next := make([][32]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+		} else {
+			next = append(next, hashMerkleNode(level[i], level[i+1]))
+		}
+	}
+	return next
+}
+
+// returns the Merkle root of the name table, all zeros for an empty table; the lock must be held
+func computeNameRootLocked(table *core.SafeNameTable) [32]byte {
+	level := hashNameLeaves(sortedNameLeavesLocked(table))
+	if len(level) == 0 {
+		return [32]byte{}
+	}
+	for len(level) > 1 {
+		level = nextMerkleLevel(level)
+	}
+	return level[0]
+}
+
+// returns the sibling hashes on the path from the leaf at the given index to the root
+func merkleProof(leafHashes [][32]byte, index int) [][]byte {
+	siblings := make([][]byte, 0)
+	level := leafHashes
+	for len(level) > 1 {
+		if sibling := index ^ 1; sibling < len(level) {
+			hash := level[sibling]
+			siblings = append(siblings, hash[:])
+		}
+		level = nextMerkleLevel(level)
+		index /= 2
+	}
+	return siblings
+}
+
+// returns the proof of inclusion of the leaf at the given index
+func nameLeafProof(leaves []core.NameLeaf, leafHashes [][32]byte, index int) *core.NameLeafProof {
+	return &core.NameLeafProof{Leaf: leaves[index], Index: uint64(index), Siblings: merkleProof(leafHashes, index)}
+}
+
+// VerifyNameProof - checks that the leaf is included at the given index of a tree with leafCount
+// leaves and the given root
+func VerifyNameProof(leaf *core.NameLeaf, index uint64, leafCount uint64, siblings [][]byte, root [32]byte) bool {
+	if index >= leafCount {
+		return false
+	}
+	hash := hashNameLeaf(leaf)
+	for count := leafCount; count > 1; count = (count + 1) / 2 {
+		if index^1 < count {
+			if len(siblings) == 0 || len(siblings[0]) != len(hash) {
+				return false
+			}
+			var sibling [32]byte
+			copy(sibling[:], siblings[0])
+			siblings = siblings[1:]
+			if index%2 == 0 {
+				hash = hashMerkleNode(hash, sibling)
+			} else {
+				hash = hashMerkleNode(sibling, hash)
+			}
+		}
+		index /= 2
+	}
+	return len(siblings) == 0 && bytes.Equal(hash[:], root[:])
+}
+
+// VerifyNameAbsence - checks that no leaf of a tree with leafCount leaves and the given root holds the
+// name, from the proofs of the adjacent leaves sorted right before and after it. Before is nil if the
+// name sorts first, and after if it sorts last
+func VerifyNameAbsence(name string, before *core.NameLeafProof, after *core.NameLeafProof, leafCount uint64,
+	root [32]byte) bool {
+	if before == nil && after == nil {
+		return false
+	}
+	if before != nil && (before.Leaf.Name >= name ||
+		!VerifyNameProof(&before.Leaf, before.Index, leafCount, before.Siblings, root)) {
+		return false
+	}
+	if after != nil && (after.Leaf.Name <= name ||
+		!VerifyNameProof(&after.Leaf, after.Index, leafCount, after.Siblings, root)) {
+		return false
+	}
+	switch {
+	case before == nil:
+		return after.Index == 0
+	case after == nil:
+		return before.Index == leafCount-1
+	}
+	return after.Index == before.Index+1
+}
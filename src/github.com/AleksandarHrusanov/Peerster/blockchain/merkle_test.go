@@ -0,0 +1,105 @@
+package blockchain
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/AleksandarHrusanov/Peerster/core"
+)
+
+// the sorted leaves of a name table holding the names a0, a2, a4, ... and their Merkle root
+func testNameTree(count int) ([]core.NameLeaf, [][32]byte, [32]byte) {
+	table := &core.SafeNameTable{Names: make(map[string]*core.NameRecord)}
+	for i := 0; i < count; i++ {
+		name := fmt.Sprintf("a%d", 2*i)
+		table.Names[name] = &core.NameRecord{Name: name, Owner: "A", Holder: "A",
+			MetafileHash: []byte{byte(i)}, Size: int64(i)}
+	}
+	leaves := sortedNameLeavesLocked(table)
+	return leaves, hashNameLeaves(leaves), computeNameRootLocked(table)
+}
+
+func TestVerifyNameProof(t *testing.T) {
+	for count := 1; count <= 9; count++ {
+		leaves, hashes, root := testNameTree(count)
+		for index := range leaves {
+			proof := nameLeafProof(leaves, hashes, index)
+			if !VerifyNameProof(&proof.Leaf, proof.Index, uint64(count), proof.Siblings, root) {
+				t.Errorf("%d leaves: valid proof of leaf %d rejected", count, index)
+			}
+		}
+	}
+}
+
+func TestVerifyNameProofRejects(t *testing.T) {
+	leaves, hashes, root := testNameTree(5)
+	proof := nameLeafProof(leaves, hashes, 2)
+	otherLeaf := proof.Leaf
+	otherLeaf.Holder = "B"
+	revoked := proof.Leaf
+	revoked.Revoked = true
+
+	tests := []struct {
+		name      string
+		leaf      core.NameLeaf
+		index     uint64
+		leafCount uint64
+		siblings  [][]byte
+		root      [32]byte
+	}{
+		{"other holder", otherLeaf, proof.Index, 5, proof.Siblings, root},
+		{"revoked", revoked, proof.Index, 5, proof.Siblings, root},
+		{"wrong index", proof.Leaf, 3, 5, proof.Siblings, root},
+		{"index out of the tree", proof.Leaf, 5, 5, proof.Siblings, root},
+		{"wrong leaf count", proof.Leaf, proof.Index, 4, proof.Siblings, root},
+		{"missing sibling", proof.Leaf, proof.Index, 5, proof.Siblings[1:], root},
+		{"extra sibling", proof.Leaf, proof.Index, 5, append(append([][]byte{}, proof.Siblings...), hashes[0][:]), root},
+		{"short sibling", proof.Leaf, proof.Index, 5, append([][]byte{proof.Siblings[0][:16]}, proof.Siblings[1:]...), root},
+		{"other root", proof.Leaf, proof.Index, 5, proof.Siblings, hashes[0]},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if VerifyNameProof(&test.leaf, test.index, test.leafCount, test.siblings, test.root) {
+				t.Error("invalid proof accepted")
+			}
+		})
+	}
+}
+
+func TestVerifyNameAbsence(t *testing.T) {
+	leaves, hashes, root := testNameTree(4) // a0, a2, a4, a6
+	proof := func(index int) *core.NameLeafProof {
+		return nameLeafProof(leaves, hashes, index)
+	}
+
+	tests := []struct {
+		name   string
+		lookup string
+		before *core.NameLeafProof
+		after  *core.NameLeafProof
+		want   bool
+	}{
+		{"between two leaves", "a3", proof(1), proof(2), true},
+		{"before the first leaf", "a", nil, proof(0), true},
+		{"after the last leaf", "a7", proof(3), nil, true},
+		{"name is a leaf", "a2", proof(1), proof(2), false},
+		{"leaves not adjacent", "a3", proof(0), proof(2), false},
+		{"name not between the leaves", "a5", proof(1), proof(2), false},
+		{"first leaf not proven", "a1", nil, proof(1), false},
+		{"last leaf not proven", "a5", proof(2), nil, false},
+		{"no proof", "a3", nil, nil, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := VerifyNameAbsence(test.lookup, test.before, test.after, uint64(len(leaves)), root); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+
+	t.Run("other root", func(t *testing.T) {
+		if VerifyNameAbsence("a3", proof(1), proof(2), uint64(len(leaves)), hashes[0]) {
+			t.Error("absence proven against another root")
+		}
+	})
+}
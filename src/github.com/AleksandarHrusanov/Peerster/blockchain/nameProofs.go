@@ -0,0 +1,183 @@
+package blockchain
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/core"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+)
+
+// ErrNoFullNode - returned when a light client knows no node to ask for a name proof
+var ErrNoFullNode = errors.New("no full node known to ask for a name proof")
+
+// ErrNoNameProof - returned when no valid name proof was received in time
+var ErrNoNameProof = errors.New("no valid name proof received")
+
+// HandleNameProofRequest - a function to answer a light client's name lookup with an inclusion proof
+func HandleNameProofRequest(gossiper *core.Gossiper, request *core.NameProofRequest) {
+	if strings.Compare(request.Destination, gossiper.Name) != 0 {
+		forwardNameProofRequest(gossiper, request)
+		return
+	}
+	if gossiper.LightClient {
+		// light clients have no name table to prove anything against
+		return
+	}
+
+	reply := &core.NameProofReply{Origin: gossiper.Name, Destination: request.Origin, HopLimit: gossiper.Settings.DefaultHopLimit,
+		Name: request.Name, Nonce: request.Nonce}
+
+	gossiper.Blockchain.ChainLock.Lock()
+	gossiper.NameTable.NamesLock.Lock()
+	reply.BlockHash = gossiper.Blockchain.Head
+	leaves := sortedNameLeavesLocked(gossiper.NameTable)
+	gossiper.NameTable.NamesLock.Unlock()
+	gossiper.Blockchain.ChainLock.Unlock()
+
+	leafHashes := hashNameLeaves(leaves)
+	reply.LeafCount = uint64(len(leaves))
+	idx := sort.Search(len(leaves), func(i int) bool {
+		return leaves[i].Name >= request.Name
+	})
+	if idx < len(leaves) && strings.Compare(leaves[idx].Name, request.Name) == 0 {
+		reply.Found = true
+		reply.Leaf = leaves[idx]
+		reply.LeafIndex = uint64(idx)
+		reply.Siblings = merkleProof(leafHashes, idx)
+	} else {
+		// prove the absence of the name with the leaves surrounding it
+		if idx > 0 {
+			reply.Before = nameLeafProof(leaves, leafHashes, idx-1)
+		}
+		if idx < len(leaves) {
+			reply.After = nameLeafProof(leaves, leafHashes, idx)
+		}
+	}
+	forwardNameProofReply(gossiper, reply)
+}
+
+// HandleNameProofReply - a function to hand a received name proof over to the lookup waiting for it
+func HandleNameProofReply(gossiper *core.Gossiper, reply *core.NameProofReply) {
+	if strings.Compare(reply.Destination, gossiper.Name) != 0 {
+		forwardNameProofReply(gossiper, reply)
+		return
+	}
+
+	gossiper.PendingNameProofs.ProofsLock.Lock()
+	if ch, ok := gossiper.PendingNameProofs.Waiting[reply.Nonce]; ok {
+		select {
+		case ch <- reply:
+		default:
+			// the lookup already has a reply to handle
+		}
+	}
+	gossiper.PendingNameProofs.ProofsLock.Unlock()
+}
+
+// asks random full nodes for the name until a reply with a valid proof against a block of the
+// canonical chain is received
+func resolveNameWithProof(gossiper *core.Gossiper, name string) ([]byte, string, error) {
+	replies := make(chan *core.NameProofReply, 1)
+	gossiper.PendingNameProofs.ProofsLock.Lock()
+	nonce := rand.Uint64()
+	for gossiper.PendingNameProofs.Waiting[nonce] != nil {
+		nonce = rand.Uint64()
+	}
+	gossiper.PendingNameProofs.Waiting[nonce] = replies
+	gossiper.PendingNameProofs.ProofsLock.Unlock()
+	defer func() {
+		gossiper.PendingNameProofs.ProofsLock.Lock()
+		delete(gossiper.PendingNameProofs.Waiting, nonce)
+		gossiper.PendingNameProofs.ProofsLock.Unlock()
+	}()
+
+	for attempt := 0; attempt < constants.NameProofAttempts; attempt++ {
+		fullNode := helpers.PickRandomInSlice(gossiper.GetAllKnownOrigins())
+		if strings.Compare(fullNode, "") == 0 {
+			return nil, "", ErrNoFullNode
+		}
+		request := &core.NameProofRequest{Origin: gossiper.Name, Destination: fullNode, HopLimit: gossiper.Settings.DefaultHopLimit,
+			Name: name, Nonce: nonce}
+		forwardNameProofRequest(gossiper, request)
+
+		select {
+		case reply := <-replies:
+			if !nameProofIsValid(gossiper, name, reply) {
+				continue
+			}
+			if !reply.Found {
+				return nil, "", ErrNameNotRegistered
+			}
+			if reply.Leaf.Revoked {
+				return nil, "", ErrNameRevoked
+			}
+			return reply.Leaf.MetafileHash, reply.Leaf.Holder, nil
+		case <-time.After(time.Duration(gossiper.Settings.NameProofTimeout) * time.Second):
+		}
+	}
+	return nil, "", ErrNoNameProof
+}
+
+// returns true if the reply proves the name, or its absence, against the name root of a block of the
+// canonical chain
+func nameProofIsValid(gossiper *core.Gossiper, name string, reply *core.NameProofReply) bool {
+	gossiper.Blockchain.ChainLock.Lock()
+	defer gossiper.Blockchain.ChainLock.Unlock()
+
+	block, ok := gossiper.Blockchain.Blocks[reply.BlockHash]
+	if !ok || !isOnCanonicalChainLocked(gossiper.Blockchain, reply.BlockHash) {
+		return false
+	}
+	if !reply.Found {
+		return VerifyNameAbsence(name, reply.Before, reply.After, reply.LeafCount, block.Header.NameRoot)
+	}
+	return strings.Compare(reply.Leaf.Name, name) == 0 &&
+		VerifyNameProof(&reply.Leaf, reply.LeafIndex, reply.LeafCount, reply.Siblings, block.Header.NameRoot)
+}
+
+// A function to forward a name proof request to the corresponding next hop
+func forwardNameProofRequest(gossiper *core.Gossiper, msg *core.NameProofRequest) {
+	if msg.HopLimit == 0 {
+		// if we have reached the HopLimit, drop the message
+		return
+	}
+	gossiper.DestinationTable.DsdvLock.Lock()
+	forwardingAddress := gossiper.DestinationTable.Dsdv[msg.Destination]
+	gossiper.DestinationTable.DsdvLock.Unlock()
+	if strings.Compare(forwardingAddress, "") == 0 {
+		// no route to the destination, drop the message
+		return
+	}
+
+	// Decrement the HopLimit right before forwarding the packet
+	msg.HopLimit--
+	// Encode and send packet
+	packetToSend := core.GossipPacket{NameProofRequest: msg}
+	gossiper.SendPacket(forwardingAddress, &packetToSend)
+}
+
+// A function to forward a name proof reply to the corresponding next hop
+func forwardNameProofReply(gossiper *core.Gossiper, msg *core.NameProofReply) {
+	if msg.HopLimit == 0 {
+		// if we have reached the HopLimit, drop the message
+		return
+	}
+	gossiper.DestinationTable.DsdvLock.Lock()
+	forwardingAddress := gossiper.DestinationTable.Dsdv[msg.Destination]
+	gossiper.DestinationTable.DsdvLock.Unlock()
+	if strings.Compare(forwardingAddress, "") == 0 {
+		// no route to the destination, drop the message
+		return
+	}
+
+	// Decrement the HopLimit right before forwarding the packet
+	msg.HopLimit--
+	// Encode and send packet
+	packetToSend := core.GossipPacket{NameProofReply: msg}
+	gossiper.SendPacket(forwardingAddress, &packetToSend)
+}
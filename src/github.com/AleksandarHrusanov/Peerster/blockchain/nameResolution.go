@@ -0,0 +1,46 @@
+package blockchain
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/AleksandarHrusanov/Peerster/core"
+)
+
+// ErrNameNotRegistered - returned when no TLC message publishes the requested name
+var ErrNameNotRegistered = errors.New("name is not registered on the chain")
+
+// ErrNameUnconfirmed - returned when the requested name is only published by unconfirmed TLC messages
+var ErrNameUnconfirmed = errors.New("name is registered but not yet confirmed")
+
+// ResolveName - returns the metahash a name maps to on the confirmed chain, together with
+// the origin which published the current version (e.g. a node known to hold the file).
+// Light clients ask a full node for the name and verify the proof against their block headers
+func ResolveName(gossiper *core.Gossiper, name string) ([]byte, string, error) {
+	if gossiper.LightClient {
+		metahash, holder, err := resolveNameWithProof(gossiper, name)
+		if err != ErrNameNotRegistered {
+			return metahash, holder, err
+		}
+	} else if record := gossiper.GetNameRecord(name); record != nil {
+		if record.Revoked {
+			return nil, "", ErrNameRevoked
+		}
+		return record.MetafileHash, record.Holder, nil
+	}
+
+	seenUnconfirmed := false
+	gossiper.TLCLock.Lock()
+	for _, tlc := range gossiper.KnownTLCs {
+		if strings.Compare(tlc.TxBlock.Transaction.Name, name) == 0 {
+			seenUnconfirmed = true
+			break
+		}
+	}
+	gossiper.TLCLock.Unlock()
+
+	if seenUnconfirmed {
+		return nil, "", ErrNameUnconfirmed
+	}
+	return nil, "", ErrNameNotRegistered
+}
@@ -0,0 +1,45 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/AleksandarHrusanov/Peerster/core"
+)
+
+func TestResolveName(t *testing.T) {
+	gossiper := testNameGossiper()
+	confirmTestTx(gossiper, "A", 1, core.TxPublish{Name: "movie", Type: core.TxClaim, MetafileHash: []byte{1}})
+	confirmTestTx(gossiper, "B", 2, core.TxPublish{Name: "movie", Type: core.TxClaim, MetafileHash: []byte{2}})
+	confirmTestTx(gossiper, "C", 3, core.TxPublish{Name: "song", Type: core.TxClaim, MetafileHash: []byte{3}})
+	confirmTestTx(gossiper, "C", 4, core.TxPublish{Name: "song", Type: core.TxTransfer, NewOwner: "D"})
+	confirmTestTx(gossiper, "D", 5, core.TxPublish{Name: "song", Type: core.TxUpdate, MetafileHash: []byte{5}})
+	confirmTestTx(gossiper, "A", 6, core.TxPublish{Name: "gone", Type: core.TxClaim, MetafileHash: []byte{6}})
+	confirmTestTx(gossiper, "A", 7, core.TxPublish{Name: "gone", Type: core.TxRevoke})
+	// published, waiting for the acks of a majority
+	gossiper.KnownTLCs = append(gossiper.KnownTLCs, core.TLCMessage{Origin: "A", ID: 8, Confirmed: -1,
+		TxBlock: core.BlockPublish{Transaction: core.TxPublish{Name: "draft", MetafileHash: []byte{8}}}})
+
+	tests := []struct {
+		name     string
+		lookup   string
+		metahash []byte
+		holder   string
+		err      error
+	}{
+		{"first confirmed claim wins", "movie", []byte{1}, "A", nil},
+		{"updated by the new owner", "song", []byte{5}, "D", nil},
+		{"revoked", "gone", nil, "", ErrNameRevoked},
+		{"only unconfirmed", "draft", nil, "", ErrNameUnconfirmed},
+		{"never published", "book", nil, "", ErrNameNotRegistered},
+		{"names are case sensitive", "Movie", nil, "", ErrNameNotRegistered},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metahash, holder, err := ResolveName(gossiper, test.lookup)
+			if err != test.err || holder != test.holder || !bytes.Equal(metahash, test.metahash) {
+				t.Errorf("got %x, %q, %v, want %x, %q, %v", metahash, holder, err, test.metahash, test.holder, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package blockchain
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/AleksandarHrusanov/Peerster/core"
+)
+
+// ErrNameTaken - returned when claiming a name which is already owned
+var ErrNameTaken = errors.New("name is already owned")
+
+// ErrNotOwner - returned when a node other than the owner modifies a name
+var ErrNotOwner = errors.New("only the owner of a name can modify it")
+
+// ErrNameRevoked - returned when modifying or resolving a revoked name
+var ErrNameRevoked = errors.New("name has been revoked")
+
+// ErrInvalidTx - returned for malformed transactions
+var ErrInvalidTx = errors.New("malformed transaction")
+
+// ValidateTx - checks that the given origin is allowed to apply the transaction to the name table
+func ValidateTx(gossiper *core.Gossiper, origin string, tx *core.TxPublish) error {
+	gossiper.NameTable.NamesLock.Lock()
+	defer gossiper.NameTable.NamesLock.Unlock()
+	return validateTxLocked(gossiper.NameTable, origin, tx)
+}
+
+func validateTxLocked(table *core.SafeNameTable, origin string, tx *core.TxPublish) error {
+	if strings.Compare(tx.Name, "") == 0 {
+		return ErrInvalidTx
+	}
+	record, registered := table.Names[tx.Name]
+
+	switch tx.Type {
+	case core.TxClaim:
+		if registered && !record.Revoked {
+			return ErrNameTaken
+		}
+		return nil
+	case core.TxUpdate, core.TxTransfer, core.TxRevoke:
+		if !registered {
+			return ErrNameNotRegistered
+		}
+		if record.Revoked {
+			return ErrNameRevoked
+		}
+		if strings.Compare(record.Owner, origin) != 0 {
+			return ErrNotOwner
+		}
+		if tx.Type == core.TxTransfer && strings.Compare(tx.NewOwner, "") == 0 {
+			return ErrInvalidTx
+		}
+		return nil
+	}
+	return ErrInvalidTx
+}
+
+// applies the transaction of a block of the canonical chain to the name table, whose lock
+// must be held. Transactions which are not valid on top of the preceding blocks are skipped
+func applyBlockLocked(table *core.SafeNameTable, block *core.ChainBlock) {
+	tx := block.Block.Transaction
+	origin := txOrigin(&tx, block.Origin)
+	if validateTxLocked(table, origin, &tx) != nil {
+		return
+	}
+
+	record, ok := table.Names[tx.Name]
+	if !ok {
+		record = &core.NameRecord{Name: tx.Name, History: make([]core.NameHistoryEntry, 0)}
+		table.Names[tx.Name] = record
+	}
+
+	switch tx.Type {
+	case core.TxClaim:
+		record.Owner = origin
+		record.Revoked = false
+		record.Holder = origin
+		record.MetafileHash = tx.MetafileHash
+		record.Size = tx.Size
+	case core.TxUpdate:
+		record.Holder = origin
+		record.MetafileHash = tx.MetafileHash
+		record.Size = tx.Size
+	case core.TxTransfer:
+		record.Owner = tx.NewOwner
+	case core.TxRevoke:
+		record.Revoked = true
+	}
+
+	entry := core.NameHistoryEntry{Type: tx.Type, Origin: origin, TLCID: block.TLCID,
+		MetafileHash: tx.MetafileHash, Size: tx.Size, NewOwner: tx.NewOwner}
+	record.History = append(record.History, entry)
+}
+
+// empties the name table, whose lock must be held
+func resetNameTableLocked(table *core.SafeNameTable) {
+	table.Names = make(map[string]*core.NameRecord)
+}
+
+// returns a copy of the name table, whose lock must be held, which can be modified independently
+func copyNameTableLocked(table *core.SafeNameTable) *core.SafeNameTable {
+	tableCopy := &core.SafeNameTable{Names: make(map[string]*core.NameRecord, len(table.Names))}
+	for name, record := range table.Names {
+		recordCopy := *record
+		recordCopy.History = append([]core.NameHistoryEntry(nil), record.History...)
+		tableCopy.Names[name] = &recordCopy
+	}
+	return tableCopy
+}
+
+// returns the node which issued the transaction: in proof-of-work mode it is recorded in the
+// transaction itself, otherwise it is the origin of the block
+func txOrigin(tx *core.TxPublish, blockOrigin string) string {
+	if strings.Compare(tx.Origin, "") != 0 {
+		return tx.Origin
+	}
+	return blockOrigin
+}
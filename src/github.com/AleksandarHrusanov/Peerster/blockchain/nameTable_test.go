@@ -0,0 +1,153 @@
+package blockchain
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/AleksandarHrusanov/Peerster/core"
+)
+
+// a gossiper with an empty chain and name table
+func testNameGossiper() *core.Gossiper {
+	return &core.Gossiper{NameTable: &core.SafeNameTable{Names: make(map[string]*core.NameRecord)},
+		Blockchain: &core.SafeBlockchain{Blocks: make(map[[32]byte]*core.ChainBlock),
+			Orphans: make(map[[32]byte][]*core.ChainBlock), Children: make(map[[32]byte][][32]byte),
+			Reorgs: make([]core.ReorgEvent, 0)},
+		KnownTLCs: make([]core.TLCMessage, 0)}
+}
+
+// confirms a transaction of the given origin on top of the given block, as the confirmed TLC
+// message with the given ID would, and returns the hash of its block. The block is given a fitness
+// rather than the one derived from its hash, so that tests choose the fittest branch
+func confirmTestTxOn(gossiper *core.Gossiper, prevHash [32]byte, fitness float32, origin string, id uint32,
+	tx core.TxPublish) [32]byte {
+	block := core.BlockPublish{PrevHash: prevHash, Transaction: tx}
+	gossiper.KnownTLCs = append(gossiper.KnownTLCs, core.TLCMessage{Origin: origin, ID: id + 100,
+		Confirmed: int(id), TxBlock: block})
+	addBlock(gossiper, &core.ChainBlock{Block: block, Header: block.Header(), Hash: block.Hash(), Origin: origin,
+		TLCID: id, Fitness: fitness})
+	return block.Hash()
+}
+
+// confirms a transaction of the given origin on top of the canonical chain
+func confirmTestTx(gossiper *core.Gossiper, origin string, id uint32, tx core.TxPublish) {
+	confirmTestTxOn(gossiper, gossiper.Blockchain.Head, 1, origin, id, tx)
+}
+
+func TestValidateTx(t *testing.T) {
+	table := &core.SafeNameTable{Names: map[string]*core.NameRecord{
+		"owned":   {Name: "owned", Owner: "A", Holder: "A"},
+		"revoked": {Name: "revoked", Owner: "A", Holder: "A", Revoked: true},
+	}}
+	tests := []struct {
+		name   string
+		origin string
+		tx     core.TxPublish
+		want   error
+	}{
+		{"claim a free name", "B", core.TxPublish{Name: "free", Type: core.TxClaim}, nil},
+		{"claim an owned name", "B", core.TxPublish{Name: "owned", Type: core.TxClaim}, ErrNameTaken},
+		{"owner claims its name again", "A", core.TxPublish{Name: "owned", Type: core.TxClaim}, ErrNameTaken},
+		{"claim a revoked name", "B", core.TxPublish{Name: "revoked", Type: core.TxClaim}, nil},
+		{"owner updates", "A", core.TxPublish{Name: "owned", Type: core.TxUpdate}, nil},
+		{"non-owner updates", "B", core.TxPublish{Name: "owned", Type: core.TxUpdate}, ErrNotOwner},
+		{"owner transfers", "A", core.TxPublish{Name: "owned", Type: core.TxTransfer, NewOwner: "B"}, nil},
+		{"non-owner transfers to itself", "B", core.TxPublish{Name: "owned", Type: core.TxTransfer, NewOwner: "B"},
+			ErrNotOwner},
+		{"transfer to nobody", "A", core.TxPublish{Name: "owned", Type: core.TxTransfer}, ErrInvalidTx},
+		{"owner revokes", "A", core.TxPublish{Name: "owned", Type: core.TxRevoke}, nil},
+		{"non-owner revokes", "B", core.TxPublish{Name: "owned", Type: core.TxRevoke}, ErrNotOwner},
+		{"former owner updates a revoked name", "A", core.TxPublish{Name: "revoked", Type: core.TxUpdate},
+			ErrNameRevoked},
+		{"update an unregistered name", "A", core.TxPublish{Name: "free", Type: core.TxUpdate}, ErrNameNotRegistered},
+		{"empty name", "A", core.TxPublish{Type: core.TxClaim}, ErrInvalidTx},
+		{"unknown type", "A", core.TxPublish{Name: "owned", Type: core.TxType(9)}, ErrInvalidTx},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := validateTxLocked(table, test.origin, &test.tx); err != test.want {
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+func TestNameOwnershipHistory(t *testing.T) {
+	gossiper := testNameGossiper()
+	steps := []struct {
+		origin string
+		tx     core.TxPublish
+	}{
+		{"A", core.TxPublish{Name: "movie", Type: core.TxClaim, MetafileHash: []byte{1}, Size: 10}},
+		{"B", core.TxPublish{Name: "movie", Type: core.TxClaim, MetafileHash: []byte{2}}},  // taken
+		{"B", core.TxPublish{Name: "movie", Type: core.TxUpdate, MetafileHash: []byte{2}}}, // not the owner
+		{"A", core.TxPublish{Name: "movie", Type: core.TxTransfer, NewOwner: "B"}},
+		{"A", core.TxPublish{Name: "movie", Type: core.TxUpdate, MetafileHash: []byte{3}}}, // no longer the owner
+		{"B", core.TxPublish{Name: "movie", Type: core.TxUpdate, MetafileHash: []byte{4}, Size: 40}},
+	}
+	for i, step := range steps {
+		confirmTestTx(gossiper, step.origin, uint32(i+1), step.tx)
+	}
+	// the same confirmed message received again is applied once
+	AddConfirmedBlock(gossiper, &gossiper.KnownTLCs[5])
+
+	record := gossiper.GetNameRecord("movie")
+	if record.Owner != "B" || record.Holder != "B" || !bytes.Equal(record.MetafileHash, []byte{4}) || record.Size != 40 {
+		t.Fatalf("unexpected record %+v", record)
+	}
+	wantHistory := []core.NameHistoryEntry{
+		{Type: core.TxClaim, Origin: "A", TLCID: 1, MetafileHash: []byte{1}, Size: 10},
+		{Type: core.TxTransfer, Origin: "A", TLCID: 4, NewOwner: "B"},
+		{Type: core.TxUpdate, Origin: "B", TLCID: 6, MetafileHash: []byte{4}, Size: 40},
+	}
+	if len(record.History) != len(wantHistory) {
+		t.Fatalf("got history %+v, want %+v", record.History, wantHistory)
+	}
+	for i, entry := range record.History {
+		want := wantHistory[i]
+		if entry.Type != want.Type || entry.Origin != want.Origin || entry.TLCID != want.TLCID ||
+			!bytes.Equal(entry.MetafileHash, want.MetafileHash) || entry.NewOwner != want.NewOwner {
+			t.Errorf("history entry %d: got %+v, want %+v", i, entry, want)
+		}
+	}
+
+	// once revoked, the name is free for anyone to claim
+	confirmTestTx(gossiper, "B", 7, core.TxPublish{Name: "movie", Type: core.TxRevoke})
+	confirmTestTx(gossiper, "C", 8, core.TxPublish{Name: "movie", Type: core.TxClaim, MetafileHash: []byte{5}})
+	record = gossiper.GetNameRecord("movie")
+	if record.Owner != "C" || record.Revoked || len(record.History) != 5 {
+		t.Errorf("revoked name not claimed again: %+v", record)
+	}
+}
+
+func TestNameHistoryAfterReorg(t *testing.T) {
+	gossiper := testNameGossiper()
+	claim := confirmTestTxOn(gossiper, [32]byte{}, 0.3, "A", 1,
+		core.TxPublish{Name: "movie", Type: core.TxClaim, MetafileHash: []byte{1}})
+	transfer := confirmTestTxOn(gossiper, claim, 0.3, "A", 2,
+		core.TxPublish{Name: "movie", Type: core.TxTransfer, NewOwner: "B"})
+
+	// a fitter branch where the owner kept the name and updated it instead
+	confirmTestTxOn(gossiper, claim, 0.5, "A", 3, core.TxPublish{Name: "movie", Type: core.TxUpdate, MetafileHash: []byte{3}})
+	record := gossiper.GetNameRecord("movie")
+	if record.Owner != "A" || !bytes.Equal(record.MetafileHash, []byte{3}) || len(record.History) != 2 ||
+		record.History[1].TLCID != 3 {
+		t.Fatalf("transfer of the abandoned branch kept: %+v", record)
+	}
+
+	// the first branch becomes the fittest again, and only its transactions are in the history
+	confirmTestTxOn(gossiper, transfer, 0.5, "B", 4, core.TxPublish{Name: "movie", Type: core.TxUpdate, MetafileHash: []byte{4}})
+	record = gossiper.GetNameRecord("movie")
+	if record.Owner != "B" || record.Holder != "B" || !bytes.Equal(record.MetafileHash, []byte{4}) {
+		t.Fatalf("name table not rolled back to the first branch: %+v", record)
+	}
+	wantIDs := []uint32{1, 2, 4}
+	if len(record.History) != len(wantIDs) {
+		t.Fatalf("got history %+v, want the transactions %v", record.History, wantIDs)
+	}
+	for i, entry := range record.History {
+		if entry.TLCID != wantIDs[i] {
+			t.Errorf("history entry %d is transaction %d, want %d", i, entry.TLCID, wantIDs[i])
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package blockchain
+
+import (
+	"encoding/hex"
+	"math/bits"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/core"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+)
+
+// CreateMiningState - a constructor for the proof-of-work state with the given difficulty in bits
+func CreateMiningState(difficulty int) *core.SafeMiningState {
+	return &core.SafeMiningState{Difficulty: difficulty, PendingTxs: make([]core.TxPublish, 0),
+		SeenTxs: make(map[[32]byte]bool)}
+}
+
+// StartMining - mines the pending transactions into blocks on top of the canonical chain
+func StartMining(gossiper *core.Gossiper) {
+	for !gossiper.IsStopping() {
+		tx, ok := nextPendingTx(gossiper)
+		if !ok {
+			gossiper.Sleep(time.Duration(gossiper.Settings.MiningIdlePeriod) * time.Millisecond)
+			continue
+		}
+
+		block := core.BlockPublish{Transaction: tx}
+		prepareBlock(gossiper, &block)
+		if mineBlock(gossiper, &block) {
+			hash := block.Hash()
+			helpers.PrintFoundBlock(hex.EncodeToString(hash[:]))
+			addMinedBlock(gossiper, &block, gossiper.Name)
+			minedBlock := &core.PoWBlock{Origin: gossiper.Name, Block: block, HopLimit: gossiper.Settings.PoWBlockHopLimit}
+			floodPoWBlock(gossiper, minedBlock, "")
+		}
+	}
+}
+
+// SubmitTx - adds a transaction issued by this gossiper to the pending ones and floods it to the miners
+func SubmitTx(gossiper *core.Gossiper, tx core.TxPublish) {
+	tx.Origin = gossiper.Name
+	if addPendingTx(gossiper, tx) {
+		floodPoWTransaction(gossiper, &core.PoWTransaction{Transaction: tx, HopLimit: gossiper.Settings.PoWTxHopLimit}, "")
+	}
+}
+
+// HandlePoWTransaction - a function to handle a transaction flooded by another peer
+func HandlePoWTransaction(gossiper *core.Gossiper, msg *core.PoWTransaction, fromAddr string) {
+	if strings.Compare(msg.Transaction.Origin, "") == 0 {
+		return
+	}
+	if addPendingTx(gossiper, msg.Transaction) {
+		floodPoWTransaction(gossiper, msg, fromAddr)
+	}
+}
+
+// HandlePoWBlock - a function to handle a block mined by another peer. Its transaction and name root
+// are checked against the name table of its parent when it is inserted in the block tree
+func HandlePoWBlock(gossiper *core.Gossiper, msg *core.PoWBlock, fromAddr string) {
+	hash := msg.Block.Hash()
+	if !hashMeetsDifficulty(hash, gossiper.Mining.Difficulty) ||
+		strings.Compare(msg.Block.Transaction.Origin, "") == 0 {
+		// drop blocks without enough work or whose transaction has no issuer
+		return
+	}
+	if addMinedBlock(gossiper, &msg.Block, msg.Origin) {
+		floodPoWBlock(gossiper, msg, fromAddr)
+	}
+}
+
+// looks for a nonce giving the block a hash with enough leading zero bits. Gives up and returns
+// false if the canonical chain moved in the meantime, as the block would then start a fork
+func mineBlock(gossiper *core.Gossiper, block *core.BlockPublish) bool {
+	header := block.Header()
+	header.Nonce = rand.Uint64()
+	for i := 1; ; i++ {
+		header.Nonce++
+		if hashMeetsDifficulty(header.Hash(), gossiper.Mining.Difficulty) {
+			block.Nonce = header.Nonce
+			return true
+		}
+		if i%constants.MiningHeadCheckInterval == 0 &&
+			(gossiper.GetChainHead() != block.PrevHash || gossiper.IsStopping()) {
+			return false
+		}
+	}
+}
+
+// adds a mined block to the block tree. Its transaction stops being pending once the block is on
+// the canonical chain. Returns false if the block was already known or was rejected
+func addMinedBlock(gossiper *core.Gossiper, block *core.BlockPublish, miner string) bool {
+	// every block carries the same amount of work, so the accumulated fitness is the chain length
+	newBlock := &core.ChainBlock{Block: *block, Header: block.Header(), Hash: block.Hash(), Origin: miner, Fitness: 1}
+	return addBlock(gossiper, newBlock)
+}
+
+// returns the first pending transaction which is valid on top of the canonical chain, dropping
+// the ones which are not valid anymore
+func nextPendingTx(gossiper *core.Gossiper) (core.TxPublish, bool) {
+	mining := gossiper.Mining
+	mining.MiningLock.Lock()
+	defer mining.MiningLock.Unlock()
+
+	for len(mining.PendingTxs) > 0 {
+		tx := mining.PendingTxs[0]
+		if ValidateTx(gossiper, tx.Origin, &tx) == nil {
+			return tx, true
+		}
+		mining.PendingTxs = mining.PendingTxs[1:]
+	}
+	return core.TxPublish{}, false
+}
+
+// adds a transaction to the pending ones if it was never seen and is valid. Returns true if added
+func addPendingTx(gossiper *core.Gossiper, tx core.TxPublish) bool {
+	mining := gossiper.Mining
+	hash := tx.Hash()
+	mining.MiningLock.Lock()
+	defer mining.MiningLock.Unlock()
+
+	if mining.SeenTxs[hash] {
+		return false
+	}
+	mining.SeenTxs[hash] = true
+	if ValidateTx(gossiper, tx.Origin, &tx) != nil {
+		return false
+	}
+	mining.PendingTxs = append(mining.PendingTxs, tx)
+	return true
+}
+
+// keeps the pending transactions in line with the canonical chain: the transactions of the blocks it
+// gained are not pending anymore, and the ones of the blocks a reorganisation removed from it are
+// pending again, so that they get mined on the new branch
+func updatePendingTxs(gossiper *core.Gossiper, applied []*core.ChainBlock, rolledBack []*core.ChainBlock) {
+	mining := gossiper.Mining
+	mining.MiningLock.Lock()
+	defer mining.MiningLock.Unlock()
+
+	included := make(map[[32]byte]bool)
+	for _, block := range applied {
+		included[block.Header.TxHash] = true
+		// mark the transaction as seen so it does not get mined again if it is flooded later on
+		mining.SeenTxs[block.Header.TxHash] = true
+	}
+	pending := make([]core.TxPublish, 0, len(mining.PendingTxs))
+	for _, tx := range mining.PendingTxs {
+		if hash := tx.Hash(); !included[hash] {
+			included[hash] = true
+			pending = append(pending, tx)
+		}
+	}
+	for _, block := range rolledBack {
+		if !included[block.Header.TxHash] {
+			included[block.Header.TxHash] = true
+			pending = append(pending, block.Block.Transaction)
+		}
+	}
+	mining.PendingTxs = pending
+}
+
+func hashMeetsDifficulty(hash [32]byte, difficulty int) bool {
+	zeroBits := 0
+	for _, b := range hash {
+		if b != 0 {
+			zeroBits += bits.LeadingZeros8(b)
+			break
+		}
+		zeroBits += 8
+	}
+	return zeroBits >= difficulty
+}
+
+// sends the transaction to every known peer except the one it came from
+func floodPoWTransaction(gossiper *core.Gossiper, msg *core.PoWTransaction, fromAddr string) {
+	if msg.HopLimit == 0 {
+		return
+	}
+	msg.HopLimit--
+	floodPacket(gossiper, &core.GossipPacket{PoWTransaction: msg}, fromAddr)
+}
+
+// sends the block to every known peer except the one it came from
+func floodPoWBlock(gossiper *core.Gossiper, msg *core.PoWBlock, fromAddr string) {
+	if msg.HopLimit == 0 {
+		return
+	}
+	msg.HopLimit--
+	floodPacket(gossiper, &core.GossipPacket{PoWBlock: msg}, fromAddr)
+}
+
+func floodPacket(gossiper *core.Gossiper, packet *core.GossipPacket, fromAddr string) {
+	gossiper.PeersLock.Lock()
+	knownPeers := gossiper.KnownPeers
+	gossiper.PeersLock.Unlock()
+
+	for _, peer := range knownPeers {
+		if strings.Compare(peer, fromAddr) != 0 {
+			gossiper.SendPacket(peer, packet)
+		}
+	}
+}
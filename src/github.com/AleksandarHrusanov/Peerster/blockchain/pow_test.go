@@ -0,0 +1,44 @@
+package blockchain
+
+import (
+	"testing"
+)
+
+// a hash starting with the given number of zero bits followed by a one bit
+func hashWithLeadingZeros(zeros int) [32]byte {
+	var hash [32]byte
+	for i := range hash {
+		hash[i] = 0xff
+	}
+	for i := 0; i < zeros; i++ {
+		hash[i/8] &^= 0x80 >> uint(i%8)
+	}
+	return hash
+}
+
+func TestHashMeetsDifficulty(t *testing.T) {
+	tests := []struct {
+		name       string
+		hash       [32]byte
+		difficulty int
+		want       bool
+	}{
+		{"no difficulty", hashWithLeadingZeros(0), 0, true},
+		{"no zero bit", hashWithLeadingZeros(0), 1, false},
+		{"exactly one byte", hashWithLeadingZeros(8), 8, true},
+		{"one bit short of a byte", hashWithLeadingZeros(7), 8, false},
+		{"more than required", hashWithLeadingZeros(12), 10, true},
+		{"inside a byte", hashWithLeadingZeros(12), 12, true},
+		{"one bit short inside a byte", hashWithLeadingZeros(12), 13, false},
+		{"across bytes", hashWithLeadingZeros(20), 17, true},
+		{"zero hash", [32]byte{}, 256, true},
+		{"one bit short of the zero hash", hashWithLeadingZeros(255), 256, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := hashMeetsDifficulty(test.hash, test.difficulty); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
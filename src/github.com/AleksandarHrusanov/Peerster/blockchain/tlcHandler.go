@@ -8,25 +8,28 @@ import (
 
 	"github.com/AleksandarHrusanov/Peerster/core"
 	"github.com/AleksandarHrusanov/Peerster/helpers"
-	"github.com/dedis/protobuf"
 )
 
 func HandleTLCMessage(gossiper *core.Gossiper, tlc *core.TLCMessage, peerCount int, ackHopLimit uint32, fromAddr string) {
+	// drop unconfirmed transactions which conflict with the name table; light clients do not
+	// have a name table and rely on the full nodes to validate transactions
+	if tlc.Confirmed == -1 && !gossiper.LightClient && ValidateTx(gossiper, tlc.Origin, &tlc.TxBlock.Transaction) != nil {
+		return
+	}
 	// add TLC to knownTLCs if it is new
-	// TODO: Check if txn is valid
+	alreadyConfirmed := isKnownConfirmedTLC(gossiper, tlc)
 	alreadySeen := addOrUpdateKnownTLC(gossiper, tlc)
 	if tlc.Confirmed == -1 {
 		// if receiving an unconfirmed tlc message
 		helpers.PrintUnconfirmedGossip(tlc.Origin, tlc.TxBlock.Transaction.Name,
 			hex.EncodeToString(tlc.TxBlock.Transaction.MetafileHash), tlc.ID, tlc.TxBlock.Transaction.Size)
+		if !alreadySeen {
+			gossiper.PublishEvent(core.EventTLCUnconfirmed, tlcEvent(tlc))
+		}
 
 		packetToSend := core.GossipPacket{TLCMessage: tlc}
-		packetBytes, err := protobuf.Encode(&packetToSend)
-		helpers.HandleErrorFatal(err)
 
-		gossiper.PeersLock.Lock()
-		knownPeers := gossiper.KnownPeers
-		gossiper.PeersLock.Unlock()
+		knownPeers := gossiper.GetLivePeers()
 
 		if alreadySeen {
 			// flip a coin to decide whether to send to a peer or not
@@ -36,14 +39,14 @@ func HandleTLCMessage(gossiper *core.Gossiper, tlc *core.TLCMessage, peerCount i
 				// send to a random peer
 				if len(knownPeers) > 0 {
 					chosenAddr := helpers.PickRandomInSliceDifferentFrom(knownPeers, fromAddr)
-					core.ConnectAndSend(chosenAddr, gossiper.Conn, packetBytes)
+					gossiper.SendPacket(chosenAddr, &packetToSend)
 				}
 			}
 		} else {
 			// send to a random peer
 			if len(knownPeers) > 0 {
 				chosenAddr := helpers.PickRandomInSliceDifferentFrom(knownPeers, fromAddr)
-				core.ConnectAndSend(chosenAddr, gossiper.Conn, packetBytes)
+				gossiper.SendPacket(chosenAddr, &packetToSend)
 			}
 
 			// create a TLCAck
@@ -57,10 +60,15 @@ func HandleTLCMessage(gossiper *core.Gossiper, tlc *core.TLCMessage, peerCount i
 		// receiving a confirmed tlc message
 		helpers.PrintConfirmedGossip(tlc.Origin, tlc.TxBlock.Transaction.Name,
 			hex.EncodeToString(tlc.TxBlock.Transaction.MetafileHash), tlc.ID, tlc.TxBlock.Transaction.Size)
+		if !alreadyConfirmed {
+			gossiper.PublishEvent(core.EventTLCConfirmed, tlcEvent(tlc))
+			gossiper.CountMetric(core.MetricTLCConfirmations, 1)
+		}
 		if alreadySeen {
 			// update if already seen, otherwise do nothing
 			updateTLC(gossiper, tlc)
 		}
+		AddConfirmedBlock(gossiper, tlc)
 	}
 }
 
@@ -79,6 +87,24 @@ func addOrUpdateKnownTLC(gossiper *core.Gossiper, t *core.TLCMessage) bool {
 	return false
 }
 
+// true if a confirmed version of the tlc message is already known
+func isKnownConfirmedTLC(gossiper *core.Gossiper, t *core.TLCMessage) bool {
+	gossiper.TLCLock.Lock()
+	defer gossiper.TLCLock.Unlock()
+	for _, tlc := range gossiper.KnownTLCs {
+		if strings.Compare(tlc.Origin, t.Origin) == 0 && tlc.ID == t.ID && tlc.Confirmed != -1 {
+			return true
+		}
+	}
+	return false
+}
+
+// the payload of the events published for a tlc message
+func tlcEvent(t *core.TLCMessage) core.TLCEvent {
+	return core.TLCEvent{Origin: t.Origin, ID: t.ID, Name: t.TxBlock.Transaction.Name,
+		Metahash: hex.EncodeToString(t.TxBlock.Transaction.MetafileHash), Size: t.TxBlock.Transaction.Size}
+}
+
 // adds a tlc message to the OwnTLC struct of gossiper
 func createAndAddOwnTLC(gossiper *core.Gossiper, t *core.TLCMessage) {
 	gossiper.TLCLock.Lock()
@@ -105,6 +131,7 @@ func HandleTlcAck(gossiper *core.Gossiper, ack *core.TLCAck, peerCount int) {
 		forwardTlcAck(gossiper, ack)
 	} else {
 		// if ack is for this gossiper, increment ack count
+		gossiper.CountMetric(core.MetricTLCAcksReceived, 1)
 		confirmed := updateTlcOnReceivedAck(gossiper, ack, peerCount)
 		if confirmed {
 			// if TLC message is now confirmed (e.g. has majority acks)
@@ -114,6 +141,9 @@ func HandleTlcAck(gossiper *core.Gossiper, ack *core.TLCAck, peerCount int) {
 
 			// Assign confirmed TLC's Confirmed field to the original TLC message ID
 			shouldResend := confirmedTlc.Confirmed == -1
+			if shouldResend {
+				gossiper.CountMetric(core.MetricTLCConfirmations, 1)
+			}
 			confirmedTlc.Confirmed = int(confirmedTlc.ID)
 
 			// Assign confirmed TLC's ID to next available mongering ID
@@ -124,20 +154,17 @@ func HandleTlcAck(gossiper *core.Gossiper, ack *core.TLCAck, peerCount int) {
 			gossiper.MyTLCs[ack.ID] = ownTlc
 			updateTLC(gossiper, &confirmedTlc)
 			gossiper.TLCLock.Unlock()
+			AddConfirmedBlock(gossiper, &confirmedTlc)
 
 			packetToSend := core.GossipPacket{TLCMessage: &confirmedTlc}
-			packetBytes, err := protobuf.Encode(&packetToSend)
-			helpers.HandleErrorFatal(err)
 
-			gossiper.PeersLock.Lock()
-			knownPeers := gossiper.KnownPeers
-			gossiper.PeersLock.Unlock()
+			knownPeers := gossiper.GetLivePeers()
 
 			// send to a random peer
 			if len(knownPeers) > 0 && shouldResend {
 				chosenAddr := helpers.PickRandomInSlice(knownPeers)
 				helpers.PrintReBroadcastId(confirmedTlc.ID, ownTlc.Witnesses)
-				core.ConnectAndSend(chosenAddr, gossiper.Conn, packetBytes)
+				gossiper.SendPacket(chosenAddr, &packetToSend)
 			}
 		}
 	}
@@ -157,15 +184,11 @@ func updateTlcOnReceivedAck(gossiper *core.Gossiper, ack *core.TLCAck, peerCount
 }
 
 // Sends TLC message every stubbornTimeout seconds until it has been ack'ed by majority
-func StubbornlySendTLC(gossiper *core.Gossiper, newFile *core.FileInformation, stubbornTimeout int) {
+func StubbornlySendTLC(gossiper *core.Gossiper, blockPublish *core.BlockPublish, stubbornTimeout int) {
 	// Create and add new TLC to knownTLCs
-	blockPublish := CreateBlockPublish(newFile.FileName, newFile.Size, newFile.MetaHash[:])
 	newTLC := CreateTLCMessage(gossiper, *blockPublish)
 	addOrUpdateKnownTLC(gossiper, newTLC)
 	createAndAddOwnTLC(gossiper, newTLC)
-	gossiper.PeersLock.Lock()
-	knownPeers := gossiper.KnownPeers
-	gossiper.PeersLock.Unlock()
 
 	for {
 		gossiper.TLCLock.Lock()
@@ -177,18 +200,18 @@ func StubbornlySendTLC(gossiper *core.Gossiper, newFile *core.FileInformation, s
 			// If stubbornTimeout passed and TLC message is still unconfirmed
 			// simply send to a random peer
 			packetToSend := core.GossipPacket{TLCMessage: &updatedTlc}
-			packetBytes, err := protobuf.Encode(&packetToSend)
-			helpers.HandleErrorFatal(err)
 			chosenAddr := ""
-			if len(knownPeers) > 0 {
+			if knownPeers := gossiper.GetLivePeers(); len(knownPeers) > 0 {
 				chosenAddr = helpers.PickRandomInSlice(knownPeers)
-				core.ConnectAndSend(chosenAddr, gossiper.Conn, packetBytes)
+				gossiper.SendPacket(chosenAddr, &packetToSend)
 				helpers.PrintUnconfirmedGossip(updatedTlc.Origin, updatedTlc.TxBlock.Transaction.Name,
 					hex.EncodeToString(updatedTlc.TxBlock.Transaction.MetafileHash), updatedTlc.ID, updatedTlc.TxBlock.Transaction.Size)
 			}
 
 			// wait for stubbornTimeout seconds
-			time.Sleep(time.Duration(stubbornTimeout) * time.Second)
+			if !gossiper.Sleep(time.Duration(stubbornTimeout) * time.Second) {
+				return
+			}
 		} else {
 			return
 		}
@@ -215,7 +238,5 @@ func forwardTlcAck(gossiperPtr *core.Gossiper, ack *core.TLCAck) {
 	ack.HopLimit--
 	// Encode and send packet
 	packetToSend := core.GossipPacket{Ack: ack}
-	packetBytes, err := protobuf.Encode(&packetToSend)
-	helpers.HandleErrorFatal(err)
-	core.ConnectAndSend(forwardingAddress, gossiperPtr.Conn, packetBytes)
+	gossiperPtr.SendPacket(forwardingAddress, &packetToSend)
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// httpAPI - how the client reaches the HTTP API of the gossiper
+type httpAPI struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// apiError - an error returned by the HTTP API of the gossiper
+type apiError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+// newHTTPAPI sets up the requests to the gossiper's HTTP API: plain HTTP unless a CA
+// certificate is given, with a bearer token and a client certificate if given
+func newHTTPAPI(localAddressAndPort string, token string, caCert string, cert string, key string) (*httpAPI, error) {
+	api := &httpAPI{baseURL: "http://" + localAddressAndPort, token: token, client: http.DefaultClient}
+	if strings.Compare(caCert, "") == 0 {
+		return api, nil
+	}
+
+	caPEM, err := ioutil.ReadFile(caCert)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CA certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, errors.New("no certificate found in " + caCert)
+	}
+	tlsConf := &tls.Config{RootCAs: pool}
+	if strings.Compare(cert, "") != 0 {
+		clientCert, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %v", err)
+		}
+		tlsConf.Certificates = []tls.Certificate{clientCert}
+	}
+	api.baseURL = "https://" + localAddressAndPort
+	api.client = &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConf}}
+	return api, nil
+}
+
+func (api *httpAPI) get(path string) (*http.Response, error) {
+	return api.send(http.MethodGet, path, nil)
+}
+
+func (api *httpAPI) send(method string, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, api.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if strings.Compare(api.token, "") != 0 {
+		req.Header.Set("Authorization", "Bearer "+api.token)
+	}
+	return api.client.Do(req)
+}
+
+// call sends a request to the v2 API with the given JSON body, nil for none, and returns the JSON
+// body of the response. Failures reported by the gossiper are returned as an *apiError
+func (api *httpAPI) call(method string, path string, body interface{}) (json.RawMessage, error) {
+	var reqBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reqBody = bytes.NewReader(encoded)
+	}
+	resp, err := api.send(method, "/api/v2"+path, reqBody)
+	if err != nil {
+		return nil, fmt.Errorf("unable to reach the gossiper: %v", err)
+	}
+	defer resp.Body.Close()
+
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		var envelope struct {
+			Error *apiError `json:"error"`
+		}
+		if json.Unmarshal(content, &envelope) != nil || envelope.Error == nil {
+			// e.g. an older gossiper without the endpoint
+			envelope.Error = &apiError{Code: "http_error", Message: strings.TrimSpace(string(content))}
+			if strings.Compare(envelope.Error.Message, "") == 0 {
+				envelope.Error.Message = resp.Status
+			}
+		}
+		envelope.Error.Status = resp.StatusCode
+		return nil, envelope.Error
+	}
+	return json.RawMessage(content), nil
+}
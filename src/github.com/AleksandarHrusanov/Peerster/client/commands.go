@@ -0,0 +1,573 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+)
+
+// commandContext - what a command needs to talk to the gossiper and print its result
+type commandContext struct {
+	api  *httpAPI
+	json bool
+	out  io.Writer
+}
+
+// commandFunc - runs a command with its arguments left after parsing its flags
+type commandFunc func(ctx *commandContext, args []string) error
+
+// command - a subcommand of the client. setup defines its flags and returns the function running it
+type command struct {
+	name    string
+	args    string
+	summary string
+	setup   func(flags *flag.FlagSet) commandFunc
+}
+
+// usageError - the arguments of a command are invalid
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string {
+	return e.msg
+}
+
+// errNoMatch - the search ran but found no file matching the keywords
+var errNoMatch = errors.New("no matching file found")
+
+// pollInterval - how often commands waiting for the gossiper ask for its progress
+const pollInterval = 500 * time.Millisecond
+
+var commands = []command{
+	{"msg", "<text>", "gossip a rumor to the network", setupMsg},
+	{"private", "-dest <node> <text>", "send a private message to a node", setupPrivate},
+	{"share", "<file>", "index and share a file from the shared files folder", setupShare},
+	{"download", "-metahash <hash> -file <name> [-dest <node>] | -name <name>", "download a file", setupDownload},
+	{"search", "<keyword>...", "search the network for files matching the keywords", setupSearch},
+	{"peers", "[add|remove <ip:port>]", "list the known peers, add one or remove one", setupPeers},
+	{"members", "", "list the peers tracked by the failure detector and their state", setupMembers},
+	{"routes", "", "list the next hop towards every known origin", setupRoutes},
+	{"chain", "", "list the blocks of the naming chain", setupChain},
+	{"status", "", "show the state of the gossiper and its downloads", setupStatus},
+}
+
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if strings.Compare(cmd.name, name) == 0 {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// printResult writes the JSON response of the gossiper as is with --json, and calls text to
+// write it for humans otherwise
+func (ctx *commandContext) printResult(body json.RawMessage, v interface{}, text func()) error {
+	if ctx.json {
+		_, err := fmt.Fprintln(ctx.out, string(body))
+		return err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return err
+	}
+	text()
+	return nil
+}
+
+// =====================================================================
+//                             Messages
+// =====================================================================
+
+func setupMsg(flags *flag.FlagSet) commandFunc {
+	return func(ctx *commandContext, args []string) error {
+		if len(args) == 0 {
+			return &usageError{"the text of the message is missing"}
+		}
+		body, err := ctx.api.call(http.MethodPost, "/messages", map[string]string{"text": strings.Join(args, " ")})
+		if err != nil {
+			return err
+		}
+		var rumor struct {
+			Origin string `json:"origin"`
+			ID     uint32 `json:"id"`
+			Status string `json:"status"`
+		}
+		return ctx.printResult(body, &rumor, func() {
+			if strings.Compare(rumor.Status, "") != 0 {
+				fmt.Fprintln(ctx.out, "BROADCAST simple message")
+				return
+			}
+			fmt.Fprintf(ctx.out, "RUMOR origin %s ID %d\n", rumor.Origin, rumor.ID)
+		})
+	}
+}
+
+func setupPrivate(flags *flag.FlagSet) commandFunc {
+	dest := flags.String("dest", "", "name of the node to send the message to")
+	return func(ctx *commandContext, args []string) error {
+		if strings.Compare(*dest, "") == 0 {
+			return &usageError{"the destination is missing"}
+		}
+		if len(args) == 0 {
+			return &usageError{"the text of the message is missing"}
+		}
+		body, err := ctx.api.call(http.MethodPost, "/private",
+			map[string]string{"destination": *dest, "text": strings.Join(args, " ")})
+		if err != nil {
+			return err
+		}
+		var msg struct {
+			Destination string `json:"destination"`
+			HopLimit    uint32 `json:"hopLimit"`
+		}
+		return ctx.printResult(body, &msg, func() {
+			fmt.Fprintf(ctx.out, "PRIVATE to %s hop-limit %d\n", msg.Destination, msg.HopLimit)
+		})
+	}
+}
+
+// =====================================================================
+//                               Files
+// =====================================================================
+
+func setupShare(flags *flag.FlagSet) commandFunc {
+	return func(ctx *commandContext, args []string) error {
+		if len(args) != 1 {
+			return &usageError{"exactly one file name is expected"}
+		}
+		body, err := ctx.api.call(http.MethodPost, "/files", map[string]string{"fileName": args[0]})
+		if err != nil {
+			return err
+		}
+		var file struct {
+			FileName string `json:"fileName"`
+			Metahash string `json:"metahash"`
+			Size     int64  `json:"size"`
+		}
+		return ctx.printResult(body, &file, func() {
+			fmt.Fprintf(ctx.out, "SHARED %s metahash %s size %d\n", file.FileName, file.Metahash, file.Size)
+		})
+	}
+}
+
+func setupDownload(flags *flag.FlagSet) commandFunc {
+	metahash := flags.String("metahash", "", "metahash of the file to download")
+	file := flags.String("file", "", "name to save the downloaded file under")
+	dest := flags.String("dest", "", "node to download from; without it the chunks located by the last search are used")
+	name := flags.String("name", "", "name of a file on the chain to resolve and download")
+	wait := flags.Bool("wait", false, "wait until the download finishes")
+	timeout := flags.Duration("timeout", 10*time.Minute, "how long to wait for the download (used in combination with wait)")
+	return func(ctx *commandContext, args []string) error {
+		if len(args) != 0 {
+			return &usageError{"unexpected arguments: " + strings.Join(args, " ")}
+		}
+		req := map[string]string{"destination": *dest}
+		if strings.Compare(*name, "") != 0 {
+			if strings.Compare(*metahash, "") != 0 || strings.Compare(*file, "") != 0 {
+				return &usageError{"-name cannot be combined with -metahash or -file"}
+			}
+			req["name"] = *name
+		} else {
+			if strings.Compare(*metahash, "") == 0 || strings.Compare(*file, "") == 0 {
+				return &usageError{"-metahash and -file are required unless -name is given"}
+			}
+			req["metahash"] = *metahash
+			req["fileName"] = *file
+		}
+		body, err := ctx.api.call(http.MethodPost, "/downloads", req)
+		if err != nil {
+			return err
+		}
+		var started struct {
+			FileName string `json:"fileName"`
+			Metahash string `json:"metahash"`
+		}
+		if err := json.Unmarshal(body, &started); err != nil {
+			return err
+		}
+		if !*wait {
+			return ctx.printResult(body, &started, func() {
+				fmt.Fprintf(ctx.out, "DOWNLOADING %s metahash %s\n", started.FileName, started.Metahash)
+			})
+		}
+
+		download, err := waitForDownload(ctx, started.Metahash, *timeout)
+		if err != nil {
+			return err
+		}
+		if ctx.json {
+			encoded, err := json.Marshal(download)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(ctx.out, string(encoded))
+			return nil
+		}
+		fmt.Fprintln(ctx.out, printDownloadLine(download))
+		return nil
+	}
+}
+
+// waitForDownload polls the downloads of the gossiper until the one of the metahash finishes
+func waitForDownload(ctx *commandContext, metahash string, timeout time.Duration) (downloadStats, error) {
+	deadline := time.Now().Add(timeout)
+	lastLine := ""
+	for {
+		downloads, err := listDownloads(ctx.api)
+		if err != nil {
+			return downloadStats{}, err
+		}
+		for _, d := range downloads {
+			if strings.Compare(d.Metahash, metahash) != 0 {
+				continue
+			}
+			if d.Failed {
+				return downloadStats{}, fmt.Errorf("download of %s failed: a peer does not have the file", metahash)
+			}
+			if d.Finished {
+				return d, nil
+			}
+			if line := printDownloadLine(d); !ctx.json && strings.Compare(line, lastLine) != 0 {
+				fmt.Fprintln(ctx.out, line)
+				lastLine = line
+			}
+		}
+		if time.Now().After(deadline) {
+			return downloadStats{}, fmt.Errorf("download of %s did not finish within %s", metahash, timeout)
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// the fields of a download listed by the gossiper's HTTP API which are printed
+type downloadStats struct {
+	FileName         string  `json:"fileName"`
+	Metahash         string  `json:"metahash"`
+	Finished         bool    `json:"finished"`
+	Failed           bool    `json:"failed"`
+	ChunksDownloaded int     `json:"chunksDownloaded"`
+	ChunkCount       int     `json:"chunkCount"`
+	BytesPerSecond   float64 `json:"bytesPerSecond"`
+	ETASeconds       float64 `json:"etaSeconds"`
+	Peers            []struct {
+		Peer    string `json:"peer"`
+		Chunks  int    `json:"chunks"`
+		Bytes   int64  `json:"bytes"`
+		Retries int    `json:"retries"`
+	} `json:"peers"`
+}
+
+func listDownloads(api *httpAPI) ([]downloadStats, error) {
+	body, err := api.call(http.MethodGet, "/downloads", nil)
+	if err != nil {
+		return nil, err
+	}
+	var downloads []downloadStats
+	if err := json.Unmarshal(body, &downloads); err != nil {
+		return nil, err
+	}
+	return downloads, nil
+}
+
+func printDownloadLine(d downloadStats) string {
+	status, eta := "DOWNLOADING", "unknown"
+	if d.Failed {
+		status = "FAILED"
+	} else if d.Finished {
+		status, eta = "FINISHED", "0s"
+	} else if d.ETASeconds >= 0 {
+		eta = (time.Duration(d.ETASeconds) * time.Second).String()
+	}
+	return fmt.Sprintf("%s %s metahash %s chunks %d/%d rate %.1f KB/s ETA %s", status, d.FileName, d.Metahash,
+		d.ChunksDownloaded, d.ChunkCount, d.BytesPerSecond/1024, eta)
+}
+
+// writeDownloads prints the progress of the downloads and the peers serving their chunks
+func writeDownloads(out io.Writer, downloads []downloadStats) {
+	for _, d := range downloads {
+		fmt.Fprintln(out, printDownloadLine(d))
+		for _, p := range d.Peers {
+			fmt.Fprintf(out, "  PEER %s chunks %d bytes %d retries %d\n", p.Peer, p.Chunks, p.Bytes, p.Retries)
+		}
+	}
+}
+
+// =====================================================================
+//                              Search
+// =====================================================================
+
+type searchMatch struct {
+	FileName     string `json:"fileName"`
+	Metahash     string `json:"metahash"`
+	ChunkCount   uint64 `json:"chunkCount"`
+	ChunksFound  int    `json:"chunksFound"`
+	FullyMatched bool   `json:"fullyMatched"`
+}
+
+func setupSearch(flags *flag.FlagSet) commandFunc {
+	budget := flags.Uint64("budget", 0, "starting budget of the expanding-ring search (0 doubles it from the default)")
+	wait := flags.Duration("wait", 10*time.Second, "how long to wait for matches")
+	return func(ctx *commandContext, args []string) error {
+		keywords := make([]string, 0)
+		for _, arg := range args {
+			for _, kw := range strings.Split(arg, ",") {
+				if strings.Compare(kw, "") != 0 {
+					keywords = append(keywords, kw)
+				}
+			}
+		}
+		if len(keywords) == 0 {
+			return &usageError{"at least one keyword is expected"}
+		}
+		if _, err := ctx.api.call(http.MethodPost, "/search",
+			map[string]interface{}{"keywords": keywords, "budget": *budget}); err != nil {
+			return err
+		}
+
+		matches, err := waitForMatches(ctx.api, keywords, *wait)
+		if err != nil {
+			return err
+		}
+		if ctx.json {
+			encoded, err := json.Marshal(matches)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(ctx.out, string(encoded))
+		} else {
+			for _, m := range matches {
+				fmt.Fprintf(ctx.out, "FOUND match %s metafile=%s chunks %d/%d\n", m.FileName, m.Metahash,
+					m.ChunksFound, m.ChunkCount)
+			}
+		}
+		if len(matches) == 0 {
+			return errNoMatch
+		}
+		return nil
+	}
+}
+
+// waitForMatches polls the matches found by the gossiper until enough files are fully located or
+// the wait is over, and returns the matches of the keywords
+func waitForMatches(api *httpAPI, keywords []string, wait time.Duration) ([]searchMatch, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		body, err := api.call(http.MethodGet, "/search", nil)
+		if err != nil {
+			return nil, err
+		}
+		var all []searchMatch
+		if err := json.Unmarshal(body, &all); err != nil {
+			return nil, err
+		}
+
+		// the gossiper keeps the matches of earlier searches as well
+		matches := make([]searchMatch, 0)
+		fullMatches := 0
+		for _, m := range all {
+			if matchesAnyKeyword(m.FileName, keywords) {
+				matches = append(matches, m)
+				if m.FullyMatched {
+					fullMatches++
+				}
+			}
+		}
+		if fullMatches >= constants.FullMatchesThreshold || time.Now().After(deadline) {
+			return matches, nil
+		}
+		time.Sleep(pollInterval)
+	}
+}
+
+// the gossipers match file names against the keywords as regular expressions
+func matchesAnyKeyword(fileName string, keywords []string) bool {
+	for _, kw := range keywords {
+		if matched, _ := regexp.MatchString(kw, fileName); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// =====================================================================
+//                          State of the node
+// =====================================================================
+
+func setupPeers(flags *flag.FlagSet) commandFunc {
+	return func(ctx *commandContext, args []string) error {
+		var body json.RawMessage
+		var err error
+		switch {
+		case len(args) == 0:
+			body, err = ctx.api.call(http.MethodGet, "/peers", nil)
+		case len(args) == 2 && strings.Compare(args[0], "add") == 0:
+			body, err = ctx.api.call(http.MethodPost, "/peers", map[string]string{"address": args[1]})
+		case len(args) == 2 && strings.Compare(args[0], "remove") == 0:
+			body, err = ctx.api.call(http.MethodDelete, "/peers/"+url.PathEscape(args[1]), nil)
+		default:
+			return &usageError{"expected no arguments, add <ip:port> or remove <ip:port>"}
+		}
+		if err != nil {
+			return err
+		}
+		var peers []string
+		return ctx.printResult(body, &peers, func() {
+			for _, peer := range peers {
+				fmt.Fprintf(ctx.out, "PEER %s\n", peer)
+			}
+		})
+	}
+}
+
+func setupMembers(flags *flag.FlagSet) commandFunc {
+	return func(ctx *commandContext, args []string) error {
+		if len(args) != 0 {
+			return &usageError{"unexpected arguments: " + strings.Join(args, " ")}
+		}
+		body, err := ctx.api.call(http.MethodGet, "/members", nil)
+		if err != nil {
+			return err
+		}
+		var members []struct {
+			Address    string    `json:"address"`
+			Identities []string  `json:"identities"`
+			State      string    `json:"state"`
+			StateSince time.Time `json:"stateSince"`
+		}
+		return ctx.printResult(body, &members, func() {
+			for _, member := range members {
+				line := fmt.Sprintf("MEMBER %s %s for %s", member.Address, member.State,
+					time.Since(member.StateSince).Round(time.Second))
+				if len(member.Identities) > 0 {
+					line += " " + strings.Join(member.Identities, " ")
+				}
+				fmt.Fprintln(ctx.out, line)
+			}
+		})
+	}
+}
+
+func setupRoutes(flags *flag.FlagSet) commandFunc {
+	return func(ctx *commandContext, args []string) error {
+		if len(args) != 0 {
+			return &usageError{"unexpected arguments: " + strings.Join(args, " ")}
+		}
+		body, err := ctx.api.call(http.MethodGet, "/routes", nil)
+		if err != nil {
+			return err
+		}
+		var routes []struct {
+			Origin  string `json:"origin"`
+			NextHop string `json:"nextHop"`
+		}
+		return ctx.printResult(body, &routes, func() {
+			for _, route := range routes {
+				fmt.Fprintf(ctx.out, "ROUTE %s via %s\n", route.Origin, route.NextHop)
+			}
+		})
+	}
+}
+
+func setupChain(flags *flag.FlagSet) commandFunc {
+	return func(ctx *commandContext, args []string) error {
+		if len(args) != 0 {
+			return &usageError{"unexpected arguments: " + strings.Join(args, " ")}
+		}
+		body, err := ctx.api.call(http.MethodGet, "/chain", nil)
+		if err != nil {
+			return err
+		}
+		var chain struct {
+			Head   string `json:"head"`
+			Blocks []struct {
+				Hash     string `json:"hash"`
+				Height   uint64 `json:"height"`
+				Origin   string `json:"origin"`
+				TxType   string `json:"txType"`
+				Name     string `json:"name"`
+				Metahash string `json:"metahash"`
+				NewOwner string `json:"newOwner"`
+			} `json:"blocks"`
+			Reorgs []json.RawMessage `json:"reorgs"`
+		}
+		return ctx.printResult(body, &chain, func() {
+			fmt.Fprintf(ctx.out, "HEAD %s blocks %d reorgs %d\n", chain.Head, len(chain.Blocks), len(chain.Reorgs))
+			for _, b := range chain.Blocks {
+				line := fmt.Sprintf("BLOCK %d %s origin %s %s %s", b.Height, b.Hash, b.Origin, b.TxType, b.Name)
+				if strings.Compare(b.NewOwner, "") != 0 {
+					line += " new-owner " + b.NewOwner
+				} else if strings.Compare(b.Metahash, "") != 0 {
+					line += " metahash " + b.Metahash
+				}
+				fmt.Fprintln(ctx.out, line)
+			}
+		})
+	}
+}
+
+func setupStatus(flags *flag.FlagSet) commandFunc {
+	return func(ctx *commandContext, args []string) error {
+		if len(args) != 0 {
+			return &usageError{"unexpected arguments: " + strings.Join(args, " ")}
+		}
+		statusBody, err := ctx.api.call(http.MethodGet, "/status", nil)
+		if err != nil {
+			return err
+		}
+		downloadsBody, err := ctx.api.call(http.MethodGet, "/downloads", nil)
+		if err != nil {
+			return err
+		}
+		if ctx.json {
+			encoded, err := json.Marshal(map[string]json.RawMessage{"node": statusBody, "downloads": downloadsBody})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(ctx.out, string(encoded))
+			return nil
+		}
+
+		var status struct {
+			Name            string `json:"name"`
+			GossipAddress   string `json:"gossipAddress"`
+			Mode            string `json:"mode"`
+			LightClient     bool   `json:"lightClient"`
+			Peers           int    `json:"peers"`
+			Origins         int    `json:"origins"`
+			Rumors          int    `json:"rumors"`
+			SharedFiles     int    `json:"sharedFiles"`
+			ActiveDownloads int    `json:"activeDownloads"`
+			ChainHeight     int    `json:"chainHeight"`
+			NodeKey         string `json:"nodeKey"`
+		}
+		var downloads []downloadStats
+		if err := json.Unmarshal(statusBody, &status); err != nil {
+			return err
+		}
+		if err := json.Unmarshal(downloadsBody, &downloads); err != nil {
+			return err
+		}
+		mode := status.Mode
+		if status.LightClient {
+			mode += " (light client)"
+		}
+		fmt.Fprintf(ctx.out, "NODE %s at %s mode %s\n", status.Name, status.GossipAddress, mode)
+		if strings.Compare(status.NodeKey, "") != 0 {
+			fmt.Fprintf(ctx.out, "NODE KEY %s\n", status.NodeKey)
+		}
+		fmt.Fprintf(ctx.out, "PEERS %d ORIGINS %d RUMORS %d FILES %d DOWNLOADS %d CHAIN %d\n", status.Peers,
+			status.Origins, status.Rumors, status.SharedFiles, status.ActiveDownloads, status.ChainHeight)
+		writeDownloads(ctx.out, downloads)
+		return nil
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/AleksandarHrusanov/Peerster/core"
+)
+
+// legacyFlags - the flags of the original client, which sends a single message to the gossiper
+// over UDP without waiting for an answer. They are kept for the course test scripts
+type legacyFlags struct {
+	msg       *string
+	dest      *string
+	file      *string
+	request   *string
+	keywords  *string
+	budget    *uint64
+	name      *string
+	transfer  *string
+	revoke    *bool
+	downloads *bool
+}
+
+func defineLegacyFlags() *legacyFlags {
+	return &legacyFlags{
+		msg:       flag.String("msg", "", "message to be sent"),
+		dest:      flag.String("dest", "", "message to be sent"),
+		file:      flag.String("file", "", "file to be indexed by the gossiper"),
+		request:   flag.String("request", "", "string representation of the metahash of the file to request"),
+		keywords:  flag.String("keywords", "", "comma separated keywords used for file searching by name"),
+		budget:    flag.Uint64("budget", uint64(0), "starting budget used for ring-expand search"),
+		name:      flag.String("name", "", "name of a file on the chain to resolve and download"),
+		transfer:  flag.String("transfer", "", "node to transfer the ownership of the name given with -name to"),
+		revoke:    flag.Bool("revoke", false, "revoke the name given with -name"),
+		downloads: flag.Bool("downloads", false, "list the ongoing and recently finished downloads of the gossiper"),
+	}
+}
+
+// set is true if any of the legacy flags was given on the command line
+func (f *legacyFlags) set() bool {
+	found := false
+	legacyNames := map[string]bool{"msg": true, "dest": true, "file": true, "request": true, "keywords": true,
+		"budget": true, "name": true, "transfer": true, "revoke": true, "downloads": true}
+	flag.Visit(func(fl *flag.Flag) {
+		if legacyNames[fl.Name] {
+			found = true
+		}
+	})
+	return found
+}
+
+// runLegacy runs the original client: the intent is inferred from the combination of flags
+func runLegacy(api *httpAPI, localAddressAndPort string, f *legacyFlags) {
+	if *f.downloads {
+		printDownloads(api)
+		return
+	}
+
+	// Establish UDP connection and send the message
+	checkFlags(f.msg, f.dest, f.file, f.request, f.keywords, f.name)
+	if strings.Compare(*f.transfer, "") != 0 || *f.revoke {
+		if strings.Compare(*f.name, "") == 0 || (strings.Compare(*f.transfer, "") != 0 && *f.revoke) {
+			log.Fatal("Combination of flags is not allowed.")
+		}
+		msg := &core.Message{Name: f.name, NewOwner: f.transfer, Revoke: f.revoke}
+		core.ClientSendMessage(localAddressAndPort, msg)
+		return
+	}
+	if strings.Compare(*f.name, "") != 0 {
+		checkNameIsResolvable(api, *f.name)
+	}
+	core.ClientConnectAndSend(localAddressAndPort, f.msg, f.dest, f.file, f.request, f.keywords, f.budget, f.name)
+}
+
+// checkNameIsResolvable asks the gossiper's HTTP server to resolve the name through
+// the confirmed chain and exits if the name is unregistered or unconfirmed
+func checkNameIsResolvable(api *httpAPI, name string) {
+	resp, err := api.get("/resolve?name=" + url.QueryEscape(name))
+	if err != nil {
+		log.Fatal("Unable to resolve name: ", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		reason, _ := ioutil.ReadAll(resp.Body)
+		log.Fatal("Unable to resolve name ", name, ": ", strings.TrimSpace(string(reason)))
+	}
+}
+
+// printDownloads asks the gossiper's HTTP server for its downloads and prints their progress
+// and the peers serving their chunks
+func printDownloads(api *httpAPI) {
+	downloads, err := listDownloads(api)
+	if err != nil {
+		log.Fatal("Unable to list downloads: ", err)
+	}
+	writeDownloads(os.Stdout, downloads)
+}
+
+func checkFlags(msgPtr, destPtr, fileToSharePtr, requestHash, keywordsPtr, namePtr *string) {
+
+	if strings.Compare(*requestHash, "") != 0 {
+		_, err := hex.DecodeString(*requestHash)
+		if err != nil {
+			log.Fatal("Unable to decode hash: ", err)
+			os.Exit(1)
+		}
+	}
+
+	// uiport + destination + file + request
+	fileDownload := (strings.Compare(*destPtr, "") != 0 &&
+		strings.Compare(*fileToSharePtr, "") != 0 &&
+		strings.Compare(*requestHash, "") != 0 &&
+		strings.Compare(*msgPtr, "") == 0)
+	// uiport + file
+	fileShare := (strings.Compare(*destPtr, "") == 0 &&
+		strings.Compare(*fileToSharePtr, "") != 0 &&
+		strings.Compare(*requestHash, "") == 0 &&
+		strings.Compare(*msgPtr, "") == 0)
+
+	// uiport + msg (+ destination)
+	sendingMessage := (strings.Compare(*fileToSharePtr, "") == 0 &&
+		strings.Compare(*requestHash, "") == 0 &&
+		strings.Compare(*msgPtr, "") != 0)
+
+	//
+	fileSearch := (strings.Compare(*keywordsPtr, "") != 0 &&
+		strings.Compare(*destPtr, "") == 0 &&
+		strings.Compare(*msgPtr, "") == 0)
+
+	implicitFileDownload := (strings.Compare(*requestHash, "") != 0 &&
+		strings.Compare(*fileToSharePtr, "") != 0 &&
+		strings.Compare(*destPtr, "") == 0)
+
+	// uiport + name (+ destination)
+	namedFileDownload := (strings.Compare(*namePtr, "") != 0 &&
+		strings.Compare(*requestHash, "") == 0 &&
+		strings.Compare(*fileToSharePtr, "") == 0 &&
+		strings.Compare(*keywordsPtr, "") == 0 &&
+		strings.Compare(*msgPtr, "") == 0)
+
+	if !(fileDownload || fileShare || sendingMessage || fileSearch || implicitFileDownload || namedFileDownload) {
+		log.Fatal("Combination of flags is not allowed.")
+		os.Exit(1)
+	}
+}
@@ -1,74 +1,137 @@
 package main
 
 import (
-	"encoding/hex"
+	"encoding/json"
 	"flag"
-	"log"
+	"fmt"
+	"net"
 	"os"
 	"strings"
+)
 
-	"github.com/AleksandarHrusanov/Peerster/core"
+// exit codes of the client
+const (
+	exitOK      = 0
+	exitFailure = 1 // the gossiper could not be reached, rejected the request or found nothing
+	exitUsage   = 2 // the command line is invalid
 )
 
 func main() {
 	// Parse the arguments
 	uIPortPtr := flag.String("UIPort", "8080", "Port for the UI client (default \"8080\")")
-	msgPtr := flag.String("msg", "", "message to be sent")
-	destPtr := flag.String("dest", "", "message to be sent")
-	fileToSharePtr := flag.String("file", "", "file to be indexed by the gossiper")
-	requestHash := flag.String("request", "", "string representation of the metahash of the file to request")
-	keywords := flag.String("keywords", "", "comma separated keywords used for file searching by name")
-	budget := flag.Uint64("budget", uint64(0), "starting budget used for ring-expand search")
+	uIAddrPtr := flag.String("UIAddr", "127.0.0.1", "Host or IP address of the UI of the gossiper, e.g. ::1")
+	tokenPtr := flag.String("token", os.Getenv("PEERSTER_TOKEN"), "API token for the HTTP API of the gossiper (default $PEERSTER_TOKEN)")
+	caCertPtr := flag.String("caCert", "", "reach the HTTP API over HTTPS, verifying the gossiper with this CA certificate")
+	certPtr := flag.String("cert", "", "client certificate presented to the HTTP API (used in combination with caCert)")
+	keyPtr := flag.String("key", "", "private key of the client certificate")
+	jsonPtr := flag.Bool("json", false, "print the results of a command as JSON")
+	legacy := defineLegacyFlags()
+	flag.Usage = usage
 	flag.Parse()
-	localAddressAndPort := "127.0.0.1:" + *uIPortPtr
-
-	// Establish UDP connection and send the message
-	checkFlags(uIPortPtr, msgPtr, destPtr, fileToSharePtr, requestHash, keywords)
-	core.ClientConnectAndSend(localAddressAndPort, msgPtr, destPtr, fileToSharePtr, requestHash, keywords, budget)
-}
 
-func checkFlags(uiPortPtr, msgPtr, destPtr, fileToSharePtr, requestHash, keywordsPtr *string) {
-
-	if strings.Compare(*uiPortPtr, "") == 0 {
-		log.Fatal("No UIPort specified.")
-		os.Exit(1)
+	if strings.Compare(*uIPortPtr, "") == 0 {
+		fmt.Fprintln(os.Stderr, "No UIPort specified.")
+		os.Exit(exitUsage)
+	}
+	localAddressAndPort := net.JoinHostPort(*uIAddrPtr, *uIPortPtr)
+	api, err := newHTTPAPI(localAddressAndPort, *tokenPtr, *caCertPtr, *certPtr, *keyPtr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitUsage)
 	}
 
-	if strings.Compare(*requestHash, "") != 0 {
-		_, err := hex.DecodeString(*requestHash)
-		if err != nil {
-			log.Fatal("Unable to decode hash: ", err)
-			os.Exit(1)
+	if flag.NArg() == 0 {
+		if !legacy.set() {
+			usage()
+			os.Exit(exitUsage)
 		}
+		runLegacy(api, localAddressAndPort, legacy)
+		return
 	}
+	if legacy.set() {
+		fmt.Fprintln(os.Stderr, "The flags of the original client cannot be combined with a command.")
+		os.Exit(exitUsage)
+	}
+	os.Exit(runCommand(api, *jsonPtr, flag.Args()))
+}
 
-	// uiport + destination + file + request
-	fileDownload := (strings.Compare(*destPtr, "") != 0 &&
-		strings.Compare(*fileToSharePtr, "") != 0 &&
-		strings.Compare(*requestHash, "") != 0 &&
-		strings.Compare(*msgPtr, "") == 0)
-	// uiport + file
-	fileShare := (strings.Compare(*destPtr, "") == 0 &&
-		strings.Compare(*fileToSharePtr, "") != 0 &&
-		strings.Compare(*requestHash, "") == 0 &&
-		strings.Compare(*msgPtr, "") == 0)
+// runCommand runs the subcommand named by the first argument and returns the exit code
+func runCommand(api *httpAPI, jsonOutput bool, args []string) int {
+	cmd, ok := findCommand(args[0])
+	if !ok {
+		if strings.Compare(args[0], "help") == 0 {
+			usage()
+			return exitOK
+		}
+		fmt.Fprintf(os.Stderr, "Unknown command %q.\n\n", args[0])
+		usage()
+		return exitUsage
+	}
 
-	// uiport + msg (+ destination)
-	sendingMessage := (strings.Compare(*fileToSharePtr, "") == 0 &&
-		strings.Compare(*requestHash, "") == 0 &&
-		strings.Compare(*msgPtr, "") != 0)
+	flags := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [global flags] %s [flags] %s\n\n%s\n", os.Args[0], cmd.name, cmd.args, cmd.summary)
+		flags.PrintDefaults()
+	}
+	localJSON := flags.Bool("json", false, "print the result as JSON")
+	run := cmd.setup(flags)
+	positional, err := parseInterspersed(flags, args[1:])
+	if err == flag.ErrHelp {
+		return exitOK
+	}
+	if err != nil {
+		return exitUsage
+	}
 
-	//
-	fileSearch := (strings.Compare(*keywordsPtr, "") != 0 &&
-		strings.Compare(*destPtr, "") == 0 &&
-		strings.Compare(*msgPtr, "") == 0)
+	ctx := &commandContext{api: api, json: jsonOutput || *localJSON, out: os.Stdout}
+	err = run(ctx, positional)
+	switch e := err.(type) {
+	case nil:
+		return exitOK
+	case *usageError:
+		fmt.Fprintln(os.Stderr, e.Error())
+		flags.Usage()
+		return exitUsage
+	case *apiError:
+		if ctx.json {
+			encoded, _ := json.Marshal(struct {
+				Error *apiError `json:"error"`
+			}{e})
+			fmt.Fprintln(ctx.out, string(encoded))
+		}
+		fmt.Fprintf(os.Stderr, "%s: %s (%s)\n", cmd.name, e.Message, e.Code)
+		return exitFailure
+	default:
+		fmt.Fprintf(os.Stderr, "%s: %s\n", cmd.name, err)
+		return exitFailure
+	}
+}
 
-	implicitFileDownload := (strings.Compare(*requestHash, "") != 0 &&
-		strings.Compare(*fileToSharePtr, "") != 0 &&
-		strings.Compare(*destPtr, "") == 0)
+// parseInterspersed parses the flags of a command wherever they appear among its arguments
+// and returns the other arguments
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0)
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
 
-	if !(fileDownload || fileShare || sendingMessage || fileSearch || implicitFileDownload) {
-		log.Fatal("Combination of flags is not allowed.")
-		os.Exit(1)
+func usage() {
+	out := os.Stderr
+	fmt.Fprintf(out, "Usage: %s [global flags] <command> [flags] [arguments]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		fmt.Fprintf(out, "  %-9s %s\n", cmd.name, cmd.summary)
 	}
+	fmt.Fprintf(out, "\nRun '%s <command> -h' for the flags of a command.\n", os.Args[0])
+	fmt.Fprintf(out, "Without a command, the flags of the original client (-msg, -dest, -file, ...) send a\n")
+	fmt.Fprintf(out, "single message to the gossiper without waiting for an answer.\n\nGlobal flags:\n")
+	flag.PrintDefaults()
 }
@@ -0,0 +1,365 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/core"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+)
+
+// ErrInvalidConfig - the configuration of the node has values out of their range
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// FileEnv - the environment variable naming the configuration file, when -config is not given
+const FileEnv = "PEERSTER_CONFIG"
+
+// EnvPrefix - the prefix of the environment variables overriding the configuration file, followed
+// by the section and the key, e.g. PEERSTER_TIMERS_ANTI_ENTROPY
+const EnvPrefix = "PEERSTER_"
+
+// Consensus modes of the naming chain
+const (
+	ConsensusNone   = "none"
+	ConsensusHw3ex2 = "hw3ex2"
+	ConsensusPoW    = "pow"
+)
+
+// Config - the configuration of a node. It is read from a TOML file with one table per section,
+// overridden by the environment and then by the command line flags
+type Config struct {
+	Node      NodeConfig      `toml:"node"`
+	API       APIConfig       `toml:"api"`
+	Peers     PeersConfig     `toml:"peers"`
+	Folders   FoldersConfig   `toml:"folders"`
+	Timers    TimersConfig    `toml:"timers"`
+	Files     FilesConfig     `toml:"files"`
+	Search    SearchConfig    `toml:"search"`
+	HopLimits HopLimitsConfig `toml:"hop_limits"`
+	Consensus ConsensusConfig `toml:"consensus"`
+	Security  SecurityConfig  `toml:"security"`
+	Log       LogConfig       `toml:"log"`
+}
+
+// NodeConfig - the identity and the gossip socket of the node
+type NodeConfig struct {
+	Name       string `toml:"name"`
+	GossipAddr string `toml:"gossip_addr"`
+	Simple     bool   `toml:"simple"`
+	Stream     bool   `toml:"stream"`
+}
+
+// APIConfig - the UI/HTTP API, which also receives the client messages
+type APIConfig struct {
+	Addr     string `toml:"addr"`
+	Port     string `toml:"port"`
+	Auth     bool   `toml:"auth"`
+	Tokens   string `toml:"tokens"`
+	TLSCert  string `toml:"tls_cert"`
+	TLSKey   string `toml:"tls_key"`
+	ClientCA string `toml:"client_ca"`
+}
+
+// PeersConfig - how the node finds its peers
+type PeersConfig struct {
+	Known     []string `toml:"known"`
+	Seeds     string   `toml:"seeds"`
+	Discovery string   `toml:"discovery"`
+	Exchange  bool     `toml:"exchange"`
+	ViewSize  int      `toml:"view_size"`
+}
+
+// FoldersConfig - the data directories; the chunks are kept in a chunks folder inside them
+type FoldersConfig struct {
+	SharedFiles string `toml:"shared_files"`
+	Downloads   string `toml:"downloads"`
+	Keys        string `toml:"keys"`
+	State       string `toml:"state"`
+}
+
+// TimersConfig - every timer of the node, in seconds unless the key ends in _ms. A timer which may
+// be 0 is turned off by it
+type TimersConfig struct {
+	AntiEntropy               int `toml:"anti_entropy"`
+	RouteRumor                int `toml:"route_rumor"`
+	Mongering                 int `toml:"mongering"`
+	Stubborn                  int `toml:"stubborn"`
+	Probe                     int `toml:"probe"`
+	ProbeTimeoutMs            int `toml:"probe_timeout_ms"`
+	SuspectTimeout            int `toml:"suspect_timeout"`
+	DeadRetention             int `toml:"dead_retention"`
+	Shuffle                   int `toml:"shuffle"`
+	Discovery                 int `toml:"discovery"`
+	PeerExchange              int `toml:"peer_exchange"`
+	Resolve                   int `toml:"resolve"`
+	DownloadRetry             int `toml:"download_retry"`
+	SearchRetry               int `toml:"search_retry"`
+	DuplicateSearchMs         int `toml:"duplicate_search_ms"`
+	NamedDownloadSearch       int `toml:"named_download_search"`
+	StreamChunk               int `toml:"stream_chunk"`
+	DownloadCleanup           int `toml:"download_cleanup"`
+	FinishedDownloadRetention int `toml:"finished_download_retention"`
+	NameProof                 int `toml:"name_proof"`
+	MiningIdleMs              int `toml:"mining_idle_ms"`
+	Fragment                  int `toml:"fragment"`
+	Handshake                 int `toml:"handshake"`
+	PlaintextRetry            int `toml:"plaintext_retry"`
+	StreamDial                int `toml:"stream_dial"`
+	StreamWrite               int `toml:"stream_write"`
+	StreamRetry               int `toml:"stream_retry"`
+	BanDuration               int `toml:"ban_duration"`
+	BanWindow                 int `toml:"ban_window"`
+	LimitsCleanup             int `toml:"limits_cleanup"`
+	EventKeepAlive            int `toml:"event_keep_alive"`
+	Shutdown                  int `toml:"shutdown"`
+}
+
+// the timers which are turned off by 0
+var timersOffAtZero = map[string]bool{"anti_entropy": true, "route_rumor": true, "probe": true,
+	"finished_download_retention": true, "plaintext_retry": true, "stream_retry": true}
+
+// FilesConfig - file sharing
+type FilesConfig struct {
+	ChunkSize int `toml:"chunk_size"`
+}
+
+// SearchConfig - the budgets of the expanding ring searches
+type SearchConfig struct {
+	StartingBudget int `toml:"starting_budget"`
+	BudgetLimit    int `toml:"budget_limit"`
+	MaxBudget      int `toml:"max_budget"`
+	FullMatches    int `toml:"full_matches"`
+}
+
+// HopLimitsConfig - the hop limits of the point-to-point and flooded packets
+type HopLimitsConfig struct {
+	Default        int `toml:"default"`
+	TLCAck         int `toml:"tlc_ack"`
+	PoWTransaction int `toml:"pow_transaction"`
+	PoWBlock       int `toml:"pow_block"`
+	Max            int `toml:"max"`
+}
+
+// ConsensusConfig - the naming chain
+type ConsensusConfig struct {
+	Mode          string `toml:"mode"`
+	Nodes         int    `toml:"nodes"`
+	PoWDifficulty int    `toml:"pow_difficulty"`
+	Light         bool   `toml:"light"`
+}
+
+// SecurityConfig - the secure channels and rate limits between peers
+type SecurityConfig struct {
+	Encrypt         bool   `toml:"encrypt"`
+	NodeKey         string `toml:"node_key"`
+	AcceptPlaintext bool   `toml:"accept_plaintext"`
+	RateLimit       bool   `toml:"rate_limit"`
+}
+
+// LogConfig - the log of the node
+type LogConfig struct {
+	Format string `toml:"format"`
+	Level  string `toml:"level"`
+}
+
+// Default - the configuration of a node started without a configuration file or flags
+func Default() *Config {
+	return &Config{
+		Node:  NodeConfig{GossipAddr: "127.0.0.1:5000"},
+		API:   APIConfig{Addr: "127.0.0.1", Port: "8080", Tokens: "tokens.json"},
+		Peers: PeersConfig{Known: make([]string, 0), ViewSize: constants.DefaultViewSize},
+		Folders: FoldersConfig{SharedFiles: constants.SharedFilesFolder, Downloads: constants.DownloadedFilesFolder,
+			Keys: constants.NodeKeysFolder, State: constants.StateFolder},
+		Timers: TimersConfig{
+			AntiEntropy:               10,
+			Mongering:                 constants.MongeringTimeout,
+			Stubborn:                  5,
+			Probe:                     constants.DefaultProbePeriod,
+			ProbeTimeoutMs:            constants.ProbeTimeoutMillis,
+			SuspectTimeout:            constants.SuspectTimeout,
+			DeadRetention:             constants.DeadRetention,
+			Shuffle:                   constants.ShufflePeriod,
+			Discovery:                 constants.DiscoveryPeriod,
+			PeerExchange:              constants.PeerExchangePeriod,
+			Resolve:                   constants.ResolvePeriod,
+			DownloadRetry:             constants.DownloadRetryPeriod,
+			SearchRetry:               constants.SearchRetryPeriod,
+			DuplicateSearchMs:         constants.DuplicateSearchWindowMillis,
+			NamedDownloadSearch:       constants.NamedDownloadSearchTimeout,
+			StreamChunk:               constants.StreamChunkTimeout,
+			DownloadCleanup:           constants.DownloadCleanupPeriod,
+			FinishedDownloadRetention: constants.FinishedDownloadRetention,
+			NameProof:                 constants.NameProofTimeout,
+			MiningIdleMs:              constants.MiningIdlePeriod,
+			Fragment:                  constants.FragmentTimeout,
+			Handshake:                 constants.HandshakeTimeout,
+			PlaintextRetry:            constants.PlaintextRetryPeriod,
+			StreamDial:                constants.StreamDialTimeout,
+			StreamWrite:               constants.StreamWriteTimeout,
+			StreamRetry:               constants.StreamRetryPeriod,
+			BanDuration:               constants.DefaultBanDuration,
+			BanWindow:                 constants.BanWindow,
+			LimitsCleanup:             constants.LimitsCleanupPeriod,
+			EventKeepAlive:            constants.EventKeepAlivePeriod,
+			Shutdown:                  constants.ShutdownTimeout,
+		},
+		Files: FilesConfig{ChunkSize: constants.FixedChunkSize},
+		Search: SearchConfig{StartingBudget: int(constants.StartingRingSearchBudget),
+			BudgetLimit: int(constants.RingSearchBudgetLimit), MaxBudget: int(constants.MaxSearchBudget),
+			FullMatches: constants.FullMatchesThreshold},
+		HopLimits: HopLimitsConfig{Default: int(constants.DefaultHopLimit), TLCAck: 10,
+			PoWTransaction: int(constants.PoWTxHopLimit), PoWBlock: int(constants.PoWBlockHopLimit),
+			Max: int(constants.MaxHopLimit)},
+		Consensus: ConsensusConfig{Mode: ConsensusNone, Nodes: 1, PoWDifficulty: 16},
+		Security:  SecurityConfig{RateLimit: true},
+		Log:       LogConfig{Format: "legacy", Level: "info"},
+	}
+}
+
+// Validate - check that every value of the configuration is in its range. All the problems found
+// are reported at once
+func (c *Config) Validate() error {
+	problems := make([]string, 0)
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(strings.Compare(c.Node.Name, "") != 0, "node.name must be set")
+	_, validGossipAddr := helpers.NormalizeAddress(c.Node.GossipAddr)
+	check(validGossipAddr, "node.gossip_addr must be of the form ip:port, got %q", c.Node.GossipAddr)
+
+	check(strings.Compare(c.API.Addr, "") != 0, "api.addr must be set")
+	port, err := strconv.Atoi(c.API.Port)
+	check(err == nil && port > 0 && port <= 65535, "api.port must be a port number, got %q", c.API.Port)
+	check(strings.Compare(c.API.TLSKey, "") == 0 || strings.Compare(c.API.TLSCert, "") != 0,
+		"api.tls_key requires api.tls_cert")
+	check(strings.Compare(c.API.TLSCert, "") == 0 || strings.Compare(c.API.TLSKey, "") != 0,
+		"api.tls_cert requires api.tls_key")
+	check(strings.Compare(c.API.ClientCA, "") == 0 || strings.Compare(c.API.TLSCert, "") != 0,
+		"api.client_ca requires api.tls_cert")
+
+	for _, peer := range c.Peers.Known {
+		_, validPeer := helpers.NormalizeAddress(peer)
+		check(validPeer || helpers.IsPeerName(peer), "peers.known must hold ip:port or host:port, got %q", peer)
+	}
+	if strings.Compare(c.Peers.Discovery, "") != 0 {
+		_, _, err := net.SplitHostPort(c.Peers.Discovery)
+		check(err == nil, "peers.discovery must be of the form ip:port, got %q", c.Peers.Discovery)
+	}
+	check(c.Peers.ViewSize >= 0, "peers.view_size must not be negative")
+
+	check(strings.Compare(c.Folders.SharedFiles, "") != 0, "folders.shared_files must be set")
+	check(strings.Compare(c.Folders.Downloads, "") != 0, "folders.downloads must be set")
+	check(strings.Compare(c.Folders.Keys, "") != 0, "folders.keys must be set")
+	check(strings.Compare(c.Folders.State, "") != 0, "folders.state must be set")
+
+	timers := reflect.ValueOf(c.Timers)
+	for i := 0; i < timers.NumField(); i++ {
+		key := timers.Type().Field(i).Tag.Get("toml")
+		value := timers.Field(i).Int()
+		if timersOffAtZero[key] {
+			check(value >= 0, "timers.%s must not be negative", key)
+		} else {
+			check(value > 0, "timers.%s must be positive", key)
+		}
+	}
+
+	check(c.Files.ChunkSize >= 1<<10 && c.Files.ChunkSize <= 1<<16,
+		"files.chunk_size must be between 1024 and 65536 bytes")
+
+	check(c.Search.StartingBudget > 0, "search.starting_budget must be positive")
+	check(c.Search.StartingBudget <= c.Search.BudgetLimit, "search.budget_limit must be at least search.starting_budget")
+	check(c.Search.BudgetLimit <= c.Search.MaxBudget, "search.max_budget must be at least search.budget_limit")
+	check(c.Search.FullMatches > 0, "search.full_matches must be positive")
+
+	check(c.HopLimits.Max > 0, "hop_limits.max must be positive")
+	for key, hopLimit := range map[string]int{"default": c.HopLimits.Default, "tlc_ack": c.HopLimits.TLCAck,
+		"pow_transaction": c.HopLimits.PoWTransaction, "pow_block": c.HopLimits.PoWBlock} {
+		check(hopLimit > 0 && hopLimit <= c.HopLimits.Max, "hop_limits.%s must be between 1 and hop_limits.max", key)
+	}
+
+	switch c.Consensus.Mode {
+	case ConsensusNone, ConsensusHw3ex2, ConsensusPoW:
+	default:
+		problems = append(problems, fmt.Sprintf("consensus.mode must be %s, %s or %s, got %q",
+			ConsensusNone, ConsensusHw3ex2, ConsensusPoW, c.Consensus.Mode))
+	}
+	check(c.Consensus.Nodes > 0, "consensus.nodes must be positive")
+	check(c.Consensus.PoWDifficulty >= 0 && c.Consensus.PoWDifficulty <= 256,
+		"consensus.pow_difficulty must be between 0 and 256 bits")
+
+	if _, err := helpers.ParseLogFormat(c.Log.Format); err != nil {
+		problems = append(problems, "log.format: "+err.Error())
+	}
+	if _, err := helpers.ParseLogLevel(c.Log.Level); err != nil {
+		problems = append(problems, "log.level: "+err.Error())
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%v: %s", ErrInvalidConfig, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Settings - the folders, timers and limits the gossiper runs with
+func (c *Config) Settings() *core.Settings {
+	return &core.Settings{
+		SharedFilesFolder:           c.Folders.SharedFiles,
+		ShareFilesChunksFolder:      chunksFolder(c.Folders.SharedFiles),
+		DownloadedFilesFolder:       c.Folders.Downloads,
+		DownloadedFilesChunksFolder: chunksFolder(c.Folders.Downloads),
+		StateFolder:                 c.Folders.State,
+
+		FixedChunkSize: c.Files.ChunkSize,
+
+		StartingRingSearchBudget: uint64(c.Search.StartingBudget),
+		RingSearchBudgetLimit:    uint64(c.Search.BudgetLimit),
+		MaxSearchBudget:          uint64(c.Search.MaxBudget),
+		FullMatchesThreshold:     c.Search.FullMatches,
+
+		DefaultHopLimit:  uint32(c.HopLimits.Default),
+		PoWTxHopLimit:    uint32(c.HopLimits.PoWTransaction),
+		PoWBlockHopLimit: uint32(c.HopLimits.PoWBlock),
+		MaxHopLimit:      uint32(c.HopLimits.Max),
+
+		MongeringTimeout:            c.Timers.Mongering,
+		DownloadRetryPeriod:         c.Timers.DownloadRetry,
+		SearchRetryPeriod:           c.Timers.SearchRetry,
+		DuplicateSearchWindowMillis: c.Timers.DuplicateSearchMs,
+		NamedDownloadSearchTimeout:  c.Timers.NamedDownloadSearch,
+		NameProofTimeout:            c.Timers.NameProof,
+		MiningIdlePeriod:            c.Timers.MiningIdleMs,
+		EventKeepAlivePeriod:        c.Timers.EventKeepAlive,
+		StreamChunkTimeout:          c.Timers.StreamChunk,
+		DownloadCleanupPeriod:       c.Timers.DownloadCleanup,
+		FinishedDownloadRetention:   c.Timers.FinishedDownloadRetention,
+		FragmentTimeout:             c.Timers.Fragment,
+		StreamDialTimeout:           c.Timers.StreamDial,
+		StreamWriteTimeout:          c.Timers.StreamWrite,
+		StreamRetryPeriod:           c.Timers.StreamRetry,
+		HandshakeTimeout:            c.Timers.Handshake,
+		PlaintextRetryPeriod:        c.Timers.PlaintextRetry,
+		BanWindow:                   c.Timers.BanWindow,
+		LimitsCleanupPeriod:         c.Timers.LimitsCleanup,
+		ProbeTimeoutMillis:          c.Timers.ProbeTimeoutMs,
+		SuspectTimeout:              c.Timers.SuspectTimeout,
+		DeadRetention:               c.Timers.DeadRetention,
+		ShufflePeriod:               c.Timers.Shuffle,
+		DiscoveryPeriod:             c.Timers.Discovery,
+		PeerExchangePeriod:          c.Timers.PeerExchange,
+		ResolvePeriod:               c.Timers.Resolve,
+		ShutdownTimeout:             c.Timers.Shutdown,
+	}
+}
+
+// the folder of the chunks of a data directory
+func chunksFolder(folder string) string {
+	return strings.TrimSuffix(folder, "/") + "/chunks"
+}
@@ -0,0 +1,367 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Load - override the configuration with the given TOML file, if any, and then with the environment
+func (c *Config) Load(path string) error {
+	if strings.Compare(path, "") != 0 {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if err := c.decode(file, path); err != nil {
+			return err
+		}
+	}
+	return c.loadEnv()
+}
+
+// decode reads the subset of TOML a configuration is written in: tables of one level, and bare or
+// quoted keys set to strings, integers, booleans or arrays of strings, which may span several lines.
+// Unknown tables and keys are errors, and so are tables and keys given twice
+func (c *Config) decode(r io.Reader, path string) error {
+	var section reflect.Value
+	sectionName := ""
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	statement := ""
+	start := 0
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(stripComment(scanner.Text()))
+		if strings.Compare(statement, "") != 0 {
+			// the value of the previous line is an array which is not closed yet
+			statement += " " + text
+		} else if strings.Compare(text, "") == 0 {
+			continue
+		} else {
+			statement = text
+			start = line
+		}
+		if arrayIsOpen(statement) {
+			continue
+		}
+		text, statement = statement, ""
+
+		if strings.HasPrefix(text, "[") && strings.HasSuffix(text, "]") {
+			name, err := parseTOMLKey(strings.TrimSpace(text[1 : len(text)-1]))
+			if err != nil {
+				return fmt.Errorf("%s:%d: table %s: %v", path, start, text, err)
+			}
+			if seen[name] {
+				return fmt.Errorf("%s:%d: table [%s] given twice", path, start, name)
+			}
+			seen[name] = true
+			sectionName = name
+			var ok bool
+			if section, ok = fieldByKey(reflect.ValueOf(c).Elem(), sectionName); !ok {
+				return fmt.Errorf("%s:%d: unknown table [%s]", path, start, sectionName)
+			}
+			continue
+		}
+		rawKey, value, ok := splitKeyValue(text)
+		if !ok {
+			return fmt.Errorf("%s:%d: expected key = value", path, start)
+		}
+		if !section.IsValid() {
+			return fmt.Errorf("%s:%d: key outside of a table", path, start)
+		}
+		key, err := parseTOMLKey(rawKey)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", path, start, err)
+		}
+		field, ok := fieldByKey(section, key)
+		if !ok {
+			return fmt.Errorf("%s:%d: unknown key %s.%s", path, start, sectionName, key)
+		}
+		if seen[sectionName+"."+key] {
+			return fmt.Errorf("%s:%d: key %s.%s given twice", path, start, sectionName, key)
+		}
+		seen[sectionName+"."+key] = true
+		if err := setTOMLValue(field, value); err != nil {
+			return fmt.Errorf("%s:%d: %s.%s: %v", path, start, sectionName, key, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if strings.Compare(statement, "") != 0 {
+		return fmt.Errorf("%s:%d: array is not closed", path, start)
+	}
+	return nil
+}
+
+// loadEnv overrides every key set in the environment as PEERSTER_<SECTION>_<KEY>. Arrays are comma
+// separated
+func (c *Config) loadEnv() error {
+	return c.forEachKey(func(sectionName string, key string, field reflect.Value) error {
+		name := EnvPrefix + strings.ToUpper(sectionName+"_"+key)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return nil
+		}
+		if err := setEnvValue(field, value); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		return nil
+	})
+}
+
+// Write - write the configuration as a TOML file, which Load reads back
+func (c *Config) Write(w io.Writer) error {
+	sectionName := ""
+	return c.forEachKey(func(section string, key string, field reflect.Value) error {
+		if strings.Compare(section, sectionName) != 0 {
+			if strings.Compare(sectionName, "") != 0 {
+				fmt.Fprintln(w)
+			}
+			sectionName = section
+			fmt.Fprintf(w, "[%s]\n", section)
+		}
+		_, err := fmt.Fprintf(w, "%s = %s\n", key, encodeTOMLValue(field))
+		return err
+	})
+}
+
+// calls f on every key of every section of the configuration, in the order they are declared
+func (c *Config) forEachKey(f func(section string, key string, field reflect.Value) error) error {
+	config := reflect.ValueOf(c).Elem()
+	for i := 0; i < config.NumField(); i++ {
+		section := config.Field(i)
+		sectionName := config.Type().Field(i).Tag.Get("toml")
+		for j := 0; j < section.NumField(); j++ {
+			if err := f(sectionName, section.Type().Field(j).Tag.Get("toml"), section.Field(j)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// the field of a struct with the given toml tag
+func fieldByKey(v reflect.Value, key string) (reflect.Value, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		if strings.Compare(v.Type().Field(i).Tag.Get("toml"), key) == 0 {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+// the line without its comment, if any; a # inside a string does not start a comment
+func stripComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// splits a key = value statement on the first = outside of a quoted key
+func splitKeyValue(text string) (string, string, bool) {
+	var quote rune
+	escaped := false
+	for i, c := range text {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '=':
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), true
+		}
+	}
+	return "", "", false
+}
+
+// a bare key of letters, digits, - and _, or a quoted key
+func parseTOMLKey(key string) (string, error) {
+	if strings.HasPrefix(key, "\"") || strings.HasPrefix(key, "'") {
+		return parseTOMLString(key)
+	}
+	if strings.Compare(key, "") == 0 {
+		return "", fmt.Errorf("empty key")
+	}
+	for _, c := range key {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return "", fmt.Errorf("invalid key %s", key)
+		}
+	}
+	return key, nil
+}
+
+// whether the statement sets a key to an array which is not closed yet
+func arrayIsOpen(statement string) bool {
+	_, value, ok := splitKeyValue(statement)
+	if !ok || !strings.HasPrefix(value, "[") {
+		return false
+	}
+	depth := 0
+	var quote rune
+	escaped := false
+	for _, c := range value {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '[':
+			depth++
+		case quote == 0 && c == ']':
+			depth--
+		}
+	}
+	return depth > 0
+}
+
+func setTOMLValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		s, err := parseTOMLString(value)
+		if err != nil {
+			return err
+		}
+		field.SetString(s)
+	case reflect.Int:
+		n, err := strconv.ParseInt(strings.Replace(value, "_", "", -1), 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %s", value)
+		}
+		field.SetInt(n)
+	case reflect.Bool:
+		switch value {
+		case "true":
+			field.SetBool(true)
+		case "false":
+			field.SetBool(false)
+		default:
+			return fmt.Errorf("expected true or false, got %s", value)
+		}
+	case reflect.Slice:
+		if !strings.HasPrefix(value, "[") || !strings.HasSuffix(value, "]") {
+			return fmt.Errorf("expected an array of strings, got %s", value)
+		}
+		list := make([]string, 0)
+		for _, item := range splitArray(value[1 : len(value)-1]) {
+			s, err := parseTOMLString(item)
+			if err != nil {
+				return err
+			}
+			list = append(list, s)
+		}
+		field.Set(reflect.ValueOf(list))
+	}
+	return nil
+}
+
+func setEnvValue(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Int:
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("expected an integer, got %s", value)
+		}
+		field.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("expected true or false, got %s", value)
+		}
+		field.SetBool(b)
+	case reflect.Slice:
+		list := make([]string, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); strings.Compare(item, "") != 0 {
+				list = append(list, item)
+			}
+		}
+		field.Set(reflect.ValueOf(list))
+	}
+	return nil
+}
+
+func encodeTOMLValue(field reflect.Value) string {
+	switch field.Kind() {
+	case reflect.String:
+		return strconv.Quote(field.String())
+	case reflect.Slice:
+		items := make([]string, 0, field.Len())
+		for i := 0; i < field.Len(); i++ {
+			items = append(items, strconv.Quote(field.Index(i).String()))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	}
+	return fmt.Sprint(field.Interface())
+}
+
+// a basic "string" or a literal 'string'
+func parseTOMLString(value string) (string, error) {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return value[1 : len(value)-1], nil
+	}
+	if len(value) >= 2 && value[0] == '"' {
+		if s, err := strconv.Unquote(value); err == nil {
+			return s, nil
+		}
+	}
+	return "", fmt.Errorf("expected a string, got %s", value)
+}
+
+// the items of an array, split on the commas outside of strings; a trailing comma is allowed
+func splitArray(items string) []string {
+	list := make([]string, 0)
+	var quote rune
+	escaped := false
+	start := 0
+	for i, c := range items {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == ',':
+			list = append(list, strings.TrimSpace(items[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(items[start:]); strings.Compare(last, "") != 0 {
+		list = append(list, last)
+	}
+	return list
+}
@@ -0,0 +1,137 @@
+package config
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		check func(c *Config) bool
+	}{
+		{
+			name:  "strings, integers and booleans",
+			input: "[node]\nname = \"A\"\ngossip_addr = '127.0.0.1:5001'\nstream = true\n[files]\nchunk_size = 4_096\n",
+			check: func(c *Config) bool {
+				return c.Node.Name == "A" && c.Node.GossipAddr == "127.0.0.1:5001" && c.Node.Stream &&
+					c.Files.ChunkSize == 4096
+			},
+		},
+		{
+			name:  "comments",
+			input: "# a node\n[node] # the node\nname = \"A#1\" # not part of the name\n",
+			check: func(c *Config) bool { return c.Node.Name == "A#1" },
+		},
+		{
+			name:  "single-line array",
+			input: "[peers]\nknown = [\"127.0.0.1:5001\", '127.0.0.1:5002']\n",
+			check: func(c *Config) bool {
+				return reflect.DeepEqual(c.Peers.Known, []string{"127.0.0.1:5001", "127.0.0.1:5002"})
+			},
+		},
+		{
+			name: "multi-line array",
+			input: "[peers]\nknown = [\n  \"127.0.0.1:5001\", # first\n  \"127.0.0.1:5002\",\n]\n" +
+				"[node]\nname = \"A\"\n",
+			check: func(c *Config) bool {
+				return reflect.DeepEqual(c.Peers.Known, []string{"127.0.0.1:5001", "127.0.0.1:5002"}) &&
+					c.Node.Name == "A"
+			},
+		},
+		{
+			name:  "bracket inside a string of an array",
+			input: "[peers]\nknown = [\"[::1]:5001\",\n\"]\"]\n",
+			check: func(c *Config) bool { return reflect.DeepEqual(c.Peers.Known, []string{"[::1]:5001", "]"}) },
+		},
+		{
+			name:  "quoted keys and tables",
+			input: "[\"node\"]\n\"name\" = \"A\"\n'gossip_addr' = \"127.0.0.1:5001\"\n",
+			check: func(c *Config) bool { return c.Node.Name == "A" && c.Node.GossipAddr == "127.0.0.1:5001" },
+		},
+		{
+			name:  "escapes",
+			input: "[node]\nname = \"A\\\"B\"\n",
+			check: func(c *Config) bool { return c.Node.Name == "A\"B" },
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := Default()
+			if err := c.decode(strings.NewReader(test.input), "test.toml"); err != nil {
+				t.Fatalf("decode: %v", err)
+			}
+			if !test.check(c) {
+				t.Errorf("unexpected configuration %+v", c)
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"unknown table", "[nodes]\n", "test.toml:1: unknown table [nodes]"},
+		{"unknown key", "[node]\nnick = \"A\"\n", "test.toml:2: unknown key node.nick"},
+		{"key outside of a table", "name = \"A\"\n", "test.toml:1: key outside of a table"},
+		{"missing value", "[node]\nname\n", "test.toml:2: expected key = value"},
+		{"duplicate key", "[node]\nname = \"A\"\nname = \"B\"\n", "test.toml:3: key node.name given twice"},
+		{"duplicate quoted key", "[node]\nname = \"A\"\n\"name\" = \"B\"\n", "test.toml:3: key node.name given twice"},
+		{"duplicate table", "[node]\n[api]\n[node]\n", "test.toml:3: table [node] given twice"},
+		{"invalid key", "[node]\nna me = \"A\"\n", "test.toml:2: invalid key na me"},
+		{"array of tables", "[[node]]\n", "test.toml:1: table [[node]]: invalid key [node]"},
+		{"unclosed array", "[peers]\nknown = [\n\"127.0.0.1:5001\",\n", "test.toml:2: array is not closed"},
+		{"wrong type", "[files]\nchunk_size = \"big\"\n", "test.toml:2: files.chunk_size: expected an integer"},
+		{"unterminated string", "[node]\nname = \"A\n", "test.toml:2: node.name: expected a string"},
+		{"not a boolean", "[node]\nstream = yes\n", "test.toml:2: node.stream: expected true or false"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := Default().decode(strings.NewReader(test.input), "test.toml")
+			if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+				t.Errorf("got error %v, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteReadsBack(t *testing.T) {
+	written := Default()
+	written.Node.Name = "A \"quoted\" name"
+	written.Peers.Known = []string{"127.0.0.1:5001", "[::1]:5002"}
+	written.Timers.Probe = 0
+
+	var file bytes.Buffer
+	if err := written.Write(&file); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	read := Default()
+	read.Peers.Known = nil
+	if err := read.decode(&file, "written.toml"); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if !reflect.DeepEqual(read, written) {
+		t.Errorf("read back %+v, want %+v", read, written)
+	}
+}
+
+func TestSettings(t *testing.T) {
+	c := Default()
+	c.Folders.SharedFiles = "./shared/"
+	c.Folders.Downloads = "./downloads"
+	c.Timers.DuplicateSearchMs = 250
+	settings := c.Settings()
+	if settings.ShareFilesChunksFolder != "./shared/chunks" || settings.DownloadedFilesChunksFolder != "./downloads/chunks" {
+		t.Errorf("unexpected chunks folders %q and %q", settings.ShareFilesChunksFolder,
+			settings.DownloadedFilesChunksFolder)
+	}
+	if settings.DuplicateSearchWindowMillis != 250 {
+		t.Errorf("got duplicate search window %d, want 250", settings.DuplicateSearchWindowMillis)
+	}
+}
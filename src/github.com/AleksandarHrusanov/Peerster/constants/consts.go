@@ -1,3 +1,5 @@
+// Package constants holds the constants of Peerster, and the defaults of the settings a node may be
+// configured with
 package constants
 
 // SharedFilesFolder - a relative path for the _SharedFiles from the main Peerster executable
@@ -12,7 +14,7 @@ const DownloadedFilesFolder = "./_Downloads/"
 // DownloadedFilesChunksFolder - a relative path for the _Downloads/chunks from the main Peerster executable
 const DownloadedFilesChunksFolder = "./_Downloads/chunks"
 
-// FixedChunkSize = the chunk limit of 8KB
+// FixedChunkSize - the size of the chunks files are divided in, 8KB by default; all the nodes must use the same
 const FixedChunkSize = 8192
 
 // FileMode - mode for creating files/directories
@@ -21,8 +23,203 @@ const FileMode = 0755
 // HashSize = the size of a sha256 hash
 const HashSize = 32
 
+// StartingRingSearchBudget - the budget of the first round of an expanding ring search
 const StartingRingSearchBudget = uint64(2)
 
+// RingSearchBudgetLimit - the largest budget an expanding ring search is doubled up to
 const RingSearchBudgetLimit = uint64(32)
 
+// FullMatchesThreshold - the number of full matches after which a search stops
 const FullMatchesThreshold = 2
+
+// DefaultHopLimit - hop limit of the point-to-point packets: private messages, data, search replies and name proofs
+const DefaultHopLimit = uint32(10)
+
+// MongeringTimeout - seconds to wait for the status answering a rumor before mongering it elsewhere
+const MongeringTimeout = 10
+
+// DownloadRetryPeriod - seconds to wait for a data reply before requesting the chunk again
+const DownloadRetryPeriod = 5
+
+// SearchRetryPeriod - seconds between two rounds of an expanding ring search
+const SearchRetryPeriod = 1
+
+// DuplicateSearchWindowMillis - milliseconds a search request is remembered, so that its duplicates are dropped
+const DuplicateSearchWindowMillis = 500
+
+// NamedDownloadSearchTimeout - seconds to wait for a search to locate a file resolved by name
+const NamedDownloadSearchTimeout = 10
+
+// NameProofTimeout - seconds a light client waits for a full node to answer a name lookup
+const NameProofTimeout = 3
+
+// NameProofAttempts - number of full nodes a light client asks before giving up on a name lookup
+const NameProofAttempts = 3
+
+// MaxReorgEvents - number of the latest chain reorganisations a node remembers
+const MaxReorgEvents = 100
+
+// MaxOrphans - the most blocks kept aside until their parent is confirmed
+const MaxOrphans = 256
+
+// MaxOrphansPerOrigin - the most blocks of one origin kept aside until their parent is confirmed
+const MaxOrphansPerOrigin = 16
+
+// OrphanTimeout - seconds a block is kept aside waiting for its parent before it is dropped
+const OrphanTimeout = 300
+
+// MiningIdlePeriod - milliseconds a miner waits before checking again for pending transactions
+const MiningIdlePeriod = 100
+
+// MiningHeadCheckInterval - number of nonces a miner tries between checks for a new chain head
+const MiningHeadCheckInterval = 1 << 12
+
+// PoWTxHopLimit - hop limit of transactions flooded in proof-of-work mode
+const PoWTxHopLimit = uint32(10)
+
+// PoWBlockHopLimit - hop limit of blocks flooded in proof-of-work mode
+const PoWBlockHopLimit = uint32(20)
+
+// EventBufferSize - number of events buffered for each event stream subscriber before events are dropped
+const EventBufferSize = 64
+
+// EventKeepAlivePeriod - seconds between keep-alive comments on an idle event stream
+const EventKeepAlivePeriod = 15
+
+// MaxUploadSize - the largest file accepted by the upload endpoint of the HTTP API, in bytes
+const MaxUploadSize = 256 << 20
+
+// StreamChunkTimeout - seconds a reader of a downloading file waits for a missing chunk
+const StreamChunkTimeout = 30
+
+// DownloadCleanupPeriod - seconds between two removals of the states of finished downloads
+const DownloadCleanupPeriod = 5
+
+// FinishedDownloadRetention - seconds the statistics of a finished download are kept for
+const FinishedDownloadRetention = 300
+
+// MaxUDPPacketSize - the largest gossip packet sent in a single datagram, in bytes. Peers have always
+// read datagrams of up to this size, so only larger packets are split into fragments
+const MaxUDPPacketSize = 9216
+
+// MaxFragmentSize - the largest fragment datagram, in bytes, small enough not to be split by IP
+const MaxFragmentSize = 1200
+
+// MaxDatagramSize - the size of the buffers datagrams are read into, enough for any UDP datagram
+const MaxDatagramSize = 65536
+
+// MaxClientMessageSize - the largest client message sent to the gossiper over UDP, in bytes
+const MaxClientMessageSize = 65507
+
+// FragmentOverhead - bytes of a fragment's packet taken by its header rather than by the packet it carries
+const FragmentOverhead = 64
+
+// MaxFragments - the largest number of fragments a gossip packet is split into; larger packets are rejected
+const MaxFragments = 128
+
+// FragmentTimeout - seconds the fragments of a packet are kept while waiting for the missing ones
+const FragmentTimeout = 10
+
+// MaxPendingReassemblies - the largest number of partially received packets kept at once
+const MaxPendingReassemblies = 256
+
+// MaxPendingReassembliesPerPeer - the largest number of partially received packets kept for one sender
+const MaxPendingReassembliesPerPeer = 8
+
+// MaxReassemblyBytes - the largest number of fragment bytes kept at once while waiting for missing fragments
+const MaxReassemblyBytes = 8 << 20
+
+// MaxStreamFrameSize - the largest frame accepted on a stream connection, in bytes
+const MaxStreamFrameSize = 1 << 20
+
+// StreamDialTimeout - seconds to set up a stream connection to a peer, handshake included
+const StreamDialTimeout = 2
+
+// StreamWriteTimeout - seconds a frame may take to be written before the stream connection is dropped
+const StreamWriteTimeout = 5
+
+// StreamQueueSize - frames waiting to be written to a stream connection; further packets go over UDP
+const StreamQueueSize = 64
+
+// StreamRetryPeriod - seconds before trying again to set up a stream connection to a peer which failed
+const StreamRetryPeriod = 60
+
+// NodeKeysFolder - a relative path for the files holding the keys of the nodes
+const NodeKeysFolder = "./_Keys/"
+
+// StateFolder - a relative path for the files holding the state the nodes save when they stop
+const StateFolder = "./_State/"
+
+// HandshakeTimeout - seconds to wait for the answer to a handshake before sending it again
+const HandshakeTimeout = 1
+
+// HandshakeAttempts - times a handshake is sent to a peer before giving up on it
+const HandshakeAttempts = 3
+
+// MaxQueuedDatagrams - the largest number of datagrams kept for a peer while the handshake with it is running
+const MaxQueuedDatagrams = 64
+
+// PlaintextRetryPeriod - seconds a peer which did not answer the handshake is sent plaintext before
+// trying again, when plaintext peers are accepted
+const PlaintextRetryPeriod = 60
+
+// MaxSearchBudget - the largest budget of a search request received from a peer; larger budgets are lowered
+const MaxSearchBudget = uint64(64)
+
+// MaxHopLimit - the largest hop limit of a packet received from a peer; larger hop limits are lowered
+const MaxHopLimit = uint32(32)
+
+// BanThreshold - packets over the rate limits a peer may send within a BanWindow before being banned
+const BanThreshold = 200
+
+// BanWindow - seconds over which the packets of a peer over the rate limits are counted
+const BanWindow = 10
+
+// DefaultBanDuration - seconds the packets of a banned peer are dropped for
+const DefaultBanDuration = 60
+
+// LimitsCleanupPeriod - seconds between two removals of the rate limits of peers which went quiet
+const LimitsCleanupPeriod = 60
+
+// DefaultProbePeriod - seconds between two probes of the failure detector
+const DefaultProbePeriod = 2
+
+// ProbeTimeoutMillis - milliseconds to wait for the ack of a direct probe before asking other peers to probe
+const ProbeTimeoutMillis = 500
+
+// IndirectProbes - peers asked to probe a peer which did not answer a direct probe
+const IndirectProbes = 3
+
+// MissedProbesBeforeSuspect - probes in a row a peer which never acked a probe may leave unanswered,
+// without sending any other packet, before it is suspected
+const MissedProbesBeforeSuspect = 3
+
+// SuspectTimeout - seconds a suspect peer has to be heard from before it is declared dead and evicted
+const SuspectTimeout = 15
+
+// DeadRetention - seconds a dead peer keeps being probed, so that it joins again once it is back
+const DeadRetention = 600
+
+// DefaultViewSize - the most peers a node knows directly, so that its degree stays small in large networks
+const DefaultViewSize = 20
+
+// ShufflePeriod - seconds between two shuffles of the partial view with a peer
+const ShufflePeriod = 10
+
+// ShuffleLength - the most peers exchanged in a shuffle of the partial view
+const ShuffleLength = 5
+
+// DiscoveryPeriod - seconds between two announcements of a node on the discovery group
+const DiscoveryPeriod = 10
+
+// PeerExchangePeriod - seconds between two requests for peers while the partial view has room
+const PeerExchangePeriod = 15
+
+// PeerExchangeSize - the most peers shared in answer to a peer exchange request
+const PeerExchangeSize = 8
+
+// ResolvePeriod - seconds between two resolutions of the peers given by host name
+const ResolvePeriod = 60
+
+// ShutdownTimeout - seconds a stopping node lets its downloads finish, and then waits for its routines
+const ShutdownTimeout = 10
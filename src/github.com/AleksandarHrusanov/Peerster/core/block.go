@@ -0,0 +1,75 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+// BlockHeader - the part of a block which light clients keep; it commits to the transaction
+// and to the Merkle root of the name table after applying the block
+type BlockHeader struct {
+	PrevHash [32]byte
+	TxHash   [32]byte
+	NameRoot [32]byte
+	Nonce    uint64
+}
+
+// Header - returns the header of the block
+func (b *BlockPublish) Header() BlockHeader {
+	return BlockHeader{PrevHash: b.PrevHash, TxHash: b.Transaction.Hash(), NameRoot: b.NameRoot, Nonce: b.Nonce}
+}
+
+// Hash - returns the hash identifying the block
+func (b *BlockPublish) Hash() [32]byte {
+	header := b.Header()
+	return header.Hash()
+}
+
+// Hash - returns the hash of the block the header belongs to. The name root and the nonce are only
+// hashed when set, so blocks without them keep the same hash as on nodes which do not know about them
+func (bh *BlockHeader) Hash() (out [32]byte) {
+	h := sha256.New()
+	h.Write(bh.PrevHash[:])
+	h.Write(bh.TxHash[:])
+	if bh.NameRoot != [32]byte{} {
+		h.Write(bh.NameRoot[:])
+	}
+	if bh.Nonce != 0 {
+		binary.Write(h, binary.LittleEndian, bh.Nonce)
+	}
+	copy(out[:], h.Sum(nil))
+	return
+}
+
+// Hash - returns the hash of the transaction. Claims hash only the name and metahash, so they stay
+// compatible with nodes which do not know about the other transaction types
+func (t *TxPublish) Hash() (out [32]byte) {
+	h := sha256.New()
+	binary.Write(h, binary.LittleEndian, uint32(len(t.Name)))
+	h.Write([]byte(t.Name))
+	h.Write(t.MetafileHash)
+	if t.Type != TxClaim {
+		binary.Write(h, binary.LittleEndian, uint32(t.Type))
+		h.Write([]byte(t.NewOwner))
+	}
+	if t.Origin != "" {
+		h.Write([]byte(t.Origin))
+	}
+	copy(out[:], h.Sum(nil))
+	return
+}
+
+// String - returns the name of the transaction type
+func (t TxType) String() string {
+	switch t {
+	case TxClaim:
+		return "claim"
+	case TxUpdate:
+		return "update"
+	case TxTransfer:
+		return "transfer"
+	case TxRevoke:
+		return "revoke"
+	}
+	return "unknown"
+}
@@ -2,9 +2,11 @@ package core
 
 import (
 	"encoding/hex"
+	"errors"
 	"net"
 	"strings"
 
+	"github.com/AleksandarHrusanov/Peerster/constants"
 	"github.com/AleksandarHrusanov/Peerster/helpers"
 	"github.com/dedis/protobuf"
 )
@@ -12,10 +14,11 @@ import (
 // ClientConnectAndSend connects to the given gossiper's address and send the text to it.
 // This function is used by the server to send a message to the gossiper.
 func ClientConnectAndSend(remoteAddr string, text *string, destination *string, fileToShare *string,
-	request *string, keywords *string, budget *uint64) {
+	request *string, keywords *string, budget *uint64, name *string) {
 	// Create GossipPacket and encapsulate message into it
 	requestBytes := make([]byte, 0)
-	msg := &Message{Text: *text, Destination: destination, File: fileToShare, Keywords: keywords, Budget: budget}
+	msg := &Message{Text: *text, Destination: destination, File: fileToShare, Keywords: keywords, Budget: budget,
+		Name: name}
 	if strings.Compare(*request, "") != 0 {
 		decoded, err := hex.DecodeString(*request)
 		if err == nil {
@@ -23,14 +26,24 @@ func ClientConnectAndSend(remoteAddr string, text *string, destination *string,
 		}
 	}
 	msg.Request = &requestBytes
+	ClientSendMessage(remoteAddr, msg)
+}
+
+// ErrClientMessageTooLarge - a client message does not fit in a single datagram
+var ErrClientMessageTooLarge = errors.New("message is too large to be sent to the gossiper over UDP")
+
+// ClientSendMessage encodes an already built client message and sends it to the given gossiper's address.
+func ClientSendMessage(remoteAddr string, msg *Message) {
 	packetBytes, err := protobuf.Encode(msg)
 	helpers.HandleErrorFatal(err)
+	if len(packetBytes) > constants.MaxClientMessageSize {
+		helpers.HandleErrorFatal(ErrClientMessageTooLarge)
+	}
 
 	// Connect
-	dst, err := net.ResolveUDPAddr("udp4", remoteAddr)
-	_ = dst
+	dst, err := net.ResolveUDPAddr("udp", remoteAddr)
 	helpers.HandleErrorFatal(err)
-	conn, err := net.DialUDP("udp4", nil, dst)
+	conn, err := net.DialUDP("udp", nil, dst)
 	helpers.HandleErrorFatal(err)
 	defer conn.Close()
 
@@ -47,12 +60,14 @@ func ConnectAndSend(addressAndPort string, conn *net.UDPConn, packetToSend []byt
 	}
 
 	// Resolve destination address
-	dst, err := net.ResolveUDPAddr("udp4", addressAndPort)
-	helpers.HandleErrorFatal(err)
-
-	// Send packet
-	_, err = conn.WriteToUDP(packetToSend, dst)
-	helpers.HandleErrorFatal(err)
+	dst, err := net.ResolveUDPAddr("udp", addressAndPort)
+	if err == nil {
+		// Send packet; an IPv4 socket cannot reach an IPv6 peer and the other way round
+		_, err = conn.WriteToUDP(packetToSend, dst)
+	}
+	if err != nil {
+		helpers.GossipLog.Debug("cannot send datagram", helpers.F("peer", addressAndPort), helpers.F("error", err))
+	}
 }
 
 // ContainsRumor Check if a list contains a Rumor, return it if true
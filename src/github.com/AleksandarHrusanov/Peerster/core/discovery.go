@@ -0,0 +1,150 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+	"github.com/dedis/protobuf"
+)
+
+// ErrInvalidAnnouncement - a datagram received on the discovery group is not the announcement of a node
+var ErrInvalidAnnouncement = errors.New("invalid discovery announcement")
+
+// the first bytes of every announcement, so that other traffic on the discovery group is ignored
+var announcementMagic = []byte("PDA1")
+
+// DiscoveryAnnouncement - sent periodically by a node to the discovery group of the local network,
+// so that the other nodes there add it to their known peers
+type DiscoveryAnnouncement struct {
+	Name    string
+	Address string
+}
+
+// SafePeerExchanges - the peers asked for some of their peers, by address, and when. Only their
+// replies are accepted
+type SafePeerExchanges struct {
+	Requested     map[string]time.Time
+	ExchangesLock sync.Mutex
+}
+
+// EncodeAnnouncement - the datagram announcing this gossiper on the discovery group
+func (g *Gossiper) EncodeAnnouncement() []byte {
+	announcement, err := protobuf.Encode(&DiscoveryAnnouncement{Name: g.Name, Address: g.Address.String()})
+	helpers.HandleErrorFatal(err)
+	return append(append([]byte{}, announcementMagic...), announcement...)
+}
+
+// DecodeAnnouncement - the gossip address announced in a datagram received from the given address.
+// The announced host has to be the one the datagram came from, unless it is unspecified or loopback
+func DecodeAnnouncement(datagram []byte, from *net.UDPAddr) (string, error) {
+	if len(datagram) <= len(announcementMagic) || !bytes.Equal(datagram[:len(announcementMagic)], announcementMagic) {
+		return "", ErrInvalidAnnouncement
+	}
+	var announcement DiscoveryAnnouncement
+	if err := protobuf.Decode(datagram[len(announcementMagic):], &announcement); err != nil {
+		return "", ErrInvalidAnnouncement
+	}
+	address, ok := announcedAddress(announcement.Address, from.String())
+	if !ok {
+		return "", ErrInvalidAnnouncement
+	}
+	host, port, _ := net.SplitHostPort(address)
+	ip := net.ParseIP(host)
+	switch {
+	case ip != nil && ip.IsLoopback() && !from.IP.IsLoopback():
+		// a node on another machine is not reached at its loopback address, but at the one it sent from
+		if address, ok = helpers.NormalizeAddress(net.JoinHostPort(from.IP.String(), port)); !ok {
+			return "", ErrInvalidAnnouncement
+		}
+	case ip != nil && ip.IsLoopback():
+	case ip == nil || !ip.Equal(from.IP):
+		// a node only announces itself, not other hosts it could point the group at
+		return "", ErrInvalidAnnouncement
+	}
+	return address, nil
+}
+
+// the address a node announced itself at, normalized. A node listening on an unspecified host, e.g.
+// 0.0.0.0 or [::], is reached at the host its announcement came from
+func announcedAddress(address string, fromAddr string) (string, bool) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", false
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		if host, _, err = net.SplitHostPort(fromAddr); err != nil {
+			return "", false
+		}
+	}
+	return helpers.NormalizeAddress(net.JoinHostPort(host, port))
+}
+
+// AddDiscoveredPeers - add the peers found through seeds, the discovery group or peer exchange to the
+// known peers, without duplicates, this node, peers of an address family it cannot reach or peers the
+// user removed, and while the partial view has room. Returns how many were added
+func (g *Gossiper) AddDiscoveredPeers(addresses []string, source string) int {
+	g.PeersLock.Lock()
+	knownPeers := g.KnownPeers
+	g.PeersLock.Unlock()
+
+	added := 0
+	for _, address := range helpers.VerifyRemoveDuplicateAddrInSlice(addresses) {
+		if g.IsSelf(address) || helpers.SliceContainsString(knownPeers, address) ||
+			!g.CanReach(address) {
+			continue
+		}
+		if g.joinKnownPeers(address) {
+			added++
+			helpers.GossipLog.Info("peer discovered", helpers.F("peer", address), helpers.F("source", source))
+		}
+	}
+	if added > 0 {
+		g.CountMetric(MetricPeersDiscovered, uint64(added))
+	}
+	return added
+}
+
+// WantsMorePeers - whether the partial view has room for more peers. Without a partial view, the
+// known peers are not bounded and a node always wants more
+func (g *Gossiper) WantsMorePeers() bool {
+	if g.View == nil {
+		return true
+	}
+	g.PeersLock.Lock()
+	known := len(g.KnownPeers)
+	g.PeersLock.Unlock()
+	return known < g.View.Size
+}
+
+// RequestPeerExchange - remember that a peer was asked for some of its peers, so that its reply is accepted
+func (g *Gossiper) RequestPeerExchange(address string) {
+	g.Exchanges.ExchangesLock.Lock()
+	g.Exchanges.Requested[address] = time.Now()
+	g.Exchanges.ExchangesLock.Unlock()
+}
+
+// AcceptPeerExchange - whether a reply with peers comes from a peer asked for them recently. Each
+// request is answered once; unsolicited replies are dropped
+func (g *Gossiper) AcceptPeerExchange(address string) bool {
+	g.Exchanges.ExchangesLock.Lock()
+	defer g.Exchanges.ExchangesLock.Unlock()
+	now := time.Now()
+	for peer, requestedAt := range g.Exchanges.Requested {
+		if now.Sub(requestedAt) > time.Duration(g.Settings.PeerExchangePeriod)*time.Second {
+			delete(g.Exchanges.Requested, peer)
+		}
+	}
+	_, requested := g.Exchanges.Requested[address]
+	delete(g.Exchanges.Requested, address)
+	return requested
+}
+
+// PickExchangePeers - up to PeerExchangeSize random alive peers to share with the peer asking for them
+func (g *Gossiper) PickExchangePeers(requester string) []string {
+	return g.pickMembers(constants.PeerExchangeSize, requester, isAlive)
+}
@@ -0,0 +1,92 @@
+package core
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/dedis/protobuf"
+)
+
+func TestDecodeAnnouncement(t *testing.T) {
+	announcement := func(address string) []byte {
+		body, err := protobuf.Encode(&DiscoveryAnnouncement{Name: "A", Address: address})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return append(append([]byte{}, announcementMagic...), body...)
+	}
+	remote := &net.UDPAddr{IP: net.ParseIP("192.168.1.20"), Port: 40000}
+	local := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 40000}
+
+	tests := []struct {
+		name     string
+		datagram []byte
+		from     *net.UDPAddr
+		want     string
+	}{
+		{"announced address", announcement("192.168.1.20:5000"), remote, "192.168.1.20:5000"},
+		{"unspecified host", announcement("0.0.0.0:5000"), remote, "192.168.1.20:5000"},
+		{"loopback from another machine", announcement("127.0.0.1:5000"), remote, "192.168.1.20:5000"},
+		{"loopback from this machine", announcement("127.0.0.1:5000"), local, "127.0.0.1:5000"},
+		{"IPv4-mapped address", announcement("[::ffff:192.168.1.20]:5000"), remote, "192.168.1.20:5000"},
+		{"IPv6 loopback from this machine", announcement("[::1]:5000"), local, "[::1]:5000"},
+		// anyone on the group could otherwise point the nodes at a host to flood
+		{"another host", announcement("192.168.1.30:5000"), remote, ""},
+		{"another host from this machine", announcement("192.168.1.30:5000"), local, ""},
+		{"no magic", announcement("192.168.1.20:5000")[len(announcementMagic):], remote, ""},
+		{"magic only", announcementMagic, remote, ""},
+		{"not an announcement", append(append([]byte{}, announcementMagic...), 0xff), remote, ""},
+		{"host name", announcement("node-a:5000"), remote, ""},
+		{"no port", announcement("192.168.1.20:0"), remote, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := DecodeAnnouncement(test.datagram, test.from)
+			if got != test.want || (test.want == "") != (err == ErrInvalidAnnouncement) {
+				t.Errorf("got %q, %v, want %q", got, err, test.want)
+			}
+		})
+	}
+}
+
+func TestAcceptPeerExchange(t *testing.T) {
+	g := newTestGossiper(t, "A")
+	if g.AcceptPeerExchange("127.0.0.1:5001") {
+		t.Error("unsolicited reply accepted")
+	}
+	g.RequestPeerExchange("127.0.0.1:5001")
+	if g.AcceptPeerExchange("127.0.0.1:5002") {
+		t.Error("reply from a peer not asked accepted")
+	}
+	if !g.AcceptPeerExchange("127.0.0.1:5001") {
+		t.Error("reply from the peer asked refused")
+	}
+	if g.AcceptPeerExchange("127.0.0.1:5001") {
+		t.Error("second reply to one request accepted")
+	}
+
+	g.RequestPeerExchange("127.0.0.1:5003")
+	g.Exchanges.Requested["127.0.0.1:5003"] = time.Now().Add(-time.Duration(2*constants.PeerExchangePeriod) * time.Second)
+	if g.AcceptPeerExchange("127.0.0.1:5003") {
+		t.Error("reply to an expired request accepted")
+	}
+}
+
+func TestAddDiscoveredPeers(t *testing.T) {
+	g := newTestGossiper(t, "A", "127.0.0.1:5001")
+	g.View = NewSafePeerView(4)
+	g.Membership.Removed["127.0.0.1:5002"] = true
+
+	added := g.AddDiscoveredPeers([]string{"127.0.0.1:5001", "127.0.0.1:5002", g.Address.String(), "[::1]:5003",
+		"127.0.0.1:5004", "[::ffff:127.0.0.1]:5004", "127.0.0.1:5005", "127.0.0.1:5006", "127.0.0.1:5007"}, "test")
+	// 5001 is known, 5002 removed, the IPv6 peer unreachable from an IPv4 socket and 5004 given twice;
+	// the view is full after 5006
+	if added != 3 || !g.isKnownPeer("127.0.0.1:5004") || g.isKnownPeer("127.0.0.1:5007") {
+		t.Errorf("added %d peers, view %v", added, g.KnownPeers)
+	}
+	if g.WantsMorePeers() {
+		t.Error("full view wants more peers")
+	}
+}
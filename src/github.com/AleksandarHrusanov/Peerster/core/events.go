@@ -0,0 +1,125 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+)
+
+// EventType - the kind of an event published on the event bus
+type EventType string
+
+// The events pushed to the subscribers of the event bus
+const (
+	EventRumor            EventType = "rumor"
+	EventPrivate          EventType = "private"
+	EventRoute            EventType = "route"
+	EventSearchMatch      EventType = "search_match"
+	EventDownloadProgress EventType = "download_progress"
+	EventChunkReceived    EventType = "chunk_received"
+	EventTLCUnconfirmed   EventType = "tlc_unconfirmed"
+	EventTLCConfirmed     EventType = "tlc_confirmed"
+	EventPeerAdded        EventType = "peer_added"
+	EventPeerState        EventType = "peer_state"
+)
+
+// Event - an event with its sequence number on the bus and a payload depending on its type
+type Event struct {
+	Seq  uint64      `json:"seq"`
+	Type EventType   `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// RouteEvent - the next hop to an origin changed
+type RouteEvent struct {
+	Origin  string `json:"origin"`
+	NextHop string `json:"nextHop"`
+}
+
+// SearchMatchEvent - a search located every chunk of a file
+type SearchMatchEvent struct {
+	FileName   string `json:"fileName"`
+	Metahash   string `json:"metahash"`
+	ChunkCount uint64 `json:"chunkCount"`
+}
+
+// ChunkReceivedEvent - a valid chunk of a file being downloaded was received; index 0 is the metafile
+type ChunkReceivedEvent struct {
+	FileName   string `json:"fileName"`
+	From       string `json:"from"`
+	ChunkIndex uint32 `json:"chunkIndex"`
+	Hash       string `json:"hash"`
+}
+
+// DownloadProgressEvent - the number of chunks downloaded so far for a file
+type DownloadProgressEvent struct {
+	FileName         string `json:"fileName"`
+	ChunksDownloaded int    `json:"chunksDownloaded"`
+	ChunkCount       int    `json:"chunkCount"`
+	Finished         bool   `json:"finished"`
+}
+
+// TLCEvent - a TLC message was received unconfirmed or confirmed
+type TLCEvent struct {
+	Origin   string `json:"origin"`
+	ID       uint32 `json:"id"`
+	Name     string `json:"name"`
+	Metahash string `json:"metahash"`
+	Size     int64  `json:"size"`
+}
+
+// PeerEvent - a new peer was added to the known peers
+type PeerEvent struct {
+	Address string `json:"address"`
+}
+
+// PeerStateEvent - the failure detector found a peer alive again, suspect or dead
+type PeerStateEvent struct {
+	Address string `json:"address"`
+	State   string `json:"state"`
+}
+
+// SafeEventBus - fans the events published by the handlers out to every subscriber
+type SafeEventBus struct {
+	Subscribers map[uint64]chan Event
+	NextID      uint64
+	Seq         uint64
+	BusLock     sync.Mutex
+}
+
+// PublishEvent - push an event to every subscriber; a subscriber whose buffer is full misses it
+// rather than blocking the handler which published it
+func (g *Gossiper) PublishEvent(eventType EventType, data interface{}) {
+	g.Events.BusLock.Lock()
+	defer g.Events.BusLock.Unlock()
+	g.Events.Seq++
+	event := Event{Seq: g.Events.Seq, Type: eventType, Time: time.Now(), Data: data}
+	for _, ch := range g.Events.Subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// SubscribeEvents - register a new subscriber; returns its id and the channel of its events
+func (g *Gossiper) SubscribeEvents() (uint64, <-chan Event) {
+	g.Events.BusLock.Lock()
+	defer g.Events.BusLock.Unlock()
+	g.Events.NextID++
+	ch := make(chan Event, constants.EventBufferSize)
+	g.Events.Subscribers[g.Events.NextID] = ch
+	return g.Events.NextID, ch
+}
+
+// UnsubscribeEvents - remove a subscriber and close its channel
+func (g *Gossiper) UnsubscribeEvents(id uint64) {
+	g.Events.BusLock.Lock()
+	defer g.Events.BusLock.Unlock()
+	if ch, ok := g.Events.Subscribers[id]; ok {
+		close(ch)
+		delete(g.Events.Subscribers, id)
+	}
+}
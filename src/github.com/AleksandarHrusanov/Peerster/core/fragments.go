@@ -0,0 +1,148 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/dedis/protobuf"
+)
+
+// ErrPacketTooLarge - a gossip packet would need more than MaxFragments fragments to be sent
+var ErrPacketTooLarge = errors.New("gossip packet is too large to be sent")
+
+// ErrInvalidFragment - a fragment is out of the limits or does not match the other fragments of its packet
+var ErrInvalidFragment = errors.New("invalid packet fragment")
+
+// ErrReassemblyFull - too many packets or bytes are partially received, in total or from the sender,
+// to keep another fragment
+var ErrReassemblyFull = errors.New("too many partially received packets")
+
+// partialPacket - the fragments of a packet received so far
+type partialPacket struct {
+	FromAddr  string
+	Parts     [][]byte
+	Received  uint32
+	Bytes     int
+	FirstSeen time.Time
+}
+
+// SafeReassembly - the packets of which only some fragments were received, by sender address and
+// fragment ID, how many of them each sender has, the bytes they hold and the ID of the next packet
+// this gossiper fragments
+type SafeReassembly struct {
+	NextID         uint64
+	Pending        map[string]*partialPacket
+	PendingByPeer  map[string]int
+	Bytes          int
+	ReassemblyLock sync.Mutex
+}
+
+// NewSafeReassembly - create an empty reassembly buffer. IDs start from the current time so that
+// fragments sent before a restart are not mixed with new ones
+func NewSafeReassembly() *SafeReassembly {
+	return &SafeReassembly{NextID: uint64(time.Now().UnixNano()), Pending: make(map[string]*partialPacket),
+		PendingByPeer: make(map[string]int)}
+}
+
+// fragmentPacket - split an encoded gossip packet into encoded fragment packets of at most MaxFragmentSize
+func (g *Gossiper) fragmentPacket(packetBytes []byte) ([][]byte, error) {
+	partSize := constants.MaxFragmentSize - constants.FragmentOverhead
+	count := (len(packetBytes) + partSize - 1) / partSize
+	if count > constants.MaxFragments {
+		return nil, ErrPacketTooLarge
+	}
+
+	g.Reassembly.ReassemblyLock.Lock()
+	g.Reassembly.NextID++
+	id := g.Reassembly.NextID
+	g.Reassembly.ReassemblyLock.Unlock()
+
+	fragments := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * partSize
+		if end > len(packetBytes) {
+			end = len(packetBytes)
+		}
+		fragment := &GossipPacket{Fragment: &PacketFragment{ID: id, Index: uint32(i), Count: uint32(count),
+			Data: packetBytes[i*partSize : end]}}
+		fragmentBytes, err := protobuf.Encode(fragment)
+		if err != nil {
+			return nil, err
+		}
+		fragments = append(fragments, fragmentBytes)
+	}
+	return fragments, nil
+}
+
+// ReassembleFragment - store a fragment received from the given address. Returns the encoded packet
+// once all of its fragments arrived, nil while some are missing
+func (g *Gossiper) ReassembleFragment(fromAddr string, fragment *PacketFragment) ([]byte, error) {
+	if fragment.Count == 0 || fragment.Count > constants.MaxFragments || fragment.Index >= fragment.Count ||
+		len(fragment.Data) == 0 || len(fragment.Data) > constants.MaxFragmentSize {
+		return nil, ErrInvalidFragment
+	}
+	key := fromAddr + "/" + strconv.FormatUint(fragment.ID, 10)
+
+	g.Reassembly.ReassemblyLock.Lock()
+	defer g.Reassembly.ReassemblyLock.Unlock()
+	partial, ok := g.Reassembly.Pending[key]
+	if !ok {
+		g.expireFragments()
+		if len(g.Reassembly.Pending) >= constants.MaxPendingReassemblies ||
+			g.Reassembly.PendingByPeer[fromAddr] >= constants.MaxPendingReassembliesPerPeer {
+			return nil, ErrReassemblyFull
+		}
+		partial = &partialPacket{FromAddr: fromAddr, Parts: make([][]byte, fragment.Count), FirstSeen: time.Now()}
+		g.Reassembly.Pending[key] = partial
+		g.Reassembly.PendingByPeer[fromAddr]++
+	}
+	if int(fragment.Count) != len(partial.Parts) {
+		g.dropPartialPacket(key, partial)
+		return nil, ErrInvalidFragment
+	}
+
+	if partial.Parts[fragment.Index] == nil {
+		if g.Reassembly.Bytes+len(fragment.Data) > constants.MaxReassemblyBytes {
+			return nil, ErrReassemblyFull
+		}
+		// the datagram buffer is reused for the next fragment
+		partial.Parts[fragment.Index] = append([]byte(nil), fragment.Data...)
+		partial.Received++
+		partial.Bytes += len(fragment.Data)
+		g.Reassembly.Bytes += len(fragment.Data)
+	}
+	if partial.Received < fragment.Count {
+		return nil, nil
+	}
+	g.removePartialPacket(key, partial)
+	return bytes.Join(partial.Parts, nil), nil
+}
+
+// drops the packets whose missing fragments did not arrive in time; must be called with the lock held
+func (g *Gossiper) expireFragments() {
+	for key, partial := range g.Reassembly.Pending {
+		if time.Since(partial.FirstSeen) > time.Duration(g.Settings.FragmentTimeout)*time.Second {
+			g.dropPartialPacket(key, partial)
+		}
+	}
+}
+
+// drops a packet which will not be reassembled and counts its fragments; must be called with the lock held
+func (g *Gossiper) dropPartialPacket(key string, partial *partialPacket) {
+	g.removePartialPacket(key, partial)
+	g.CountMetric(MetricFragmentsDropped, uint64(partial.Received))
+}
+
+// releases the place and bytes taken by a partially received packet; must be called with the lock held
+func (g *Gossiper) removePartialPacket(key string, partial *partialPacket) {
+	delete(g.Reassembly.Pending, key)
+	g.Reassembly.Bytes -= partial.Bytes
+	g.Reassembly.PendingByPeer[partial.FromAddr]--
+	if g.Reassembly.PendingByPeer[partial.FromAddr] <= 0 {
+		delete(g.Reassembly.PendingByPeer, partial.FromAddr)
+	}
+}
@@ -0,0 +1,115 @@
+package core
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/dedis/protobuf"
+)
+
+// splits the payload into fragments and decodes them back as received
+func testFragments(t *testing.T, g *Gossiper, payload []byte) []*PacketFragment {
+	encoded, err := g.fragmentPacket(payload)
+	if err != nil {
+		t.Fatalf("fragment: %v", err)
+	}
+	fragments := make([]*PacketFragment, 0, len(encoded))
+	for _, fragmentBytes := range encoded {
+		packet := &GossipPacket{}
+		if err := protobuf.Decode(fragmentBytes, packet); err != nil || packet.Fragment == nil {
+			t.Fatalf("decode fragment: %v", err)
+		}
+		fragments = append(fragments, packet.Fragment)
+	}
+	return fragments
+}
+
+func TestFragmentRoundtrip(t *testing.T) {
+	g := newTestGossiper(t, "A")
+	partSize := constants.MaxFragmentSize - constants.FragmentOverhead
+	for _, size := range []int{1, partSize, partSize + 1, 5*partSize + 7, constants.MaxUDPPacketSize * 4} {
+		payload := make([]byte, size)
+		rand.Read(payload)
+		fragments := testFragments(t, g, payload)
+		if want := (size + partSize - 1) / partSize; len(fragments) != want {
+			t.Fatalf("%d bytes: %d fragments, want %d", size, len(fragments), want)
+		}
+
+		// fragments may arrive in any order and more than once
+		rand.Shuffle(len(fragments), func(i, j int) { fragments[i], fragments[j] = fragments[j], fragments[i] })
+		for i, fragment := range fragments[:len(fragments)-1] {
+			for _, f := range []*PacketFragment{fragment, fragments[i/2]} {
+				if packet, err := g.ReassembleFragment("127.0.0.1:5001", f); packet != nil || err != nil {
+					t.Fatalf("%d bytes: packet %v, error %v before the last fragment", size, packet != nil, err)
+				}
+			}
+		}
+		packet, err := g.ReassembleFragment("127.0.0.1:5001", fragments[len(fragments)-1])
+		if err != nil || !bytes.Equal(packet, payload) {
+			t.Fatalf("%d bytes: reassembled packet differs, error %v", size, err)
+		}
+		if len(g.Reassembly.Pending) != 0 || g.Reassembly.Bytes != 0 {
+			t.Errorf("%d bytes: reassembly buffer not released", size)
+		}
+	}
+}
+
+func TestFragmentTooLarge(t *testing.T) {
+	g := newTestGossiper(t, "A")
+	partSize := constants.MaxFragmentSize - constants.FragmentOverhead
+	if _, err := g.fragmentPacket(make([]byte, constants.MaxFragments*partSize+1)); err != ErrPacketTooLarge {
+		t.Errorf("got error %v, want %v", err, ErrPacketTooLarge)
+	}
+}
+
+func TestReassembleInvalidFragment(t *testing.T) {
+	tests := []struct {
+		name     string
+		fragment PacketFragment
+	}{
+		{"no fragment", PacketFragment{ID: 1, Index: 0, Count: 0, Data: []byte{1}}},
+		{"too many fragments", PacketFragment{ID: 1, Index: 0, Count: constants.MaxFragments + 1, Data: []byte{1}}},
+		{"index out of the packet", PacketFragment{ID: 1, Index: 2, Count: 2, Data: []byte{1}}},
+		{"empty", PacketFragment{ID: 1, Index: 0, Count: 2}},
+		{"too large", PacketFragment{ID: 1, Index: 0, Count: 2, Data: make([]byte, constants.MaxFragmentSize+1)}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := newTestGossiper(t, "A")
+			if _, err := g.ReassembleFragment("127.0.0.1:5001", &test.fragment); err != ErrInvalidFragment {
+				t.Errorf("got error %v, want %v", err, ErrInvalidFragment)
+			}
+		})
+	}
+}
+
+func TestReassembleCountMismatch(t *testing.T) {
+	g := newTestGossiper(t, "A")
+	g.ReassembleFragment("127.0.0.1:5001", &PacketFragment{ID: 1, Index: 0, Count: 3, Data: []byte{1}})
+	_, err := g.ReassembleFragment("127.0.0.1:5001", &PacketFragment{ID: 1, Index: 1, Count: 2, Data: []byte{2}})
+	if err != ErrInvalidFragment {
+		t.Errorf("got error %v, want %v", err, ErrInvalidFragment)
+	}
+	if len(g.Reassembly.Pending) != 0 || g.Reassembly.Bytes != 0 {
+		t.Error("packet with mismatching fragments kept")
+	}
+}
+
+func TestReassemblyLimitPerPeer(t *testing.T) {
+	g := newTestGossiper(t, "A")
+	for id := uint64(0); id < constants.MaxPendingReassembliesPerPeer; id++ {
+		if _, err := g.ReassembleFragment("127.0.0.1:5001", &PacketFragment{ID: id, Count: 2, Data: []byte{1}}); err != nil {
+			t.Fatalf("packet %d: %v", id, err)
+		}
+	}
+	fragment := &PacketFragment{ID: constants.MaxPendingReassembliesPerPeer, Count: 2, Data: []byte{1}}
+	if _, err := g.ReassembleFragment("127.0.0.1:5001", fragment); err != ErrReassemblyFull {
+		t.Errorf("got error %v, want %v", err, ErrReassemblyFull)
+	}
+	// other senders keep their own share
+	if _, err := g.ReassembleFragment("127.0.0.1:5002", fragment); err != nil {
+		t.Errorf("other sender refused: %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package core
 import (
 	"net"
 	"sync"
+	"time"
 
 	"github.com/AleksandarHrusanov/Peerster/constants"
 	"github.com/AleksandarHrusanov/Peerster/helpers"
@@ -57,6 +58,53 @@ type SafeFilesAndMetahashes struct {
 	FilesLock                sync.Mutex
 }
 
+// PeerTransferStats - what a single peer contributed to a download
+type PeerTransferStats struct {
+	Chunks  int
+	Bytes   int64
+	Retries int // requests to the peer which had to be resent
+}
+
+// DownloadStream - the chunks of a file received so far by a download, so that the file can be
+// read while it is being downloaded, and the statistics of the transfer. Chunk indices start at 1
+// like in the metafile
+type DownloadStream struct {
+	FileName   string
+	Metafile   map[uint32][constants.HashSize]byte // nil until the metafile arrived
+	Chunks     map[uint32][]byte
+	Finished   bool
+	Failed     bool          // the download stopped before every chunk was received
+	Priority   uint32        // a chunk a reader is waiting for, 0 if none
+	Updated    chan struct{} // closed and replaced whenever the stream changes
+	Started    time.Time
+	FinishedAt time.Time
+	Bytes      int64
+	Peers      map[string]*PeerTransferStats
+}
+
+// DownloadSummary - the progress and transfer statistics of a download at a point in time
+type DownloadSummary struct {
+	FileName         string
+	Metahash         string
+	Finished         bool
+	Failed           bool
+	ChunksDownloaded int
+	ChunkCount       int // 0 until the metafile arrived
+	Bytes            int64
+	BytesPerSecond   float64
+	ETA              time.Duration // -1 while it cannot be estimated
+	Started          time.Time
+	FinishedAt       time.Time
+	Peers            map[string]PeerTransferStats
+}
+
+// SafeDownloadStreams - the streams of the ongoing and recently finished downloads by hex encoded
+// metahash
+type SafeDownloadStreams struct {
+	Streams     map[string]*DownloadStream
+	StreamsLock sync.Mutex
+}
+
 //SafeDownloadingStates - a struct to hold downloading states
 type SafeDownloadingStates struct {
 	DownloadingStates map[string][]*DownloadingState
@@ -74,6 +122,80 @@ type SafeRecentFileSearches struct {
 	SearchesLock sync.Mutex
 }
 
+// NameHistoryEntry - a confirmed transaction applied to a name
+type NameHistoryEntry struct {
+	Type         TxType
+	Origin       string
+	TLCID        uint32
+	MetafileHash []byte
+	Size         int64
+	NewOwner     string
+}
+
+// NameRecord - the current state of a name on the chain and every transaction applied to it
+type NameRecord struct {
+	Name         string
+	Owner        string
+	Holder       string // the node which published the current version of the file
+	MetafileHash []byte
+	Size         int64
+	Revoked      bool
+	History      []NameHistoryEntry
+}
+
+// SafeNameTable - a struct to hold the name table built from confirmed transactions
+type SafeNameTable struct {
+	Names     map[string]*NameRecord
+	NamesLock sync.Mutex
+}
+
+// ChainBlock - a confirmed block together with its position in the block tree
+type ChainBlock struct {
+	Block    BlockPublish // left empty by light clients, which only keep the header
+	Header   BlockHeader
+	Hash     [32]byte
+	Origin   string
+	TLCID    uint32
+	Height   uint64
+	Fitness  float32   // accumulated fitness from the genesis up to and including this block
+	Received time.Time // when the block was kept aside waiting for its parent, to drop it in time
+}
+
+// ReorgEvent - a switch of the canonical chain to a branch which does not extend the previous head
+type ReorgEvent struct {
+	OldHead        string
+	NewHead        string
+	CommonAncestor string
+	RolledBack     uint64
+	Applied        uint64
+	Time           time.Time
+}
+
+// SafeBlockchain - a struct to hold the tree of confirmed blocks and the head of the canonical chain
+type SafeBlockchain struct {
+	Blocks    map[[32]byte]*ChainBlock
+	Orphans   map[[32]byte][]*ChainBlock // blocks waiting for their parent, keyed by PrevHash
+	Children  map[[32]byte][][32]byte    // hashes of the blocks of the tree extending each block
+	Head      [32]byte
+	Reorgs    []ReorgEvent // the latest MaxReorgEvents reorganisations
+	ChainLock sync.Mutex
+}
+
+// SafePendingNameProofs - a struct to hold the chanels of name lookups waiting for a proof, keyed by
+// the nonce of their requests
+type SafePendingNameProofs struct {
+	Waiting    map[uint64]chan *NameProofReply
+	ProofsLock sync.Mutex
+}
+
+// SafeMiningState - a struct to hold the proof-of-work consensus state
+type SafeMiningState struct {
+	Difficulty int // number of leading zero bits required in a block hash
+	PendingTxs []TxPublish
+	SeenTxs    map[[32]byte]bool
+	MiningLock sync.Mutex
+}
+
 type OwnTLC struct {
 	TLC          TLCMessage
 	AcksReceived int
@@ -92,6 +214,7 @@ type Gossiper struct {
 	KnownPeers         []string
 	PeersLock          sync.Mutex
 	KnownRumors        []RumorMessage
+	RumorsLock         sync.Mutex
 	KnownTLCs          []TLCMessage
 	MyTLCs             map[uint32]OwnTLC
 	TLCLock            sync.Mutex
@@ -100,6 +223,7 @@ type Gossiper struct {
 	MongeringIDLock    sync.Mutex
 	Want               []PeerStatus
 	MongeringStatus    []*MongeringStatus
+	MongeringLock      sync.Mutex
 	DestinationTable   *SafeDestinationTable
 	PrivateMessages    *SafePrivateMessages
 	FilesAndMetahashes *SafeFilesAndMetahashes
@@ -107,19 +231,41 @@ type Gossiper struct {
 	DownloadingLock    sync.Mutex
 	OngoingFileSearch  *SafeOngoingFileSearching
 	RecentSearches     *SafeRecentFileSearches
+	NameTable          *SafeNameTable
+	Blockchain         *SafeBlockchain
+	LightClient        bool
+	PendingNameProofs  *SafePendingNameProofs
+	Mining             *SafeMiningState // nil unless running in proof-of-work mode
+	SimpleMode         bool
+	Naming             bool // the naming chain runs, either with hw3ex2 TLC consensus or proof-of-work
+	StubbornTimeout    int
+	Events             *SafeEventBus
+	Metrics            *SafeMetrics
+	DownloadStreams    *SafeDownloadStreams
+	Reassembly         *SafeReassembly
+	Streams            *SafeStreamTransport // nil unless data requests and replies may use stream connections
+	Channels           *SafeSecureChannels  // nil unless the traffic with peers is encrypted
+	Limits             *SafePeerLimits      // nil unless the packets of peers are rate limited
+	Membership         *SafeMembership
+	Identities         *SafePeerIdentities
+	Exchanges          *SafePeerExchanges
+	View               *SafePeerView // nil unless the known peers are a partial view of bounded size
+	Lifecycle          *SafeLifecycle
+	Settings           *Settings
 }
 
-// NewGossiper Create a new Gossiper
+// NewGossiper Create a new Gossiper. The gossip address may be IPv4 or IPv6; an unspecified host, e.g.
+// [::]:5000, listens on both. The client messages are received on the UI address and port
 func NewGossiper(address string, name string,
-	knownPeersList []string, UIPort string) *Gossiper {
-	udpAddr, err := net.ResolveUDPAddr("udp4", address)
+	knownPeersList []string, UIAddr string, UIPort string) *Gossiper {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
 	helpers.HandleErrorFatal(err)
-	udpConn, err := net.ListenUDP("udp4", udpAddr)
+	udpConn, err := net.ListenUDP("udp", udpAddr)
 	helpers.HandleErrorFatal(err)
-	clientAddr := "127.0.0.1:" + UIPort
-	udpAddrLocal, err := net.ResolveUDPAddr("udp4", clientAddr)
+	clientAddr := net.JoinHostPort(UIAddr, UIPort)
+	udpAddrLocal, err := net.ResolveUDPAddr("udp", clientAddr)
 	helpers.HandleErrorFatal(err)
-	udpConnLocal, err := net.ListenUDP("udp4", udpAddrLocal)
+	udpConnLocal, err := net.ListenUDP("udp", udpAddrLocal)
 	helpers.HandleErrorFatal(err)
 	dsdv := &SafeDestinationTable{Dsdv: make(map[string]string)}
 	filesAndMetahashes := &SafeFilesAndMetahashes{FileNamesToMetahashesMap: make(map[string]string),
@@ -128,6 +274,11 @@ func NewGossiper(address string, name string,
 	privateMessages := &SafePrivateMessages{Messages: make(map[string][]string)}
 	recentSearches := &SafeRecentFileSearches{Searches: make(map[string]bool)}
 	ongoingSearch := CreateSafeOngoingFileSearching()
+	nameTable := &SafeNameTable{Names: make(map[string]*NameRecord)}
+	blockchain := &SafeBlockchain{Blocks: make(map[[32]byte]*ChainBlock),
+		Orphans: make(map[[32]byte][]*ChainBlock), Children: make(map[[32]byte][][32]byte),
+		Reorgs: make([]ReorgEvent, 0)}
+	pendingNameProofs := &SafePendingNameProofs{Waiting: make(map[uint64]chan *NameProofReply)}
 
 	return &Gossiper{
 		Address:            udpAddr,
@@ -150,5 +301,17 @@ func NewGossiper(address string, name string,
 		DownloadingStates:  make(map[string][]*DownloadingState),
 		RecentSearches:     recentSearches,
 		OngoingFileSearch:  ongoingSearch,
+		NameTable:          nameTable,
+		Blockchain:         blockchain,
+		PendingNameProofs:  pendingNameProofs,
+		Events:             &SafeEventBus{Subscribers: make(map[uint64]chan Event)},
+		Metrics:            NewSafeMetrics(),
+		DownloadStreams:    &SafeDownloadStreams{Streams: make(map[string]*DownloadStream)},
+		Reassembly:         NewSafeReassembly(),
+		Membership:         NewSafeMembership(knownPeersList),
+		Identities:         NewSafePeerIdentities(),
+		Exchanges:          &SafePeerExchanges{Requested: make(map[string]time.Time)},
+		Lifecycle:          NewSafeLifecycle(),
+		Settings:           DefaultSettings(),
 	}
 }
@@ -2,10 +2,10 @@ package core
 
 import (
 	"encoding/hex"
-	"fmt"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/AleksandarHrusanov/Peerster/helpers"
 )
@@ -31,11 +31,21 @@ func (g *Gossiper) GetLocalAddr() string {
 	return g.LocalAddr.String()
 }
 
-// GetAllRumors Get the rumors known by the gossiper
+// GetAllRumors Get the rumors known by the gossiper. Rumors are only appended, so the returned
+// slice can be read while new rumors arrive
 func (g *Gossiper) GetAllRumors() []RumorMessage {
+	g.RumorsLock.Lock()
+	defer g.RumorsLock.Unlock()
 	return g.KnownRumors
 }
 
+// GetMongeringStatuses Get the rumors and TLCs sent which wait for a status, oldest first
+func (g *Gossiper) GetMongeringStatuses() []*MongeringStatus {
+	g.MongeringLock.Lock()
+	defer g.MongeringLock.Unlock()
+	return g.MongeringStatus
+}
+
 // GetAllFullyMatchedFilenames Get the rumors known by the gossiper
 func (g *Gossiper) GetAllFullyMatchedFilenames() []string {
 	return g.OngoingFileSearch.MatchesFileNames
@@ -61,11 +71,124 @@ func (g *Gossiper) GetConfirmedTLCs() []TLCMessage {
 	return tlcs
 }
 
+// GetFileSearchMatches Return a copy of every file match found by searches so far, sorted by file name
+func (g *Gossiper) GetFileSearchMatches() []FileSearchMatch {
+	g.OngoingFileSearch.SearchRequestLock.Lock()
+	matches := make([]FileSearchMatch, 0, len(g.OngoingFileSearch.MatchesFound))
+	for _, match := range g.OngoingFileSearch.MatchesFound {
+		matches = append(matches, *match)
+	}
+	g.OngoingFileSearch.SearchRequestLock.Unlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].FileName < matches[j].FileName
+	})
+	return matches
+}
+
 func (g *Gossiper) GetMetafileHashByName(fname string) string {
 	finfo := g.OngoingFileSearch.MatchesFound[fname]
 	return hex.EncodeToString(finfo.Metahash)
 }
 
+// GetDownloadSummaries - returns the progress and transfer statistics of the ongoing and recently
+// finished downloads, oldest first
+func (g *Gossiper) GetDownloadSummaries() []DownloadSummary {
+	now := time.Now()
+	g.DownloadStreams.StreamsLock.Lock()
+	summaries := make([]DownloadSummary, 0, len(g.DownloadStreams.Streams))
+	for metahash, stream := range g.DownloadStreams.Streams {
+		summary := DownloadSummary{FileName: stream.FileName, Metahash: metahash, Finished: stream.Finished,
+			Failed: stream.Failed, ChunksDownloaded: len(stream.Chunks), ChunkCount: len(stream.Metafile), Bytes: stream.Bytes,
+			ETA: -1, Started: stream.Started, FinishedAt: stream.FinishedAt,
+			Peers: make(map[string]PeerTransferStats, len(stream.Peers))}
+		for peer, stats := range stream.Peers {
+			summary.Peers[peer] = *stats
+		}
+
+		end := now
+		if stream.Finished {
+			end = stream.FinishedAt
+			summary.ETA = 0
+		}
+		if elapsed := end.Sub(stream.Started).Seconds(); elapsed > 0 {
+			summary.BytesPerSecond = float64(stream.Bytes) / elapsed
+		}
+		if !stream.Finished && summary.BytesPerSecond > 0 && summary.ChunkCount > 0 {
+			// assume the missing chunks have the average size of the received ones
+			averageChunk := float64(stream.Bytes) / float64(summary.ChunksDownloaded)
+			remaining := averageChunk * float64(summary.ChunkCount-summary.ChunksDownloaded)
+			summary.ETA = time.Duration(remaining / summary.BytesPerSecond * float64(time.Second))
+		}
+		summaries = append(summaries, summary)
+	}
+	g.DownloadStreams.StreamsLock.Unlock()
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Started.Before(summaries[j].Started)
+	})
+	return summaries
+}
+
+// GetNameRecord - returns a copy of the name table record for the given name, or nil if unknown
+func (g *Gossiper) GetNameRecord(name string) *NameRecord {
+	g.NameTable.NamesLock.Lock()
+	defer g.NameTable.NamesLock.Unlock()
+
+	record, ok := g.NameTable.Names[name]
+	if !ok {
+		return nil
+	}
+	recordCopy := *record
+	recordCopy.History = append([]NameHistoryEntry(nil), record.History...)
+	return &recordCopy
+}
+
+// GetAllNameRecords - returns a copy of every record in the name table, sorted by name
+func (g *Gossiper) GetAllNameRecords() []NameRecord {
+	g.NameTable.NamesLock.Lock()
+	records := make([]NameRecord, 0, len(g.NameTable.Names))
+	for _, record := range g.NameTable.Names {
+		recordCopy := *record
+		recordCopy.History = append([]NameHistoryEntry(nil), record.History...)
+		records = append(records, recordCopy)
+	}
+	g.NameTable.NamesLock.Unlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Name < records[j].Name
+	})
+	return records
+}
+
+// GetChainHead - returns the hash of the head of the canonical chain (all zeros for an empty chain)
+func (g *Gossiper) GetChainHead() [32]byte {
+	g.Blockchain.ChainLock.Lock()
+	defer g.Blockchain.ChainLock.Unlock()
+	return g.Blockchain.Head
+}
+
+// GetCanonicalChain - returns the blocks of the canonical chain, from the genesis to the head
+func (g *Gossiper) GetCanonicalChain() []ChainBlock {
+	g.Blockchain.ChainLock.Lock()
+	defer g.Blockchain.ChainLock.Unlock()
+
+	chain := make([]ChainBlock, 0)
+	block, ok := g.Blockchain.Blocks[g.Blockchain.Head]
+	for ok {
+		chain = append([]ChainBlock{*block}, chain...)
+		block, ok = g.Blockchain.Blocks[block.Header.PrevHash]
+	}
+	return chain
+}
+
+// GetReorgEvents - returns every reorganisation of the canonical chain seen so far
+func (g *Gossiper) GetReorgEvents() []ReorgEvent {
+	g.Blockchain.ChainLock.Lock()
+	defer g.Blockchain.ChainLock.Unlock()
+	return append([]ReorgEvent(nil), g.Blockchain.Reorgs...)
+}
+
 // GetAllFileNames - return an array of filenames shared/downloaded by the gossiper
 func (g *Gossiper) GetAllFileNames() []string {
 	g.FilesAndMetahashes.FilesLock.Lock()
@@ -88,7 +211,7 @@ func (g *Gossiper) GetAllKnownFiles() *SafeFilesAndMetahashes {
 
 // GetAllNonRouteRumors Get the rumors known by the gossiper
 func (g *Gossiper) GetAllNonRouteRumors() []RumorMessage {
-	allRumors := g.KnownRumors
+	allRumors := g.GetAllRumors()
 	regularRumors := make([]RumorMessage, 0)
 	for _, r := range allRumors {
 		if strings.Compare(r.Text, "") != 0 {
@@ -108,6 +231,17 @@ func (g *Gossiper) GetAllPrivateMessagesBetween() map[string][]string {
 	return g.PrivateMessages.Messages
 }
 
+// GetRoutingTable - returns a copy of the next hop towards every known origin
+func (g *Gossiper) GetRoutingTable() map[string]string {
+	g.DestinationTable.DsdvLock.Lock()
+	defer g.DestinationTable.DsdvLock.Unlock()
+	routes := make(map[string]string, len(g.DestinationTable.Dsdv))
+	for origin, nextHop := range g.DestinationTable.Dsdv {
+		routes[origin] = nextHop
+	}
+	return routes
+}
+
 // GetAllKnownOrigins - returns the origins known to this gossiper
 func (g *Gossiper) GetAllKnownOrigins() []string {
 	origins := make([]string, 0)
@@ -121,15 +255,14 @@ func (g *Gossiper) GetAllKnownOrigins() []string {
 	return origins
 }
 
-// AddPeer Add a peer to the list of known peers
+// AddPeer Add a peer to the list of known peers, also if the user removed it before or the partial view is full
 func (g *Gossiper) AddPeer(address string) {
-	if helpers.IPAddressIsValid(address) {
-		for _, peer := range g.KnownPeers {
-			if strings.Compare(peer, address) == 0 {
-				return
-			}
-		}
-		g.KnownPeers = append(g.KnownPeers, address)
+	if address, valid := helpers.NormalizeAddress(address); valid {
+		g.Membership.MembershipLock.Lock()
+		delete(g.Membership.Removed, address)
+		g.Membership.MembershipLock.Unlock()
+		g.MakeRoomInView(address)
+		g.HeardFrom(address)
 	}
 }
 
@@ -218,14 +351,18 @@ func IsRouteRumor(rumor *RumorMessage) bool {
 
 // PrintOutputStatus print on the console
 func PrintOutputStatus(fromAddr string, listOfWanted []PeerStatus, knownPeers []string) {
-	// Print first line
-	fmt.Print("STATUS from " + fromAddr)
+	if !helpers.LegacyEnabled(helpers.LevelDebug) {
+		// status packets are frequent, don't build lines which are not written
+		return
+	}
+	line := "STATUS from " + fromAddr
+	wanted := make([]string, 0, len(listOfWanted))
 	for _, peerStatus := range listOfWanted {
-		fmt.Print(" peer " + peerStatus.Identifier + " nextID " + strconv.Itoa(int(peerStatus.NextID)))
+		line += " peer " + peerStatus.Identifier + " nextID " + strconv.Itoa(int(peerStatus.NextID))
+		wanted = append(wanted, peerStatus.Identifier+":"+strconv.Itoa(int(peerStatus.NextID)))
 	}
-	fmt.Println()
-
-	// Print second line
 	stringPeers := helpers.CreateStringKnownPeers(knownPeers)
-	fmt.Println("PEERS " + stringPeers)
+	helpers.GossipLog.Legacy(helpers.LevelDebug, line+"\nPEERS "+stringPeers,
+		"status received", helpers.F("peer", fromAddr), helpers.F("want", strings.Join(wanted, ",")),
+		helpers.F("peers", stringPeers))
 }
@@ -0,0 +1,15 @@
+package core
+
+import (
+	"testing"
+)
+
+// a gossiper listening on free loopback ports, closed when the test ends
+func newTestGossiper(t *testing.T, name string, peers ...string) *Gossiper {
+	g := NewGossiper("127.0.0.1:0", name, peers, "127.0.0.1", "0")
+	t.Cleanup(func() {
+		g.Conn.Close()
+		g.LocalConn.Close()
+	})
+	return g
+}
@@ -0,0 +1,184 @@
+package core
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+)
+
+// SafeLifecycle - whether the gossiper is stopping, and its routines. Stopping is closed once, when
+// it starts to stop, so that every routine of the node returns; no routine starts afterwards
+type SafeLifecycle struct {
+	Stopping      chan struct{}
+	StopOnce      sync.Once
+	Routines      sync.WaitGroup
+	LifecycleLock sync.Mutex
+}
+
+// NewSafeLifecycle - create the lifecycle of a running gossiper
+func NewSafeLifecycle() *SafeLifecycle {
+	return &SafeLifecycle{Stopping: make(chan struct{})}
+}
+
+// Done - closed when the gossiper starts to stop
+func (g *Gossiper) Done() <-chan struct{} {
+	return g.Lifecycle.Stopping
+}
+
+// IsStopping - whether the gossiper started to stop
+func (g *Gossiper) IsStopping() bool {
+	select {
+	case <-g.Lifecycle.Stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// BeginStop - make the routines of the gossiper return. Calling it again has no effect
+func (g *Gossiper) BeginStop() {
+	g.Lifecycle.StopOnce.Do(func() {
+		g.Lifecycle.LifecycleLock.Lock()
+		close(g.Lifecycle.Stopping)
+		g.Lifecycle.LifecycleLock.Unlock()
+	})
+}
+
+// Go - run a routine of the gossiper, which WaitRoutines waits for. Returns false without running
+// it if the gossiper is stopping
+func (g *Gossiper) Go(routine func()) bool {
+	l := g.Lifecycle
+	l.LifecycleLock.Lock()
+	defer l.LifecycleLock.Unlock()
+	if g.IsStopping() {
+		return false
+	}
+	l.Routines.Add(1)
+	go func() {
+		defer l.Routines.Done()
+		routine()
+	}()
+	return true
+}
+
+// WaitRoutines - wait for the routines of a stopping gossiper to return, for up to the given
+// duration. Returns false if some did not return in time
+func (g *Gossiper) WaitRoutines(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		g.Lifecycle.Routines.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Sleep - wait for the given duration, or until the gossiper starts to stop. Returns false in the
+// latter case, so that periodic routines are written as: for g.Sleep(period) { ... }
+func (g *Gossiper) Sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-g.Lifecycle.Stopping:
+		return false
+	}
+}
+
+// NodeState - what a node keeps on disk between two runs: its known peers, to join the network
+// again, and the downloads it did not finish, which start again
+type NodeState struct {
+	KnownPeers []string
+	Downloads  []PendingDownload
+}
+
+// PendingDownload - a download which was in progress when the node stopped. From is the node it
+// was downloading from, "" for a download of a file located by a search
+type PendingDownload struct {
+	FileName string
+	Metahash string
+	From     string
+}
+
+// StateFile - the file the state of the node is saved to
+func (g *Gossiper) StateFile() string {
+	return filepath.Join(g.Settings.StateFolder, g.Name+".json")
+}
+
+// PendingDownloads - the downloads in progress
+func (g *Gossiper) PendingDownloads() []PendingDownload {
+	pending := make([]PendingDownload, 0)
+	g.DownloadStreams.StreamsLock.Lock()
+	for metahash, stream := range g.DownloadStreams.Streams {
+		if !stream.Finished {
+			pending = append(pending, PendingDownload{FileName: stream.FileName, Metahash: metahash})
+		}
+	}
+	g.DownloadStreams.StreamsLock.Unlock()
+
+	g.DownloadingLock.Lock()
+	for from, states := range g.DownloadingStates {
+		for _, state := range states {
+			if state.DownloadFinished {
+				continue
+			}
+			for i := range pending {
+				if strings.Compare(pending[i].Metahash, hex.EncodeToString(state.FileInfo.MetaHash[:])) == 0 {
+					pending[i].From = from
+				}
+			}
+		}
+	}
+	g.DownloadingLock.Unlock()
+	return pending
+}
+
+// SaveState - write the known peers and the downloads in progress to the state file
+func (g *Gossiper) SaveState(downloads []PendingDownload) error {
+	g.PeersLock.Lock()
+	state := NodeState{KnownPeers: append([]string{}, g.KnownPeers...), Downloads: downloads}
+	g.PeersLock.Unlock()
+
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := g.StateFile()
+	if err := os.MkdirAll(filepath.Dir(path), constants.FileMode); err != nil {
+		return err
+	}
+	// write to a temporary file first, so that a crash never leaves a truncated state behind
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// LoadState - read the state saved by the previous run of the node, empty if there is none
+func (g *Gossiper) LoadState() (*NodeState, error) {
+	state := &NodeState{KnownPeers: make([]string, 0), Downloads: make([]PendingDownload, 0)}
+	data, err := ioutil.ReadFile(g.StateFile())
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
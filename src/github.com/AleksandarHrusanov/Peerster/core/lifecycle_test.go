@@ -0,0 +1,123 @@
+package core
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLoadStateOfFirstRun(t *testing.T) {
+	g := newTestGossiper(t, "A", "127.0.0.1:5001")
+	g.Settings.StateFolder = t.TempDir() + "/missing"
+	state, err := g.LoadState()
+	if err != nil || len(state.KnownPeers) != 0 || len(state.Downloads) != 0 {
+		t.Errorf("got state %+v, error %v before any was saved", state, err)
+	}
+}
+
+func TestSaveStateSurvivesRestart(t *testing.T) {
+	folder := t.TempDir() + "/state"
+	g := newTestGossiper(t, "A", "127.0.0.1:5001", "[::1]:5002")
+	g.Settings.StateFolder = folder
+	downloads := []PendingDownload{{FileName: "a.txt", Metahash: "00ff", From: "B"}, {FileName: "b.txt", Metahash: "ff00"}}
+	if err := g.SaveState(downloads); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	if _, err := os.Stat(g.StateFile() + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temporary state file left behind")
+	}
+
+	// the next run of the same node, which knows no peers yet, reads what the previous one saved
+	next := newTestGossiper(t, "A")
+	next.Settings.StateFolder = folder
+	state, err := next.LoadState()
+	if err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	want := &NodeState{KnownPeers: []string{"127.0.0.1:5001", "[::1]:5002"}, Downloads: downloads}
+	if !reflect.DeepEqual(state, want) {
+		t.Errorf("loaded %+v, want %+v", state, want)
+	}
+
+	// another node does not pick it up
+	other := newTestGossiper(t, "B")
+	other.Settings.StateFolder = folder
+	if state, err := other.LoadState(); err != nil || len(state.KnownPeers) != 0 {
+		t.Errorf("other node loaded %+v, error %v", state, err)
+	}
+}
+
+func TestLoadTruncatedState(t *testing.T) {
+	g := newTestGossiper(t, "A")
+	g.Settings.StateFolder = t.TempDir()
+	if err := ioutil.WriteFile(g.StateFile(), []byte("{\"KnownPeers\": ["), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := g.LoadState(); err == nil {
+		t.Error("truncated state loaded")
+	}
+}
+
+func TestPendingDownloads(t *testing.T) {
+	g := newTestGossiper(t, "A")
+	var named, aborted [32]byte
+	named[0], aborted[0] = 1, 4
+	g.DownloadStreams.Streams[hex.EncodeToString(named[:])] = &DownloadStream{FileName: "named.txt"}
+	g.DownloadStreams.Streams["02"] = &DownloadStream{FileName: "searched.txt"}
+	g.DownloadStreams.Streams["03"] = &DownloadStream{FileName: "done.txt", Finished: true}
+	// a failed download finishes its stream, and is not resumed even though its state is still there
+	g.DownloadStreams.Streams[hex.EncodeToString(aborted[:])] = &DownloadStream{FileName: "aborted.txt", Finished: true}
+	g.DownloadingStates["B"] = []*DownloadingState{{FileInfo: &FileInformation{MetaHash: named}},
+		{FileInfo: &FileInformation{MetaHash: aborted}}}
+
+	pending := map[string]PendingDownload{}
+	for _, download := range g.PendingDownloads() {
+		pending[download.FileName] = download
+	}
+	want := map[string]PendingDownload{
+		"named.txt":    {FileName: "named.txt", Metahash: hex.EncodeToString(named[:]), From: "B"},
+		"searched.txt": {FileName: "searched.txt", Metahash: "02"},
+	}
+	if !reflect.DeepEqual(pending, want) {
+		t.Errorf("got pending downloads %+v, want %+v", pending, want)
+	}
+}
+
+func TestStopWaitsForRoutines(t *testing.T) {
+	g := newTestGossiper(t, "A")
+	running := make(chan bool, 1)
+	release := make(chan struct{})
+	if !g.Go(func() {
+		running <- true
+		<-release
+	}) {
+		t.Fatal("routine not started")
+	}
+	<-running
+
+	// stopping twice must not close the channel twice
+	g.BeginStop()
+	g.BeginStop()
+	if !g.IsStopping() {
+		t.Error("gossiper not stopping")
+	}
+	if g.Sleep(time.Hour) {
+		t.Error("sleep not interrupted by the stop")
+	}
+	if g.Go(func() { running <- true }) {
+		t.Error("routine started while stopping")
+	}
+	if g.WaitRoutines(10 * time.Millisecond) {
+		t.Error("running routine not waited for")
+	}
+	close(release)
+	if !g.WaitRoutines(time.Second) {
+		t.Error("routines did not return")
+	}
+	if len(running) != 0 {
+		t.Error("refused routine ran")
+	}
+}
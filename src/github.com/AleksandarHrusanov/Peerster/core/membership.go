@@ -0,0 +1,407 @@
+package core
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+)
+
+// ErrUnknownPeer - the address is not one of the known peers
+var ErrUnknownPeer = errors.New("unknown peer")
+
+// PeerState - the state of a peer for the failure detector
+type PeerState string
+
+// The states of a peer. Only alive peers are picked for mongering and anti-entropy; dead peers and
+// peers which left are evicted from the known peers. Unlike dead peers, peers which left are not
+// probed: they join again by sending packets when they start again
+const (
+	PeerAlive   PeerState = "alive"
+	PeerSuspect PeerState = "suspect"
+	PeerDead    PeerState = "dead"
+	PeerLeft    PeerState = "left"
+)
+
+// PeerMember - the state of a peer, since when it is in it, when a packet was last received from it,
+// whether it was probed, whether it ever acked a probe and how many probes in a row it missed since.
+// Peers which never acked one, e.g. nodes which do not run the failure detector, are only suspected
+// once they missed several probes without sending any other packet
+type PeerMember struct {
+	Address       string
+	State         PeerState
+	StateSince    time.Time
+	LastHeard     time.Time
+	Probed        bool
+	AnswersProbes bool
+	MissedProbes  int
+}
+
+// pendingProbe - a probe waiting for its ack, from the target or one of the helpers asked to probe it.
+// Probes sent on behalf of another peer forward the ack to it under the sequence number it chose
+type pendingProbe struct {
+	Target       string
+	Helpers      []string
+	Requester    string
+	RequesterSeq uint64
+	Acked        chan bool
+}
+
+// SafeMembership - the state of the known peers and of the dead peers still probed, by address, the
+// peers removed by the user, which are not added back when they send packets, the pending probes by
+// random sequence number, so that acks do not match the probes of a previous run, and the period of
+// the failure detector
+type SafeMembership struct {
+	Members        map[string]*PeerMember
+	Removed        map[string]bool
+	Probes         map[uint64]*pendingProbe
+	ProbePeriod    time.Duration
+	MembershipLock sync.Mutex
+}
+
+// NewSafeMembership - create the membership of a gossiper starting with the given peers, alive
+func NewSafeMembership(peers []string) *SafeMembership {
+	m := &SafeMembership{Members: make(map[string]*PeerMember), Removed: make(map[string]bool),
+		Probes: make(map[uint64]*pendingProbe), ProbePeriod: constants.DefaultProbePeriod * time.Second}
+	now := time.Now()
+	for _, peer := range peers {
+		m.Members[peer] = &PeerMember{Address: peer, State: PeerAlive, StateSince: now}
+	}
+	return m
+}
+
+// HeardFrom - record that a packet was received from a peer, which is therefore alive. A peer which
+// is not known yet, or was evicted as dead, is added to the known peers unless the user removed it
+// or the partial view is full
+func (g *Gossiper) HeardFrom(address string) {
+	if strings.Compare(address, "") == 0 || !g.joinKnownPeers(address) {
+		return
+	}
+	m := g.Membership
+	m.MembershipLock.Lock()
+	now := time.Now()
+	member := m.Members[address]
+	previous := member.State
+	member.LastHeard = now
+	member.MissedProbes = 0
+	if previous != PeerAlive {
+		member.State = PeerAlive
+		member.StateSince = now
+	}
+	m.MembershipLock.Unlock()
+
+	if previous != PeerAlive {
+		helpers.GossipLog.Info("peer is alive again", helpers.F("peer", address), helpers.F("was", string(previous)))
+		g.PublishEvent(EventPeerState, PeerStateEvent{Address: address, State: string(PeerAlive)})
+	}
+}
+
+// adds a peer to the known peers and tracks it, alive if it was not tracked yet. Returns false if it
+// is not a known peer because the user removed it or the partial view is full
+func (g *Gossiper) joinKnownPeers(address string) bool {
+	m := g.Membership
+	m.MembershipLock.Lock()
+	removed := m.Removed[address]
+	m.MembershipLock.Unlock()
+	if removed || !g.addKnownPeer(address) {
+		return false
+	}
+
+	m.MembershipLock.Lock()
+	if _, ok := m.Members[address]; !ok {
+		m.Members[address] = &PeerMember{Address: address, State: PeerAlive, StateSince: time.Now()}
+	}
+	m.MembershipLock.Unlock()
+	return true
+}
+
+// adds an address to the known peers if it is not one of them yet. Returns false if it is not one of
+// them because the partial view is full
+func (g *Gossiper) addKnownPeer(address string) bool {
+	g.PeersLock.Lock()
+	for _, peer := range g.KnownPeers {
+		if strings.Compare(peer, address) == 0 {
+			g.PeersLock.Unlock()
+			return true
+		}
+	}
+	if g.View != nil && len(g.KnownPeers) >= g.View.Size {
+		g.PeersLock.Unlock()
+		return false
+	}
+	g.KnownPeers = append(g.KnownPeers, address)
+	g.PeersLock.Unlock()
+	g.PublishEvent(EventPeerAdded, PeerEvent{Address: address})
+	return true
+}
+
+// RemovePeer - remove a peer from the known peers at the request of the user. It is not added back
+// when it sends packets, only when it is added again
+func (g *Gossiper) RemovePeer(address string) error {
+	address, _ = helpers.NormalizeAddress(address)
+	if !g.evictFromView(address) {
+		return ErrUnknownPeer
+	}
+	g.Membership.MembershipLock.Lock()
+	g.Membership.Removed[address] = true
+	g.Membership.MembershipLock.Unlock()
+	helpers.GossipLog.Info("peer removed", helpers.F("peer", address))
+	return nil
+}
+
+// removes an address from the known peers and closes its stream connection; the slice is copied
+// since callers iterate over the old one
+func (g *Gossiper) evictKnownPeer(address string) bool {
+	g.PeersLock.Lock()
+	peers := make([]string, 0, len(g.KnownPeers))
+	for _, peer := range g.KnownPeers {
+		if strings.Compare(peer, address) != 0 {
+			peers = append(peers, peer)
+		}
+	}
+	found := len(peers) < len(g.KnownPeers)
+	g.KnownPeers = peers
+	g.PeersLock.Unlock()
+
+	if found && g.View != nil {
+		g.View.forget(address)
+	}
+	if found {
+		g.CloseStream(address)
+	}
+	return found
+}
+
+// whether an address is one of the known peers
+func (g *Gossiper) isKnownPeer(address string) bool {
+	g.PeersLock.Lock()
+	defer g.PeersLock.Unlock()
+	return helpers.SliceContainsString(g.KnownPeers, address)
+}
+
+// GetLivePeers - the known peers which are alive. Peers are picked among them for mongering and
+// anti-entropy
+func (g *Gossiper) GetLivePeers() []string {
+	g.PeersLock.Lock()
+	knownPeers := g.KnownPeers
+	g.PeersLock.Unlock()
+
+	live := make([]string, 0, len(knownPeers))
+	g.Membership.MembershipLock.Lock()
+	for _, peer := range knownPeers {
+		if member, ok := g.Membership.Members[peer]; !ok || member.State == PeerAlive {
+			live = append(live, peer)
+		}
+	}
+	g.Membership.MembershipLock.Unlock()
+	return live
+}
+
+// GetMembers - the state of the known peers and of the dead peers still probed, sorted by address
+func (g *Gossiper) GetMembers() []PeerMember {
+	g.Membership.MembershipLock.Lock()
+	members := make([]PeerMember, 0, len(g.Membership.Members))
+	for _, member := range g.Membership.Members {
+		members = append(members, *member)
+	}
+	g.Membership.MembershipLock.Unlock()
+	sort.Slice(members, func(i, j int) bool { return strings.Compare(members[i].Address, members[j].Address) < 0 })
+	return members
+}
+
+// NextProbeTarget - a random alive or suspect peer not heard from within the given period, or never
+// probed so that it is known whether it answers probes, "" if there is none
+func (g *Gossiper) NextProbeTarget(period time.Duration) string {
+	return firstOrEmpty(g.pickMembers(1, "", func(member *PeerMember) bool {
+		return member.State != PeerDead && member.State != PeerLeft &&
+			(time.Since(member.LastHeard) > period || !member.Probed)
+	}))
+}
+
+// PickDeadPeer - a random dead peer, "" if there is none
+func (g *Gossiper) PickDeadPeer() string {
+	return firstOrEmpty(g.pickMembers(1, "", func(member *PeerMember) bool { return member.State == PeerDead }))
+}
+
+// PickProbeHelpers - up to n random alive peers other than the target, asked to probe it
+func (g *Gossiper) PickProbeHelpers(target string, n int) []string {
+	return g.pickMembers(n, target, isAlive)
+}
+
+func isAlive(member *PeerMember) bool {
+	return member.State == PeerAlive
+}
+
+// picks up to n random members matching the filter other than the excluded one
+func (g *Gossiper) pickMembers(n int, excluded string, filter func(*PeerMember) bool) []string {
+	g.Membership.MembershipLock.Lock()
+	candidates := make([]string, 0)
+	for address, member := range g.Membership.Members {
+		if strings.Compare(address, excluded) != 0 && filter(member) {
+			candidates = append(candidates, address)
+		}
+	}
+	g.Membership.MembershipLock.Unlock()
+
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
+
+func firstOrEmpty(sx []string) string {
+	if len(sx) == 0 {
+		return ""
+	}
+	return sx[0]
+}
+
+// StartProbe - register a probe of the target and return its sequence number and a channel closed
+// when it is acked. A requester is given for probes sent on behalf of another peer
+func (g *Gossiper) StartProbe(target string, requester string, requesterSeq uint64) (uint64, chan bool) {
+	m := g.Membership
+	m.MembershipLock.Lock()
+	defer m.MembershipLock.Unlock()
+	seq := rand.Uint64()
+	for _, used := m.Probes[seq]; used || seq == 0; _, used = m.Probes[seq] {
+		seq = rand.Uint64()
+	}
+	probe := &pendingProbe{Target: target, Requester: requester, RequesterSeq: requesterSeq, Acked: make(chan bool)}
+	m.Probes[seq] = probe
+	if member, ok := m.Members[target]; ok {
+		member.Probed = true
+	}
+	return seq, probe.Acked
+}
+
+// ProbeThrough - record the peers asked to probe the target of a probe, whose acks are accepted too
+func (g *Gossiper) ProbeThrough(seq uint64, probeHelpers []string) {
+	g.Membership.MembershipLock.Lock()
+	if probe, ok := g.Membership.Probes[seq]; ok {
+		probe.Helpers = append(probe.Helpers, probeHelpers...)
+	}
+	g.Membership.MembershipLock.Unlock()
+}
+
+// EndProbe - forget a probe, acked or timed out
+func (g *Gossiper) EndProbe(seq uint64) {
+	g.Membership.MembershipLock.Lock()
+	delete(g.Membership.Probes, seq)
+	g.Membership.MembershipLock.Unlock()
+}
+
+// AckProbe - handle the ack of a probe received from a peer, which has to be the target of the probe or
+// one of its helpers. Returns the peer the ack has to be forwarded to, with the sequence number and the
+// target of its probe, if the probe was sent on its behalf
+func (g *Gossiper) AckProbe(seq uint64, fromAddr string) (string, uint64, string) {
+	m := g.Membership
+	m.MembershipLock.Lock()
+	probe, ok := m.Probes[seq]
+	ok = ok && (strings.Compare(fromAddr, probe.Target) == 0 || helpers.SliceContainsString(probe.Helpers, fromAddr))
+	if ok {
+		delete(m.Probes, seq)
+		if member, tracked := m.Members[probe.Target]; tracked {
+			member.AnswersProbes = true
+		}
+	}
+	m.MembershipLock.Unlock()
+	if !ok {
+		return "", 0, ""
+	}
+	if strings.Compare(probe.Requester, "") != 0 {
+		return probe.Requester, probe.RequesterSeq, probe.Target
+	}
+	close(probe.Acked)
+	// the ack may have come through another peer
+	g.HeardFrom(probe.Target)
+	return "", 0, ""
+}
+
+// SuspectPeer - mark a peer which did not answer the probes started at the given time as suspect,
+// unless a packet was received from it since. A peer which never acked a probe is only suspected
+// once it missed MissedProbesBeforeSuspect of them in a row
+func (g *Gossiper) SuspectPeer(address string, probedAt time.Time) {
+	m := g.Membership
+	m.MembershipLock.Lock()
+	member, ok := m.Members[address]
+	suspected := false
+	if ok && member.State == PeerAlive && member.LastHeard.Before(probedAt) {
+		member.MissedProbes++
+		suspected = member.AnswersProbes || member.MissedProbes >= constants.MissedProbesBeforeSuspect
+	}
+	if suspected {
+		member.State = PeerSuspect
+		member.StateSince = time.Now()
+	}
+	m.MembershipLock.Unlock()
+
+	if suspected {
+		helpers.GossipLog.Info("peer is suspected to have failed", helpers.F("peer", address))
+		g.PublishEvent(EventPeerState, PeerStateEvent{Address: address, State: string(PeerSuspect)})
+	}
+}
+
+// ExpireMembers - declare dead and evict the suspect peers not heard from in time, and forget the
+// dead peers probed for long enough
+func (g *Gossiper) ExpireMembers() {
+	m := g.Membership
+	now := time.Now()
+	dead := make([]string, 0)
+	m.MembershipLock.Lock()
+	for address, member := range m.Members {
+		switch {
+		case member.State == PeerSuspect && now.Sub(member.StateSince) > time.Duration(g.Settings.SuspectTimeout)*time.Second:
+			member.State = PeerDead
+			member.StateSince = now
+			dead = append(dead, address)
+		case (member.State == PeerDead || member.State == PeerLeft) &&
+			now.Sub(member.StateSince) > time.Duration(g.Settings.DeadRetention)*time.Second:
+			delete(m.Members, address)
+		}
+	}
+	m.MembershipLock.Unlock()
+
+	for _, address := range dead {
+		g.evictKnownPeer(address)
+		g.CountMetric(MetricPeersEvicted, 1)
+		helpers.GossipLog.Warn("peer is dead, evicted from the known peers", helpers.F("peer", address))
+		g.PublishEvent(EventPeerState, PeerStateEvent{Address: address, State: string(PeerDead)})
+	}
+}
+
+// PeerLeaving - handle the announcement of a peer that it is stopping. Only an authenticated
+// announcement, sealed with a session agreed with the peer, marks it left at once. Any other may be
+// forged, so it only makes the peer suspect, and the failure detector declares it dead unless it is
+// heard from again
+func (g *Gossiper) PeerLeaving(address string, authenticated bool) {
+	if authenticated {
+		g.PeerLeft(address)
+		return
+	}
+	g.SuspectPeer(address, time.Now())
+}
+
+// PeerLeft - mark a peer which announced that it is stopping as left, and evict it from the known peers
+func (g *Gossiper) PeerLeft(address string) {
+	m := g.Membership
+	m.MembershipLock.Lock()
+	member, ok := m.Members[address]
+	if ok {
+		member.State = PeerLeft
+		member.StateSince = time.Now()
+	}
+	m.MembershipLock.Unlock()
+	if !ok {
+		return
+	}
+
+	g.evictKnownPeer(address)
+	helpers.GossipLog.Info("peer left, evicted from the known peers", helpers.F("peer", address))
+	g.PublishEvent(EventPeerState, PeerStateEvent{Address: address, State: string(PeerLeft)})
+}
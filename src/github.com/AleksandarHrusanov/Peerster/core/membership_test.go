@@ -0,0 +1,256 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+)
+
+// the peers of the membership tests: a probe target, a peer asked to probe it and a bystander
+const (
+	probeTarget = "127.0.0.1:5001"
+	probeHelper = "127.0.0.1:5002"
+	bystander   = "127.0.0.1:5003"
+)
+
+func acked(c chan bool) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestDirectProbeAckedByTarget(t *testing.T) {
+	g := newTestGossiper(t, "A", probeTarget, probeHelper, bystander)
+	seq, done := g.StartProbe(probeTarget, "", 0)
+	if !g.Membership.Members[probeTarget].Probed {
+		t.Error("target not marked as probed")
+	}
+
+	if requester, _, _ := g.AckProbe(seq, probeTarget); requester != "" {
+		t.Errorf("own probe forwarded to %s", requester)
+	}
+	if !acked(done) {
+		t.Fatal("probe not acked")
+	}
+	member := g.Membership.Members[probeTarget]
+	if !member.AnswersProbes || member.LastHeard.IsZero() {
+		t.Errorf("ack not recorded: %+v", *member)
+	}
+	if _, pending := g.Membership.Probes[seq]; pending {
+		t.Error("acked probe still pending")
+	}
+}
+
+func TestIndirectProbeAckedThroughHelper(t *testing.T) {
+	g := newTestGossiper(t, "A", probeTarget, probeHelper, bystander)
+	seq, done := g.StartProbe(probeTarget, "", 0)
+	g.ProbeThrough(seq, []string{probeHelper})
+
+	// the ack of the helper stands for the target, which is then heard from
+	g.AckProbe(seq, probeHelper)
+	if !acked(done) {
+		t.Fatal("probe not acked through the helper")
+	}
+	if g.Membership.Members[probeTarget].LastHeard.IsZero() {
+		t.Error("target not heard from")
+	}
+	if g.Membership.Members[probeHelper].AnswersProbes {
+		t.Error("helper recorded as answering probes")
+	}
+}
+
+func TestProbeAckIgnored(t *testing.T) {
+	g := newTestGossiper(t, "A", probeTarget, probeHelper, bystander)
+	seq, done := g.StartProbe(probeTarget, "", 0)
+	g.ProbeThrough(seq, []string{probeHelper})
+
+	// a peer neither probed nor asked to probe cannot ack, even with the right sequence number
+	g.AckProbe(seq, bystander)
+	// and the target cannot ack a probe it was not sent, e.g. one of a previous run
+	g.AckProbe(seq+1, probeTarget)
+	g.AckProbe(0, probeTarget)
+
+	if acked(done) {
+		t.Fatal("probe acked")
+	}
+	if g.Membership.Members[probeTarget].AnswersProbes {
+		t.Error("target recorded as answering probes")
+	}
+	// the probe still waits for a legitimate ack
+	g.AckProbe(seq, probeTarget)
+	if !acked(done) {
+		t.Error("probe not acked by its target after the ignored acks")
+	}
+}
+
+func TestProbeOnBehalfOfRequester(t *testing.T) {
+	g := newTestGossiper(t, "A", probeTarget, probeHelper, bystander)
+	seq, done := g.StartProbe(probeTarget, probeHelper, 42)
+	if seq == 42 {
+		t.Error("sequence number of the requester reused")
+	}
+
+	requester, requesterSeq, target := g.AckProbe(seq, probeTarget)
+	if requester != probeHelper || requesterSeq != 42 || target != probeTarget {
+		t.Errorf("ack forwarded to %q with %d for %q", requester, requesterSeq, target)
+	}
+	if acked(done) {
+		t.Error("probe of the requester acked locally")
+	}
+	// a replayed ack is not forwarded again
+	if requester, _, _ := g.AckProbe(seq, probeTarget); requester != "" {
+		t.Errorf("ack forwarded twice, to %s", requester)
+	}
+}
+
+func TestSuspectPeer(t *testing.T) {
+	g := newTestGossiper(t, "A", probeTarget, probeHelper, bystander)
+	probedAt := time.Now()
+	for _, address := range []string{probeTarget, probeHelper} {
+		g.Membership.Members[address].AnswersProbes = true
+	}
+	// a packet received after the probe started shows the peer is alive, even without an ack
+	g.Membership.Members[probeHelper].LastHeard = probedAt.Add(time.Millisecond)
+
+	for _, address := range []string{probeTarget, probeHelper, bystander, "127.0.0.1:5009"} {
+		g.SuspectPeer(address, probedAt)
+	}
+	if state := g.Membership.Members[probeTarget].State; state != PeerSuspect {
+		t.Errorf("silent peer is %s", state)
+	}
+	if state := g.Membership.Members[probeHelper].State; state != PeerAlive {
+		t.Errorf("peer heard from since the probe is %s", state)
+	}
+	// a peer which never acked a probe is given a few more
+	if state := g.Membership.Members[bystander].State; state != PeerAlive {
+		t.Errorf("peer which never answered a probe is %s after one missed probe", state)
+	}
+	if _, tracked := g.Membership.Members["127.0.0.1:5009"]; tracked {
+		t.Error("unknown peer tracked")
+	}
+
+	// a suspect peer is no longer picked for gossip, but is still known and probed
+	if live := g.GetLivePeers(); len(live) != 2 {
+		t.Errorf("live peers %v", live)
+	}
+	if !g.isKnownPeer(probeTarget) {
+		t.Error("suspect peer evicted")
+	}
+	if target := g.NextProbeTarget(0); target == "" {
+		t.Error("no probe target")
+	}
+}
+
+func TestSuspectPeerNeverAcking(t *testing.T) {
+	g := newTestGossiper(t, "A", probeTarget, probeHelper)
+	silent, chatty := g.Membership.Members[probeTarget], g.Membership.Members[probeHelper]
+
+	for i := 1; i <= constants.MissedProbesBeforeSuspect; i++ {
+		// neither acks, but one keeps sending other packets between the probes
+		g.HeardFrom(probeHelper)
+		probedAt := time.Now().Add(time.Millisecond)
+		for _, address := range []string{probeTarget, probeHelper} {
+			seq, _ := g.StartProbe(address, "", 0)
+			g.EndProbe(seq)
+			g.SuspectPeer(address, probedAt)
+		}
+		if i < constants.MissedProbesBeforeSuspect && silent.State != PeerAlive {
+			t.Fatalf("suspected after %d missed probes", i)
+		}
+	}
+	if silent.State != PeerSuspect || silent.MissedProbes != constants.MissedProbesBeforeSuspect {
+		t.Fatalf("silent peer is %s after %d missed probes", silent.State, silent.MissedProbes)
+	}
+	if chatty.State != PeerAlive || chatty.MissedProbes != 1 {
+		t.Errorf("peer sending other packets is %s after %d missed probes", chatty.State, chatty.MissedProbes)
+	}
+
+	// and is evicted like any suspect peer which stays silent
+	silent.StateSince = time.Now().Add(-time.Hour)
+	g.ExpireMembers()
+	if silent.State != PeerDead || g.isKnownPeer(probeTarget) {
+		t.Errorf("silent peer not evicted, state %s", silent.State)
+	}
+	g.HeardFrom(probeTarget)
+	if silent.State != PeerAlive || silent.MissedProbes != 0 {
+		t.Errorf("peer heard from again is %s with %d missed probes", silent.State, silent.MissedProbes)
+	}
+}
+
+func TestExpireMembers(t *testing.T) {
+	g := newTestGossiper(t, "A", probeTarget, probeHelper, bystander)
+	long := time.Now().Add(-time.Hour)
+	g.Membership.Members[probeTarget].State = PeerSuspect
+	g.Membership.Members[probeTarget].StateSince = long
+	g.Membership.Members[probeHelper].State = PeerSuspect
+	g.Membership.Members[probeHelper].StateSince = time.Now()
+
+	g.ExpireMembers()
+	if state := g.Membership.Members[probeTarget].State; state != PeerDead || g.isKnownPeer(probeTarget) {
+		t.Errorf("suspect peer not declared dead and evicted, state %s", state)
+	}
+	if state := g.Membership.Members[probeHelper].State; state != PeerSuspect || !g.isKnownPeer(probeHelper) {
+		t.Errorf("recently suspected peer expired, state %s", state)
+	}
+	// dead peers are still probed, until they are forgotten
+	if dead := g.PickDeadPeer(); dead != probeTarget {
+		t.Errorf("picked dead peer %q", dead)
+	}
+	g.Membership.Members[probeTarget].StateSince = long
+	g.ExpireMembers()
+	if _, tracked := g.Membership.Members[probeTarget]; tracked {
+		t.Error("dead peer not forgotten")
+	}
+
+	// a forgotten peer sending packets again joins as alive
+	g.HeardFrom(probeTarget)
+	if state := g.Membership.Members[probeTarget].State; state != PeerAlive || !g.isKnownPeer(probeTarget) {
+		t.Errorf("dead peer not revived, state %s", state)
+	}
+}
+
+func TestRemovedPeerNotAddedBack(t *testing.T) {
+	g := newTestGossiper(t, "A", probeTarget, probeHelper, bystander)
+	if err := g.RemovePeer(probeTarget); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if err := g.RemovePeer(probeTarget); err != ErrUnknownPeer {
+		t.Errorf("removing twice: got error %v, want %v", err, ErrUnknownPeer)
+	}
+	g.HeardFrom(probeTarget)
+	if g.isKnownPeer(probeTarget) {
+		t.Error("removed peer added back")
+	}
+}
+
+func TestPeerLeaving(t *testing.T) {
+	g := newTestGossiper(t, "A", probeTarget, probeHelper, bystander)
+	heardAt := time.Now().Add(-time.Second)
+	for _, address := range []string{probeTarget, probeHelper} {
+		g.Membership.Members[address].AnswersProbes = true
+		g.Membership.Members[address].LastHeard = heardAt
+	}
+
+	// a leave sealed with a session evicts the peer at once
+	g.PeerLeaving(probeTarget, true)
+	if state := g.Membership.Members[probeTarget].State; state != PeerLeft || g.isKnownPeer(probeTarget) {
+		t.Errorf("authenticated leave: state %s, known %v", state, g.isKnownPeer(probeTarget))
+	}
+	if target := g.NextProbeTarget(0); target == probeTarget {
+		t.Error("peer which left is probed")
+	}
+
+	// anyone may have sent a plaintext one, so the failure detector has the last word
+	g.PeerLeaving(probeHelper, false)
+	if state := g.Membership.Members[probeHelper].State; state != PeerSuspect || !g.isKnownPeer(probeHelper) {
+		t.Errorf("unauthenticated leave: state %s, known %v", state, g.isKnownPeer(probeHelper))
+	}
+	g.HeardFrom(probeHelper)
+	if state := g.Membership.Members[probeHelper].State; state != PeerAlive {
+		t.Errorf("peer heard from after a forged leave is %s", state)
+	}
+}
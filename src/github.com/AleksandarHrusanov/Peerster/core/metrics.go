@@ -0,0 +1,215 @@
+package core
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+	"github.com/dedis/protobuf"
+)
+
+// MetricCounter - a counter of the gossiper exported on /metrics, named like the Prometheus metric
+type MetricCounter string
+
+// The counters of the gossiper besides the packets sent and received
+const (
+	MetricDecodeErrors           MetricCounter = "peerster_decode_errors_total"
+	MetricChunkBytesServed       MetricCounter = "peerster_chunk_bytes_served_total"
+	MetricChunkBytesReceived     MetricCounter = "peerster_chunk_bytes_received_total"
+	MetricSearchRequestsHandled  MetricCounter = "peerster_search_requests_handled_total"
+	MetricTLCAcksReceived        MetricCounter = "peerster_tlc_acks_received_total"
+	MetricTLCConfirmations       MetricCounter = "peerster_tlc_confirmations_total"
+	MetricPacketsFragmented      MetricCounter = "peerster_packets_fragmented_total"
+	MetricPacketsReassembled     MetricCounter = "peerster_packets_reassembled_total"
+	MetricPacketsTooLarge        MetricCounter = "peerster_packets_too_large_total"
+	MetricFragmentsDropped       MetricCounter = "peerster_fragments_dropped_total"
+	MetricStreamPacketsSent      MetricCounter = "peerster_stream_packets_sent_total"
+	MetricStreamPacketsReceived  MetricCounter = "peerster_stream_packets_received_total"
+	MetricHandshakesCompleted    MetricCounter = "peerster_handshakes_completed_total"
+	MetricHandshakesFailed       MetricCounter = "peerster_handshakes_failed_total"
+	MetricPacketsRejected        MetricCounter = "peerster_packets_rejected_total"
+	MetricPacketsFromBannedPeers MetricCounter = "peerster_packets_from_banned_peers_total"
+	MetricPeersBanned            MetricCounter = "peerster_peer_bans_total"
+	MetricPacketLimitsCapped     MetricCounter = "peerster_packet_limits_capped_total"
+	MetricPeersEvicted           MetricCounter = "peerster_peers_evicted_total"
+	MetricViewShuffles           MetricCounter = "peerster_view_shuffles_total"
+	MetricPeersDiscovered        MetricCounter = "peerster_peers_discovered_total"
+)
+
+// SafeMetrics - the counters of the gossiper. Gauges are computed from its state when scraped
+type SafeMetrics struct {
+	PacketsSent        map[string]uint64 // by packet type
+	PacketsReceived    map[string]uint64 // by packet type
+	PacketsRateLimited map[string]uint64 // by packet type
+	Counters           map[MetricCounter]uint64
+	MetricsLock        sync.Mutex
+}
+
+// NewSafeMetrics - create empty metrics
+func NewSafeMetrics() *SafeMetrics {
+	return &SafeMetrics{PacketsSent: make(map[string]uint64), PacketsReceived: make(map[string]uint64),
+		PacketsRateLimited: make(map[string]uint64), Counters: make(map[MetricCounter]uint64)}
+}
+
+// PacketType - the name of the message carried by a gossip packet, "unknown" if it is empty
+func PacketType(packet *GossipPacket) string {
+	switch {
+	case packet.Simple != nil:
+		return "simple"
+	case packet.Rumor != nil:
+		return "rumor"
+	case packet.Status != nil:
+		return "status"
+	case packet.Private != nil:
+		return "private"
+	case packet.DataRequest != nil:
+		return "data_request"
+	case packet.DataReply != nil:
+		return "data_reply"
+	case packet.SearchRequest != nil:
+		return "search_request"
+	case packet.SearchReply != nil:
+		return "search_reply"
+	case packet.TLCMessage != nil:
+		return "tlc"
+	case packet.Ack != nil:
+		return "tlc_ack"
+	case packet.PoWTransaction != nil:
+		return "pow_transaction"
+	case packet.PoWBlock != nil:
+		return "pow_block"
+	case packet.NameProofRequest != nil:
+		return "name_proof_request"
+	case packet.NameProofReply != nil:
+		return "name_proof_reply"
+	case packet.Fragment != nil:
+		return "fragment"
+	case packet.Probe != nil:
+		return "probe"
+	case packet.Shuffle != nil:
+		return "shuffle"
+	case packet.PeerExchange != nil:
+		return "peer_exchange"
+	}
+	return "unknown"
+}
+
+// CountMetric - add n to a counter of the gossiper
+func (g *Gossiper) CountMetric(counter MetricCounter, n uint64) {
+	g.Metrics.MetricsLock.Lock()
+	g.Metrics.Counters[counter] += n
+	g.Metrics.MetricsLock.Unlock()
+}
+
+// CountPacketReceived - count a gossip packet received from a peer
+func (g *Gossiper) CountPacketReceived(packet *GossipPacket) {
+	packetType := PacketType(packet)
+	g.Metrics.MetricsLock.Lock()
+	g.Metrics.PacketsReceived[packetType]++
+	g.Metrics.MetricsLock.Unlock()
+}
+
+// SendPacket - encode the gossip packet and send it to the given peer, counting it in the metrics.
+// Data requests and replies go over the stream connection to the peer if there is one. Packets
+// larger than a datagram are sent in fragments; packets too large even for that are dropped with
+// an error. Datagrams are sealed for the peer when the gossip socket is encrypted
+func (g *Gossiper) SendPacket(addressAndPort string, packet *GossipPacket) error {
+	packetBytes, err := protobuf.Encode(packet)
+	helpers.HandleErrorFatal(err)
+	if strings.Compare(addressAndPort, "") == 0 {
+		return nil
+	}
+
+	packetType := PacketType(packet)
+	if g.Streams != nil && UsesStream(packet) && g.sendOverStream(addressAndPort, packetBytes) {
+		g.Metrics.MetricsLock.Lock()
+		g.Metrics.PacketsSent[packetType]++
+		g.Metrics.MetricsLock.Unlock()
+		return nil
+	}
+
+	datagrams := [][]byte{packetBytes}
+	if len(packetBytes) > constants.MaxUDPPacketSize {
+		datagrams, err = g.fragmentPacket(packetBytes)
+		if err != nil {
+			g.CountMetric(MetricPacketsTooLarge, 1)
+			helpers.GossipLog.Error("cannot send packet", helpers.F("peer", addressAndPort),
+				helpers.F("type", packetType), helpers.F("size", len(packetBytes)), helpers.F("error", err))
+			return err
+		}
+		g.CountMetric(MetricPacketsFragmented, 1)
+	}
+
+	g.Metrics.MetricsLock.Lock()
+	g.Metrics.PacketsSent[packetType]++
+	g.Metrics.MetricsLock.Unlock()
+	for _, datagram := range datagrams {
+		g.sendDatagram(addressAndPort, datagram)
+	}
+	return nil
+}
+
+// MetricsSnapshot - the counters of the gossiper and its gauges at a point in time
+type MetricsSnapshot struct {
+	PacketsSent        map[string]uint64
+	PacketsReceived    map[string]uint64
+	PacketsRateLimited map[string]uint64
+	Counters           map[MetricCounter]uint64
+	RumorsStored       int
+	MongeringStatuses  int // rumors and TLCs sent and still waiting for a status
+	RoutingTableSize   int
+	KnownPeers         int
+	ActiveDownloads    int
+	BannedPeers        int
+	PeersByState       map[string]uint64
+}
+
+// GetMetricsSnapshot - returns a copy of the counters of the gossiper together with its gauges
+func (g *Gossiper) GetMetricsSnapshot() MetricsSnapshot {
+	snapshot := MetricsSnapshot{PacketsSent: make(map[string]uint64), PacketsReceived: make(map[string]uint64),
+		PacketsRateLimited: make(map[string]uint64), Counters: make(map[MetricCounter]uint64)}
+	g.Metrics.MetricsLock.Lock()
+	for packetType, n := range g.Metrics.PacketsSent {
+		snapshot.PacketsSent[packetType] = n
+	}
+	for packetType, n := range g.Metrics.PacketsReceived {
+		snapshot.PacketsReceived[packetType] = n
+	}
+	for packetType, n := range g.Metrics.PacketsRateLimited {
+		snapshot.PacketsRateLimited[packetType] = n
+	}
+	for counter, n := range g.Metrics.Counters {
+		snapshot.Counters[counter] = n
+	}
+	g.Metrics.MetricsLock.Unlock()
+
+	snapshot.RumorsStored = len(g.GetAllRumors())
+	for _, status := range g.GetMongeringStatuses() {
+		status.Lock.Lock()
+		if !status.AckReceived {
+			snapshot.MongeringStatuses++
+		}
+		status.Lock.Unlock()
+	}
+	g.DestinationTable.DsdvLock.Lock()
+	snapshot.RoutingTableSize = len(g.DestinationTable.Dsdv)
+	g.DestinationTable.DsdvLock.Unlock()
+	g.PeersLock.Lock()
+	snapshot.KnownPeers = len(g.KnownPeers)
+	g.PeersLock.Unlock()
+	g.DownloadStreams.StreamsLock.Lock()
+	for _, stream := range g.DownloadStreams.Streams {
+		if !stream.Finished {
+			snapshot.ActiveDownloads++
+		}
+	}
+	g.DownloadStreams.StreamsLock.Unlock()
+	snapshot.BannedPeers = len(g.GetBans())
+	snapshot.PeersByState = map[string]uint64{string(PeerAlive): 0, string(PeerSuspect): 0, string(PeerDead): 0,
+		string(PeerLeft): 0}
+	for _, member := range g.GetMembers() {
+		snapshot.PeersByState[string(member.State)]++
+	}
+	return snapshot
+}
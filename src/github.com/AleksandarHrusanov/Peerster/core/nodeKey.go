@@ -0,0 +1,62 @@
+package core
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+)
+
+// ErrInvalidNodeKey - the node key file does not hold an Ed25519 private key
+var ErrInvalidNodeKey = errors.New("node key file does not hold an Ed25519 private key")
+
+// LoadOrCreateNodeKey - read the Ed25519 key identifying the node to its neighbours from the given
+// file, creating the file with a new key if it does not exist
+func LoadOrCreateNodeKey(path string) (ed25519.PrivateKey, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return createNodeKey(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, ErrInvalidNodeKey
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	nodeKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, ErrInvalidNodeKey
+	}
+	return nodeKey, nil
+}
+
+func createNodeKey(path string) (ed25519.PrivateKey, error) {
+	_, nodeKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(nodeKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), constants.FileMode); err != nil {
+		return nil, err
+	}
+	content := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := ioutil.WriteFile(path, content, 0600); err != nil {
+		return nil, err
+	}
+	return nodeKey, nil
+}
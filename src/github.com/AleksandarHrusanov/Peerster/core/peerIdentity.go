@@ -0,0 +1,158 @@
+package core
+
+import (
+	"net"
+	"net/netip"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+)
+
+// SafePeerIdentities - the identities of the peers, kept apart from their addresses so that a peer
+// which changes address is not known twice. An identity is the node key a peer presented in its
+// handshake ("key:<hex>"), or the host name it was given by ("dns:<name:port>"). Addresses holds the
+// current address of every identity; Names the host names of peers resolved again periodically
+type SafePeerIdentities struct {
+	Addresses      map[string]string
+	Names          []string
+	IdentitiesLock sync.Mutex
+}
+
+// NewSafePeerIdentities - create the identities of the peers of a gossiper
+func NewSafePeerIdentities() *SafePeerIdentities {
+	return &SafePeerIdentities{Addresses: make(map[string]string), Names: make([]string, 0)}
+}
+
+// BindIdentity - record the current address of a peer identity. If the peer was known at another
+// address, that address is replaced by the new one in the known peers. Returns false if the identity
+// was already bound to that address
+func (g *Gossiper) BindIdentity(identity string, address string) bool {
+	ids := g.Identities
+	ids.IdentitiesLock.Lock()
+	previous, ok := ids.Addresses[identity]
+	ids.Addresses[identity] = address
+	ids.IdentitiesLock.Unlock()
+	if !ok || strings.Compare(previous, address) == 0 {
+		return !ok
+	}
+
+	helpers.GossipLog.Info("peer changed address", helpers.F("peer", address), helpers.F("previous", previous),
+		helpers.F("identity", identity))
+	if g.evictFromView(previous) {
+		g.joinKnownPeers(address)
+	}
+	return true
+}
+
+// GetPeerIdentities - the identities bound to each address, sorted
+func (g *Gossiper) GetPeerIdentities() map[string][]string {
+	identities := make(map[string][]string)
+	g.Identities.IdentitiesLock.Lock()
+	for identity, address := range g.Identities.Addresses {
+		identities[address] = append(identities[address], identity)
+	}
+	g.Identities.IdentitiesLock.Unlock()
+	for _, ids := range identities {
+		sort.Strings(ids)
+	}
+	return identities
+}
+
+// AddPeerNames - add peers given by host name, which are resolved now and every ResolvePeriod seconds
+func (g *Gossiper) AddPeerNames(names []string) {
+	g.Identities.IdentitiesLock.Lock()
+	for _, name := range names {
+		if !helpers.SliceContainsString(g.Identities.Names, name) {
+			g.Identities.Names = append(g.Identities.Names, name)
+		}
+	}
+	g.Identities.IdentitiesLock.Unlock()
+	g.ResolvePeerNames()
+}
+
+// ResolvePeerNames - resolve the host names of the peers given by name, and add them to the known
+// peers at their current address
+func (g *Gossiper) ResolvePeerNames() {
+	g.Identities.IdentitiesLock.Lock()
+	names := g.Identities.Names
+	g.Identities.IdentitiesLock.Unlock()
+
+	for _, name := range names {
+		address, err := g.ResolveName(name)
+		if err != nil {
+			helpers.GossipLog.Warn("cannot resolve peer", helpers.F("peer", name), helpers.F("error", err))
+			continue
+		}
+		if g.IsSelf(address) {
+			continue
+		}
+		if g.BindIdentity("dns:"+name, address) {
+			g.joinKnownPeers(address)
+		}
+	}
+}
+
+// ResolveName - the address of a host name and port, in the address family the gossip socket can reach
+func (g *Gossiper) ResolveName(name string) (string, error) {
+	udpAddr, err := net.ResolveUDPAddr(g.gossipNetwork(), name)
+	if err != nil {
+		return "", err
+	}
+	address, _ := helpers.NormalizeAddress(udpAddr.String())
+	return address, nil
+}
+
+// CanReach - whether an address is of the address family the gossip socket can send to
+func (g *Gossiper) CanReach(address string) bool {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return false
+	}
+	switch g.gossipNetwork() {
+	case "udp4":
+		return addrPort.Addr().Unmap().Is4()
+	case "udp6":
+		return !addrPort.Addr().Unmap().Is4()
+	}
+	return true
+}
+
+// the network of the gossip socket: udp4 for an IPv4 socket, udp6 for an IPv6 one and udp for a
+// socket on both
+func (g *Gossiper) gossipNetwork() string {
+	switch {
+	case g.Address.IP.IsUnspecified():
+		return "udp"
+	case g.Address.IP.To4() != nil:
+		return "udp4"
+	}
+	return "udp6"
+}
+
+// IsSelf - whether an address is one of this gossiper's: its gossip address or, for a socket on an
+// unspecified host, its port on any address of this machine
+func (g *Gossiper) IsSelf(address string) bool {
+	if strings.Compare(address, g.Address.String()) == 0 {
+		return true
+	}
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil || !g.Address.IP.IsUnspecified() || int(addrPort.Port()) != g.Address.Port {
+		return false
+	}
+	ip := addrPort.Addr().Unmap()
+	if ip.IsLoopback() || ip.IsUnspecified() {
+		return true
+	}
+	localAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, localAddr := range localAddrs {
+		if ipNet, ok := localAddr.(*net.IPNet); ok && ipNet.IP.Equal(net.IP(ip.AsSlice())) {
+			return true
+		}
+	}
+	return false
+}
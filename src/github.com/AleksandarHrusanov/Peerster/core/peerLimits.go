@@ -0,0 +1,241 @@
+package core
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+)
+
+// rateLimit - the packets per second a peer may send of one type, and how many it may send at once
+type rateLimit struct {
+	Rate  float64
+	Burst float64
+}
+
+// the rate limits per peer by packet type. Data requests and replies are sent back to back during a
+// download; searches flood the network and are the cheapest to abuse
+var packetRateLimits = map[string]rateLimit{
+	"simple":             {Rate: 50, Burst: 100},
+	"rumor":              {Rate: 100, Burst: 200},
+	"status":             {Rate: 100, Burst: 200},
+	"private":            {Rate: 20, Burst: 50},
+	"data_request":       {Rate: 2000, Burst: 4000},
+	"data_reply":         {Rate: 2000, Burst: 4000},
+	"search_request":     {Rate: 5, Burst: 10},
+	"search_reply":       {Rate: 20, Burst: 50},
+	"tlc":                {Rate: 20, Burst: 50},
+	"tlc_ack":            {Rate: 20, Burst: 50},
+	"pow_transaction":    {Rate: 20, Burst: 50},
+	"pow_block":          {Rate: 10, Burst: 20},
+	"name_proof_request": {Rate: 10, Burst: 20},
+	"name_proof_reply":   {Rate: 10, Burst: 20},
+	"handshake":          {Rate: 10, Burst: 20},
+	"probe":              {Rate: 20, Burst: 50},
+	"shuffle":            {Rate: 5, Burst: 10},
+	"peer_exchange":      {Rate: 2, Burst: 5},
+}
+
+// the rate limit of packets which could not be decoded or authenticated
+var unknownPacketRateLimit = rateLimit{Rate: 5, Burst: 10}
+
+// tokenBucket - the packets of one type a peer may still send, refilled at the rate of the type
+type tokenBucket struct {
+	Tokens float64
+	Last   time.Time
+}
+
+// peerOffences - the packets over the rate limits a peer sent in the current ban window
+type peerOffences struct {
+	Count       int
+	WindowStart time.Time
+}
+
+// SafePeerLimits - the token buckets of the peers by address and packet type, their packets over the
+// limits and the peers banned until a given time
+type SafePeerLimits struct {
+	Buckets     map[string]*tokenBucket
+	Offences    map[string]*peerOffences
+	Bans        map[string]time.Time
+	BanDuration time.Duration
+	LastCleanup time.Time
+	LimitsLock  sync.Mutex
+}
+
+// PeerBan - a peer whose packets are dropped until the given time
+type PeerBan struct {
+	Address string
+	Until   time.Time
+}
+
+// NewSafePeerLimits - create the rate limits of a gossiper, banning abusive peers for the given duration
+func NewSafePeerLimits(banDuration time.Duration) *SafePeerLimits {
+	return &SafePeerLimits{Buckets: make(map[string]*tokenBucket), Offences: make(map[string]*peerOffences),
+		Bans: make(map[string]time.Time), BanDuration: banDuration, LastCleanup: time.Now()}
+}
+
+// AdmitPacket - whether a packet received from a peer is handled. Packets of banned peers and packets
+// over the rate limit of their type are dropped. Only authenticated packets, which surely come from
+// the peer, count towards a ban: packets with its forged address must not get it banned.
+// Search budgets and hop limits above the caps are lowered
+func (g *Gossiper) AdmitPacket(fromAddr string, packet *GossipPacket, authenticated bool) bool {
+	if !g.admitPacketType(fromAddr, PacketType(packet), authenticated) {
+		return false
+	}
+	if capPacketLimits(packet, g.Settings) {
+		g.CountMetric(MetricPacketLimitsCapped, 1)
+	}
+	return true
+}
+
+// takes a token from the bucket of the packet type of a peer, if the peer is not banned. A packet over
+// the limit counts as an offence of the peer only if it is authenticated
+func (g *Gossiper) admitPacketType(fromAddr string, packetType string, authenticated bool) bool {
+	if g.Limits == nil {
+		return true
+	}
+	l := g.Limits
+	now := time.Now()
+
+	l.LimitsLock.Lock()
+	if now.Sub(l.LastCleanup) > time.Duration(g.Settings.LimitsCleanupPeriod)*time.Second {
+		l.cleanup(now, g.Settings)
+	}
+	if until, banned := l.Bans[fromAddr]; banned {
+		if now.Before(until) {
+			l.LimitsLock.Unlock()
+			g.CountMetric(MetricPacketsFromBannedPeers, 1)
+			return false
+		}
+		delete(l.Bans, fromAddr)
+	}
+
+	limit, ok := packetRateLimits[packetType]
+	if !ok {
+		limit = unknownPacketRateLimit
+	}
+	key := fromAddr + "/" + packetType
+	bucket, ok := l.Buckets[key]
+	if !ok {
+		bucket = &tokenBucket{Tokens: limit.Burst, Last: now}
+		l.Buckets[key] = bucket
+	}
+	bucket.Tokens += now.Sub(bucket.Last).Seconds() * limit.Rate
+	if bucket.Tokens > limit.Burst {
+		bucket.Tokens = limit.Burst
+	}
+	bucket.Last = now
+	if bucket.Tokens >= 1 {
+		bucket.Tokens--
+		l.LimitsLock.Unlock()
+		return true
+	}
+	banned := authenticated && l.offend(fromAddr, now, g.Settings)
+	l.LimitsLock.Unlock()
+
+	g.Metrics.MetricsLock.Lock()
+	g.Metrics.PacketsRateLimited[packetType]++
+	g.Metrics.MetricsLock.Unlock()
+	if banned {
+		g.CountMetric(MetricPeersBanned, 1)
+		helpers.GossipLog.Warn("banned peer sending too many packets", helpers.F("peer", fromAddr),
+			helpers.F("type", packetType), helpers.F("duration", l.BanDuration.String()))
+	}
+	return false
+}
+
+// GetBans - the peers currently banned, sorted by address
+func (g *Gossiper) GetBans() []PeerBan {
+	bans := make([]PeerBan, 0)
+	if g.Limits == nil {
+		return bans
+	}
+	now := time.Now()
+	g.Limits.LimitsLock.Lock()
+	for address, until := range g.Limits.Bans {
+		if now.Before(until) {
+			bans = append(bans, PeerBan{Address: address, Until: until})
+		}
+	}
+	g.Limits.LimitsLock.Unlock()
+	sort.Slice(bans, func(i, j int) bool { return strings.Compare(bans[i].Address, bans[j].Address) < 0 })
+	return bans
+}
+
+// counts a packet over the limits and bans the peer if it sent too many; must be called with the lock held
+func (l *SafePeerLimits) offend(fromAddr string, now time.Time, settings *Settings) bool {
+	offences, ok := l.Offences[fromAddr]
+	if !ok || now.Sub(offences.WindowStart) > time.Duration(settings.BanWindow)*time.Second {
+		offences = &peerOffences{WindowStart: now}
+		l.Offences[fromAddr] = offences
+	}
+	offences.Count++
+	if offences.Count < constants.BanThreshold {
+		return false
+	}
+	delete(l.Offences, fromAddr)
+	l.Bans[fromAddr] = now.Add(l.BanDuration)
+	return true
+}
+
+// forgets the buckets of peers which went quiet, which are full again, and the bans and offences which
+// are over, so that packets from many addresses do not grow the maps forever; must be called with the lock held
+func (l *SafePeerLimits) cleanup(now time.Time, settings *Settings) {
+	l.LastCleanup = now
+	for key, bucket := range l.Buckets {
+		if now.Sub(bucket.Last) > time.Duration(settings.LimitsCleanupPeriod)*time.Second {
+			delete(l.Buckets, key)
+		}
+	}
+	for address, offences := range l.Offences {
+		if now.Sub(offences.WindowStart) > time.Duration(settings.BanWindow)*time.Second {
+			delete(l.Offences, address)
+		}
+	}
+	for address, until := range l.Bans {
+		if !now.Before(until) {
+			delete(l.Bans, address)
+		}
+	}
+}
+
+// lowers the search budget and the hop limit of a packet to the caps. Returns true if any was lowered
+func capPacketLimits(packet *GossipPacket, settings *Settings) bool {
+	var hopLimit *uint32
+	switch {
+	case packet.SearchRequest != nil:
+		if packet.SearchRequest.Budget > settings.MaxSearchBudget {
+			packet.SearchRequest.Budget = settings.MaxSearchBudget
+			return true
+		}
+		return false
+	case packet.Private != nil:
+		hopLimit = &packet.Private.HopLimit
+	case packet.DataRequest != nil:
+		hopLimit = &packet.DataRequest.HopLimit
+	case packet.DataReply != nil:
+		hopLimit = &packet.DataReply.HopLimit
+	case packet.SearchReply != nil:
+		hopLimit = &packet.SearchReply.HopLimit
+	case packet.Ack != nil:
+		hopLimit = &packet.Ack.HopLimit
+	case packet.NameProofRequest != nil:
+		hopLimit = &packet.NameProofRequest.HopLimit
+	case packet.NameProofReply != nil:
+		hopLimit = &packet.NameProofReply.HopLimit
+	case packet.PoWTransaction != nil:
+		hopLimit = &packet.PoWTransaction.HopLimit
+	case packet.PoWBlock != nil:
+		hopLimit = &packet.PoWBlock.HopLimit
+	default:
+		return false
+	}
+	if *hopLimit > settings.MaxHopLimit {
+		*hopLimit = settings.MaxHopLimit
+		return true
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package core
+
+import (
+	"testing"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+)
+
+// a test gossiper with rate limits, banning for a minute
+func newLimitedTestGossiper(t *testing.T) *Gossiper {
+	g := newTestGossiper(t, "A")
+	g.Limits = NewSafePeerLimits(time.Minute)
+	return g
+}
+
+func TestAdmitPacketTypeBurst(t *testing.T) {
+	g := newLimitedTestGossiper(t)
+	limit := packetRateLimits["search_request"]
+	for i := 0; i < int(limit.Burst); i++ {
+		if !g.admitPacketType("127.0.0.1:5001", "search_request", true) {
+			t.Fatalf("packet %d of the burst refused", i)
+		}
+	}
+	if g.admitPacketType("127.0.0.1:5001", "search_request", true) {
+		t.Error("packet over the burst admitted")
+	}
+	if !g.admitPacketType("127.0.0.1:5001", "rumor", true) {
+		t.Error("packet of another type refused")
+	}
+	if !g.admitPacketType("127.0.0.1:5002", "search_request", true) {
+		t.Error("packet of another peer refused")
+	}
+
+	// the bucket refills at the rate of the type
+	g.Limits.Buckets["127.0.0.1:5001/search_request"].Last = time.Now().Add(-time.Second)
+	for i := 0; i < int(limit.Rate); i++ {
+		if !g.admitPacketType("127.0.0.1:5001", "search_request", true) {
+			t.Fatalf("packet %d after a second refused", i)
+		}
+	}
+	if g.admitPacketType("127.0.0.1:5001", "search_request", true) {
+		t.Error("more packets admitted than refilled")
+	}
+}
+
+func TestAdmitPacketTypeBan(t *testing.T) {
+	tests := []struct {
+		name          string
+		authenticated bool
+		wantBan       bool
+	}{
+		{"authenticated packets", true, true},
+		// anyone can send packets with the address of the peer
+		{"unauthenticated packets", false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := newLimitedTestGossiper(t)
+			burst := int(unknownPacketRateLimit.Burst)
+			admitted := 0
+			for i := 0; i < burst+constants.BanThreshold; i++ {
+				if g.admitPacketType("127.0.0.1:5001", "unknown", test.authenticated) {
+					admitted++
+				}
+			}
+			if admitted != burst {
+				t.Errorf("admitted %d packets, want %d", admitted, burst)
+			}
+			_, banned := g.Limits.Bans["127.0.0.1:5001"]
+			if banned != test.wantBan {
+				t.Fatalf("banned %v, want %v", banned, test.wantBan)
+			}
+			if admitted := g.admitPacketType("127.0.0.1:5001", "rumor", false); admitted == test.wantBan {
+				t.Errorf("packet of another type admitted %v", admitted)
+			}
+			if bans := g.GetBans(); test.wantBan && (len(bans) != 1 || bans[0].Address != "127.0.0.1:5001") {
+				t.Errorf("unexpected bans %+v", bans)
+			}
+		})
+	}
+}
+
+func TestBanExpires(t *testing.T) {
+	g := newLimitedTestGossiper(t)
+	g.Limits.Bans["127.0.0.1:5001"] = time.Now().Add(-time.Second)
+	if !g.admitPacketType("127.0.0.1:5001", "rumor", true) {
+		t.Error("packet refused after the ban")
+	}
+	if len(g.GetBans()) != 0 {
+		t.Error("ban still listed")
+	}
+}
+
+func TestLimitsCleanup(t *testing.T) {
+	g := newLimitedTestGossiper(t)
+	now := time.Now()
+	l := g.Limits
+	old := now.Add(-time.Duration(g.Settings.LimitsCleanupPeriod+g.Settings.BanWindow) * time.Second)
+	l.Buckets["quiet/rumor"] = &tokenBucket{Last: old}
+	l.Buckets["busy/rumor"] = &tokenBucket{Last: now}
+	l.Offences["quiet"] = &peerOffences{Count: 1, WindowStart: old}
+	l.Offences["busy"] = &peerOffences{Count: 1, WindowStart: now}
+	l.Bans["quiet"] = now.Add(-time.Second)
+	l.Bans["busy"] = now.Add(time.Minute)
+
+	l.cleanup(now, g.Settings)
+	if _, ok := l.Buckets["quiet/rumor"]; ok || len(l.Buckets) != 1 {
+		t.Errorf("unexpected buckets %v", l.Buckets)
+	}
+	if _, ok := l.Offences["quiet"]; ok || len(l.Offences) != 1 {
+		t.Errorf("unexpected offences %v", l.Offences)
+	}
+	if _, ok := l.Bans["quiet"]; ok || len(l.Bans) != 1 {
+		t.Errorf("unexpected bans %v", l.Bans)
+	}
+}
+
+func TestCapPacketLimits(t *testing.T) {
+	settings := DefaultSettings()
+	tests := []struct {
+		name       string
+		packet     *GossipPacket
+		wantCapped bool
+		limit      func(p *GossipPacket) uint64
+		want       uint64
+	}{
+		{"search budget over the cap", &GossipPacket{SearchRequest: &SearchRequest{Budget: settings.MaxSearchBudget + 1}},
+			true, func(p *GossipPacket) uint64 { return p.SearchRequest.Budget }, settings.MaxSearchBudget},
+		{"search budget at the cap", &GossipPacket{SearchRequest: &SearchRequest{Budget: settings.MaxSearchBudget}},
+			false, func(p *GossipPacket) uint64 { return p.SearchRequest.Budget }, settings.MaxSearchBudget},
+		{"hop limit over the cap", &GossipPacket{Private: &PrivateMessage{HopLimit: 1000}},
+			true, func(p *GossipPacket) uint64 { return uint64(p.Private.HopLimit) }, uint64(settings.MaxHopLimit)},
+		{"hop limit under the cap", &GossipPacket{Private: &PrivateMessage{HopLimit: 10}},
+			false, func(p *GossipPacket) uint64 { return uint64(p.Private.HopLimit) }, 10},
+		{"no limit", &GossipPacket{Rumor: &RumorMessage{ID: 1}},
+			false, func(p *GossipPacket) uint64 { return uint64(p.Rumor.ID) }, 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if capped := capPacketLimits(test.packet, settings); capped != test.wantCapped {
+				t.Errorf("capped %v, want %v", capped, test.wantCapped)
+			}
+			if got := test.limit(test.packet); got != test.want {
+				t.Errorf("got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package core
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+)
+
+// SafePeerView - the partial view of the network: the known peers are at most Size, and are shuffled
+// with other peers Cyclon-style so that the overlay stays connected while the degree of every node
+// stays small. Ages counts the shuffles each known peer has been in the view for; Sent holds the peers
+// offered in the shuffles waiting for a reply, by target. When the reply comes, the target is replaced
+// first by the peers received in exchange, then the peers offered to it
+type SafePeerView struct {
+	Size     int
+	Ages     map[string]uint32
+	Sent     map[string][]string
+	ViewLock sync.Mutex
+}
+
+// NewSafePeerView - create a partial view of the given size
+func NewSafePeerView(size int) *SafePeerView {
+	return &SafePeerView{Size: size, Ages: make(map[string]uint32), Sent: make(map[string][]string)}
+}
+
+// forgets the age and the pending shuffle of a peer which left the view
+func (v *SafePeerView) forget(address string) {
+	v.ViewLock.Lock()
+	delete(v.Ages, address)
+	delete(v.Sent, address)
+	v.ViewLock.Unlock()
+}
+
+// StartShuffle - age the view and pick the oldest alive peer to shuffle with, together with the peers
+// offered to it: this node and up to ShuffleLength-1 other random peers. Returns "" if there is no
+// peer to shuffle with
+func (g *Gossiper) StartShuffle() (string, *PeerShuffle) {
+	peers := g.GetLivePeers()
+	if g.View == nil || len(peers) == 0 {
+		return "", nil
+	}
+	v := g.View
+	v.ViewLock.Lock()
+	target := ""
+	for _, peer := range peers {
+		v.Ages[peer]++
+		if strings.Compare(target, "") == 0 || v.Ages[peer] > v.Ages[target] {
+			target = peer
+		}
+	}
+	// the target gets this node in exchange, so it is no longer the oldest entry
+	v.Ages[target] = 0
+	v.ViewLock.Unlock()
+
+	sent := g.pickMembers(constants.ShuffleLength-1, target, isAlive)
+	v.ViewLock.Lock()
+	v.Sent[target] = sent
+	shuffle := &PeerShuffle{Entries: []*ShuffleEntry{{Address: g.Address.String()}}}
+	for _, peer := range sent {
+		shuffle.Entries = append(shuffle.Entries, &ShuffleEntry{Address: peer, Age: v.Ages[peer]})
+	}
+	v.ViewLock.Unlock()
+	return target, shuffle
+}
+
+// HandleShuffle - merge the peers received in a shuffle into the view. A request is answered with up
+// to ShuffleLength random peers, which are the first replaced by the received ones; a requester which
+// is not in the view replaces at most one of them, so that peers unknown to this node cannot take
+// over its view. The reply to a shuffle of this node replaces the target, as in Cyclon, then the peers
+// it offered. Returns the reply to send, nil when the shuffle is itself a reply
+func (g *Gossiper) HandleShuffle(fromAddr string, shuffle *PeerShuffle) *PeerShuffle {
+	if g.View == nil {
+		return nil
+	}
+	v := g.View
+	var reply *PeerShuffle
+	var replaceable []string
+	if !shuffle.Reply {
+		replaceable = g.pickMembers(constants.ShuffleLength, fromAddr, isAlive)
+		v.ViewLock.Lock()
+		reply = &PeerShuffle{Reply: true, Entries: make([]*ShuffleEntry, 0, len(replaceable))}
+		for _, peer := range replaceable {
+			reply.Entries = append(reply.Entries, &ShuffleEntry{Address: peer, Age: v.Ages[peer]})
+		}
+		v.ViewLock.Unlock()
+		if !g.isKnownPeer(fromAddr) && len(replaceable) > 1 {
+			replaceable = replaceable[:1]
+		}
+	} else {
+		v.ViewLock.Lock()
+		sent, ok := v.Sent[fromAddr]
+		delete(v.Sent, fromAddr)
+		v.ViewLock.Unlock()
+		if !ok {
+			// not the reply to a shuffle of this node
+			return nil
+		}
+		replaceable = append([]string{fromAddr}, sent...)
+		g.CountMetric(MetricViewShuffles, 1)
+	}
+
+	entries := shuffle.Entries
+	if len(entries) > constants.ShuffleLength {
+		entries = entries[:constants.ShuffleLength]
+	}
+	added := 0
+	for _, entry := range entries {
+		if entry == nil {
+			continue
+		}
+		address, valid := announcedAddress(entry.Address, fromAddr)
+		if !valid || !g.isShuffleCandidate(address) {
+			continue
+		}
+		if !g.joinKnownPeers(address) {
+			// the view is full: replace one of the peers given in exchange, if any is left
+			for len(replaceable) > 0 && !g.evictFromView(replaceable[0]) {
+				replaceable = replaceable[1:]
+			}
+			if len(replaceable) == 0 {
+				break
+			}
+			replaceable = replaceable[1:]
+			if !g.joinKnownPeers(address) {
+				continue
+			}
+		}
+		v.ViewLock.Lock()
+		v.Ages[address] = entry.Age
+		v.ViewLock.Unlock()
+		added++
+	}
+	if shuffle.Reply && added > 0 && len(replaceable) > 0 && strings.Compare(replaceable[0], fromAddr) == 0 {
+		// the view was not full: the target still leaves it for the peers it sent
+		g.evictFromView(fromAddr)
+	}
+	if added > 0 {
+		helpers.GossipLog.Debug("view shuffled", helpers.F("peer", fromAddr), helpers.F("added", added))
+	}
+	return reply
+}
+
+// MakeRoomInView - evict the oldest peer of the view if it is full and the address is not in it, so
+// that a peer added by the user always joins it
+func (g *Gossiper) MakeRoomInView(address string) {
+	if g.View == nil {
+		return
+	}
+	g.PeersLock.Lock()
+	peers := g.KnownPeers
+	g.PeersLock.Unlock()
+	if len(peers) < g.View.Size || helpers.SliceContainsString(peers, address) {
+		return
+	}
+	oldest := ""
+	g.View.ViewLock.Lock()
+	for _, peer := range peers {
+		if strings.Compare(oldest, "") == 0 || g.View.Ages[peer] > g.View.Ages[oldest] {
+			oldest = peer
+		}
+	}
+	g.View.ViewLock.Unlock()
+	g.evictFromView(oldest)
+}
+
+// a peer offered in a shuffle is added to the view only if it is not this node, can be reached, is
+// not tracked yet, i.e. neither known nor known to be dead, and was not removed by the user
+func (g *Gossiper) isShuffleCandidate(address string) bool {
+	if g.IsSelf(address) || !g.CanReach(address) {
+		return false
+	}
+	g.Membership.MembershipLock.Lock()
+	_, tracked := g.Membership.Members[address]
+	removed := g.Membership.Removed[address]
+	g.Membership.MembershipLock.Unlock()
+	return !tracked && !removed
+}
+
+// removes a peer from the view and stops tracking it, so that it can be offered again later
+func (g *Gossiper) evictFromView(address string) bool {
+	if !g.evictKnownPeer(address) {
+		return false
+	}
+	g.Membership.MembershipLock.Lock()
+	delete(g.Membership.Members, address)
+	g.Membership.MembershipLock.Unlock()
+	return true
+}
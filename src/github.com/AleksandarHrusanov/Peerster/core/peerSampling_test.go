@@ -0,0 +1,148 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+)
+
+// a gossiper whose partial view of the given size holds the given number of peers, 127.0.0.1:6001, ...
+func newViewTestGossiper(t *testing.T, size int, peers int) *Gossiper {
+	addresses := make([]string, 0, peers)
+	for i := 1; i <= peers; i++ {
+		addresses = append(addresses, fmt.Sprintf("127.0.0.1:%d", 6000+i))
+	}
+	g := newTestGossiper(t, "A", addresses...)
+	g.View = NewSafePeerView(size)
+	return g
+}
+
+// a shuffle offering the given addresses, all of age 0
+func testShuffle(reply bool, addresses ...string) *PeerShuffle {
+	shuffle := &PeerShuffle{Reply: reply}
+	for _, address := range addresses {
+		shuffle.Entries = append(shuffle.Entries, &ShuffleEntry{Address: address})
+	}
+	return shuffle
+}
+
+func TestShuffleRequestReplacesPeersSent(t *testing.T) {
+	g := newViewTestGossiper(t, 6, 6)
+	before := append([]string{}, g.KnownPeers...)
+	reply := g.HandleShuffle("127.0.0.1:6001", testShuffle(false, "127.0.0.1:7001", "127.0.0.1:7002", "127.0.0.1:7003"))
+	if reply == nil || !reply.Reply || len(reply.Entries) == 0 || len(reply.Entries) > constants.ShuffleLength {
+		t.Fatalf("unexpected reply %+v", reply)
+	}
+	sent := make(map[string]bool)
+	for _, entry := range reply.Entries {
+		if entry.Address == "127.0.0.1:6001" {
+			t.Error("requester offered to itself")
+		}
+		sent[entry.Address] = true
+	}
+
+	if len(g.KnownPeers) != g.View.Size {
+		t.Errorf("view holds %d peers, want %d", len(g.KnownPeers), g.View.Size)
+	}
+	for _, address := range []string{"127.0.0.1:7001", "127.0.0.1:7002", "127.0.0.1:7003"} {
+		if !g.isKnownPeer(address) {
+			t.Errorf("offered peer %s not added", address)
+		}
+	}
+	// only peers sent in exchange make room, so that no peer is lost from both views
+	for _, address := range before {
+		if !g.isKnownPeer(address) && !sent[address] {
+			t.Errorf("peer %s evicted without being sent to the requester", address)
+		}
+	}
+}
+
+func TestShuffleRequestFromUnknownPeer(t *testing.T) {
+	g := newViewTestGossiper(t, 6, 6)
+	offered := []string{"127.0.0.1:7001", "127.0.0.1:7002", "127.0.0.1:7003", "127.0.0.1:7004"}
+	g.HandleShuffle("127.0.0.1:7000", testShuffle(false, offered...))
+	added := 0
+	for _, address := range offered {
+		if g.isKnownPeer(address) {
+			added++
+		}
+	}
+	if added != 1 || len(g.KnownPeers) != 6 {
+		t.Errorf("unknown requester replaced %d peers, view holds %d", added, len(g.KnownPeers))
+	}
+}
+
+func TestShuffleReplyReplacesTarget(t *testing.T) {
+	g := newViewTestGossiper(t, 4, 4)
+	g.View.Ages["127.0.0.1:6003"] = 7
+	target, shuffle := g.StartShuffle()
+	if target != "127.0.0.1:6003" {
+		t.Fatalf("shuffled with %s, want the oldest peer", target)
+	}
+	if shuffle.Entries[0].Address != g.Address.String() {
+		t.Errorf("first entry %s, want this node", shuffle.Entries[0].Address)
+	}
+	for _, entry := range shuffle.Entries[1:] {
+		if entry.Address == target {
+			t.Error("target offered to itself")
+		}
+	}
+
+	if g.HandleShuffle("127.0.0.1:6001", testShuffle(true, "127.0.0.1:7009")) != nil || g.isKnownPeer("127.0.0.1:7009") {
+		t.Error("reply from a peer which was not shuffled with merged")
+	}
+	g.HandleShuffle(target, testShuffle(true, "127.0.0.1:7001"))
+	if g.isKnownPeer(target) || !g.isKnownPeer("127.0.0.1:7001") || len(g.KnownPeers) != 4 {
+		t.Errorf("target not replaced by the peer it sent, view %v", g.KnownPeers)
+	}
+	g.HandleShuffle(target, testShuffle(true, "127.0.0.1:7002"))
+	if g.isKnownPeer("127.0.0.1:7002") {
+		t.Error("second reply to the same shuffle merged")
+	}
+}
+
+func TestShuffleReplyWithRoomInView(t *testing.T) {
+	g := newViewTestGossiper(t, 8, 3)
+	target, _ := g.StartShuffle()
+	g.HandleShuffle(target, testShuffle(true, "127.0.0.1:7001"))
+	// the target has this node in its view now, as in Cyclon, so it leaves this one even if there is room
+	if g.isKnownPeer(target) || !g.isKnownPeer("127.0.0.1:7001") || len(g.KnownPeers) != 3 {
+		t.Errorf("unexpected view %v after shuffling with %s", g.KnownPeers, target)
+	}
+}
+
+func TestShuffleSkipsEntries(t *testing.T) {
+	g := newViewTestGossiper(t, 16, 2)
+	g.Membership.Removed["127.0.0.1:7001"] = true
+	g.Membership.Members["127.0.0.1:7002"] = &PeerMember{Address: "127.0.0.1:7002", State: PeerDead}
+	shuffle := testShuffle(false, g.Address.String(), "127.0.0.1:7001", "127.0.0.1:7002", "[::1]:7003", "peer")
+	shuffle.Entries = append(shuffle.Entries, nil)
+	g.HandleShuffle("127.0.0.1:6001", shuffle)
+	if len(g.KnownPeers) != 2 {
+		t.Errorf("entries added to the view %v", g.KnownPeers)
+	}
+
+	// entries past ShuffleLength are ignored
+	offered := make([]string, 0, constants.ShuffleLength+2)
+	for i := 0; i < constants.ShuffleLength+2; i++ {
+		offered = append(offered, fmt.Sprintf("127.0.0.1:%d", 7100+i))
+	}
+	g.HandleShuffle("127.0.0.1:6001", testShuffle(false, offered...))
+	if len(g.KnownPeers) != 2+constants.ShuffleLength {
+		t.Errorf("view holds %d peers, want %d", len(g.KnownPeers), 2+constants.ShuffleLength)
+	}
+}
+
+func TestMakeRoomInView(t *testing.T) {
+	g := newViewTestGossiper(t, 3, 3)
+	g.View.Ages["127.0.0.1:6002"] = 5
+	g.MakeRoomInView("127.0.0.1:6001")
+	if len(g.KnownPeers) != 3 {
+		t.Fatal("peer evicted to make room for a peer of the view")
+	}
+	g.MakeRoomInView("127.0.0.1:7001")
+	if len(g.KnownPeers) != 2 || g.isKnownPeer("127.0.0.1:6002") {
+		t.Errorf("oldest peer not evicted, view %v", g.KnownPeers)
+	}
+}
@@ -0,0 +1,637 @@
+package core
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh" // Go 1.20 or later
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+	"github.com/dedis/protobuf"
+)
+
+// ErrUnknownPeerKey - no node key is pinned to the address
+var ErrUnknownPeerKey = errors.New("no node key pinned to this peer")
+
+// The datagrams of the secure channels start with this prefix, which an encoded gossip packet never
+// starts with, followed by their kind
+var secureMagic = []byte("PSC1")
+
+// kinds of the datagrams of the secure channels
+const (
+	kindHandshakeInit     byte = 1
+	kindHandshakeResponse byte = 2
+	kindSealed            byte = 3
+)
+
+const sessionIDSize = 8
+
+// magic, kind, session ID and counter
+const sealedHeaderSize = 4 + 1 + sessionIDSize + 8
+
+// the number of counters below the highest one received for which replays are detected
+const replayWindowSize = 1024
+
+// sessions kept per peer, so that packets sealed with the previous keys can still be opened
+const maxSessionsPerPeer = 3
+
+// contexts of the signatures of the handshake, so that one message cannot be passed for the other
+const (
+	handshakeInitContext     = "peerster handshake init"
+	handshakeResponseContext = "peerster handshake response"
+)
+
+// HandshakeMessage - the body of both handshake datagrams. The initiator signs its ephemeral key
+// with its node key. The responder signs both ephemeral keys and the node key of the initiator
+type HandshakeMessage struct {
+	NodeKey      []byte
+	EphemeralKey []byte
+	Signature    []byte
+}
+
+// replayWindow - the counters of the packets opened in a session among the last replayWindowSize ones
+type replayWindow struct {
+	Highest uint64
+	Seen    [replayWindowSize / 64]uint64
+}
+
+// secureSession - the keys agreed with a peer in one handshake. A session created by answering a
+// handshake is confirmed, and used for sending, once a packet sealed with it was received
+type secureSession struct {
+	ID          [sessionIDSize]byte
+	Send        cipher.AEAD
+	Receive     cipher.AEAD
+	SendCounter uint64
+	Replay      replayWindow
+	Confirmed   bool
+	PeerKey     ed25519.PublicKey
+}
+
+// pendingHandshake - a handshake sent to a peer and the datagrams waiting for its answer
+type pendingHandshake struct {
+	Ephemeral *ecdh.PrivateKey
+	Init      []byte
+	Queue     [][]byte
+}
+
+// SafeSecureChannels - the sessions agreed with the peers of the gossiper by address, the node keys
+// they presented at their first handshake and the peers which did not answer the handshake
+type SafeSecureChannels struct {
+	NodeKey         ed25519.PrivateKey
+	AcceptPlaintext bool // plaintext is accepted from and sent to peers which do not answer the handshake
+	Sessions        map[string][]*secureSession
+	Current         map[string]*secureSession // the session datagrams to a peer are sealed with
+	Pending         map[string]*pendingHandshake
+	PeerKeys        map[string]string // hex encoded
+	PlaintextPeers  map[string]time.Time
+	ChannelsLock    sync.Mutex
+}
+
+// NewSafeSecureChannels - create the secure channels of a gossiper identified by the given node key
+func NewSafeSecureChannels(nodeKey ed25519.PrivateKey, acceptPlaintext bool) *SafeSecureChannels {
+	return &SafeSecureChannels{NodeKey: nodeKey, AcceptPlaintext: acceptPlaintext,
+		Sessions: make(map[string][]*secureSession), Current: make(map[string]*secureSession),
+		Pending: make(map[string]*pendingHandshake), PeerKeys: make(map[string]string),
+		PlaintextPeers: make(map[string]time.Time)}
+}
+
+// PublicKey - the hex encoded public node key of the gossiper
+func (c *SafeSecureChannels) PublicKey() string {
+	return hex.EncodeToString(c.NodeKey.Public().(ed25519.PublicKey))
+}
+
+// sendDatagram - send a datagram to a peer on the gossip socket, sealed with the session agreed with
+// the peer. Until there is one, datagrams wait for the handshake
+func (g *Gossiper) sendDatagram(addressAndPort string, datagram []byte) {
+	if g.Channels == nil {
+		ConnectAndSend(addressAndPort, g.Conn, datagram)
+		return
+	}
+	c := g.Channels
+	c.ChannelsLock.Lock()
+	if s := c.Current[addressAndPort]; s != nil {
+		sealed := s.seal(datagram)
+		c.ChannelsLock.Unlock()
+		ConnectAndSend(addressAndPort, g.Conn, sealed)
+		return
+	}
+	if c.sendsPlaintext(addressAndPort, g.Settings.PlaintextRetryPeriod) {
+		c.ChannelsLock.Unlock()
+		ConnectAndSend(addressAndPort, g.Conn, datagram)
+		return
+	}
+	pending, running := c.Pending[addressAndPort]
+	if !running {
+		pending = c.startHandshake(addressAndPort)
+	}
+	if len(pending.Queue) < constants.MaxQueuedDatagrams {
+		pending.Queue = append(pending.Queue, datagram)
+	}
+	c.ChannelsLock.Unlock()
+	if !running {
+		ConnectAndSend(addressAndPort, g.Conn, pending.Init)
+		go g.awaitHandshake(addressAndPort, pending)
+	}
+}
+
+// OpenDatagram - handle a datagram received on the gossip socket. Returns the encoded gossip packet
+// it carries, or false if it was part of a handshake or was rejected
+func (g *Gossiper) OpenDatagram(fromAddr string, datagram []byte) ([]byte, bool) {
+	if g.Channels == nil {
+		return datagram, true
+	}
+	if !IsSecureDatagram(datagram) {
+		return g.acceptPlaintext(fromAddr, datagram)
+	}
+	kind := datagram[len(secureMagic)]
+	// handshakes cost signatures and key exchanges; anyone can send one with the address of the peer
+	if (kind == kindHandshakeInit || kind == kindHandshakeResponse) &&
+		!g.admitPacketType(fromAddr, "handshake", false) {
+		return nil, false
+	}
+	switch kind {
+	case kindHandshakeInit:
+		g.answerHandshake(fromAddr, datagram[len(secureMagic)+1:])
+	case kindHandshakeResponse:
+		g.completeHandshake(fromAddr, datagram[len(secureMagic)+1:])
+	case kindSealed:
+		packetBytes, ok := g.openSealed(fromAddr, datagram, true)
+		// the empty packet sent after a handshake only confirms the session
+		return packetBytes, ok && len(packetBytes) > 0
+	default:
+		g.rejectPacket(fromAddr)
+	}
+	return nil, false
+}
+
+// SealForStream - seal a frame sent over the stream connection to a peer with the session agreed
+// with it on the gossip socket. Returns false if there is none yet
+func (g *Gossiper) SealForStream(addressAndPort string, frame []byte) ([]byte, bool) {
+	if g.Channels == nil {
+		return frame, true
+	}
+	c := g.Channels
+	c.ChannelsLock.Lock()
+	defer c.ChannelsLock.Unlock()
+	if s := c.Current[addressAndPort]; s != nil {
+		return s.seal(frame), true
+	}
+	return frame, c.sendsPlaintext(addressAndPort, g.Settings.PlaintextRetryPeriod)
+}
+
+// OpenStreamFrame - open a frame received over a stream connection from the peer with the given
+// gossip address, connected from the given remote address. Sealed frames only open with the keys
+// agreed with that gossip address, so the address the peer gave when connecting cannot be forged.
+// Plaintext frames are only accepted if the gossip address is on the host the connection comes from
+func (g *Gossiper) OpenStreamFrame(fromAddr string, remoteAddr string, frame []byte) ([]byte, bool) {
+	if g.Channels == nil {
+		return frame, true
+	}
+	if !IsSecureDatagram(frame) {
+		if !sameHost(fromAddr, remoteAddr) {
+			g.rejectPacket(fromAddr)
+			helpers.GossipLog.Debug("rejected plaintext frame for the address of another host",
+				helpers.F("peer", fromAddr), helpers.F("remote", remoteAddr))
+			return nil, false
+		}
+		return g.acceptPlaintext(fromAddr, frame)
+	}
+	if frame[len(secureMagic)] != kindSealed {
+		g.rejectPacket(fromAddr)
+		return nil, false
+	}
+	return g.openSealed(fromAddr, frame, false)
+}
+
+// IsSecureDatagram - whether a datagram is a handshake or sealed with a session, rather than plaintext
+func IsSecureDatagram(datagram []byte) bool {
+	return len(datagram) > len(secureMagic) && bytes.Equal(datagram[:len(secureMagic)], secureMagic)
+}
+
+// plaintext from a peer is only accepted in compatibility mode, and then the peer is answered in
+// plaintext. It is never accepted from a peer a session was agreed with, which surely encrypts: it
+// would come from someone forging the address of the peer
+func (g *Gossiper) acceptPlaintext(fromAddr string, datagram []byte) ([]byte, bool) {
+	c := g.Channels
+	c.ChannelsLock.Lock()
+	_, secured := c.Current[fromAddr]
+	accepted := c.AcceptPlaintext && !secured
+	if accepted {
+		c.PlaintextPeers[fromAddr] = time.Now()
+	}
+	c.ChannelsLock.Unlock()
+	if !accepted {
+		g.rejectPacket(fromAddr)
+		helpers.GossipLog.Debug("rejected plaintext packet", helpers.F("peer", fromAddr))
+		return nil, false
+	}
+	return datagram, true
+}
+
+// whether two addresses are on the same host, whatever their ports
+func sameHost(address string, other string) bool {
+	host, _, err := net.SplitHostPort(address)
+	otherHost, _, otherErr := net.SplitHostPort(other)
+	if err != nil || otherErr != nil {
+		return false
+	}
+	ip, otherIP := net.ParseIP(host), net.ParseIP(otherHost)
+	return ip != nil && ip.Equal(otherIP)
+}
+
+// whether datagrams to the peer are sent in plaintext; must be called with the lock held
+func (c *SafeSecureChannels) sendsPlaintext(addressAndPort string, retryPeriod int) bool {
+	since, ok := c.PlaintextPeers[addressAndPort]
+	return c.AcceptPlaintext && ok && time.Since(since) < time.Duration(retryPeriod)*time.Second
+}
+
+// startHandshake - create a handshake with a peer; must be called with the lock held. The caller
+// sends it and waits for the answer
+func (c *SafeSecureChannels) startHandshake(addressAndPort string) *pendingHandshake {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	helpers.HandleErrorFatal(err)
+	ephemeralKey := ephemeral.PublicKey().Bytes()
+	msg := &HandshakeMessage{NodeKey: c.NodeKey.Public().(ed25519.PublicKey), EphemeralKey: ephemeralKey,
+		Signature: ed25519.Sign(c.NodeKey, signedBytes(handshakeInitContext, ephemeralKey))}
+	pending := &pendingHandshake{Ephemeral: ephemeral, Init: handshakeDatagram(kindHandshakeInit, msg),
+		Queue: make([][]byte, 0)}
+	c.Pending[addressAndPort] = pending
+	return pending
+}
+
+// sends the handshake again until the peer answers. A peer which never does is sent the waiting
+// datagrams in plaintext in compatibility mode; otherwise they are dropped
+func (g *Gossiper) awaitHandshake(addressAndPort string, pending *pendingHandshake) {
+	c := g.Channels
+	for attempt := 1; ; attempt++ {
+		time.Sleep(time.Duration(g.Settings.HandshakeTimeout) * time.Second)
+		c.ChannelsLock.Lock()
+		if c.Pending[addressAndPort] != pending {
+			c.ChannelsLock.Unlock()
+			return
+		}
+		if attempt < constants.HandshakeAttempts {
+			c.ChannelsLock.Unlock()
+			ConnectAndSend(addressAndPort, g.Conn, pending.Init)
+			continue
+		}
+		delete(c.Pending, addressAndPort)
+		if c.AcceptPlaintext {
+			c.PlaintextPeers[addressAndPort] = time.Now()
+		}
+		c.ChannelsLock.Unlock()
+
+		g.CountMetric(MetricHandshakesFailed, 1)
+		if !c.AcceptPlaintext {
+			helpers.GossipLog.Warn("peer did not answer the handshake, dropping packets",
+				helpers.F("peer", addressAndPort), helpers.F("dropped", len(pending.Queue)))
+			return
+		}
+		helpers.GossipLog.Warn("peer did not answer the handshake, sending plaintext",
+			helpers.F("peer", addressAndPort))
+		for _, datagram := range pending.Queue {
+			ConnectAndSend(addressAndPort, g.Conn, datagram)
+		}
+		return
+	}
+}
+
+// answers the handshake of a peer with a new session, which is used for sending once the peer
+// sealed a packet with it
+func (g *Gossiper) answerHandshake(fromAddr string, body []byte) {
+	c := g.Channels
+	msg, ok := decodeHandshake(body)
+	if !ok || !ed25519.Verify(msg.NodeKey, signedBytes(handshakeInitContext, msg.EphemeralKey), msg.Signature) {
+		g.rejectHandshake(fromAddr, "invalid handshake")
+		return
+	}
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	helpers.HandleErrorFatal(err)
+	ephemeralKey := ephemeral.PublicKey().Bytes()
+	shared, ok := sharedSecret(ephemeral, msg.EphemeralKey)
+	if !ok {
+		g.rejectHandshake(fromAddr, "invalid ephemeral key")
+		return
+	}
+
+	c.ChannelsLock.Lock()
+	if !c.pinPeerKey(fromAddr, msg.NodeKey) {
+		c.ChannelsLock.Unlock()
+		g.rejectHandshake(fromAddr, "node key differs from the one presented before")
+		return
+	}
+	s := newSecureSession(shared, msg.EphemeralKey, ephemeralKey, false)
+	s.PeerKey = msg.NodeKey
+	c.addSession(fromAddr, s)
+	nodeKey := c.NodeKey.Public().(ed25519.PublicKey)
+	answer := &HandshakeMessage{NodeKey: nodeKey, EphemeralKey: ephemeralKey,
+		Signature: ed25519.Sign(c.NodeKey, signedBytes(handshakeResponseContext, msg.EphemeralKey, ephemeralKey,
+			msg.NodeKey))}
+	c.ChannelsLock.Unlock()
+
+	ConnectAndSend(fromAddr, g.Conn, handshakeDatagram(kindHandshakeResponse, answer))
+}
+
+// completes the handshake sent to a peer, then sends the datagrams which waited for it
+func (g *Gossiper) completeHandshake(fromAddr string, body []byte) {
+	c := g.Channels
+	msg, ok := decodeHandshake(body)
+	if !ok {
+		g.rejectHandshake(fromAddr, "invalid handshake answer")
+		return
+	}
+
+	c.ChannelsLock.Lock()
+	pending, running := c.Pending[fromAddr]
+	if !running {
+		// an answer to a handshake sent again, or a replay
+		c.ChannelsLock.Unlock()
+		return
+	}
+	ephemeralKey := pending.Ephemeral.PublicKey().Bytes()
+	signed := signedBytes(handshakeResponseContext, ephemeralKey, msg.EphemeralKey, c.NodeKey.Public().(ed25519.PublicKey))
+	shared, valid := sharedSecret(pending.Ephemeral, msg.EphemeralKey)
+	if !valid || !ed25519.Verify(msg.NodeKey, signed, msg.Signature) {
+		c.ChannelsLock.Unlock()
+		g.rejectHandshake(fromAddr, "invalid handshake answer")
+		return
+	}
+	if !c.pinPeerKey(fromAddr, msg.NodeKey) {
+		c.ChannelsLock.Unlock()
+		g.rejectHandshake(fromAddr, "node key differs from the one presented before")
+		return
+	}
+	s := newSecureSession(shared, ephemeralKey, msg.EphemeralKey, true)
+	s.PeerKey = msg.NodeKey
+	s.Confirmed = true
+	c.addSession(fromAddr, s)
+	c.Current[fromAddr] = s
+	delete(c.Pending, fromAddr)
+	delete(c.PlaintextPeers, fromAddr)
+	sealed := [][]byte{s.seal(nil)}
+	for _, datagram := range pending.Queue {
+		sealed = append(sealed, s.seal(datagram))
+	}
+	c.ChannelsLock.Unlock()
+
+	g.CountMetric(MetricHandshakesCompleted, 1)
+	helpers.GossipLog.Info("secure channel established", helpers.F("peer", fromAddr),
+		helpers.F("nodeKey", hex.EncodeToString(msg.NodeKey)))
+	g.BindIdentity("key:"+hex.EncodeToString(msg.NodeKey), fromAddr)
+	for _, datagram := range sealed {
+		ConnectAndSend(fromAddr, g.Conn, datagram)
+	}
+}
+
+// counts a packet which could not be authenticated. Its source address may be forged, so it does not
+// count towards a ban of the peer
+func (g *Gossiper) rejectPacket(fromAddr string) {
+	g.CountMetric(MetricPacketsRejected, 1)
+}
+
+func (g *Gossiper) rejectHandshake(fromAddr string, reason string) {
+	g.rejectPacket(fromAddr)
+	helpers.GossipLog.Warn("rejected handshake", helpers.F("peer", fromAddr), helpers.F("reason", reason))
+}
+
+// opens a sealed datagram or frame with the session of the peer it names. A datagram sealed with a
+// session this gossiper does not know, e.g. because it restarted, starts a new handshake if it comes
+// from a known peer. The source of the datagram is not authenticated, so a handshake is never sent
+// to other addresses, which would reflect traffic to whoever the address was forged for
+func (g *Gossiper) openSealed(fromAddr string, datagram []byte, rekey bool) ([]byte, bool) {
+	c := g.Channels
+	if len(datagram) < sealedHeaderSize {
+		g.rejectPacket(fromAddr)
+		return nil, false
+	}
+	var id [sessionIDSize]byte
+	copy(id[:], datagram[len(secureMagic)+1:])
+
+	c.ChannelsLock.Lock()
+	for _, s := range c.Sessions[fromAddr] {
+		if s.ID != id {
+			continue
+		}
+		packetBytes, ok := s.open(datagram)
+		confirmed := ok && !s.Confirmed
+		if confirmed {
+			s.Confirmed = true
+			c.Current[fromAddr] = s
+			delete(c.PlaintextPeers, fromAddr)
+		}
+		c.ChannelsLock.Unlock()
+
+		if !ok {
+			g.rejectPacket(fromAddr)
+		}
+		if confirmed {
+			g.CountMetric(MetricHandshakesCompleted, 1)
+			helpers.GossipLog.Info("secure channel established", helpers.F("peer", fromAddr),
+				helpers.F("nodeKey", hex.EncodeToString(s.PeerKey)))
+			g.BindIdentity("key:"+hex.EncodeToString(s.PeerKey), fromAddr)
+		}
+		return packetBytes, ok
+	}
+
+	_, running := c.Pending[fromAddr]
+	c.ChannelsLock.Unlock()
+
+	g.rejectPacket(fromAddr)
+	var pending *pendingHandshake
+	if rekey && !running && g.isKnownPeer(fromAddr) && g.admitPacketType(fromAddr, "handshake", false) {
+		c.ChannelsLock.Lock()
+		if _, running = c.Pending[fromAddr]; !running {
+			pending = c.startHandshake(fromAddr)
+		}
+		c.ChannelsLock.Unlock()
+	}
+	if pending != nil {
+		helpers.GossipLog.Debug("packet sealed with an unknown session, starting a handshake",
+			helpers.F("peer", fromAddr))
+		ConnectAndSend(fromAddr, g.Conn, pending.Init)
+		go g.awaitHandshake(fromAddr, pending)
+	}
+	return nil, false
+}
+
+// hasSecureSession - whether a session was established with the peer, i.e. it proved who it is
+func (g *Gossiper) hasSecureSession(address string) bool {
+	if g.Channels == nil {
+		return false
+	}
+	g.Channels.ChannelsLock.Lock()
+	defer g.Channels.ChannelsLock.Unlock()
+	return g.Channels.Current[address] != nil
+}
+
+// ForgetPeerKey - forget the node key pinned to the address of a peer, and the sessions agreed with
+// it, e.g. because the peer was given a new key. The next handshake pins the key it presents
+func (g *Gossiper) ForgetPeerKey(address string) error {
+	if g.Channels == nil {
+		return ErrUnknownPeerKey
+	}
+	address, _ = helpers.NormalizeAddress(address)
+	c := g.Channels
+	c.ChannelsLock.Lock()
+	_, pinned := c.PeerKeys[address]
+	delete(c.PeerKeys, address)
+	delete(c.Sessions, address)
+	delete(c.Current, address)
+	delete(c.Pending, address)
+	c.ChannelsLock.Unlock()
+	if !pinned {
+		return ErrUnknownPeerKey
+	}
+	helpers.GossipLog.Info("forgot the node key of peer", helpers.F("peer", address))
+	return nil
+}
+
+// pins the node key of a peer at its first handshake; later handshakes have to present the same
+// key until it is forgotten with ForgetPeerKey. Must be called with the lock held
+func (c *SafeSecureChannels) pinPeerKey(addressAndPort string, nodeKey []byte) bool {
+	key := hex.EncodeToString(nodeKey)
+	if known, ok := c.PeerKeys[addressAndPort]; ok {
+		return strings.Compare(known, key) == 0
+	}
+	c.PeerKeys[addressAndPort] = key
+	return true
+}
+
+// adds a session with a peer, forgetting its oldest one other than the current one if there are
+// too many; must be called with the lock held
+func (c *SafeSecureChannels) addSession(addressAndPort string, s *secureSession) {
+	sessions := append([]*secureSession{s}, c.Sessions[addressAndPort]...)
+	for i := len(sessions) - 1; len(sessions) > maxSessionsPerPeer && i >= 0; i-- {
+		if sessions[i] != c.Current[addressAndPort] {
+			sessions = append(sessions[:i], sessions[i+1:]...)
+		}
+	}
+	c.Sessions[addressAndPort] = sessions
+}
+
+// derives the keys of both directions from the shared secret and the ephemeral keys, HKDF-style
+func newSecureSession(shared []byte, initiatorKey []byte, responderKey []byte, initiator bool) *secureSession {
+	salt := signedBytes("", initiatorKey, responderKey)
+	prk := hmacSHA256(salt, shared)
+	toResponder := newAEAD(hmacSHA256(prk, []byte("initiator to responder\x01")))
+	toInitiator := newAEAD(hmacSHA256(prk, []byte("responder to initiator\x01")))
+
+	s := &secureSession{Send: toResponder, Receive: toInitiator}
+	if !initiator {
+		s.Send, s.Receive = toInitiator, toResponder
+	}
+	id := sha256.Sum256(salt)
+	copy(s.ID[:], id[:])
+	return s
+}
+
+// seal - seal a datagram with the next counter of the session; must be called with the lock held
+func (s *secureSession) seal(datagram []byte) []byte {
+	s.SendCounter++
+	header := make([]byte, sealedHeaderSize, sealedHeaderSize+len(datagram)+s.Send.Overhead())
+	copy(header, secureMagic)
+	header[len(secureMagic)] = kindSealed
+	copy(header[len(secureMagic)+1:], s.ID[:])
+	binary.BigEndian.PutUint64(header[sealedHeaderSize-8:], s.SendCounter)
+	return s.Send.Seal(header, sealNonce(s.SendCounter), datagram, header)
+}
+
+// open - authenticate and decrypt a sealed datagram, rejecting replays; must be called with the lock held
+func (s *secureSession) open(datagram []byte) ([]byte, bool) {
+	header := datagram[:sealedHeaderSize]
+	counter := binary.BigEndian.Uint64(header[sealedHeaderSize-8:])
+	plaintext, err := s.Receive.Open(nil, sealNonce(counter), datagram[sealedHeaderSize:], header)
+	if err != nil || !s.Replay.accept(counter) {
+		return nil, false
+	}
+	return plaintext, true
+}
+
+// accept - true the first time a counter is seen, false for replays and counters too old to tell
+func (w *replayWindow) accept(counter uint64) bool {
+	if counter == 0 || (counter <= w.Highest && w.Highest-counter >= replayWindowSize) {
+		return false
+	}
+	for ; w.Highest < counter; w.Highest++ {
+		if counter-w.Highest > replayWindowSize {
+			// the whole window moves past the counters seen so far
+			w.Seen = [replayWindowSize / 64]uint64{}
+			w.Highest = counter - 1
+		}
+		next := (w.Highest + 1) % replayWindowSize
+		w.Seen[next/64] &^= 1 << (next % 64)
+	}
+	bit := counter % replayWindowSize
+	if w.Seen[bit/64]&(1<<(bit%64)) != 0 {
+		return false
+	}
+	w.Seen[bit/64] |= 1 << (bit % 64)
+	return true
+}
+
+func decodeHandshake(body []byte) (*HandshakeMessage, bool) {
+	msg := &HandshakeMessage{}
+	if err := protobuf.Decode(body, msg); err != nil {
+		return nil, false
+	}
+	return msg, len(msg.NodeKey) == ed25519.PublicKeySize && len(msg.EphemeralKey) == 32 &&
+		len(msg.Signature) == ed25519.SignatureSize
+}
+
+func handshakeDatagram(kind byte, msg *HandshakeMessage) []byte {
+	body, err := protobuf.Encode(msg)
+	helpers.HandleErrorFatal(err)
+	datagram := append([]byte(nil), secureMagic...)
+	return append(append(datagram, kind), body...)
+}
+
+func sharedSecret(ephemeral *ecdh.PrivateKey, peerKey []byte) ([]byte, bool) {
+	publicKey, err := ecdh.X25519().NewPublicKey(peerKey)
+	if err != nil {
+		return nil, false
+	}
+	// fails for the low order points, which would give a known secret
+	shared, err := ephemeral.ECDH(publicKey)
+	return shared, err == nil
+}
+
+// the keys are all of fixed size, so plain concatenation is unambiguous
+func signedBytes(context string, keys ...[]byte) []byte {
+	signed := []byte(context)
+	for _, key := range keys {
+		signed = append(signed, key...)
+	}
+	return signed
+}
+
+func sealNonce(counter uint64) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+func hmacSHA256(key []byte, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func newAEAD(key []byte) cipher.AEAD {
+	block, err := aes.NewCipher(key)
+	helpers.HandleErrorFatal(err)
+	aead, err := cipher.NewGCM(block)
+	helpers.HandleErrorFatal(err)
+	return aead
+}
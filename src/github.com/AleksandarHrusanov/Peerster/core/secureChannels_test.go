@@ -0,0 +1,197 @@
+package core
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+)
+
+// a test gossiper with secure channels and a new node key
+func newSecureTestGossiper(t *testing.T, name string, acceptPlaintext bool) *Gossiper {
+	g := newTestGossiper(t, name)
+	_, nodeKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g.Channels = NewSafeSecureChannels(nodeKey, acceptPlaintext)
+	return g
+}
+
+// the next datagram received on the gossip socket of the gossiper
+func readDatagram(t *testing.T, g *Gossiper) []byte {
+	buffer := make([]byte, 65536)
+	g.Conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := g.Conn.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("%s did not receive a datagram: %v", g.Name, err)
+	}
+	return buffer[:n]
+}
+
+// runs a handshake from a to b, delivering the datagrams by hand. Returns the datagram a queued
+// while waiting for it, as received by b
+func testHandshake(t *testing.T, a *Gossiper, b *Gossiper, queued []byte) []byte {
+	aAddr, bAddr := a.Conn.LocalAddr().String(), b.Conn.LocalAddr().String()
+	a.sendDatagram(bAddr, queued)
+	if _, ok := b.OpenDatagram(aAddr, readDatagram(t, b)); ok {
+		t.Fatal("handshake returned as a packet")
+	}
+	if _, ok := a.OpenDatagram(bAddr, readDatagram(t, a)); ok {
+		t.Fatal("handshake answer returned as a packet")
+	}
+	// the empty datagram confirming the session comes first
+	if _, ok := b.OpenDatagram(aAddr, readDatagram(t, b)); ok {
+		t.Fatal("session confirmation returned as a packet")
+	}
+	packet, ok := b.OpenDatagram(aAddr, readDatagram(t, b))
+	if !ok {
+		t.Fatal("queued datagram not opened")
+	}
+	return packet
+}
+
+func TestSecureChannelHandshake(t *testing.T) {
+	a := newSecureTestGossiper(t, "A", false)
+	b := newSecureTestGossiper(t, "B", false)
+	aAddr, bAddr := a.Conn.LocalAddr().String(), b.Conn.LocalAddr().String()
+
+	if packet := testHandshake(t, a, b, []byte("hello")); !bytes.Equal(packet, []byte("hello")) {
+		t.Fatalf("got %q, want %q", packet, "hello")
+	}
+	if !a.hasSecureSession(bAddr) || !b.hasSecureSession(aAddr) {
+		t.Fatal("session not established on both sides")
+	}
+	if b.Channels.PeerKeys[aAddr] != a.Channels.PublicKey() || a.Channels.PeerKeys[bAddr] != b.Channels.PublicKey() {
+		t.Error("node keys not pinned")
+	}
+
+	// the responder now seals with the confirmed session
+	b.sendDatagram(aAddr, []byte("reply"))
+	sealed := readDatagram(t, a)
+	if !IsSecureDatagram(sealed) || bytes.Contains(sealed, []byte("reply")) {
+		t.Fatal("datagram sent in plaintext")
+	}
+	if packet, ok := a.OpenDatagram(bAddr, sealed); !ok || !bytes.Equal(packet, []byte("reply")) {
+		t.Fatalf("got %q, want %q", packet, "reply")
+	}
+	if _, ok := a.OpenDatagram(bAddr, sealed); ok {
+		t.Error("replayed datagram accepted")
+	}
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	if _, ok := a.OpenDatagram(bAddr, tampered); ok {
+		t.Error("tampered datagram accepted")
+	}
+	b.sendDatagram(aAddr, []byte("reply"))
+	if _, ok := a.OpenDatagram("127.0.0.1:1", readDatagram(t, a)); ok {
+		t.Error("datagram opened for another address")
+	}
+}
+
+func TestSecureChannelRejectsOtherNodeKey(t *testing.T) {
+	a := newSecureTestGossiper(t, "A", false)
+	b := newSecureTestGossiper(t, "B", false)
+	aAddr := a.Conn.LocalAddr().String()
+	testHandshake(t, a, b, []byte("hello"))
+
+	// a handshake from the same address with another node key is not answered
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	other := NewSafeSecureChannels(otherKey, false)
+	init := other.startHandshake(b.Conn.LocalAddr().String()).Init
+	sessions := len(b.Channels.Sessions[aAddr])
+	b.OpenDatagram(aAddr, init)
+	if len(b.Channels.Sessions[aAddr]) != sessions {
+		t.Error("session added for another node key")
+	}
+	if b.Channels.PeerKeys[aAddr] != a.Channels.PublicKey() {
+		t.Error("pinned node key replaced")
+	}
+
+	if err := b.ForgetPeerKey(aAddr); err != nil {
+		t.Fatalf("forget: %v", err)
+	}
+	b.OpenDatagram(aAddr, init)
+	if b.Channels.PeerKeys[aAddr] != other.PublicKey() {
+		t.Error("node key not pinned after being forgotten")
+	}
+}
+
+func TestPlaintext(t *testing.T) {
+	tests := []struct {
+		name            string
+		acceptPlaintext bool
+		secured         bool
+		want            bool
+	}{
+		{"compatibility mode", true, false, true},
+		{"secure only", false, false, false},
+		// the peer encrypts, so its address is forged
+		{"peer with a session", true, true, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			g := newSecureTestGossiper(t, "A", test.acceptPlaintext)
+			if test.secured {
+				g.Channels.Current["127.0.0.1:5001"] = &secureSession{Confirmed: true}
+			}
+			packet, ok := g.OpenDatagram("127.0.0.1:5001", []byte("plain"))
+			if ok != test.want || (ok && !bytes.Equal(packet, []byte("plain"))) {
+				t.Errorf("got %q, %v, want accepted %v", packet, ok, test.want)
+			}
+			if _, marked := g.Channels.PlaintextPeers["127.0.0.1:5001"]; marked != test.want {
+				t.Errorf("marked as a plaintext peer %v, want %v", marked, test.want)
+			}
+		})
+	}
+}
+
+func TestPlaintextStreamFrame(t *testing.T) {
+	g := newSecureTestGossiper(t, "A", true)
+	// the peer connects from another port than its gossip one
+	if _, ok := g.OpenStreamFrame("127.0.0.1:5001", "127.0.0.1:41234", []byte("plain")); !ok {
+		t.Error("plaintext frame from the host of the gossip address rejected")
+	}
+	if _, ok := g.OpenStreamFrame("[::ffff:127.0.0.1]:5001", "127.0.0.1:41234", []byte("plain")); !ok {
+		t.Error("plaintext frame from another spelling of the host rejected")
+	}
+	if _, ok := g.OpenStreamFrame("10.0.0.7:5001", "127.0.0.1:41234", []byte("plain")); ok {
+		t.Error("plaintext frame accepted for the gossip address of another host")
+	}
+	if _, marked := g.Channels.PlaintextPeers["10.0.0.7:5001"]; marked {
+		t.Error("address of another host marked as a plaintext peer")
+	}
+
+	g.Channels.Current["127.0.0.1:5001"] = &secureSession{Confirmed: true}
+	if _, ok := g.OpenStreamFrame("127.0.0.1:5001", "127.0.0.1:41234", []byte("plain")); ok {
+		t.Error("plaintext frame accepted from a peer with a session")
+	}
+}
+
+func TestReplayWindow(t *testing.T) {
+	tests := []struct {
+		name     string
+		counters []uint64
+		want     []bool
+	}{
+		{"in order", []uint64{1, 2, 3}, []bool{true, true, true}},
+		{"zero", []uint64{0}, []bool{false}},
+		{"replay", []uint64{1, 2, 1, 2}, []bool{true, true, false, false}},
+		{"out of order", []uint64{3, 1, 2, 3}, []bool{true, true, true, false}},
+		{"jump", []uint64{1, 5000, 1, 4000, 5000}, []bool{true, true, false, true, false}},
+		{"edge of the window", []uint64{replayWindowSize + 1, 1, 2}, []bool{true, false, true}},
+		{"window moved past", []uint64{10, 10 + replayWindowSize, 10 + 2*replayWindowSize, 10 + replayWindowSize,
+			11 + replayWindowSize}, []bool{true, true, true, false, true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := &replayWindow{}
+			for i, counter := range test.counters {
+				if got := w.accept(counter); got != test.want[i] {
+					t.Errorf("counter %d: got %v, want %v", counter, got, test.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package core
+
+import (
+	"github.com/AleksandarHrusanov/Peerster/constants"
+)
+
+// Settings - the folders, timers and limits a gossiper runs with. They are set once from the
+// configuration of the node, before the gossiper starts, and only read afterwards. Timers are in
+// seconds unless their name says otherwise
+type Settings struct {
+	SharedFilesFolder           string
+	ShareFilesChunksFolder      string
+	DownloadedFilesFolder       string
+	DownloadedFilesChunksFolder string
+	StateFolder                 string
+
+	FixedChunkSize int
+
+	StartingRingSearchBudget uint64
+	RingSearchBudgetLimit    uint64
+	MaxSearchBudget          uint64
+	FullMatchesThreshold     int
+
+	DefaultHopLimit  uint32
+	PoWTxHopLimit    uint32
+	PoWBlockHopLimit uint32
+	MaxHopLimit      uint32
+
+	MongeringTimeout            int
+	DownloadRetryPeriod         int
+	SearchRetryPeriod           int
+	DuplicateSearchWindowMillis int
+	NamedDownloadSearchTimeout  int
+	NameProofTimeout            int
+	MiningIdlePeriod            int
+	EventKeepAlivePeriod        int
+	StreamChunkTimeout          int
+	DownloadCleanupPeriod       int
+	FinishedDownloadRetention   int
+	FragmentTimeout             int
+	StreamDialTimeout           int
+	StreamWriteTimeout          int
+	StreamRetryPeriod           int
+	HandshakeTimeout            int
+	PlaintextRetryPeriod        int
+	BanWindow                   int
+	LimitsCleanupPeriod         int
+	ProbeTimeoutMillis          int
+	SuspectTimeout              int
+	DeadRetention               int
+	ShufflePeriod               int
+	DiscoveryPeriod             int
+	PeerExchangePeriod          int
+	ResolvePeriod               int
+	ShutdownTimeout             int
+}
+
+// DefaultSettings - the settings of a gossiper which is not configured otherwise
+func DefaultSettings() *Settings {
+	return &Settings{
+		SharedFilesFolder:           constants.SharedFilesFolder,
+		ShareFilesChunksFolder:      constants.ShareFilesChunksFolder,
+		DownloadedFilesFolder:       constants.DownloadedFilesFolder,
+		DownloadedFilesChunksFolder: constants.DownloadedFilesChunksFolder,
+		StateFolder:                 constants.StateFolder,
+		FixedChunkSize:              constants.FixedChunkSize,
+		StartingRingSearchBudget:    constants.StartingRingSearchBudget,
+		RingSearchBudgetLimit:       constants.RingSearchBudgetLimit,
+		MaxSearchBudget:             constants.MaxSearchBudget,
+		FullMatchesThreshold:        constants.FullMatchesThreshold,
+		DefaultHopLimit:             constants.DefaultHopLimit,
+		PoWTxHopLimit:               constants.PoWTxHopLimit,
+		PoWBlockHopLimit:            constants.PoWBlockHopLimit,
+		MaxHopLimit:                 constants.MaxHopLimit,
+		MongeringTimeout:            constants.MongeringTimeout,
+		DownloadRetryPeriod:         constants.DownloadRetryPeriod,
+		SearchRetryPeriod:           constants.SearchRetryPeriod,
+		DuplicateSearchWindowMillis: constants.DuplicateSearchWindowMillis,
+		NamedDownloadSearchTimeout:  constants.NamedDownloadSearchTimeout,
+		NameProofTimeout:            constants.NameProofTimeout,
+		MiningIdlePeriod:            constants.MiningIdlePeriod,
+		EventKeepAlivePeriod:        constants.EventKeepAlivePeriod,
+		StreamChunkTimeout:          constants.StreamChunkTimeout,
+		DownloadCleanupPeriod:       constants.DownloadCleanupPeriod,
+		FinishedDownloadRetention:   constants.FinishedDownloadRetention,
+		FragmentTimeout:             constants.FragmentTimeout,
+		StreamDialTimeout:           constants.StreamDialTimeout,
+		StreamWriteTimeout:          constants.StreamWriteTimeout,
+		StreamRetryPeriod:           constants.StreamRetryPeriod,
+		HandshakeTimeout:            constants.HandshakeTimeout,
+		PlaintextRetryPeriod:        constants.PlaintextRetryPeriod,
+		BanWindow:                   constants.BanWindow,
+		LimitsCleanupPeriod:         constants.LimitsCleanupPeriod,
+		ProbeTimeoutMillis:          constants.ProbeTimeoutMillis,
+		SuspectTimeout:              constants.SuspectTimeout,
+		DeadRetention:               constants.DeadRetention,
+		ShufflePeriod:               constants.ShufflePeriod,
+		DiscoveryPeriod:             constants.DiscoveryPeriod,
+		PeerExchangePeriod:          constants.PeerExchangePeriod,
+		ResolvePeriod:               constants.ResolvePeriod,
+		ShutdownTimeout:             constants.ShutdownTimeout,
+	}
+}
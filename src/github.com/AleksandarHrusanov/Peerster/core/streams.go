@@ -0,0 +1,245 @@
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+	"github.com/dedis/protobuf"
+)
+
+// ErrFrameTooLarge - a frame on a stream connection is larger than MaxStreamFrameSize
+var ErrFrameTooLarge = errors.New("stream frame too large")
+
+// ErrStreamHandshake - the peer at the other end of a stream connection did not answer the handshake
+var ErrStreamHandshake = errors.New("stream handshake failed")
+
+// StreamHello - the first frame sent in each direction of a stream connection. The node dialing
+// gives the gossip address its packets come from; the node accepting answers with its own
+type StreamHello struct {
+	Name    string
+	Address string
+	Version uint32
+}
+
+// StreamProtocolVersion - the version of the stream transport spoken by this gossiper
+const StreamProtocolVersion = 1
+
+// streamConn - an established stream connection to a peer. Frames of concurrent senders are queued
+// and written by a routine of the connection, so that the requests and replies of all downloads
+// share the connection and senders never wait for a slow peer
+type streamConn struct {
+	Conn      net.Conn
+	Frames    chan []byte
+	Closed    chan struct{}
+	CloseOnce sync.Once
+}
+
+// SafeStreamTransport - the stream connections used to send data requests and replies to peers by
+// gossip address, and the peers which recently failed to set one up
+type SafeStreamTransport struct {
+	Conns      map[string]*streamConn
+	Dialing    map[string]bool
+	FailedAt   map[string]time.Time
+	StreamLock sync.Mutex
+}
+
+// NewSafeStreamTransport - create a stream transport without connections
+func NewSafeStreamTransport() *SafeStreamTransport {
+	return &SafeStreamTransport{Conns: make(map[string]*streamConn), Dialing: make(map[string]bool),
+		FailedAt: make(map[string]time.Time)}
+}
+
+// UsesStream - true for the packets of bulk transfers, which go over a stream connection when
+// there is one. Gossip, status and search packets always go over UDP
+func UsesStream(packet *GossipPacket) bool {
+	return packet.DataRequest != nil || packet.DataReply != nil
+}
+
+// WriteFrame - write a length-prefixed frame
+func WriteFrame(w io.Writer, frame []byte) error {
+	if len(frame) > constants.MaxStreamFrameSize {
+		return ErrFrameTooLarge
+	}
+	header := make([]byte, 4)
+	binary.BigEndian.PutUint32(header, uint32(len(frame)))
+	if _, err := w.Write(append(header, frame...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ReadFrame - read a length-prefixed frame
+func ReadFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	size := binary.BigEndian.Uint32(header)
+	if size > constants.MaxStreamFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	return frame, nil
+}
+
+// sendOverStream - queue an encoded packet on the stream connection to the peer. Returns false if
+// there is no usable connection or its queue is full, in which case the packet has to go over UDP.
+// A connection is set up in the background for the next packets, unless the peer failed to answer
+// recently
+func (g *Gossiper) sendOverStream(addressAndPort string, packetBytes []byte) bool {
+	g.Streams.StreamLock.Lock()
+	sc, ok := g.Streams.Conns[addressAndPort]
+	if !ok {
+		failedAt, failed := g.Streams.FailedAt[addressAndPort]
+		retry := !failed || time.Since(failedAt) > time.Duration(g.Settings.StreamRetryPeriod)*time.Second
+		if retry && !g.Streams.Dialing[addressAndPort] {
+			g.Streams.Dialing[addressAndPort] = true
+			if !g.Go(func() { g.dialStream(addressAndPort) }) {
+				delete(g.Streams.Dialing, addressAndPort)
+			}
+		}
+	}
+	g.Streams.StreamLock.Unlock()
+	if !ok {
+		return false
+	}
+	// frames are sealed with the keys agreed on the gossip socket, which are set up by sending over UDP
+	frame, ok := g.SealForStream(addressAndPort, packetBytes)
+	if !ok {
+		return false
+	}
+
+	select {
+	case sc.Frames <- frame:
+		return true
+	case <-sc.Closed:
+		return false
+	default:
+		// the peer reads slower than packets are sent
+		return false
+	}
+}
+
+// writes the frames queued on a stream connection until it is dropped or the gossiper stops
+func (g *Gossiper) writeStream(addressAndPort string, sc *streamConn) {
+	for {
+		select {
+		case frame := <-sc.Frames:
+			sc.Conn.SetWriteDeadline(time.Now().Add(time.Duration(g.Settings.StreamWriteTimeout) * time.Second))
+			if err := WriteFrame(sc.Conn, frame); err != nil {
+				helpers.GossipLog.Warn("stream connection lost", helpers.F("peer", addressAndPort),
+					helpers.F("error", err))
+				g.dropStream(addressAndPort, sc)
+				return
+			}
+			g.CountMetric(MetricStreamPacketsSent, 1)
+		case <-sc.Closed:
+			return
+		case <-g.Done():
+			g.dropStream(addressAndPort, sc)
+			return
+		}
+	}
+}
+
+// connects to the stream listener of the peer, which shares the address of its UDP socket, and
+// checks with a handshake that it speaks the stream transport
+func (g *Gossiper) dialStream(addressAndPort string) {
+	timeout := time.Duration(g.Settings.StreamDialTimeout) * time.Second
+	conn, err := net.DialTimeout("tcp", addressAndPort, timeout)
+	if err == nil {
+		err = streamHandshake(conn, StreamHello{Name: g.Name, Address: g.Address.String(),
+			Version: StreamProtocolVersion}, timeout)
+		if err != nil {
+			conn.Close()
+		}
+	}
+
+	g.Streams.StreamLock.Lock()
+	defer g.Streams.StreamLock.Unlock()
+	delete(g.Streams.Dialing, addressAndPort)
+	if err != nil {
+		helpers.GossipLog.Debug("peer does not accept stream connections, using UDP",
+			helpers.F("peer", addressAndPort), helpers.F("error", err))
+		g.Streams.FailedAt[addressAndPort] = time.Now()
+		return
+	}
+	delete(g.Streams.FailedAt, addressAndPort)
+	sc := &streamConn{Conn: conn, Frames: make(chan []byte, constants.StreamQueueSize), Closed: make(chan struct{})}
+	if !g.Go(func() { g.writeStream(addressAndPort, sc) }) {
+		// the gossiper is stopping
+		conn.Close()
+		return
+	}
+	g.Streams.Conns[addressAndPort] = sc
+	helpers.GossipLog.Info("stream connection established", helpers.F("peer", addressAndPort))
+}
+
+func streamHandshake(conn net.Conn, hello StreamHello, timeout time.Duration) error {
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+	helloBytes, err := protobuf.Encode(&hello)
+	if err != nil {
+		return err
+	}
+	if err := WriteFrame(conn, helloBytes); err != nil {
+		return err
+	}
+	answer, err := ReadStreamHello(conn)
+	if err != nil {
+		return err
+	}
+	if answer.Version != StreamProtocolVersion {
+		return ErrStreamHandshake
+	}
+	return nil
+}
+
+// ReadStreamHello - read the handshake frame of a stream connection
+func ReadStreamHello(conn net.Conn) (*StreamHello, error) {
+	frame, err := ReadFrame(conn)
+	if err != nil {
+		return nil, err
+	}
+	hello := &StreamHello{}
+	if err := protobuf.Decode(frame, hello); err != nil || strings.Compare(hello.Address, "") == 0 {
+		return nil, ErrStreamHandshake
+	}
+	return hello, nil
+}
+
+// CloseStream - close the stream connection to a peer, if any, e.g. because it was evicted
+func (g *Gossiper) CloseStream(addressAndPort string) {
+	if g.Streams == nil {
+		return
+	}
+	g.Streams.StreamLock.Lock()
+	sc, ok := g.Streams.Conns[addressAndPort]
+	g.Streams.StreamLock.Unlock()
+	if ok {
+		g.dropStream(addressAndPort, sc)
+	}
+}
+
+// closes a broken connection; later packets go over UDP until a new connection is set up
+func (g *Gossiper) dropStream(addressAndPort string, sc *streamConn) {
+	sc.CloseOnce.Do(func() {
+		close(sc.Closed)
+		sc.Conn.Close()
+	})
+	g.Streams.StreamLock.Lock()
+	if g.Streams.Conns[addressAndPort] == sc {
+		delete(g.Streams.Conns, addressAndPort)
+	}
+	g.Streams.StreamLock.Unlock()
+}
@@ -30,6 +30,9 @@ type Message struct {
 	Request     *[]byte
 	Keywords    *string
 	Budget      *uint64
+	Name        *string
+	NewOwner    *string
+	Revoke      *bool
 }
 
 // RumorMessage sent between gossipers
@@ -62,16 +65,60 @@ type PrivateMessage struct {
 // GossipPacket standard wrapper for communications
 // between gossipers
 type GossipPacket struct {
-	Simple        *SimpleMessage
-	Rumor         *RumorMessage
-	Status        *StatusPacket
-	Private       *PrivateMessage
-	DataRequest   *DataRequest
-	DataReply     *DataReply
-	SearchRequest *SearchRequest
-	SearchReply   *SearchReply
-	TLCMessage    *TLCMessage
-	Ack           *TLCAck
+	Simple           *SimpleMessage
+	Rumor            *RumorMessage
+	Status           *StatusPacket
+	Private          *PrivateMessage
+	DataRequest      *DataRequest
+	DataReply        *DataReply
+	SearchRequest    *SearchRequest
+	SearchReply      *SearchReply
+	TLCMessage       *TLCMessage
+	Ack              *TLCAck
+	NameProofRequest *NameProofRequest
+	NameProofReply   *NameProofReply
+	PoWTransaction   *PoWTransaction
+	PoWBlock         *PoWBlock
+	Fragment         *PacketFragment
+	Probe            *MembershipProbe
+	Shuffle          *PeerShuffle
+	PeerExchange     *PeerExchange
+}
+
+// MembershipProbe - a probe of the failure detector. Without a target it is a ping to be acked by the
+// receiver; with one, it asks the receiver to ping the target and forward its ack. With Leave, it
+// announces that the sender is stopping and is not to be probed
+type MembershipProbe struct {
+	Seq    uint64
+	Target string
+	Ack    bool
+	Leave  bool
+}
+
+// PeerShuffle - peers of the partial view of a node offered to another in exchange for some of its own
+type PeerShuffle struct {
+	Entries []*ShuffleEntry
+	Reply   bool
+}
+
+// ShuffleEntry - a peer of a partial view and the shuffles it has been in the view for
+type ShuffleEntry struct {
+	Address string
+	Age     uint32
+}
+
+// PeerExchange - a request for some of the known peers of a node, or the reply with them
+type PeerExchange struct {
+	Peers []string
+	Reply bool
+}
+
+// PacketFragment - a part of an encoded gossip packet too large for a single datagram
+type PacketFragment struct {
+	ID    uint64 // the same for all fragments of a packet from a given sender
+	Index uint32
+	Count uint32
+	Data  []byte
 }
 
 // DataRequest - a struct for requesting file chunks
@@ -113,15 +160,34 @@ type SearchResult struct {
 
 // Blockchain
 
+// TxType - the operation a transaction performs on a name
+type TxType int
+
+const (
+	// TxClaim - claim a new (or previously revoked) name
+	TxClaim TxType = iota
+	// TxUpdate - point an owned name to a new version of the file
+	TxUpdate
+	// TxTransfer - hand an owned name over to another node
+	TxTransfer
+	// TxRevoke - release an owned name
+	TxRevoke
+)
+
 type TxPublish struct {
 	Name         string
 	Size         int64 // size in bytes
 	MetafileHash []byte
+	Type         TxType
+	NewOwner     string // only set for TxTransfer
+	Origin       string // the issuing node, only set in proof-of-work mode where it is not the miner
 }
 
 type BlockPublish struct {
 	PrevHash    [32]byte
 	Transaction TxPublish
+	NameRoot    [32]byte // Merkle root of the name table after applying the block
+	Nonce       uint64   // only used in proof-of-work mode
 }
 
 type TLCMessage struct {
@@ -134,3 +200,61 @@ type TLCMessage struct {
 }
 
 type TLCAck PrivateMessage
+
+// NameLeaf - the state of a name as committed to in the Merkle tree of the name table
+type NameLeaf struct {
+	Name         string
+	Owner        string
+	Holder       string
+	MetafileHash []byte
+	Size         int64
+	Revoked      bool
+}
+
+// NameLeafProof - a leaf of the name table Merkle tree, its index and the sibling hashes on its path
+// to the root
+type NameLeafProof struct {
+	Leaf     NameLeaf
+	Index    uint64
+	Siblings [][]byte
+}
+
+// NameProofRequest - a request to a full node for a name and its inclusion proof
+type NameProofRequest struct {
+	Origin      string
+	Destination string
+	HopLimit    uint32
+	Name        string
+	Nonce       uint64 // identifies the lookup the reply is for
+}
+
+// NameProofReply - a name, the block whose name root it is proven against and the Merkle path to it.
+// A name which is not registered is proven absent by the leaves sorted right before and after it
+type NameProofReply struct {
+	Origin      string
+	Destination string
+	HopLimit    uint32
+	Name        string
+	Found       bool
+	Leaf        NameLeaf
+	BlockHash   [32]byte
+	LeafIndex   uint64
+	LeafCount   uint64
+	Siblings    [][]byte
+	Nonce       uint64
+	Before      *NameLeafProof // nil if the name sorts before every leaf
+	After       *NameLeafProof // nil if the name sorts after every leaf
+}
+
+// PoWTransaction - a transaction flooded to the miners in proof-of-work mode
+type PoWTransaction struct {
+	Transaction TxPublish
+	HopLimit    uint32
+}
+
+// PoWBlock - a mined block flooded to the network in proof-of-work mode
+type PoWBlock struct {
+	Origin   string
+	Block    BlockPublish
+	HopLimit uint32
+}
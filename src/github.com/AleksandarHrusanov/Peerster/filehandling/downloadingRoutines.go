@@ -2,7 +2,6 @@ package filehandling
 
 import (
 	"bytes"
-	"encoding/hex"
 	"math/rand"
 	"strings"
 	"time"
@@ -54,14 +53,15 @@ func initiateFilesearchDownloading(gossiper *core.Gossiper, match *core.FileSear
 	gossiper.OngoingFileSearch.SearchRequestLock.Unlock()
 
 	// create a ticker
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := time.NewTicker(time.Duration(gossiper.Settings.DownloadRetryPeriod) * time.Second)
 	haveMetaFile := false
 	nextIdx := uint64(0)
 
 	// pick a random peer to request the metafile from
 	randomPeer := pickRandomPeerToRequestChunk(match, nextIdx)
-	request := createDataRequest(gossiper.Name, randomPeer, match.Metahash)
+	request := createDataRequest(gossiper, randomPeer, match.Metahash)
 	fInfo.MetaHash = convertSliceTo32Fixed(match.Metahash)
+	startDownloadStream(gossiper, fname, match.Metahash)
 	forwardDataRequest(gossiper, request)
 
 	// in an infinite for-loop
@@ -71,22 +71,29 @@ func initiateFilesearchDownloading(gossiper *core.Gossiper, match *core.FileSear
 		select {
 		// if ticker timeout
 		case <-ticker.C:
-			// resend data request
+			// resend data request, switching to the chunk a reader waits for if there is one
 			if !haveMetaFile {
-				request := createDataRequest(gossiper.Name, randomPeer, match.Metahash)
+				request := createDataRequest(gossiper, randomPeer, match.Metahash)
+				recordRetry(gossiper, match.Metahash, randomPeer)
 				forwardDataRequest(gossiper, request)
 			} else {
+				nextIdx = uint64(nextChunkToRequest(gossiper, match.Metahash, fileHasChunk(fInfo), uint32(len(fInfo.Metafile))))
 				downloadFrom := pickRandomPeerToRequestChunk(match, nextIdx)
 				chunkHash := fInfo.Metafile[uint32(nextIdx)]
+				recordRetry(gossiper, match.Metahash, downloadFrom)
 				helpers.PrintDownloadingChunk(fname, downloadFrom, uint32(nextIdx))
 				resendDataRequest(gossiper, downloadFrom, chunkHash)
 			}
+		case <-gossiper.Done():
+			// the node is stopping; the download is saved with its state and starts again with the node
+			continueDownloading = false
 		case reply := <-ch:
 			if reply != nil {
 				// if a dataReply comes from the chanel
 				if len(reply.Data) == 0 {
 					// if the Data field of the reply was empty, stop downloading
 					continueDownloading = false
+					failDownloadStream(gossiper, match.Metahash)
 				} else {
 					// sanity check - make sure it is a reply to my last request
 					var lastChunkRequested []byte
@@ -97,10 +104,10 @@ func initiateFilesearchDownloading(gossiper *core.Gossiper, match *core.FileSear
 						lastChunkRequested = chunkHash[:]
 					}
 					if !replyWasExpected(lastChunkRequested, reply) {
-						// received data reply for a chunk that was not requested; do nothing
-					}
-					if !replyIntegrityCheck(reply) {
+						// received data reply for a chunk that was not requested (e.g. for another download); ignore it
+					} else if !replyIntegrityCheck(reply) {
 						// received data reply with mismatching hash and data; resend request
+						recordRetry(gossiper, match.Metahash, reply.Origin)
 						if !haveMetaFile {
 							resendDataRequest(gossiper, randomPeer, convertSliceTo32Fixed(match.Metahash))
 						} else {
@@ -115,30 +122,42 @@ func initiateFilesearchDownloading(gossiper *core.Gossiper, match *core.FileSear
 						if !haveMetaFile {
 							fInfo.Metafile = mapifyMetafile(reply.Data)
 							haveMetaFile = true
+							streamMetafile(gossiper, match.Metahash, fInfo.Metafile)
+							// a resumed download only requests the chunks it did not store yet
+							storedChunks := loadStoredChunks(gossiper, fInfo.Metafile)
+							for idx, data := range storedChunks {
+								fInfo.ChunksMap[hashToString(fInfo.Metafile[idx])] = data
+							}
+							streamStoredChunks(gossiper, match.Metahash, storedChunks)
 						} else {
 							// the datareply SHOULD be containing a file data chunk
 							// update FileInfo struct
 							fInfo.ChunksMap[chunkHashString] = reply.Data[:len(reply.Data)]
+							storeDownloadedChunk(gossiper, reply.HashValue, reply.Data)
+							streamChunk(gossiper, match.Metahash, uint32(nextIdx), reply.Data, reply.Origin)
 						}
 
 						// if that was the last chunk to be downloaded reconstruct save the full file
-						if nextIdx == match.ChunkCount {
+						next := nextChunkToRequest(gossiper, match.Metahash, fileHasChunk(fInfo), uint32(len(fInfo.Metafile)))
+						finished := next == 0
+						publishChunkReceived(gossiper, fname, reply.Origin, uint32(nextIdx), reply.HashValue,
+							len(fInfo.ChunksMap), int(match.ChunkCount), finished)
+						if finished {
 							helpers.PrintReconstructedFile(fname)
 							continueDownloading = false
-							gossiper.FilesAndMetahashes.FileNamesToMetahashesMap[fInfo.FileName] = hex.EncodeToString(fInfo.MetaHash[:])
-							reconstructAndSaveFullyDownloadedFile(fInfo)
+							completeDownload(gossiper, fInfo)
 						}
-					}
 
-					if continueDownloading {
-						// if not, get next chunk request, (update ticker) and send it
-						ticker = time.NewTicker(5 * time.Second)
-						nextIdx++
-						nextHashToRequest := fInfo.Metafile[uint32(nextIdx)]
-						downloadFrom := pickRandomPeerToRequestChunk(match, nextIdx)
-						request := createDataRequest(gossiper.Name, downloadFrom, nextHashToRequest[:])
-						helpers.PrintDownloadingChunk(fname, downloadFrom, uint32(nextIdx))
-						forwardDataRequest(gossiper, request)
+						if continueDownloading {
+							// if not, get next chunk request, (update ticker) and send it
+							ticker = time.NewTicker(time.Duration(gossiper.Settings.DownloadRetryPeriod) * time.Second)
+							nextIdx = uint64(next)
+							nextHashToRequest := fInfo.Metafile[uint32(nextIdx)]
+							downloadFrom := pickRandomPeerToRequestChunk(match, nextIdx)
+							request := createDataRequest(gossiper, downloadFrom, nextHashToRequest[:])
+							helpers.PrintDownloadingChunk(fname, downloadFrom, uint32(nextIdx))
+							forwardDataRequest(gossiper, request)
+						}
 					}
 				}
 			}
@@ -165,10 +184,11 @@ func initiateRegularDownloading(gossiper *core.Gossiper, downloadFrom string, fn
 		return
 	}
 	ch := state.DownloadChanel
+	metahash := state.FileInfo.MetaHash
 	gossiper.DownloadingLock.Unlock()
 
 	// create a ticker
-	ticker := time.NewTicker(5 * time.Second)
+	ticker := time.NewTicker(time.Duration(gossiper.Settings.DownloadRetryPeriod) * time.Second)
 	state.StateLock.Lock()
 	// in an infinite for-loop
 	for {
@@ -177,17 +197,29 @@ func initiateRegularDownloading(gossiper *core.Gossiper, downloadFrom string, fn
 		select {
 		// if ticker timeout
 		case <-ticker.C:
-			// resend data request
+			// resend data request, switching to the chunk a reader waits for if there is one
+			if state.MetafileDownloaded {
+				selectNextChunk(gossiper, state)
+			}
 			nextIdx := state.NextChunkIndex
 			helpers.PrintDownloadingChunk(fname, downloadFrom, nextIdx)
 
+			recordRetry(gossiper, metahash[:], downloadFrom)
 			resendDataRequest(gossiper, downloadFrom, state.LatestRequestedChunk)
+		case <-gossiper.Done():
+			// the node is stopping; the download is saved with its state and starts again with the node
+			continueDownloading = false
 		case reply := <-ch:
 			if reply != nil {
 				// if a dataReply comes from the chanel
 				if len(reply.Data) == 0 {
-					// if the Data field of the reply was empty, stop downloading
+					// if the Data field of the reply was empty, stop downloading; the download failed and
+					// the state gets removed by removeCompletedStates
 					continueDownloading = false
+					failDownloadStream(gossiper, metahash[:])
+					gossiper.DownloadingLock.Lock()
+					state.DownloadFinished = true
+					gossiper.DownloadingLock.Unlock()
 				} else {
 					// sanity check - make sure it is a reply to my last request
 					lastChunk := state.LatestRequestedChunk[:]
@@ -198,46 +230,63 @@ func initiateRegularDownloading(gossiper *core.Gossiper, downloadFrom string, fn
 						// received data reply with mismatching hash and data; resend request
 						nextIdx := state.NextChunkIndex
 						helpers.PrintDownloadingChunk(fname, downloadFrom, nextIdx)
+						recordRetry(gossiper, metahash[:], downloadFrom)
 						resendDataRequest(gossiper, downloadFrom, state.LatestRequestedChunk)
 					} else {
+						receivedIdx := uint32(0)
 						mfReqeusted := state.MetafileRequested
 						mfDownloaded := state.MetafileDownloaded
 						if mfReqeusted && !mfDownloaded {
 							// the datareply SHOULD contain the metafile then
 							handleReceivedMetafile(gossiper, reply, fname, state)
+							streamMetafile(gossiper, metahash[:], state.FileInfo.Metafile)
+							// a resumed download only requests the chunks it did not store yet
+							storedChunks := loadStoredChunks(gossiper, state.FileInfo.Metafile)
+							gossiper.DownloadingLock.Lock()
+							for idx, data := range storedChunks {
+								state.FileInfo.ChunksMap[hashToString(state.FileInfo.Metafile[idx])] = data
+							}
+							gossiper.DownloadingLock.Unlock()
+							streamStoredChunks(gossiper, metahash[:], storedChunks)
 						} else {
-							// the datareply SHOULD be containing a file data chunk
-							// update FileInfo struct
+							// the datareply SHOULD be containing a file data chunk. The chunk requested
+							// last may have changed since this one was requested, so its index is
+							// looked up in the metafile
+							receivedIdx = chunkIndex(state.FileInfo.Metafile, reply.HashValue, state.NextChunkIndex)
+							if receivedIdx == 0 {
+								// not a chunk of this file; ignore it
+								continue
+							}
 							chunkHash := convertSliceTo32Fixed(reply.HashValue)
 							chunkHashString := hashToString(chunkHash)
 							gossiper.DownloadingLock.Lock()
 							state.FileInfo.ChunksMap[chunkHashString] = reply.Data[:len(reply.Data)]
-							state.NextChunkIndex++
 							gossiper.DownloadingLock.Unlock()
+							storeDownloadedChunk(gossiper, reply.HashValue, reply.Data)
+							streamChunk(gossiper, metahash[:], receivedIdx, reply.Data, downloadFrom)
+						}
 
-							// save chunk to a new file
-							// chunkPath, _ := filepath.Abs(constants.DownloadedFilesChunksFolder + "/" + chunkHashString)
-							// ioutil.WriteFile(chunkPath, reply.Data[:len(reply.Data)], constants.FileMode)
-							// if that was the last chunk to be downloaded close the chanel and save the full file
-							if wasLastFileChunk(gossiper, reply, state) {
-								helpers.PrintReconstructedFile(fname)
-								state.DownloadFinished = true
-								continueDownloading = false
-								fInfo := state.FileInfo
-								gossiper.FilesAndMetahashes.FileNamesToMetahashesMap[fInfo.FileName] = hex.EncodeToString(fInfo.MetaHash[:])
-								reconstructAndSaveFullyDownloadedFile(fInfo)
-								// removeState(gossiper.DownloadingStates[downloadFrom])
-								// delete(gossiper.DownloadingStates, downloadFrom)
-							}
+						// if that was the last chunk to be downloaded close the chanel and save the full file
+						finished := selectNextChunk(gossiper, state) == 0
+						gossiper.DownloadingLock.Lock()
+						downloaded := len(state.FileInfo.ChunksMap)
+						gossiper.DownloadingLock.Unlock()
+						publishChunkReceived(gossiper, fname, downloadFrom, receivedIdx, reply.HashValue,
+							downloaded, len(state.FileInfo.Metafile), finished)
+						if finished {
+							helpers.PrintReconstructedFile(fname)
+							continueDownloading = false
+							completeDownload(gossiper, state.FileInfo)
+							// the state gets removed by removeCompletedStates
+							gossiper.DownloadingLock.Lock()
+							state.DownloadFinished = true
+							gossiper.DownloadingLock.Unlock()
 						}
 
 						if continueDownloading {
-							// if not, get next chunk request, (update ticker) and send it
-							ticker = time.NewTicker(5 * time.Second)
-							nextChunkIdx := state.NextChunkIndex
-							nextHashToRequest, _ := state.FileInfo.Metafile[nextChunkIdx]
-							state.LatestRequestedChunk = nextHashToRequest
-							request := createDataRequest(gossiper.Name, downloadFrom, nextHashToRequest[:])
+							// if not, (update ticker) and send the next chunk request
+							ticker = time.NewTicker(time.Duration(gossiper.Settings.DownloadRetryPeriod) * time.Second)
+							request := createDataRequest(gossiper, downloadFrom, state.LatestRequestedChunk[:])
 							helpers.PrintDownloadingChunk(fname, downloadFrom, state.NextChunkIndex)
 							forwardDataRequest(gossiper, request)
 						}
@@ -252,6 +301,20 @@ func initiateRegularDownloading(gossiper *core.Gossiper, downloadFrom string, fn
 	}
 }
 
+// picks the next chunk to request in a regular download, the one a reader waits for first,
+// and records it in the state. Returns 0 once every chunk was received
+func selectNextChunk(gossiper *core.Gossiper, state *core.DownloadingState) uint32 {
+	gossiper.DownloadingLock.Lock()
+	defer gossiper.DownloadingLock.Unlock()
+	fInfo := state.FileInfo
+	next := nextChunkToRequest(gossiper, fInfo.MetaHash[:], fileHasChunk(fInfo), uint32(len(fInfo.Metafile)))
+	if next != 0 {
+		state.NextChunkIndex = next
+		state.LatestRequestedChunk = fInfo.Metafile[next]
+	}
+	return next
+}
+
 func pickRandomPeerToRequestChunk(match *core.FileSearchMatch, chunkIdx uint64) string {
 	allChunkLocations := match.LocationOfChunks
 
@@ -12,16 +12,15 @@ import (
 	"github.com/AleksandarHrusanov/Peerster/constants"
 	"github.com/AleksandarHrusanov/Peerster/core"
 	"github.com/AleksandarHrusanov/Peerster/helpers"
-	"github.com/dedis/protobuf"
 )
 
-func createDataRequest(origin string, dest string, hash []byte) *core.DataRequest {
-	request := core.DataRequest{Origin: origin, Destination: dest, HopLimit: uint32(10), HashValue: hash[:]}
+func createDataRequest(gossiper *core.Gossiper, dest string, hash []byte) *core.DataRequest {
+	request := core.DataRequest{Origin: gossiper.Name, Destination: dest, HopLimit: gossiper.Settings.DefaultHopLimit, HashValue: hash[:]}
 	return &request
 }
 
-func createDataReply(origin string, dest string, hash []byte, data []byte) *core.DataReply {
-	reply := core.DataReply{Origin: origin, Destination: dest, HopLimit: uint32(10), HashValue: hash[:], Data: data}
+func createDataReply(gossiper *core.Gossiper, dest string, hash []byte, data []byte) *core.DataReply {
+	reply := core.DataReply{Origin: gossiper.Name, Destination: dest, HopLimit: gossiper.Settings.DefaultHopLimit, HashValue: hash[:], Data: data}
 	return &reply
 }
 
@@ -46,9 +45,7 @@ func forwardDataRequest(gossiper *core.Gossiper, msg *core.DataRequest) {
 	msg.HopLimit--
 	// Encode and send packet
 	packetToSend := core.GossipPacket{DataRequest: msg}
-	packetBytes, err := protobuf.Encode(&packetToSend)
-	helpers.HandleErrorFatal(err)
-	core.ConnectAndSend(forwardingAddress, gossiper.Conn, packetBytes)
+	gossiper.SendPacket(forwardingAddress, &packetToSend)
 }
 
 // A function to forward a data request to the corresponding next hop
@@ -70,15 +67,13 @@ func forwardDataReply(gossiper *core.Gossiper, msg *core.DataReply) {
 	msg.HopLimit--
 	// Encode and send packet
 	packetToSend := core.GossipPacket{DataReply: msg}
-	packetBytes, err := protobuf.Encode(&packetToSend)
-	helpers.HandleErrorFatal(err)
-	core.ConnectAndSend(forwardingAddress, gossiper.Conn, packetBytes)
+	gossiper.SendPacket(forwardingAddress, &packetToSend)
 }
 
 // a function to resend the latest requested chunk
 func resendDataRequest(gossiper *core.Gossiper, downloadFrom string, chunkToRerequest [constants.HashSize]byte) {
 	if _, ok := gossiper.DownloadingStates[downloadFrom]; ok {
-		request := createDataRequest(gossiper.Name, downloadFrom, chunkToRerequest[:])
+		request := createDataRequest(gossiper, downloadFrom, chunkToRerequest[:])
 		forwardDataRequest(gossiper, request)
 	}
 }
@@ -95,7 +90,7 @@ func replyIntegrityCheck(reply *core.DataReply) bool {
 	return bytes.Compare(actualHash, dataHash[:]) == 0
 }
 
-func reconstructAndSaveFullyDownloadedFile(fileInfo *core.FileInformation) {
+func reconstructAndSaveFullyDownloadedFile(gossiper *core.Gossiper, fileInfo *core.FileInformation) {
 	// create a file
 	fileData := make([]byte, 0)
 	numChunks := len(fileInfo.Metafile)
@@ -105,7 +100,7 @@ func reconstructAndSaveFullyDownloadedFile(fileInfo *core.FileInformation) {
 		fileData = append(fileData, chunk[:]...)
 	}
 	// create and write to file
-	path, _ := filepath.Abs(constants.DownloadedFilesFolder)
+	path, _ := filepath.Abs(gossiper.Settings.DownloadedFilesFolder)
 	filePath, _ := filepath.Abs(path + "/" + fileInfo.FileName)
 	ioutil.WriteFile(filePath, fileData[:], 0777)
 }
@@ -165,6 +160,20 @@ func mapifyMetafile(mfile []byte) map[uint32][constants.HashSize]byte {
 	return metafile
 }
 
+// returns the index of the chunk with the given hash in the metafile, 0 if the file has no such chunk.
+// A file may hold the same chunk several times: the requested index is preferred, then the first one
+func chunkIndex(metafile map[uint32][constants.HashSize]byte, hash []byte, requested uint32) uint32 {
+	if chunkHash, ok := metafile[requested]; ok && bytes.Equal(chunkHash[:], hash) {
+		return requested
+	}
+	for idx := uint32(1); idx <= uint32(len(metafile)); idx++ {
+		if chunkHash := metafile[idx]; bytes.Equal(chunkHash[:], hash) {
+			return idx
+		}
+	}
+	return 0
+}
+
 func hashToString(hash [constants.HashSize]byte) string {
 	return hex.EncodeToString(hash[:])
 }
@@ -185,8 +194,8 @@ func convertSliceTo32Fixed(slice []byte) [constants.HashSize]byte {
 
 // Handle requested hash from file system
 // ======================================
-func retrieveRequestedHashFromFileSystem(requestedHash [constants.HashSize]byte) []byte {
-	hashBytes := getChunkOrMetafileFromFileSystem(requestedHash)
+func retrieveRequestedHashFromFileSystem(gossiper *core.Gossiper, requestedHash [constants.HashSize]byte) []byte {
+	hashBytes := getChunkOrMetafileFromFileSystem(gossiper, requestedHash)
 	if hashBytes != nil {
 		// if the requested hash  was a filechunk
 		return hashBytes
@@ -206,9 +215,9 @@ func retrieveRequestedHashFromGossiperMemory(gossiper *core.Gossiper, requestedH
 }
 
 // if the given fileInfo has the requested chunk, return it
-func getChunkOrMetafileFromFileSystem(chunkHash [constants.HashSize]byte) []byte {
+func getChunkOrMetafileFromFileSystem(gossiper *core.Gossiper, chunkHash [constants.HashSize]byte) []byte {
 	// Look for chunk in the _SharedFiles folder
-	sharedPath := buildChunkPath(constants.ShareFilesChunksFolder, chunkHash[:])
+	sharedPath := buildChunkPath(gossiper.Settings.ShareFilesChunksFolder, chunkHash[:])
 	if _, err := os.Stat(sharedPath); err == nil {
 		data, _ := ioutil.ReadFile(sharedPath)
 		return data
@@ -216,7 +225,7 @@ func getChunkOrMetafileFromFileSystem(chunkHash [constants.HashSize]byte) []byte
 
 	// Look for chunk in the _DownloadedFiles folder
 	// downloadsPath, _ := filepath.Abs(constants.DownloadedFilesChunksFolder + "/" + hashString)
-	downloadsPath := buildChunkPath(constants.DownloadedFilesChunksFolder, chunkHash[:])
+	downloadsPath := buildChunkPath(gossiper.Settings.DownloadedFilesChunksFolder, chunkHash[:])
 	if _, err := os.Stat(downloadsPath); err == nil {
 		data, _ := ioutil.ReadFile(downloadsPath)
 		return data
@@ -225,10 +234,26 @@ func getChunkOrMetafileFromFileSystem(chunkHash [constants.HashSize]byte) []byte
 	return nil
 }
 
-func wasLastFileChunk(gossiper *core.Gossiper, reply *core.DataReply, state *core.DownloadingState) bool {
-	metafile := state.FileInfo.Metafile
-	lastChunkInMetafile := state.FileInfo.Metafile[uint32(len(metafile)-1)]
-	return bytes.Compare(reply.HashValue, lastChunkInMetafile[:]) == 0
+// stores a chunk received for a download in the downloaded chunks folder, so that the download
+// resumed by the next run of the node does not request it again
+func storeDownloadedChunk(gossiper *core.Gossiper, hash []byte, data []byte) {
+	chunkPath := buildChunkPath(gossiper.Settings.DownloadedFilesChunksFolder, hash)
+	if err := ioutil.WriteFile(chunkPath, data, constants.FileMode); err != nil {
+		helpers.FilesLog.Warn("cannot store downloaded chunk", helpers.F("error", err))
+	}
+}
+
+// the chunks of a file stored by an earlier run of the node, by index. Chunks which do not match
+// their hash are left out and requested again
+func loadStoredChunks(gossiper *core.Gossiper, metafile map[uint32][constants.HashSize]byte) map[uint32][]byte {
+	chunks := make(map[uint32][]byte)
+	for idx, hash := range metafile {
+		data, err := ioutil.ReadFile(buildChunkPath(gossiper.Settings.DownloadedFilesChunksFolder, hash[:]))
+		if err == nil && computeSha256(data) == hash {
+			chunks[idx] = data
+		}
+	}
+	return chunks
 }
 
 func buildChunkPath(folder string, hashValue []byte) string {
@@ -236,6 +261,15 @@ func buildChunkPath(folder string, hashValue []byte) string {
 	return chunkPath
 }
 
+// publishes the events for a valid chunk received from a peer and the download progress it makes
+func publishChunkReceived(gossiper *core.Gossiper, fname string, from string, chunkIndex uint32, hash []byte,
+	downloaded int, total int, finished bool) {
+	gossiper.PublishEvent(core.EventChunkReceived, core.ChunkReceivedEvent{FileName: fname, From: from,
+		ChunkIndex: chunkIndex, Hash: hex.EncodeToString(hash)})
+	gossiper.PublishEvent(core.EventDownloadProgress, core.DownloadProgressEvent{FileName: fname,
+		ChunksDownloaded: downloaded, ChunkCount: total, Finished: finished})
+}
+
 func handleReceivedMetafile(gossiper *core.Gossiper, reply *core.DataReply, fname string, state *core.DownloadingState) {
 	// read the metafile and populate hashedChunks in the file
 	metafile := mapifyMetafile(reply.Data)
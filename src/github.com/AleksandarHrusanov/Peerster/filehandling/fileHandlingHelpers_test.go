@@ -0,0 +1,37 @@
+package filehandling
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/core"
+)
+
+func TestLoadStoredChunks(t *testing.T) {
+	settings := core.DefaultSettings()
+	settings.DownloadedFilesChunksFolder = t.TempDir()
+	gossiper := &core.Gossiper{Settings: settings}
+
+	first, second, corrupted, missing := []byte("first chunk"), []byte("second chunk"), []byte("corrupted chunk"),
+		[]byte("missing chunk")
+	for _, chunk := range [][]byte{first, second, corrupted} {
+		hash := computeSha256(chunk)
+		storeDownloadedChunk(gossiper, hash[:], chunk)
+	}
+	// a chunk damaged on disk does not match its hash any more
+	corruptedHash := computeSha256(corrupted)
+	corruptedPath := buildChunkPath(settings.DownloadedFilesChunksFolder, corruptedHash[:])
+	if err := ioutil.WriteFile(corruptedPath, []byte("other data"), constants.FileMode); err != nil {
+		t.Fatal(err)
+	}
+
+	// the second chunk appears twice in the file, and is loaded at both indices
+	metafile := map[uint32][constants.HashSize]byte{0: computeSha256(first), 1: computeSha256(second),
+		2: computeSha256(corrupted), 3: computeSha256(missing), 4: computeSha256(second)}
+	chunks := loadStoredChunks(gossiper, metafile)
+	if len(chunks) != 3 || string(chunks[0]) != string(first) || string(chunks[1]) != string(second) ||
+		string(chunks[4]) != string(second) {
+		t.Errorf("got chunks %q, want the first and the second ones", chunks)
+	}
+}
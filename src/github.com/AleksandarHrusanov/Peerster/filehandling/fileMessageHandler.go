@@ -16,14 +16,16 @@ func HandlePeerDataReply(gossiper *core.Gossiper, dataReply *core.DataReply) {
 	// if dataReplay message has reached destination
 	if strings.Compare(dataReply.Destination, gossiper.Name) == 0 {
 		// packet is for this gossiper
+		gossiper.CountMetric(core.MetricChunkBytesReceived, uint64(len(dataReply.Data)))
 		// lock
 		gossiper.OngoingFileSearch.SearchRequestLock.Lock()
 		// if there is a file search currently happening, this data reply might be for it,
-		// so send it to the chanel for handling
-		// if gossiper.OngoingFileSearch.IsOngoing {
-		// fmt.Println("Sending the data reply to the search download chanel!!")
-		gossiper.OngoingFileSearch.SearchDownloadReplyChanel <- dataReply
-		// }
+		// so send it to the chanel for handling; without a download waiting for it the reply
+		// is dropped rather than blocking the listener
+		select {
+		case gossiper.OngoingFileSearch.SearchDownloadReplyChanel <- dataReply:
+		default:
+		}
 		gossiper.OngoingFileSearch.SearchRequestLock.Unlock()
 
 		gossiper.DownloadingLock.Lock()
@@ -34,7 +36,11 @@ func HandlePeerDataReply(gossiper *core.Gossiper, dataReply *core.DataReply) {
 			// send to map of downloading states
 			for _, download := range gossiper.DownloadingStates[origin] {
 				if bytes.Compare(download.LatestRequestedChunk[:], hashValue) == 0 {
-					download.DownloadChanel <- dataReply
+					// a duplicate reply arriving while the download handles the first one is dropped
+					select {
+					case download.DownloadChanel <- dataReply:
+					default:
+					}
 				}
 			}
 		}
@@ -56,8 +62,9 @@ func HandlePeerDataRequest(gossiper *core.Gossiper, dataRequest *core.DataReques
 			// chunk was not found, do nothing
 			return
 		}
+		gossiper.CountMetric(core.MetricChunkBytesServed, uint64(len(retrievedChunk)))
 		// create a datareply object
-		reply := createDataReply(gossiper.Name, dataRequest.Origin, dataRequest.HashValue, retrievedChunk)
+		reply := createDataReply(gossiper, dataRequest.Origin, dataRequest.HashValue, retrievedChunk)
 		// send DataReply to the origin of the data request
 		forwardDataReply(gossiper, reply)
 	} else {
@@ -81,7 +88,8 @@ func HandleClientDownloadRequest(gossiper *core.Gossiper, clientMsg *core.Messag
 	gossiper.DownloadingLock.Unlock()
 
 	// create a DataRequest message and send a gossip packet
-	request := createDataRequest(gossiper.Name, downloadFrom, requestedMetaHash)
+	startDownloadStream(gossiper, fname, requestedMetaHash)
+	request := createDataRequest(gossiper, downloadFrom, requestedMetaHash)
 	helpers.PrintDownloadingMetafile(fname, downloadFrom)
 	forwardDataRequest(gossiper, request)
 
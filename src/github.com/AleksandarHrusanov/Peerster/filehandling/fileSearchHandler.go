@@ -6,10 +6,8 @@ import (
 	"strings"
 	"time"
 
-	"github.com/AleksandarHrusanov/Peerster/constants"
 	"github.com/AleksandarHrusanov/Peerster/core"
 	"github.com/AleksandarHrusanov/Peerster/helpers"
-	"github.com/dedis/protobuf"
 )
 
 // A function to handle a search request coming from the client of this peerster node
@@ -38,23 +36,23 @@ func HandlePeerSearchRequest(gossiper *core.Gossiper, searchRequest *core.Search
 			gossiper.RecentSearches.SearchesLock.Unlock()
 			return
 		}
-		//      otherwise, update recent search requests and TIME 0.5 seconds (go routine which
-		//      deletes the message from the recent search requests after 0.5 seconds?)
+		//      otherwise, update recent search requests and forget the request once the
+		//      duplicate search window is over
 		gossiper.RecentSearches.Searches[duplicateSignature] = true
-		go func() {
-			time.Sleep(500 * time.Millisecond)
+		time.AfterFunc(time.Duration(gossiper.Settings.DuplicateSearchWindowMillis)*time.Millisecond, func() {
 			gossiper.RecentSearches.SearchesLock.Lock()
 			delete(gossiper.RecentSearches.Searches, duplicateSignature)
 			gossiper.RecentSearches.SearchesLock.Unlock()
-		}()
+		})
 		gossiper.RecentSearches.SearchesLock.Unlock()
+		gossiper.CountMetric(core.MetricSearchRequestsHandled, 1)
 
 		// 2) process the search request locally (and possibly send a SearchReply)
 		//   Check both _SharedFiles and _Downloades (gossiper memory) for keywords matches
 		searchResults := performLocalFilenameSearch(gossiper, searchRequest.Keywords)
 		//   If any matches found, create and send a Search Reply (using next hop?)
 		if len(searchResults) > 0 {
-			searchReply := &core.SearchReply{Origin: gossiper.Name, Destination: searchRequest.Origin, HopLimit: uint32(10), Results: searchResults}
+			searchReply := &core.SearchReply{Origin: gossiper.Name, Destination: searchRequest.Origin, HopLimit: gossiper.Settings.DefaultHopLimit, Results: searchResults}
 			forwardSearchReply(gossiper, searchReply)
 		}
 	}
@@ -68,9 +66,7 @@ func HandlePeerSearchRequest(gossiper *core.Gossiper, searchRequest *core.Search
 	//         budget as evenly as possible to up to B neighboring nodes
 	//         every peer gets a search request with budget = integer part of (budget / #neighbours)
 	//         and then iteratively add 1 to the budget of the first R neighbors (where R = budget % # neighbors)
-	gossiper.PeersLock.Lock()
-	peers := gossiper.KnownPeers
-	gossiper.PeersLock.Unlock()
+	peers := gossiper.GetLivePeers()
 	peersCount := uint64(len(peers))
 
 	if peersCount > 0 {
@@ -84,9 +80,7 @@ func HandlePeerSearchRequest(gossiper *core.Gossiper, searchRequest *core.Search
 			}
 			newSearchRequest := &core.SearchRequest{Origin: searchRequest.Origin, Budget: newBdg, Keywords: searchRequest.Keywords}
 			packetToSend := core.GossipPacket{SearchRequest: newSearchRequest}
-			packetBytes, err := protobuf.Encode(&packetToSend)
-			helpers.HandleErrorFatal(err)
-			core.ConnectAndSend(peer, gossiper.Conn, packetBytes)
+			gossiper.SendPacket(peer, &packetToSend)
 		}
 	}
 }
@@ -117,10 +111,10 @@ func initiateFileSearching(gossiper *core.Gossiper, budget *uint64, searchKeywor
 	defaultBudget := false
 	if searchBudget == 0 {
 		defaultBudget = true
-		searchBudget = uint64(2)
+		searchBudget = gossiper.Settings.StartingRingSearchBudget
 	}
 
-	ticker := time.NewTicker(1 * time.Second)
+	ticker := time.NewTicker(time.Duration(gossiper.Settings.SearchRetryPeriod) * time.Second)
 
 	newSearchRequest := &core.SearchRequest{Origin: gossiper.Name, Budget: searchBudget, Keywords: strings.Split(*searchKeywords, ",")}
 	HandlePeerSearchRequest(gossiper, newSearchRequest)
@@ -134,7 +128,7 @@ func initiateFileSearching(gossiper *core.Gossiper, budget *uint64, searchKeywor
 
 			if defaultBudget {
 				// check if budget exceeded maximum
-				if searchBudget > constants.RingSearchBudgetLimit {
+				if searchBudget > gossiper.Settings.RingSearchBudgetLimit {
 					//    if so, end the search, return
 					// gossiper.OngoingFileSearch.IsOngoing = false
 					return
@@ -146,7 +140,7 @@ func initiateFileSearching(gossiper *core.Gossiper, budget *uint64, searchKeywor
 				HandlePeerSearchRequest(gossiper, newSearchRequest)
 
 				//      restart the ticker
-				ticker = time.NewTicker(1 * time.Second)
+				ticker = time.NewTicker(time.Duration(gossiper.Settings.SearchRetryPeriod) * time.Second)
 			}
 
 		case searchReply := <-searchReplyChanel:
@@ -197,13 +191,15 @@ func initiateFileSearching(gossiper *core.Gossiper, budget *uint64, searchKeywor
 					fullMatchesCount++
 				}
 			}
-			if fullMatchesCount >= constants.FullMatchesThreshold {
+			if fullMatchesCount >= gossiper.Settings.FullMatchesThreshold {
 				//    if so, print "SEARCH FINISHED"
 				helpers.PrintSearchFinished()
 				return
 			}
-			ticker = time.NewTicker(1 * time.Second)
+			ticker = time.NewTicker(time.Duration(gossiper.Settings.SearchRetryPeriod) * time.Second)
 
+		case <-gossiper.Done():
+			return
 		}
 	}
 
@@ -224,6 +220,9 @@ func addNewMatchedFileName(gossiper *core.Gossiper, fname string) {
 	}
 	if !contains {
 		gossiper.OngoingFileSearch.MatchesFileNames = append(gossiper.OngoingFileSearch.MatchesFileNames, fname)
+		match := gossiper.OngoingFileSearch.MatchesFound[fname]
+		gossiper.PublishEvent(core.EventSearchMatch, core.SearchMatchEvent{FileName: fname,
+			Metahash: hex.EncodeToString(match.Metahash), ChunkCount: match.ChunkCount})
 	}
 }
 
@@ -245,9 +244,7 @@ func forwardSearchReply(gossiper *core.Gossiper, msg *core.SearchReply) {
 	msg.HopLimit--
 	// Encode and send packet
 	packetToSend := core.GossipPacket{SearchReply: msg}
-	packetBytes, err := protobuf.Encode(&packetToSend)
-	helpers.HandleErrorFatal(err)
-	core.ConnectAndSend(forwardingAddress, gossiper.Conn, packetBytes)
+	gossiper.SendPacket(forwardingAddress, &packetToSend)
 }
 
 func performLocalFilenameSearch(gossiper *core.Gossiper, keywords []string) []*core.SearchResult {
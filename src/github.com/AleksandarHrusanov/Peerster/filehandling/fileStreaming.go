@@ -0,0 +1,304 @@
+package filehandling
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/core"
+)
+
+// ErrUnknownFile - the file is neither shared, downloaded nor being downloaded by this node
+var ErrUnknownFile = errors.New("file is neither shared nor being downloaded")
+
+// ErrChunkTimeout - a chunk of a downloading file did not arrive in time
+var ErrChunkTimeout = errors.New("timed out waiting for a chunk of the file")
+
+// FileReader - reads a file known to this node by its metahash. Reading a part of a file which is
+// still being downloaded blocks until the chunk holding it arrives, and asks the downloader to
+// fetch that chunk next
+type FileReader struct {
+	ctx        context.Context
+	gossiper   *core.Gossiper
+	name       string
+	size       int64
+	chunkCount uint32
+	chunk      func(ctx context.Context, idx uint32) ([]byte, error)
+	offset     int64
+}
+
+// OpenFile - open a shared, downloaded or downloading file for reading. For a downloading file this
+// waits for its metafile and its last chunk, which gives the size of the file
+func OpenFile(ctx context.Context, gossiper *core.Gossiper, metahash [constants.HashSize]byte) (*FileReader, error) {
+	metahashString := hashToString(metahash)
+	reader := &FileReader{ctx: ctx, gossiper: gossiper}
+
+	gossiper.FilesAndMetahashes.FilesLock.Lock()
+	fileInfo, complete := gossiper.FilesAndMetahashes.MetaStringToFileInfo[metahashString]
+	gossiper.FilesAndMetahashes.FilesLock.Unlock()
+	if complete {
+		reader.name = fileInfo.FileName
+		reader.size = fileInfo.Size
+		reader.chunkCount = uint32(fileInfo.ChunksCount)
+		reader.chunk = func(ctx context.Context, idx uint32) ([]byte, error) {
+			return fileInfo.ChunksMap[hashToString(fileInfo.Metafile[idx])], nil
+		}
+		return reader, nil
+	}
+
+	gossiper.DownloadStreams.StreamsLock.Lock()
+	stream, downloading := gossiper.DownloadStreams.Streams[metahashString]
+	gossiper.DownloadStreams.StreamsLock.Unlock()
+	if !downloading {
+		return nil, ErrUnknownFile
+	}
+	reader.name = stream.FileName
+	reader.chunk = func(ctx context.Context, idx uint32) ([]byte, error) {
+		return waitForChunk(ctx, gossiper, stream, idx)
+	}
+
+	if err := waitForMetafile(ctx, gossiper, stream); err != nil {
+		return nil, err
+	}
+	reader.chunkCount = uint32(len(stream.Metafile))
+	if reader.chunkCount > 0 {
+		lastChunk, err := waitForChunk(ctx, gossiper, stream, reader.chunkCount)
+		if err != nil {
+			return nil, err
+		}
+		reader.size = int64(reader.chunkCount-1)*int64(gossiper.Settings.FixedChunkSize) + int64(len(lastChunk))
+	}
+	return reader, nil
+}
+
+// Name - the name of the file
+func (f *FileReader) Name() string {
+	return f.name
+}
+
+// Size - the size of the file in bytes
+func (f *FileReader) Size() int64 {
+	return f.size
+}
+
+func (f *FileReader) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+	idx := uint32(f.offset/int64(f.gossiper.Settings.FixedChunkSize)) + 1
+	data, err := f.chunk(f.ctx, idx)
+	if err != nil {
+		return 0, err
+	}
+	n := copy(p, data[f.offset%int64(f.gossiper.Settings.FixedChunkSize):])
+	f.offset += int64(n)
+	return n, nil
+}
+
+// Seek - implements io.Seeker so that the file can be served with range requests
+func (f *FileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+// =====================================================================
+//                  Download streams, fed by the downloaders
+// =====================================================================
+
+// registers the stream of a download which is starting; an ongoing stream for the same file is kept
+func startDownloadStream(gossiper *core.Gossiper, fname string, metahash []byte) {
+	gossiper.DownloadStreams.StreamsLock.Lock()
+	defer gossiper.DownloadStreams.StreamsLock.Unlock()
+	key := hashToString(convertSliceTo32Fixed(metahash))
+	if stream, ok := gossiper.DownloadStreams.Streams[key]; !ok || stream.Finished {
+		gossiper.DownloadStreams.Streams[key] = &core.DownloadStream{FileName: fname,
+			Chunks: make(map[uint32][]byte), Updated: make(chan struct{}), Started: time.Now(),
+			Peers: make(map[string]*core.PeerTransferStats)}
+	}
+}
+
+// updates the stream of a download and wakes up its readers
+func updateDownloadStream(gossiper *core.Gossiper, metahash []byte, update func(stream *core.DownloadStream)) {
+	gossiper.DownloadStreams.StreamsLock.Lock()
+	defer gossiper.DownloadStreams.StreamsLock.Unlock()
+	stream, ok := gossiper.DownloadStreams.Streams[hashToString(convertSliceTo32Fixed(metahash))]
+	if !ok {
+		return
+	}
+	update(stream)
+	close(stream.Updated)
+	stream.Updated = make(chan struct{})
+}
+
+func streamMetafile(gossiper *core.Gossiper, metahash []byte, metafile map[uint32][constants.HashSize]byte) {
+	updateDownloadStream(gossiper, metahash, func(stream *core.DownloadStream) {
+		stream.Metafile = metafile
+	})
+}
+
+func streamChunk(gossiper *core.Gossiper, metahash []byte, idx uint32, data []byte, from string) {
+	updateDownloadStream(gossiper, metahash, func(stream *core.DownloadStream) {
+		if _, ok := stream.Chunks[idx]; ok {
+			return
+		}
+		stream.Chunks[idx] = data
+		if stream.Priority == idx {
+			stream.Priority = 0
+		}
+		stream.Bytes += int64(len(data))
+		peerStats := peerTransferStats(stream, from)
+		peerStats.Chunks++
+		peerStats.Bytes += int64(len(data))
+	})
+}
+
+// adds to the stream of a download the chunks an earlier run of the node stored
+func streamStoredChunks(gossiper *core.Gossiper, metahash []byte, chunks map[uint32][]byte) {
+	updateDownloadStream(gossiper, metahash, func(stream *core.DownloadStream) {
+		for idx, data := range chunks {
+			if _, ok := stream.Chunks[idx]; !ok {
+				stream.Chunks[idx] = data
+				stream.Bytes += int64(len(data))
+			}
+		}
+	})
+}
+
+// counts a request of the download which had to be resent to the given peer
+func recordRetry(gossiper *core.Gossiper, metahash []byte, peer string) {
+	gossiper.DownloadStreams.StreamsLock.Lock()
+	defer gossiper.DownloadStreams.StreamsLock.Unlock()
+	if stream, ok := gossiper.DownloadStreams.Streams[hashToString(convertSliceTo32Fixed(metahash))]; ok {
+		peerTransferStats(stream, peer).Retries++
+	}
+}
+
+func peerTransferStats(stream *core.DownloadStream, peer string) *core.PeerTransferStats {
+	peerStats, ok := stream.Peers[peer]
+	if !ok {
+		peerStats = &core.PeerTransferStats{}
+		stream.Peers[peer] = peerStats
+	}
+	return peerStats
+}
+
+// marks the download as finished; the stream is kept for its statistics until it gets cleaned up,
+// while new readers find the file among the complete ones
+func finishDownloadStream(gossiper *core.Gossiper, metahash []byte) {
+	updateDownloadStream(gossiper, metahash, func(stream *core.DownloadStream) {
+		stream.Finished = true
+		stream.FinishedAt = time.Now()
+	})
+}
+
+// marks the download as finished without every chunk; its readers stop waiting
+func failDownloadStream(gossiper *core.Gossiper, metahash []byte) {
+	updateDownloadStream(gossiper, metahash, func(stream *core.DownloadStream) {
+		stream.Finished = true
+		stream.Failed = true
+		stream.FinishedAt = time.Now()
+	})
+}
+
+// returns the index of the chunk to request next, 0 if every chunk was received: the chunk a reader
+// is waiting for if any, otherwise the first missing one
+func nextChunkToRequest(gossiper *core.Gossiper, metahash []byte, have func(idx uint32) bool, chunkCount uint32) uint32 {
+	gossiper.DownloadStreams.StreamsLock.Lock()
+	priority := uint32(0)
+	if stream, ok := gossiper.DownloadStreams.Streams[hashToString(convertSliceTo32Fixed(metahash))]; ok {
+		priority = stream.Priority
+	}
+	gossiper.DownloadStreams.StreamsLock.Unlock()
+
+	if priority > 0 && priority <= chunkCount && !have(priority) {
+		return priority
+	}
+	for idx := uint32(1); idx <= chunkCount; idx++ {
+		if !have(idx) {
+			return idx
+		}
+	}
+	return 0
+}
+
+// returns whether the chunk with the given index of a file being downloaded was received
+func fileHasChunk(fileInfo *core.FileInformation) func(idx uint32) bool {
+	return func(idx uint32) bool {
+		_, ok := fileInfo.ChunksMap[hashToString(fileInfo.Metafile[idx])]
+		return ok
+	}
+}
+
+// saves a fully downloaded file and makes it available like a shared file, to the other peers
+// as well as to readers
+func completeDownload(gossiper *core.Gossiper, fileInfo *core.FileInformation) {
+	fileInfo.ChunksCount = uint64(len(fileInfo.Metafile))
+	fileInfo.Size = 0
+	for i := uint32(1); i <= uint32(fileInfo.ChunksCount); i++ {
+		fileInfo.Size += int64(len(fileInfo.ChunksMap[hashToString(fileInfo.Metafile[i])]))
+	}
+	reconstructAndSaveFullyDownloadedFile(gossiper, fileInfo)
+	storeIndexedFile(gossiper, fileInfo)
+	finishDownloadStream(gossiper, fileInfo.MetaHash[:])
+}
+
+func waitForMetafile(ctx context.Context, gossiper *core.Gossiper, stream *core.DownloadStream) error {
+	return waitForStream(ctx, gossiper, stream, func() bool {
+		return stream.Metafile != nil
+	})
+}
+
+func waitForChunk(ctx context.Context, gossiper *core.Gossiper, stream *core.DownloadStream, idx uint32) ([]byte, error) {
+	var data []byte
+	err := waitForStream(ctx, gossiper, stream, func() bool {
+		chunk, ok := stream.Chunks[idx]
+		if !ok {
+			stream.Priority = idx
+		}
+		data = chunk
+		return ok
+	})
+	return data, err
+}
+
+// blocks until ready returns true, checking it under the lock of the streams each time the stream
+// changes
+func waitForStream(ctx context.Context, gossiper *core.Gossiper, stream *core.DownloadStream, ready func() bool) error {
+	timeout := time.NewTimer(time.Duration(gossiper.Settings.StreamChunkTimeout) * time.Second)
+	defer timeout.Stop()
+	for {
+		gossiper.DownloadStreams.StreamsLock.Lock()
+		if ready() {
+			gossiper.DownloadStreams.StreamsLock.Unlock()
+			return nil
+		}
+		finished := stream.Finished
+		updated := stream.Updated
+		gossiper.DownloadStreams.StreamsLock.Unlock()
+		if finished {
+			return ErrUnknownFile
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timeout.C:
+			return ErrChunkTimeout
+		case <-updated:
+		}
+	}
+}
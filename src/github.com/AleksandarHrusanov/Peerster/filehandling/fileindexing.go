@@ -1,74 +1,116 @@
 package filehandling
 
 import (
-	"fmt"
-	"math"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
 	"github.com/AleksandarHrusanov/Peerster/constants"
 	"github.com/AleksandarHrusanov/Peerster/core"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
 )
 
 // HandleFileIndexing - a function to index, divide, hash, and save hashed chunks of a file
-func HandleFileIndexing(gossiper *core.Gossiper, fname string) *core.FileInformation {
+func HandleFileIndexing(gossiper *core.Gossiper, fname string) (*core.FileInformation, error) {
 
-	filePath, _ := filepath.Abs(constants.SharedFilesFolder + fname)
+	filePath, _ := filepath.Abs(gossiper.Settings.SharedFilesFolder + fname)
 	file, err := os.Open(filePath)
-	fileSize := int64(0)
-
-	fileInfo := &core.FileInformation{FileName: fname, Metafile: make(map[uint32][constants.HashSize]byte),
-		ChunksMap: make(map[string][]byte)}
-
 	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		helpers.FilesLog.Error("cannot index file", helpers.F("file", fname), helpers.F("error", err))
+		return nil, err
 	}
 	defer file.Close()
 
-	fInfo, _ := file.Stat()
-	var fSize int64 = fInfo.Size()
-	totalChunksCount := uint64(math.Ceil(float64(fSize) / float64(constants.FixedChunkSize)))
-	fileInfo.ChunksCount = totalChunksCount
+	fileInfo, err := indexChunks(fname, file, ioutil.Discard, gossiper.Settings.FixedChunkSize)
+	if err != nil {
+		helpers.FilesLog.Error("cannot index file", helpers.F("file", fname), helpers.F("error", err))
+		return nil, err
+	}
+	storeIndexedFile(gossiper, fileInfo)
+	return fileInfo, nil
+}
 
-	metaFile := make(map[uint32][constants.HashSize]byte)
+// HandleFileUpload - a function to stream an uploaded file into the shared files folder while
+// indexing it; chunks are hashed as they arrive so the file is read only once. The file replaces
+// any shared file with the same name only once it was fully received
+func HandleFileUpload(gossiper *core.Gossiper, fname string, upload io.Reader) (*core.FileInformation, error) {
+	if err := os.MkdirAll(gossiper.Settings.SharedFilesFolder, constants.FileMode); err != nil {
+		return nil, err
+	}
+	tmpFile, err := ioutil.TempFile(gossiper.Settings.SharedFilesFolder, ".upload-")
+	if err != nil {
+		return nil, err
+	}
+	// after the rename the temporary file no longer exists and removing it is a no-op
+	defer os.Remove(tmpFile.Name())
 
-	if _, err := os.Stat(constants.ShareFilesChunksFolder); os.IsNotExist(err) {
-		os.Mkdir(constants.ShareFilesChunksFolder, constants.FileMode)
+	fileInfo, err := indexChunks(fname, upload, tmpFile, gossiper.Settings.FixedChunkSize)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
 	}
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpFile.Name(), filepath.Join(gossiper.Settings.SharedFilesFolder, fname)); err != nil {
+		return nil, err
+	}
+	storeIndexedFile(gossiper, fileInfo)
+	return fileInfo, nil
+}
 
-	for i := uint64(0); i < totalChunksCount; i++ {
-		currChunkSize := int(math.Min(constants.FixedChunkSize,
-			float64(fSize-int64(i*constants.FixedChunkSize))))
-		buffer := make([]byte, currChunkSize)
-		bytesRead, _ := file.Read(buffer)
-		fileSize += int64(bytesRead)
-		buffer = buffer[:bytesRead]
-		hash := computeSha256(buffer)
-		newName := hashToString(hash)
+// divides the content of the reader in chunks of the given size, hashing each of them and copying
+// the content to the sink as it goes
+func indexChunks(fname string, content io.Reader, sink io.Writer, chunkSize int) (*core.FileInformation, error) {
+	fileInfo := &core.FileInformation{FileName: fname, Metafile: make(map[uint32][constants.HashSize]byte),
+		ChunksMap: make(map[string][]byte)}
 
-		metaFile[uint32(i+1)] = hash
-		fileInfo.ChunksMap[newName] = buffer
-		gossiper.FilesAndMetahashes.FilesLock.Lock()
-		gossiper.FilesAndMetahashes.AllChunks[newName] = buffer
-		gossiper.FilesAndMetahashes.FilesLock.Unlock()
+	for i := uint32(1); ; i++ {
+		buffer := make([]byte, chunkSize)
+		bytesRead, err := io.ReadFull(content, buffer)
+		if bytesRead > 0 {
+			buffer = buffer[:bytesRead]
+			if _, err := sink.Write(buffer); err != nil {
+				return nil, err
+			}
+			hash := computeSha256(buffer)
+			fileInfo.Metafile[i] = hash
+			fileInfo.ChunksMap[hashToString(hash)] = buffer
+			fileInfo.Size += int64(bytesRead)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
 	}
+	fileInfo.ChunksCount = uint64(len(fileInfo.Metafile))
+	fileInfo.MetaHash = computeSha256(appendMetafile(fileInfo))
+	return fileInfo, nil
+}
 
-	fileInfo.Metafile = metaFile
-	appendedMetaFile := make([]byte, 0, constants.HashSize*len(metaFile))
-	for i := uint64(0); i < totalChunksCount; i++ {
-		hs := metaFile[uint32(i+1)]
+// the metafile of an indexed file: the hashes of its chunks in order
+func appendMetafile(fileInfo *core.FileInformation) []byte {
+	appendedMetaFile := make([]byte, 0, constants.HashSize*len(fileInfo.Metafile))
+	for i := uint32(1); i <= uint32(fileInfo.ChunksCount); i++ {
+		hs := fileInfo.Metafile[i]
 		appendedMetaFile = append(appendedMetaFile, hs[:]...)
 	}
+	return appendedMetaFile
+}
+
+// makes the chunks and metafile of an indexed file available to the other peers
+func storeIndexedFile(gossiper *core.Gossiper, fileInfo *core.FileInformation) {
+	metahashString := hashToString(fileInfo.MetaHash)
+	appendedMetaFile := appendMetafile(fileInfo)
 
-	metahash := computeSha256(appendedMetaFile)
-	metahashString := hashToString(metahash)
-	fileInfo.MetaHash = metahash
-	fileInfo.Size = fileSize
 	gossiper.FilesAndMetahashes.FilesLock.Lock()
-	gossiper.FilesAndMetahashes.FileNamesToMetahashesMap[fname] = metahashString
+	for hash, chunk := range fileInfo.ChunksMap {
+		gossiper.FilesAndMetahashes.AllChunks[hash] = chunk
+	}
+	gossiper.FilesAndMetahashes.FileNamesToMetahashesMap[fileInfo.FileName] = metahashString
 	gossiper.FilesAndMetahashes.MetaStringToFileInfo[metahashString] = fileInfo
 	gossiper.FilesAndMetahashes.MetaHashes[metahashString] = appendedMetaFile
 	gossiper.FilesAndMetahashes.FilesLock.Unlock()
-	return fileInfo
 }
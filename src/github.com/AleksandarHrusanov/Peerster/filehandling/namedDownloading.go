@@ -0,0 +1,59 @@
+package filehandling
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/core"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+)
+
+// HandleClientNamedDownloadRequest - a function to download a file whose metahash was resolved
+// through the confirmed chain. If a route to the node which published the name is known, the
+// file is downloaded directly from it; otherwise the file is searched for by name first
+func HandleClientNamedDownloadRequest(gossiper *core.Gossiper, fname string, metahash []byte, holder string) {
+	if strings.Compare(holder, gossiper.Name) == 0 {
+		// we published this name ourselves, so we already have the file
+		helpers.FilesLog.Info("file is already shared by this node", helpers.F("file", fname))
+		return
+	}
+
+	gossiper.DestinationTable.DsdvLock.Lock()
+	_, routeKnown := gossiper.DestinationTable.Dsdv[holder]
+	gossiper.DestinationTable.DsdvLock.Unlock()
+
+	if routeKnown {
+		request := metahash
+		downloadMsg := &core.Message{File: &fname, Destination: &holder, Request: &request}
+		HandleClientDownloadRequest(gossiper, downloadMsg)
+		return
+	}
+
+	go searchAndDownloadByMetahash(gossiper, fname, metahash)
+}
+
+// issues a search for the given file name and starts downloading as soon as every chunk of
+// the file with the given metahash has been located
+func searchAndDownloadByMetahash(gossiper *core.Gossiper, fname string, metahash []byte) {
+	budget := uint64(0)
+	go initiateFileSearching(gossiper, &budget, &fname)
+
+	deadline := time.Now().Add(time.Duration(gossiper.Settings.NamedDownloadSearchTimeout) * time.Second)
+	for time.Now().Before(deadline) {
+		if !gossiper.Sleep(500 * time.Millisecond) {
+			return
+		}
+
+		gossiper.OngoingFileSearch.SearchRequestLock.Lock()
+		match, found := gossiper.OngoingFileSearch.MatchesFound[fname]
+		wholeFileFound := core.IsWholeFileFound(gossiper.OngoingFileSearch, fname)
+		gossiper.OngoingFileSearch.SearchRequestLock.Unlock()
+
+		if found && wholeFileFound && bytes.Compare(match.Metahash, metahash) == 0 {
+			initiateFileDownloading(gossiper, "", "", nil, match)
+			return
+		}
+	}
+	helpers.SearchLog.Warn("could not locate all chunks of the file", helpers.F("file", fname))
+}
@@ -0,0 +1,174 @@
+package gossiper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/core"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+)
+
+// ErrInvalidDiscoveryGroup - the discovery group is not a multicast address and port
+var ErrInvalidDiscoveryGroup = errors.New("discovery group must be a multicast ip:port")
+
+// StartDiscovery - bootstrap the known peers beyond the -peers addresses: add the peers given by host
+// name, resolved again every ResolvePeriod seconds, add the seeds, in random order so that nodes with a
+// small partial view do not all pick the same ones, announce the node on the discovery group of the
+// local network and listen for other nodes there, and ask peers for more peers while the partial view
+// has room. The group and peer exchange are optional
+func StartDiscovery(gossiper *core.Gossiper, seeds []string, peerNames []string, group string, peerExchange bool) error {
+	gossiper.AddPeerNames(peerNames)
+	gossiper.Go(func() { peerResolver(gossiper) })
+	seeds = resolveSeeds(gossiper, seeds)
+	rand.Shuffle(len(seeds), func(i, j int) { seeds[i], seeds[j] = seeds[j], seeds[i] })
+	gossiper.AddDiscoveredPeers(seeds, "seeds")
+
+	if strings.Compare(group, "") != 0 {
+		groupAddr, err := net.ResolveUDPAddr("udp", group)
+		if err != nil || !groupAddr.IP.IsMulticast() {
+			return ErrInvalidDiscoveryGroup
+		}
+		listenConn, err := net.ListenMulticastUDP("udp", nil, groupAddr)
+		if err != nil {
+			return err
+		}
+		sendConn, err := net.DialUDP("udp", nil, groupAddr)
+		if err != nil {
+			listenConn.Close()
+			return err
+		}
+		gossiper.Go(func() { discoveryListener(gossiper, listenConn) })
+		gossiper.Go(func() { discoveryAnnouncer(gossiper, sendConn) })
+	}
+	if peerExchange {
+		gossiper.Go(func() { peerExchanger(gossiper) })
+	}
+	return nil
+}
+
+// ReadSeedsFile Read the addresses of the seed nodes from a file, one ip:port or host:port per line.
+// Empty lines and lines starting with # are skipped
+func ReadSeedsFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	seeds := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		seed := strings.TrimSpace(scanner.Text())
+		if strings.Compare(seed, "") == 0 || strings.HasPrefix(seed, "#") {
+			continue
+		}
+		if !helpers.IPAddressIsValid(seed) && !helpers.IsPeerName(seed) {
+			return nil, fmt.Errorf("%s:%d: seed must be of the form ip:port or host:port", path, line)
+		}
+		seeds = append(seeds, seed)
+	}
+	return seeds, scanner.Err()
+}
+
+// the addresses of the seeds, the seeds given by host name being resolved once
+func resolveSeeds(gossiper *core.Gossiper, seeds []string) []string {
+	addresses := make([]string, 0, len(seeds))
+	for _, seed := range seeds {
+		if !helpers.IsPeerName(seed) {
+			addresses = append(addresses, seed)
+			continue
+		}
+		address, err := gossiper.ResolveName(seed)
+		if err != nil {
+			helpers.GossipLog.Warn("cannot resolve seed", helpers.F("peer", seed), helpers.F("error", err))
+			continue
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
+// Resolve the peers given by host name again every ResolvePeriod seconds, following their address changes
+func peerResolver(gossiper *core.Gossiper) {
+	for gossiper.Sleep(time.Duration(gossiper.Settings.ResolvePeriod) * time.Second) {
+		gossiper.ResolvePeerNames()
+	}
+}
+
+// Announce the node on the discovery group every DiscoveryPeriod seconds
+func discoveryAnnouncer(gossiper *core.Gossiper, conn *net.UDPConn) {
+	defer conn.Close()
+	announcement := gossiper.EncodeAnnouncement()
+	for {
+		if _, err := conn.Write(announcement); err != nil {
+			helpers.GossipLog.Debug("cannot announce on the discovery group", helpers.F("error", err))
+		}
+		if !gossiper.Sleep(time.Duration(gossiper.Settings.DiscoveryPeriod) * time.Second) {
+			return
+		}
+	}
+}
+
+// Add the nodes announcing themselves on the discovery group to the known peers
+func discoveryListener(gossiper *core.Gossiper, conn *net.UDPConn) {
+	defer conn.Close()
+	go func() {
+		// unblock the read when the node stops
+		<-gossiper.Done()
+		conn.Close()
+	}()
+	buffer := make([]byte, 1024)
+	for {
+		size, from, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if !gossiper.IsStopping() {
+				helpers.GossipLog.Warn("discovery group closed", helpers.F("error", err))
+			}
+			return
+		}
+		address, err := core.DecodeAnnouncement(buffer[:size], from)
+		if err != nil {
+			continue
+		}
+		gossiper.AddDiscoveredPeers([]string{address}, "discovery")
+	}
+}
+
+// Ask a random peer for some of its peers every PeerExchangePeriod seconds while the partial view has room
+func peerExchanger(gossiper *core.Gossiper) {
+	for gossiper.Sleep(time.Duration(gossiper.Settings.PeerExchangePeriod) * time.Second) {
+		if !gossiper.WantsMorePeers() {
+			continue
+		}
+		if livePeers := gossiper.GetLivePeers(); len(livePeers) > 0 {
+			peer := helpers.PickRandomInSlice(livePeers)
+			gossiper.RequestPeerExchange(peer)
+			gossiper.SendPacket(peer, &core.GossipPacket{PeerExchange: &core.PeerExchange{}})
+		}
+	}
+}
+
+// Share some known peers with the peer asking for them, or add the peers shared by a peer this node asked
+func handlePeerExchange(gossiper *core.Gossiper, exchange *core.PeerExchange, fromAddr string) {
+	if !exchange.Reply {
+		reply := &core.PeerExchange{Peers: gossiper.PickExchangePeers(fromAddr), Reply: true}
+		gossiper.SendPacket(fromAddr, &core.GossipPacket{PeerExchange: reply})
+		return
+	}
+	if !gossiper.AcceptPeerExchange(fromAddr) {
+		helpers.GossipLog.Debug("dropped unsolicited peer exchange", helpers.F("peer", fromAddr))
+		return
+	}
+	peers := exchange.Peers
+	if len(peers) > constants.PeerExchangeSize {
+		peers = peers[:constants.PeerExchangeSize]
+	}
+	gossiper.AddDiscoveredPeers(peers, "peer exchange")
+}
@@ -1,61 +1,218 @@
 package gossiper
 
 import (
+	"context"
+	"encoding/hex"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/AleksandarHrusanov/Peerster/blockchain"
 	"github.com/AleksandarHrusanov/Peerster/constants"
 	"github.com/AleksandarHrusanov/Peerster/core"
+	"github.com/AleksandarHrusanov/Peerster/filehandling"
 	"github.com/AleksandarHrusanov/Peerster/helpers"
 )
 
-// StartGossiper Start the gossiper
-func StartGossiper(gossiperPtr *core.Gossiper, simplePtr *bool, antiEntropyPtr *int, routeRumorPtr *int, hw3ex2 *bool, nPtr *int, stubbornTimeout *int, ackHopLimit *int) {
+// NodeOptions - how a node runs: its mode, the periods of its routines in seconds, 0 turning one
+// off, and the settings of the hw3ex2 naming chain
+type NodeOptions struct {
+	Simple      bool
+	AntiEntropy int
+	Probe       int
+	RouteRumor  int
+	Hw3ex2      bool
+	N           int
+	AckHopLimit uint32
+}
+
+// Node - a gossiper and the routines running it, from Start until Stop
+type Node struct {
+	Gossiper *core.Gossiper
+	Options  NodeOptions
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+// NewNode - create the node running the given gossiper
+func NewNode(gossiperPtr *core.Gossiper, options NodeOptions) *Node {
+	return &Node{Gossiper: gossiperPtr, Options: options, stopped: make(chan struct{})}
+}
+
+// Start - start the routines of the node, join the peers known by its previous run again and resume
+// the downloads it did not finish. The node stops when the context is done
+func (n *Node) Start(ctx context.Context) error {
+	gossiperPtr := n.Gossiper
+	options := n.Options
 	rand.Seed(time.Now().UnixNano())
-	// Clean files= folders on startup
-	cleanFileFoldersOnStartup(constants.ShareFilesChunksFolder)
-	cleanFileFoldersOnStartup(constants.DownloadedFilesFolder)
-	cleanFileFoldersOnStartup(constants.DownloadedFilesChunksFolder)
+	state, err := gossiperPtr.LoadState()
+	if err != nil {
+		return err
+	}
+	// Clean files= folders on startup; the chunks of the downloads to resume are kept
+	settings := gossiperPtr.Settings
+	cleanFileFoldersOnStartup(settings.ShareFilesChunksFolder, "")
+	if len(state.Downloads) == 0 {
+		cleanFileFoldersOnStartup(settings.DownloadedFilesFolder, "")
+		cleanFileFoldersOnStartup(settings.DownloadedFilesChunksFolder, "")
+	} else {
+		cleanFileFoldersOnStartup(settings.DownloadedFilesFolder, settings.DownloadedFilesChunksFolder)
+		os.MkdirAll(settings.DownloadedFilesChunksFolder, constants.FileMode)
+	}
+	// Join the peers known by the previous run again
+	if len(state.KnownPeers) > 0 {
+		gossiperPtr.AddDiscoveredPeers(state.KnownPeers, "state")
+	}
+
+	if options.Probe > 0 {
+		gossiperPtr.Membership.ProbePeriod = time.Duration(options.Probe) * time.Second
+	}
 
 	// Listen from client and peers
-	if !*simplePtr {
-		go clientListener(gossiperPtr, *simplePtr, *stubbornTimeout, *hw3ex2)
-		go peersListener(gossiperPtr, *simplePtr, *nPtr, *hw3ex2, uint32(*ackHopLimit))
-	} else {
-		// In simple mode there is no anti-entropy so no infinite loop
-		// to prevent the program to end
-		go clientListener(gossiperPtr, *simplePtr, *stubbornTimeout, *hw3ex2)
-		peersListener(gossiperPtr, *simplePtr, *nPtr, *hw3ex2, uint32(*ackHopLimit))
+	if gossiperPtr.Streams != nil && !options.Simple {
+		n.Run(func() { streamListener(gossiperPtr) })
+	}
+	n.Run(func() { clientListener(gossiperPtr) })
+	n.Run(func() { peersListener(gossiperPtr, options.Simple, options.N, options.Hw3ex2, options.AckHopLimit) })
+	// In simple mode the node only forwards the messages it receives
+	if options.Simple {
+		n.watch(ctx)
+		return nil
 	}
-	defer gossiperPtr.Conn.Close()
-	defer gossiperPtr.LocalConn.Close()
 
 	// Send the initial route rumor message on startup
-	go routeRumorHandler(gossiperPtr, routeRumorPtr)
-	// go removeCompletedStates(gossiperPtr)
+	n.Run(func() { routeRumorHandler(gossiperPtr, &options.RouteRumor) })
+	if gossiperPtr.Mining != nil && !gossiperPtr.LightClient {
+		n.Run(func() { blockchain.StartMining(gossiperPtr) })
+	}
+	n.Run(func() { removeCompletedStates(gossiperPtr) })
+	// Failure detection
+	if options.Probe > 0 {
+		n.Run(func() { failureDetector(gossiperPtr, time.Duration(options.Probe)*time.Second) })
+	}
+	// Peer sampling
+	if gossiperPtr.View != nil {
+		n.Run(func() { peerSampler(gossiperPtr) })
+	}
 	// Anti-entropy
-	if *antiEntropyPtr > 0 {
-		for {
-			time.Sleep(time.Duration(*antiEntropyPtr) * time.Second)
+	if options.AntiEntropy > 0 {
+		n.Run(func() { antiEntropy(gossiperPtr, time.Duration(options.AntiEntropy)*time.Second) })
+	}
 
-			if len(gossiperPtr.KnownPeers) > 0 {
-				randomAddress := helpers.PickRandomInSlice(gossiperPtr.KnownPeers)
-				sendStatus(gossiperPtr, randomAddress)
-			}
+	if len(state.Downloads) > 0 {
+		n.Run(func() { resumeDownloads(gossiperPtr, state.Downloads) })
+	}
+
+	n.watch(ctx)
+	return nil
+}
+
+// stops the node when the context is done
+func (n *Node) watch(ctx context.Context) {
+	go func() {
+		select {
+		case <-ctx.Done():
+			n.Stop()
+		case <-n.stopped:
+		}
+	}()
+}
+
+// Stop - stop the node gracefully: let the downloads in progress finish for up to ShutdownTimeout
+// seconds, stop the routines, announce the departure to the known peers so that they evict the node
+// at once, and save the known peers and the unfinished downloads for the next run. Returns once the
+// node stopped; calling it again has no effect
+func (n *Node) Stop() {
+	n.stopOnce.Do(n.shutdown)
+	<-n.stopped
+}
+
+// Wait - block until the node stopped
+func (n *Node) Wait() {
+	<-n.stopped
+}
+
+func (n *Node) shutdown() {
+	gossiperPtr := n.Gossiper
+	grace := time.Duration(gossiperPtr.Settings.ShutdownTimeout) * time.Second
+	helpers.GossipLog.Info("node stopping")
+
+	deadline := time.Now().Add(grace)
+	for len(gossiperPtr.PendingDownloads()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+	pending := gossiperPtr.PendingDownloads()
+
+	gossiperPtr.BeginStop()
+	if !n.Options.Simple {
+		for _, peer := range gossiperPtr.GetAllKnownPeers() {
+			sendProbe(gossiperPtr, peer, &core.MembershipProbe{Leave: true})
 		}
+	}
+	// closing the sockets unblocks the listeners
+	gossiperPtr.Conn.Close()
+	gossiperPtr.LocalConn.Close()
+
+	if !gossiperPtr.WaitRoutines(grace) {
+		helpers.GossipLog.Warn("routines did not stop in time")
+	}
+
+	if err := gossiperPtr.SaveState(pending); err != nil {
+		helpers.GossipLog.Error("cannot save the state of the node", helpers.F("error", err))
 	} else {
-		for {
-			time.Sleep(time.Duration(*antiEntropyPtr) * 999)
+		helpers.GossipLog.Info("node stopped", helpers.F("state", gossiperPtr.StateFile()),
+			helpers.F("pending_downloads", len(pending)))
+	}
+	close(n.stopped)
+}
+
+// Run - run a routine of the node, e.g. its HTTP server, which Stop waits for. The routine must
+// return once the gossiper is done
+func (n *Node) Run(routine func()) {
+	n.Gossiper.Go(routine)
+}
+
+// Send a status to a random alive peer every period
+func antiEntropy(gossiper *core.Gossiper, period time.Duration) {
+	for gossiper.Sleep(period) {
+		if livePeers := gossiper.GetLivePeers(); len(livePeers) > 0 {
+			randomAddress := helpers.PickRandomInSlice(livePeers)
+			sendStatus(gossiper, randomAddress)
 		}
 	}
 }
 
-func cleanFileFoldersOnStartup(folder string) error {
+// Start again the downloads the previous run did not finish, from the node they were downloaded from
+// once a route to it is known. Downloads of files located by a search, or from a node no route is
+// found to, search for the file by name again
+func resumeDownloads(gossiper *core.Gossiper, downloads []core.PendingDownload) {
+	for _, download := range downloads {
+		metahash, err := hex.DecodeString(download.Metahash)
+		if err != nil || len(metahash) != constants.HashSize || !fileNameIsValid(download.FileName) {
+			continue
+		}
+		deadline := time.Now().Add(time.Duration(gossiper.Settings.NamedDownloadSearchTimeout) * time.Second)
+		for strings.Compare(download.From, "") != 0 && !routeIsKnown(gossiper, download.From) &&
+			time.Now().Before(deadline) {
+			if !gossiper.Sleep(500 * time.Millisecond) {
+				return
+			}
+		}
+		helpers.FilesLog.Info("resuming download", helpers.F("file", download.FileName),
+			helpers.F("from", download.From))
+		filehandling.HandleClientNamedDownloadRequest(gossiper, download.FileName, metahash, download.From)
+	}
+}
+
+// Remove everything in the folder but the kept one, if it is inside
+func cleanFileFoldersOnStartup(folder string, keep string) error {
+	kept, _ := filepath.Abs(keep)
 
 	if _, err := os.Stat(folder); os.IsNotExist(err) {
-		os.Mkdir(folder, constants.FileMode)
+		os.MkdirAll(folder, constants.FileMode)
 	} else {
 		dir, _ := filepath.Abs(folder)
 		d, err := os.Open(dir)
@@ -69,6 +226,9 @@ func cleanFileFoldersOnStartup(folder string) error {
 			return err
 		}
 		for _, name := range names {
+			if strings.Compare(keep, "") != 0 && strings.Compare(filepath.Join(dir, name), kept) == 0 {
+				continue
+			}
 			err = os.RemoveAll(filepath.Join(dir, name))
 			if err != nil {
 				return err
@@ -78,25 +238,34 @@ func cleanFileFoldersOnStartup(folder string) error {
 	return nil
 }
 
-// TODO: remove downloading states from memory when finished
-// func removeCompletedStates(gossiper *core.Gossiper) {
-// 	for {
-// 		time.Sleep(5 * time.Second)
-// 		gossiper.DownloadingLock.Lock()
-// 		allStates := gossiper.DownloadingStates
-// 		for downloadFrom, states := range allStates {
-// 			for i, st := range states {
-// 				if st.DownloadFinished {
-// 					close(states[i].DownloadChanel)
-// 					if len(states) == 1 {
-// 						delete(gossiper.DownloadingStates, downloadFrom)
-// 					} else {
-// 						states[i] = states[len(states)-1]
-// 						states = states[:len(states)-1]
-// 					}
-// 				}
-// 			}
-// 		}
-// 		gossiper.DownloadingLock.Unlock()
-// 	}
-// }
+// Periodically remove the states of finished regular downloads, and the streams of downloads
+// which finished more than FinishedDownloadRetention seconds ago. No reply is sent to a removed
+// state since replies are dispatched under the same lock
+func removeCompletedStates(gossiper *core.Gossiper) {
+	for gossiper.Sleep(time.Duration(gossiper.Settings.DownloadCleanupPeriod) * time.Second) {
+		gossiper.DownloadingLock.Lock()
+		for downloadFrom, states := range gossiper.DownloadingStates {
+			ongoing := make([]*core.DownloadingState, 0, len(states))
+			for _, st := range states {
+				if !st.DownloadFinished {
+					ongoing = append(ongoing, st)
+				}
+			}
+			if len(ongoing) == 0 {
+				delete(gossiper.DownloadingStates, downloadFrom)
+			} else {
+				gossiper.DownloadingStates[downloadFrom] = ongoing
+			}
+		}
+		gossiper.DownloadingLock.Unlock()
+
+		expired := time.Now().Add(-time.Duration(gossiper.Settings.FinishedDownloadRetention) * time.Second)
+		gossiper.DownloadStreams.StreamsLock.Lock()
+		for metahash, stream := range gossiper.DownloadStreams.Streams {
+			if stream.Finished && stream.FinishedAt.Before(expired) {
+				delete(gossiper.DownloadStreams.Streams, metahash)
+			}
+		}
+		gossiper.DownloadStreams.StreamsLock.Unlock()
+	}
+}
@@ -8,7 +8,7 @@ import (
 
 // Retrieve a Rumor from a list given its Origin and ID
 func getRumor(g *core.Gossiper, o string, i uint32) *core.RumorMessage {
-	for _, rm := range g.KnownRumors {
+	for _, rm := range g.GetAllRumors() {
 		if strings.Compare(o, rm.Origin) == 0 && i == rm.ID {
 			return &rm
 		}
@@ -31,12 +31,18 @@ func updateRumorListNoDuplicates(r core.RumorMessage, list []core.RumorMessage)
 
 // Add a rumor to the gossiper's known rumors if it is not already there
 func addRumorToKnownRumors(g *core.Gossiper, r core.RumorMessage) {
+	g.RumorsLock.Lock()
 	for _, rumor := range g.KnownRumors {
 		if strings.Compare(rumor.Origin, r.Origin) == 0 && rumor.ID == r.ID {
+			g.RumorsLock.Unlock()
 			return
 		}
 	}
 	g.KnownRumors = append(g.KnownRumors, r)
+	g.RumorsLock.Unlock()
+	if !core.IsRouteRumor(&r) {
+		g.PublishEvent(core.EventRumor, r)
+	}
 }
 
 // Update Want slice for given origin
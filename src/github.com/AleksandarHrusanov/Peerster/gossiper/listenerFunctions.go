@@ -1,6 +1,7 @@
 package gossiper
 
 import (
+	"fmt"
 	"net"
 	"strings"
 
@@ -8,14 +9,13 @@ import (
 	"github.com/AleksandarHrusanov/Peerster/core"
 	"github.com/AleksandarHrusanov/Peerster/filehandling"
 	"github.com/AleksandarHrusanov/Peerster/helpers"
-	"github.com/dedis/protobuf"
 )
 
 // TODO: Break this function into shorter separate functions
 // Main peersListener function
 func peersListener(gossiper *core.Gossiper, simpleMode bool, peerCount int, hw3ex2 bool, ackHopLimit uint32) {
 	// Remove all timed-out or handled mongering statuses
-	go mongeringStatusRefresher(gossiper)
+	gossiper.Go(func() { mongeringStatusRefresher(gossiper) })
 
 	// Infinite loop
 	for {
@@ -23,40 +23,48 @@ func peersListener(gossiper *core.Gossiper, simpleMode bool, peerCount int, hw3e
 		var fromAddrPtr *net.UDPAddr
 		fromAddr := ""
 
-		// Listen
-		gossipPacket, fromAddrPtr = receiveAndDecode(gossiper)
+		// Listen until the socket is closed by Stop
+		gossipPacket, fromAddrPtr, authenticated := receiveAndDecode(gossiper)
+		if gossiper.IsStopping() {
+			return
+		}
 		if fromAddrPtr != nil {
 			fromAddr = fromAddrPtr.String()
 		}
 
-		// Store address from the sender
+		if strings.Compare(fromAddr, "") == 0 {
+			continue
+		}
+		// Drop the packets of banned peers and of peers sending too many packets
+		if !gossiper.AdmitPacket(fromAddr, &gossipPacket, authenticated) {
+			continue
+		}
+
+		// Store address from the sender and mark it alive, unless its packet could not be decoded
+		if strings.Compare(core.PacketType(&gossipPacket), "unknown") != 0 {
+			gossiper.HeardFrom(fromAddr)
+		}
 		gossiper.PeersLock.Lock()
 		knownPeers := gossiper.KnownPeers
-		if !helpers.SliceContainsString(knownPeers, fromAddr) &&
-			strings.Compare(fromAddr, "") != 0 {
-			gossiper.KnownPeers = append(knownPeers, fromAddr)
-		}
 		gossiper.PeersLock.Unlock()
 
 		if gossipPacket.Simple != nil {
 			// Print simple output
 			simpleMessage := *gossipPacket.Simple
-			// helpers.PrintOutputSimpleMessageFromPeer(simpleMessage.Contents,
-			// 	simpleMessage.OriginalName,
-			// 	simpleMessage.RelayPeerAddr,
-			// 	gossiper.KnownPeers)
+			helpers.PrintOutputSimpleMessageFromPeer(simpleMessage.Contents,
+				simpleMessage.OriginalName,
+				simpleMessage.RelayPeerAddr,
+				gossiper.GetAllKnownPeers())
 
 			if simpleMode {
 				// Prepare the message to be sent (SIMPLE MODE)
 				simpleMessage.RelayPeerAddr = gossiper.Address.String()
 				packetToSend := core.GossipPacket{Simple: &simpleMessage}
-				packetBytes, err := protobuf.Encode(&packetToSend)
-				helpers.HandleErrorFatal(err)
 
 				// Send message to all other known peers
 				for _, knownAddress := range knownPeers {
 					if strings.Compare(knownAddress, fromAddr) != 0 {
-						core.ConnectAndSend(knownAddress, gossiper.Conn, packetBytes)
+						gossiper.SendPacket(knownAddress, &packetToSend)
 					}
 				}
 			}
@@ -83,105 +91,104 @@ func peersListener(gossiper *core.Gossiper, simpleMode bool, peerCount int, hw3e
 				blockchain.HandleTLCMessage(gossiper, gossipPacket.TLCMessage, peerCount, ackHopLimit, fromAddr)
 			} else if gossipPacket.Ack != nil && hw3ex2 {
 				blockchain.HandleTlcAck(gossiper, gossipPacket.Ack, peerCount)
+			} else if gossipPacket.PoWTransaction != nil && gossiper.Mining != nil {
+				blockchain.HandlePoWTransaction(gossiper, gossipPacket.PoWTransaction, fromAddr)
+			} else if gossipPacket.PoWBlock != nil && gossiper.Mining != nil {
+				blockchain.HandlePoWBlock(gossiper, gossipPacket.PoWBlock, fromAddr)
+			} else if gossipPacket.NameProofRequest != nil && (hw3ex2 || gossiper.Mining != nil) {
+				blockchain.HandleNameProofRequest(gossiper, gossipPacket.NameProofRequest)
+			} else if gossipPacket.NameProofReply != nil && (hw3ex2 || gossiper.Mining != nil) {
+				blockchain.HandleNameProofReply(gossiper, gossipPacket.NameProofReply)
+			} else if gossipPacket.Probe != nil {
+				handleProbe(gossiper, gossipPacket.Probe, fromAddr, authenticated)
+			} else if gossipPacket.Shuffle != nil {
+				handleShuffle(gossiper, gossipPacket.Shuffle, fromAddr)
+			} else if gossipPacket.PeerExchange != nil {
+				handlePeerExchange(gossiper, gossipPacket.PeerExchange, fromAddr)
 			} else if gossipPacket.Rumor != nil {
 				// Print RumorFromPeer output
-				// helpers.PrintOutputRumorFromPeer(gossipPacket.Rumor.Origin, fromAddr, gossipPacket.Rumor.ID, gossipPacket.Rumor.Text, knownPeers)
-				handleRumorMessage(gossiper, gossipPacket.Rumor, fromAddr, knownPeers)
+				helpers.PrintOutputRumorFromPeer(gossipPacket.Rumor.Origin, fromAddr, gossipPacket.Rumor.ID, gossipPacket.Rumor.Text, knownPeers)
+				handleRumorMessage(gossiper, gossipPacket.Rumor, fromAddr, gossiper.GetLivePeers())
 			} else if gossipPacket.Status != nil {
 				// Print STATUS message
-				// core.PrintOutputStatus(fromAddr, gossipPacket.Status.Want, gossiper.KnownPeers)
-				handleStatusPacket(gossiper, gossipPacket.Status, fromAddr, knownPeers)
+				core.PrintOutputStatus(fromAddr, gossipPacket.Status.Want, knownPeers)
+				handleStatusPacket(gossiper, gossipPacket.Status, fromAddr, gossiper.GetLivePeers())
 			}
 		}
 	}
 }
 
-func clientListener(gossiper *core.Gossiper, simpleMode bool, stubbornTimeout int, hw3ex2 bool) {
+func clientListener(gossiper *core.Gossiper) {
 	for {
-		gossiper.PeersLock.Lock()
-		knownPeers := gossiper.KnownPeers
-		gossiper.PeersLock.Unlock()
-		// Receive and decode messages
-		message, _ := receiveAndDecodeFromClient(gossiper)
-
-		if simpleMode {
-			// Print simple output
-			// helpers.PrintOutputSimpleMessageFromClient(message.Text, gossiper.KnownPeers)
-
-			// Prepare the message to be sent (SIMPLE MODE)
-			simpleMessageToSend := core.SimpleMessage{
-				OriginalName:  gossiper.Name,
-				RelayPeerAddr: gossiper.Address.String(),
-				Contents:      message.Text,
-			}
-			packetToSend := core.GossipPacket{Simple: &simpleMessageToSend}
-			packetBytes, err := protobuf.Encode(&packetToSend)
-			helpers.HandleErrorFatal(err)
+		// Receive and decode messages until the socket is closed by Stop
+		message, fromAddr := receiveAndDecodeFromClient(gossiper)
+		if gossiper.IsStopping() {
+			return
+		}
+		if fromAddr == nil {
+			continue
+		}
 
-			// Send message to all known peers
-			for _, knownAddress := range knownPeers {
-				core.ConnectAndSend(knownAddress, gossiper.Conn, packetBytes)
+		if gossiper.SimpleMode {
+			// In simple mode the client can only send messages
+			if _, err := SendRumor(gossiper, message.Text); err != nil {
+				helpers.GossipLog.Warn("cannot send message", helpers.F("error", err))
 			}
+			continue
 		}
 
-		// Prepare the message to be sent
-		if !simpleMode {
-			if isClientFileIndexing(&message) {
-				//Handle messages from client to simply index a file
-				newFile := filehandling.HandleFileIndexing(gossiper, *message.File)
+		if err := handleClientMessage(gossiper, &message); err != nil {
+			helpers.GossipLog.Warn("cannot handle client message", helpers.F("error", err))
+		}
+	}
+}
 
-				if hw3ex2 {
-					go blockchain.StubbornlySendTLC(gossiper, newFile, stubbornTimeout)
-				}
-				// monger tlc message just like a rumor message
-				// updateWant(gossiper, gossiper.Name)
-				//
-				// // Pick a random address and send the tlc message
-				// chosenAddr := ""
-				// if len(knownPeers) > 0 {
-				// 	chosenAddr = helpers.PickRandomInSlice(knownPeers)
-				// 	sendTLC(newTLC, gossiper, chosenAddr)
-				// 	helpers.PrintUnconfirmedGossip(newTLC.Origin, newFile.FileName, hex.EncodeToString(newFile.MetaHash[:]), newTLC.ID, blockPublish.Transaction.Size)
-				// }
-			} else if isClientRequestingDownload(&message) {
-				// Handle message from client to request a file download
-				filehandling.HandleClientDownloadRequest(gossiper, &message)
-			} else if isClientRequestingFileSearch(&message) {
-				filehandling.HandleClientSearchRequest(gossiper, &message)
-			} else if isClientRequestingImplicitDownload(&message) {
-				filehandling.HandleClientImplicitDownloadRequest(gossiper, &message)
-			} else {
-				if isClientMessagePrivate(&message) {
-					// Handle private messages from client
-					privateMsg := createNewPrivateMessage(gossiper.Name, message.Text, message.Destination)
-					handlePrivateMessage(gossiper, privateMsg)
-				} else {
-					// Print output
-					// helpers.PrintOutputSimpleMessageFromClient(message.Text, gossiper.KnownPeers)
-
-					// Add rumor to list of known rumors
-					gossiper.MongeringIDLock.Lock()
-					gossiper.CurrentMongeringID++
-					newRumor := core.RumorMessage{
-						Origin: gossiper.Name,
-						ID:     gossiper.CurrentMongeringID,
-						Text:   message.Text,
-					}
-					gossiper.MongeringIDLock.Unlock()
-					addRumorToKnownRumors(gossiper, newRumor)
-					updateWant(gossiper, gossiper.Name)
-
-					// Pick a random address and send the rumor
-					chosenAddr := ""
-					if len(knownPeers) > 0 {
-						chosenAddr = helpers.PickRandomInSlice(knownPeers)
-						sendRumor(newRumor, gossiper, chosenAddr)
-						// helpers.PrintOutputMongering(chosenAddr)
-					}
-				}
-			}
+// Dispatch a message from the client to the matching call of the service API
+func handleClientMessage(gossiper *core.Gossiper, message *core.Message) error {
+	if isClientRequestingNameTx(message) {
+		// Transfer or revoke an owned name
+		if message.Revoke != nil && *message.Revoke {
+			return wrapClientError("cannot revoke "+*message.Name, RevokeName(gossiper, *message.Name))
+		}
+		return wrapClientError("cannot transfer "+*message.Name, TransferName(gossiper, *message.Name, *message.NewOwner))
+	} else if isClientRequestingNamedDownload(message) {
+		// Download a file by its name on the chain
+		destination := ""
+		if message.Destination != nil {
+			destination = *message.Destination
 		}
+		_, err := DownloadByName(gossiper, *message.Name, destination)
+		return wrapClientError("cannot download "+*message.Name, err)
+	} else if isClientFileIndexing(message) {
+		// Simply index a file and publish its name
+		_, err := ShareFile(gossiper, *message.File)
+		return wrapClientError("cannot share "+*message.File, err)
+	} else if isClientRequestingDownload(message) {
+		err := Download(gossiper, *message.File, *message.Destination, *message.Request)
+		return wrapClientError("cannot download "+*message.File, err)
+	} else if isClientRequestingFileSearch(message) {
+		budget := uint64(0)
+		if message.Budget != nil {
+			budget = *message.Budget
+		}
+		return wrapClientError("cannot search", Search(gossiper, strings.Split(*message.Keywords, ","), budget))
+	} else if isClientRequestingImplicitDownload(message) {
+		err := Download(gossiper, *message.File, "", *message.Request)
+		return wrapClientError("cannot download "+*message.File, err)
+	} else if isClientMessagePrivate(message) {
+		_, err := SendPrivate(gossiper, *message.Destination, message.Text)
+		return wrapClientError("cannot send private message to "+*message.Destination, err)
 	}
+	_, err := SendRumor(gossiper, message.Text)
+	return wrapClientError("cannot send message", err)
+}
+
+// prefixes the error with what the client tried to do, nil if there is no error
+func wrapClientError(action string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("%s: %s", action, err)
 }
 
 // =====================================================================
@@ -190,27 +197,55 @@ func clientListener(gossiper *core.Gossiper, simpleMode bool, stubbornTimeout in
 // =====================================================================
 // =====================================================================
 
+// true if the client wants to transfer or revoke a name on the chain
+func isClientRequestingNameTx(clientMsg *core.Message) bool {
+	if !isClientRequestingNamedDownload(clientMsg) {
+		return false
+	}
+	revoke := clientMsg.Revoke != nil && *clientMsg.Revoke
+	transfer := clientMsg.NewOwner != nil && strings.Compare(*clientMsg.NewOwner, "") != 0
+	return revoke || transfer
+}
+
+// true if the client wants to download a file by its name on the chain
+func isClientRequestingNamedDownload(clientMsg *core.Message) bool {
+	return clientMsg.Name != nil && strings.Compare(*(clientMsg.Name), "") != 0
+}
+
 // true if the client did not specify a destination - only wants to index and divide file locally
 func isClientFileIndexing(clientMsg *core.Message) bool {
-	return (strings.Compare(*(clientMsg.File), "") != 0 &&
-		(strings.Compare(*(clientMsg.Destination), "") == 0) &&
-		len(*clientMsg.Request) == 0)
+	return (strings.Compare(optionalString(clientMsg.File), "") != 0 &&
+		(strings.Compare(optionalString(clientMsg.Destination), "") == 0) &&
+		!hasClientRequest(clientMsg))
 }
 
 func isClientRequestingFileSearch(clientMsg *core.Message) bool {
-	return (strings.Compare(*(clientMsg.Keywords), "") != 0)
+	return (strings.Compare(optionalString(clientMsg.Keywords), "") != 0)
 }
 
 // true if the client did not specify a destination - only wants to index and divide file locally
 func isClientRequestingDownload(clientMsg *core.Message) bool {
-	return (strings.Compare(*(clientMsg.File), "") != 0 &&
-		(strings.Compare(*(clientMsg.Destination), "") != 0) &&
-		(len(*clientMsg.Request) != 0))
+	return (strings.Compare(optionalString(clientMsg.File), "") != 0 &&
+		(strings.Compare(optionalString(clientMsg.Destination), "") != 0) &&
+		hasClientRequest(clientMsg))
 }
 
 // true if the client did not specify a destination - only wants to index and divide file locally
 func isClientRequestingImplicitDownload(clientMsg *core.Message) bool {
-	return (strings.Compare(*(clientMsg.File), "") != 0 &&
-		(strings.Compare(*(clientMsg.Destination), "") == 0) &&
-		(len(*clientMsg.Request) != 0))
+	return (strings.Compare(optionalString(clientMsg.File), "") != 0 &&
+		(strings.Compare(optionalString(clientMsg.Destination), "") == 0) &&
+		hasClientRequest(clientMsg))
+}
+
+// true if the client gave the metahash of a file to download
+func hasClientRequest(clientMsg *core.Message) bool {
+	return clientMsg.Request != nil && len(*clientMsg.Request) != 0
+}
+
+// the value of an optional field of a client message, "" if the client left it out
+func optionalString(field *string) string {
+	if field == nil {
+		return ""
+	}
+	return *field
 }
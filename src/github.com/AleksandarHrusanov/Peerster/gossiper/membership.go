@@ -0,0 +1,94 @@
+package gossiper
+
+import (
+	"strings"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/core"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+)
+
+// Probe a peer not heard from lately every period, SWIM-style: directly first, then through other
+// peers. Peers which answer neither become suspect, and dead if they stay silent. A dead peer is
+// also pinged every period, so that it joins again once it is back
+func failureDetector(gossiper *core.Gossiper, period time.Duration) {
+	for gossiper.Sleep(period) {
+		gossiper.ExpireMembers()
+
+		if target := gossiper.NextProbeTarget(period); strings.Compare(target, "") != 0 {
+			go probePeer(gossiper, target, period)
+		}
+		if dead := gossiper.PickDeadPeer(); strings.Compare(dead, "") != 0 {
+			// the ack, if any, marks the peer alive
+			seq, _ := gossiper.StartProbe(dead, "", 0)
+			sendProbe(gossiper, dead, &core.MembershipProbe{Seq: seq})
+			go expireProbe(gossiper, seq, period)
+		}
+	}
+}
+
+// Probe a peer directly, then through up to IndirectProbes other peers if it does not answer in time
+func probePeer(gossiper *core.Gossiper, target string, period time.Duration) {
+	probedAt := time.Now()
+	seq, acked := gossiper.StartProbe(target, "", 0)
+	defer gossiper.EndProbe(seq)
+
+	sendProbe(gossiper, target, &core.MembershipProbe{Seq: seq})
+	timeout := time.Duration(gossiper.Settings.ProbeTimeoutMillis) * time.Millisecond
+	select {
+	case <-acked:
+		return
+	case <-time.After(timeout):
+	}
+
+	probeHelpers := gossiper.PickProbeHelpers(target, constants.IndirectProbes)
+	gossiper.ProbeThrough(seq, probeHelpers)
+	for _, helper := range probeHelpers {
+		sendProbe(gossiper, helper, &core.MembershipProbe{Seq: seq, Target: target})
+	}
+	if period > 2*timeout {
+		timeout = period - timeout
+	}
+	select {
+	case <-acked:
+	case <-time.After(timeout):
+		gossiper.SuspectPeer(target, probedAt)
+	}
+}
+
+// Answer a probe of the failure detector received from a peer. Only an authenticated packet makes
+// a peer which leaves evicted at once
+func handleProbe(gossiper *core.Gossiper, probe *core.MembershipProbe, fromAddr string, authenticated bool) {
+	switch {
+	case probe.Leave:
+		gossiper.PeerLeaving(fromAddr, authenticated)
+	case !probe.Ack && strings.Compare(probe.Target, "") == 0:
+		// Ping
+		sendProbe(gossiper, fromAddr, &core.MembershipProbe{Seq: probe.Seq, Ack: true})
+	case !probe.Ack:
+		// Ping on behalf of the peer; only known peers are pinged so that probes cannot be reflected
+		// at any address
+		if !helpers.SliceContainsString(gossiper.GetAllKnownPeers(), probe.Target) {
+			return
+		}
+		seq, _ := gossiper.StartProbe(probe.Target, fromAddr, probe.Seq)
+		sendProbe(gossiper, probe.Target, &core.MembershipProbe{Seq: seq})
+		go expireProbe(gossiper, seq, gossiper.Membership.ProbePeriod)
+	default:
+		requester, requesterSeq, target := gossiper.AckProbe(probe.Seq, fromAddr)
+		if strings.Compare(requester, "") != 0 {
+			sendProbe(gossiper, requester, &core.MembershipProbe{Seq: requesterSeq, Target: target, Ack: true})
+		}
+	}
+}
+
+func sendProbe(gossiper *core.Gossiper, toAddr string, probe *core.MembershipProbe) {
+	gossiper.SendPacket(toAddr, &core.GossipPacket{Probe: probe})
+}
+
+// forgets a probe which was not acked in time
+func expireProbe(gossiper *core.Gossiper, seq uint64, after time.Duration) {
+	time.Sleep(after)
+	gossiper.EndProbe(seq)
+}
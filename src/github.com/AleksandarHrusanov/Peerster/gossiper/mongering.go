@@ -9,12 +9,11 @@ import (
 	"github.com/AleksandarHrusanov/Peerster/helpers"
 )
 
-// Repeat the rumor mongering process
-func rumorMongerAgain(g *core.Gossiper) {
+// Repeat the rumor mongering process for the oldest mongering status
+func rumorMongerAgain(g *core.Gossiper, status *core.MongeringStatus) {
 	// Send again the rumor to a different address
-	rumorToSend := g.MongeringStatus[0].RumorMessage
-	chosenAddr := helpers.PickRandomInSliceDifferentFrom(g.KnownPeers,
-		g.MongeringStatus[0].WaitingStatusFromAddr)
+	rumorToSend := status.RumorMessage
+	chosenAddr := helpers.PickRandomInSliceDifferentFrom(g.GetLivePeers(), status.WaitingStatusFromAddr)
 	if strings.Compare(chosenAddr, "") != 0 {
 		safeMongeringStatusDelete(g)
 		sendRumor(rumorToSend, g, chosenAddr)
@@ -24,26 +23,31 @@ func rumorMongerAgain(g *core.Gossiper) {
 	}
 }
 
-// Safe delete of mongering status
+// Safe delete of the oldest mongering status
 func safeMongeringStatusDelete(g *core.Gossiper) {
+	g.MongeringLock.Lock()
 	statusToDelete := g.MongeringStatus[0]
 	statusToDelete.Lock.Lock()
 	g.MongeringStatus = g.MongeringStatus[1:]
 	statusToDelete.Lock.Unlock()
+	g.MongeringLock.Unlock()
 }
 
 // Remove all mongering status that timed-out and repeat the mongering process
 func mongeringStatusRefresher(g *core.Gossiper) {
-	for {
-		if len(g.MongeringStatus) > 0 {
-			if g.MongeringStatus[0] != nil {
+	for !g.IsStopping() {
+		if statuses := g.GetMongeringStatuses(); len(statuses) > 0 {
+			if oldest := statuses[0]; oldest != nil {
 				select {
-				case <-g.MongeringStatus[0].TimeUp:
+				case <-oldest.TimeUp:
 					// Timed-out
-					rumorMongerAgain(g)
+					rumorMongerAgain(g, oldest)
 				default:
 					// Already acknowledged
-					if g.MongeringStatus[0].AckReceived {
+					oldest.Lock.Lock()
+					acknowledged := oldest.AckReceived
+					oldest.Lock.Unlock()
+					if acknowledged {
 						safeMongeringStatusDelete(g)
 					}
 				}
@@ -89,9 +93,14 @@ func handleRumorMessage(gossiper *core.Gossiper, rumor *core.RumorMessage, fromA
 
 	// Update destiantionTable
 	gossiper.DestinationTable.DsdvLock.Lock()
+	previousHop := gossiper.DestinationTable.Dsdv[rumor.Origin]
 	core.UpdateDestinationTable(gossiper.Name, rumor.Origin, rumor.ID, fromAddr,
-		gossiper.DestinationTable.Dsdv, gossiper.KnownRumors, originIsKnown, !core.IsRouteRumor(rumor))
+		gossiper.DestinationTable.Dsdv, gossiper.GetAllRumors(), originIsKnown, !core.IsRouteRumor(rumor))
+	nextHop := gossiper.DestinationTable.Dsdv[rumor.Origin]
 	gossiper.DestinationTable.DsdvLock.Unlock()
+	if strings.Compare(previousHop, nextHop) != 0 {
+		gossiper.PublishEvent(core.EventRoute, core.RouteEvent{Origin: rumor.Origin, NextHop: nextHop})
+	}
 
 	// Send status
 	sendStatus(gossiper, fromAddr)
@@ -101,7 +110,7 @@ func handleRumorMessage(gossiper *core.Gossiper, rumor *core.RumorMessage, fromA
 			// Pick a random address and send the rumor
 			chosenAddr := helpers.PickRandomInSlice(knownPeers)
 			sendRumor(*rumor, gossiper, chosenAddr)
-			// helpers.PrintOutputMongering(chosenAddr)
+			helpers.PrintOutputMongering(chosenAddr)
 		}
 	}
 
@@ -117,7 +126,7 @@ func handleStatusPacket(gossiper *core.Gossiper, statusPckt *core.StatusPacket,
 	// corresponding Rumor if we need to send it again after a coin flip
 	rumorsToFlipCoinFor := make([]core.RumorMessage, 0)
 	// For each mongeringstatus
-	for _, mongeringStatus := range gossiper.MongeringStatus {
+	for _, mongeringStatus := range gossiper.GetMongeringStatuses() {
 		// Check if the mongeringStatus has not yet been deleted
 		if mongeringStatus != nil {
 			mongeringStatus.Lock.Lock()
@@ -208,7 +217,7 @@ func handleStatusPacket(gossiper *core.Gossiper, statusPckt *core.StatusPacket,
 			rumorToSend = getRumor(gossiper, peerStatusTemp.Identifier, peerStatusTemp.NextID)
 		}
 		if rumorToSend != nil {
-			// helpers.PrintOutputMongering(fromAddr)
+			helpers.PrintOutputMongering(fromAddr)
 			sendRumor(*rumorToSend, gossiper, fromAddr)
 		}
 	} else if iWantYourRumors {
@@ -217,7 +226,7 @@ func handleStatusPacket(gossiper *core.Gossiper, statusPckt *core.StatusPacket,
 		// Case: each peer is up-to-date and the gossiper
 		// decide to continue rumormongering or not
 		// Print IN SYNC WITH message
-		// helpers.PrintOutputInSyncWith(fromAddr)
+		helpers.PrintOutputInSyncWith(fromAddr)
 		// Continue mongering test
 		if len(rumorsToFlipCoinFor) > 0 {
 			for _, rToFlip := range rumorsToFlipCoinFor {
@@ -230,7 +239,7 @@ func handleStatusPacket(gossiper *core.Gossiper, statusPckt *core.StatusPacket,
 					if strings.Compare(chosenAddr, "") != 0 {
 						// Print FLIPPED COIN message and send rumor
 						sendRumor(rToFlip, gossiper, chosenAddr)
-						// helpers.PrintOutputFlippedCoin(chosenAddr)
+						helpers.PrintOutputFlippedCoin(chosenAddr)
 					}
 				}
 			}
@@ -0,0 +1,25 @@
+package gossiper
+
+import (
+	"strings"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/core"
+)
+
+// Shuffle the partial view with its oldest peer every ShufflePeriod seconds, so that new peers spread
+// through the network and every node keeps a random sample of it
+func peerSampler(gossiper *core.Gossiper) {
+	for gossiper.Sleep(time.Duration(gossiper.Settings.ShufflePeriod) * time.Second) {
+		if target, shuffle := gossiper.StartShuffle(); strings.Compare(target, "") != 0 {
+			gossiper.SendPacket(target, &core.GossipPacket{Shuffle: shuffle})
+		}
+	}
+}
+
+// Merge the peers received from another node into the partial view, answering its shuffle request
+func handleShuffle(gossiper *core.Gossiper, shuffle *core.PeerShuffle, fromAddr string) {
+	if reply := gossiper.HandleShuffle(fromAddr, shuffle); reply != nil {
+		gossiper.SendPacket(fromAddr, &core.GossipPacket{Shuffle: reply})
+	}
+}
@@ -5,19 +5,18 @@ import (
 
 	"github.com/AleksandarHrusanov/Peerster/core"
 	"github.com/AleksandarHrusanov/Peerster/helpers"
-	"github.com/dedis/protobuf"
 )
 
 // Given a message from the client, return true if it is private
 func isClientMessagePrivate(clientMsg *core.Message) bool {
-	return (strings.Compare(*(clientMsg.Destination), "") != 0)
+	return (strings.Compare(optionalString(clientMsg.Destination), "") != 0)
 }
 
-// A constructor for PrivateMessages - defaultID = 0 and defaultHopLimit = 10
-func createNewPrivateMessage(origin string, msg string, dest *string) *core.PrivateMessage {
+// A constructor for PrivateMessages - defaultID = 0 and defaultHopLimit = DefaultHopLimit
+func createNewPrivateMessage(gossiper *core.Gossiper, msg string, dest *string) *core.PrivateMessage {
 	defaultID := uint32(0) // to enforce NOT sequencing
-	defaultHopLimit := uint32(10)
-	privateMsg := core.PrivateMessage{Origin: origin, ID: defaultID, Text: msg, Destination: *dest, HopLimit: defaultHopLimit}
+	defaultHopLimit := gossiper.Settings.DefaultHopLimit
+	privateMsg := core.PrivateMessage{Origin: gossiper.Name, ID: defaultID, Text: msg, Destination: *dest, HopLimit: defaultHopLimit}
 	return &privateMsg
 }
 
@@ -57,9 +56,7 @@ func forwardPrivateMessage(gossiperPtr *core.Gossiper, msg *core.PrivateMessage)
 	msg.HopLimit--
 	// Encode and send packet
 	packetToSend := core.GossipPacket{Private: msg}
-	packetBytes, err := protobuf.Encode(&packetToSend)
-	helpers.HandleErrorFatal(err)
-	core.ConnectAndSend(forwardingAddress, gossiperPtr.Conn, packetBytes)
+	gossiperPtr.SendPacket(forwardingAddress, &packetToSend)
 }
 
 func storePrivateMessage(gossiper *core.Gossiper, msg *core.PrivateMessage) {
@@ -83,6 +80,11 @@ func storePrivateMessage(gossiper *core.Gossiper, msg *core.PrivateMessage) {
 		}
 		messagesArray = append(messagesArray, stringToStore)
 		gossiper.PrivateMessages.Messages[orgn] = messagesArray
+	} else {
+		// only relaying the message
+		gossiper.PrivateMessages.MessageLock.Unlock()
+		return
 	}
 	gossiper.PrivateMessages.MessageLock.Unlock()
+	gossiper.PublishEvent(core.EventPrivate, *msg)
 }
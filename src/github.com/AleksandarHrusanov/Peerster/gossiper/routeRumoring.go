@@ -6,7 +6,6 @@ import (
 
 	"github.com/AleksandarHrusanov/Peerster/core"
 	"github.com/AleksandarHrusanov/Peerster/helpers"
-	"github.com/dedis/protobuf"
 )
 
 // A function to generate a route rumor (e.g. with empty Text field)
@@ -26,8 +25,6 @@ func generateAndSendRouteRumor(gossiperPtr *core.Gossiper, toAll bool) {
 	gossiperPtr.MongeringIDLock.Unlock()
 
 	packetToSend := core.GossipPacket{Rumor: &newRouteRumor}
-	packetBytes, err := protobuf.Encode(&packetToSend)
-	helpers.HandleErrorFatal(err)
 
 	gossiperPtr.PeersLock.Lock()
 	knownPeers := gossiperPtr.KnownPeers
@@ -35,16 +32,13 @@ func generateAndSendRouteRumor(gossiperPtr *core.Gossiper, toAll bool) {
 
 	if toAll {
 		for _, peer := range knownPeers {
-			core.ConnectAndSend(peer, gossiperPtr.Conn, packetBytes)
+			gossiperPtr.SendPacket(peer, &packetToSend)
 		}
 	} else {
-		chosenAddr := ""
-		if len(knownPeers) > 0 {
-			chosenAddr = helpers.PickRandomInSlice(knownPeers)
-		}
+		chosenAddr := helpers.PickRandomInSlice(gossiperPtr.GetLivePeers())
 
 		if strings.Compare(chosenAddr, "") != 0 {
-			core.ConnectAndSend(chosenAddr, gossiperPtr.Conn, packetBytes)
+			gossiperPtr.SendPacket(chosenAddr, &packetToSend)
 		}
 	}
 }
@@ -55,8 +49,7 @@ func routeRumorHandler(gossiperPtr *core.Gossiper, routeRumorPtr *int) {
 	if *routeRumorPtr > 0 {
 		// if the route rumor timer is 0, disable sending route rumors completely
 		generateAndSendRouteRumor(gossiperPtr, true)
-		for {
-			time.Sleep(time.Duration(*routeRumorPtr) * time.Second)
+		for gossiperPtr.Sleep(time.Duration(*routeRumorPtr) * time.Second) {
 			generateAndSendRouteRumor(gossiperPtr, false)
 		}
 	}
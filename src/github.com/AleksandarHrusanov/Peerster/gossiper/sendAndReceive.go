@@ -5,6 +5,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/AleksandarHrusanov/Peerster/constants"
 	"github.com/AleksandarHrusanov/Peerster/core"
 	"github.com/AleksandarHrusanov/Peerster/helpers"
 	"github.com/dedis/protobuf"
@@ -17,15 +18,12 @@ func sendStatus(gossiper *core.Gossiper, toAddr string) {
 	}
 	sp := core.StatusPacket{Want: gossiper.Want}
 	packetToSend := core.GossipPacket{Status: &sp}
-	packetBytes, err := protobuf.Encode(&packetToSend)
-	helpers.HandleErrorFatal(err)
-	core.ConnectAndSend(toAddr, gossiper.Conn, packetBytes)
+	gossiper.SendPacket(toAddr, &packetToSend)
 }
 
 // Send a RumorMessage to the given address
 func sendRumor(r core.RumorMessage, gossiper *core.Gossiper, toAddr string) {
 	packetToSend := core.GossipPacket{Rumor: &r}
-	packetBytes, err := protobuf.Encode(&packetToSend)
 
 	// Create new mongering status, set a timer for it and
 	// append it to the slice in the gossiper struct
@@ -36,19 +34,23 @@ func sendRumor(r core.RumorMessage, gossiper *core.Gossiper, toAddr string) {
 		AckReceived:           false,
 	}
 	go func(mongeringStatusPtr *core.MongeringStatus) {
-		time.Sleep(10 * time.Second)
-		mongeringStatusPtr.TimeUp <- true
+		if gossiper.Sleep(time.Duration(gossiper.Settings.MongeringTimeout) * time.Second) {
+			select {
+			case mongeringStatusPtr.TimeUp <- true:
+			case <-gossiper.Done():
+			}
+		}
 	}(&newMongeringStatus)
+	gossiper.MongeringLock.Lock()
 	gossiper.MongeringStatus = append(gossiper.MongeringStatus, &newMongeringStatus)
+	gossiper.MongeringLock.Unlock()
 
-	helpers.HandleErrorFatal(err)
-	core.ConnectAndSend(toAddr, gossiper.Conn, packetBytes)
+	gossiper.SendPacket(toAddr, &packetToSend)
 }
 
 // Send a RumorMessage to the given address
 func sendTLC(tlc *core.TLCMessage, gossiper *core.Gossiper, toAddr string) {
 	packetToSend := core.GossipPacket{TLCMessage: tlc}
-	packetBytes, err := protobuf.Encode(&packetToSend)
 
 	// Create new mongering status, set a timer for it and
 	// append it to the slice in the gossiper struct
@@ -59,57 +61,107 @@ func sendTLC(tlc *core.TLCMessage, gossiper *core.Gossiper, toAddr string) {
 		AckReceived:           false,
 	}
 	go func(mongeringStatusPtr *core.MongeringStatus) {
-		time.Sleep(10 * time.Second)
-		mongeringStatusPtr.TimeUp <- true
+		if gossiper.Sleep(time.Duration(gossiper.Settings.MongeringTimeout) * time.Second) {
+			select {
+			case mongeringStatusPtr.TimeUp <- true:
+			case <-gossiper.Done():
+			}
+		}
 	}(&newMongeringStatus)
+	gossiper.MongeringLock.Lock()
 	gossiper.MongeringStatus = append(gossiper.MongeringStatus, &newMongeringStatus)
+	gossiper.MongeringLock.Unlock()
 
-	helpers.HandleErrorFatal(err)
-	core.ConnectAndSend(toAddr, gossiper.Conn, packetBytes)
+	gossiper.SendPacket(toAddr, &packetToSend)
 }
 
-// Receive a message from UDP and decode it into a GossipPacket
-func receiveAndDecode(gossiper *core.Gossiper) (core.GossipPacket, *net.UDPAddr) {
+// Receive a message from UDP and decode it into a GossipPacket. Fragments are collected until
+// the packet they carry is complete, and datagrams of the secure channels are opened first
+func receiveAndDecode(gossiper *core.Gossiper) (core.GossipPacket, *net.UDPAddr, bool) {
 	// Create buffer
-	buffer := make([]byte, 9216)
-
-	// Read message from UDP
-	conn := gossiper.Conn
-	size, fromAddr, err := conn.ReadFromUDP(buffer)
-
-	// Timeout
-	helpers.HandleErrorNonFatal(err)
-	if err != nil {
-		return core.GossipPacket{}, nil
+	buffer := make([]byte, constants.MaxDatagramSize)
+
+	for {
+		// Read message from UDP
+		conn := gossiper.Conn
+		size, fromAddr, err := conn.ReadFromUDP(buffer)
+
+		// Timeout, or the socket was closed because the node stops
+		if err != nil {
+			if !gossiper.IsStopping() {
+				helpers.HandleErrorNonFatal(err)
+			}
+			return core.GossipPacket{}, nil, false
+		}
+
+		// Open the datagram if it is sealed; handshakes are answered there
+		datagram, ok := gossiper.OpenDatagram(fromAddr.String(), buffer[0:size])
+		if !ok {
+			continue
+		}
+		// only a packet sealed with a session surely comes from the peer; the fragments of a packet
+		// are not all checked, so a reassembled packet is not authenticated
+		authenticated := gossiper.Channels != nil && core.IsSecureDatagram(buffer[0:size])
+
+		// Decode the packet
+		gossipPacket := core.GossipPacket{}
+		err = protobuf.Decode(datagram, &gossipPacket)
+		if err == nil && gossipPacket.Fragment != nil {
+			packetBytes, fragmentErr := gossiper.ReassembleFragment(fromAddr.String(), gossipPacket.Fragment)
+			if fragmentErr != nil {
+				gossiper.CountMetric(core.MetricFragmentsDropped, 1)
+				helpers.GossipLog.Warn("dropped packet fragment", helpers.F("peer", fromAddr.String()),
+					helpers.F("error", fragmentErr))
+				continue
+			}
+			if packetBytes == nil {
+				// more fragments to come
+				continue
+			}
+			gossiper.CountMetric(core.MetricPacketsReassembled, 1)
+			authenticated = false
+			gossipPacket = core.GossipPacket{}
+			err = protobuf.Decode(packetBytes, &gossipPacket)
+			if err == nil && gossipPacket.Fragment != nil {
+				err = core.ErrInvalidFragment
+			}
+		}
+		helpers.HandleErrorNonFatal(err)
+		if err != nil {
+			gossiper.CountMetric(core.MetricDecodeErrors, 1)
+		} else {
+			gossiper.CountPacketReceived(&gossipPacket)
+		}
+
+		return gossipPacket, fromAddr, authenticated
 	}
-
-	// Decode the packet
-	gossipPacket := core.GossipPacket{}
-	err = protobuf.Decode(buffer[0:size], &gossipPacket)
-	helpers.HandleErrorNonFatal(err)
-
-	return gossipPacket, fromAddr
 }
 
-// Receive a client's message from UDP and decode it into a GossipPacket
+// Receive a client's message from UDP and decode it. No address is returned if nothing was
+// received or the message could not be decoded
 func receiveAndDecodeFromClient(gossiper *core.Gossiper) (core.Message, *net.UDPAddr) {
 	// Create buffer
-	buffer := make([]byte, 128)
+	buffer := make([]byte, constants.MaxDatagramSize)
 
 	// Read message from UDP
 	conn := gossiper.LocalConn
 	size, fromAddr, err := conn.ReadFromUDP(buffer)
 
-	// Timeout
-	helpers.HandleErrorNonFatal(err)
+	// Timeout, or the socket was closed because the node stops
 	if err != nil {
+		if !gossiper.IsStopping() {
+			helpers.HandleErrorNonFatal(err)
+		}
 		return core.Message{}, nil
 	}
 
-	// Decode the packet
+	// Decode the packet, dropping it if it is not a message
 	message := core.Message{}
-	err = protobuf.Decode(buffer[0:size], &message)
-	helpers.HandleErrorNonFatal(err)
+	if err = protobuf.Decode(buffer[0:size], &message); err != nil {
+		helpers.HandleErrorNonFatal(err)
+		gossiper.CountMetric(core.MetricDecodeErrors, 1)
+		return core.Message{}, nil
+	}
 
 	return message, fromAddr
 }
@@ -0,0 +1,282 @@
+package gossiper
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/AleksandarHrusanov/Peerster/blockchain"
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/core"
+	"github.com/AleksandarHrusanov/Peerster/filehandling"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+)
+
+// The functions in this file are the service API of the gossiper: the client listener and the
+// HTTP server both call into them and get back their results and errors synchronously
+
+// ErrEmptyMessage - the text of a rumor or private message is empty
+var ErrEmptyMessage = errors.New("message text is empty")
+
+// ErrUnknownDestination - no route to the destination of a private message or download is known
+var ErrUnknownDestination = errors.New("no route to the destination is known")
+
+// ErrFileNotFound - the file to share is not in the shared files folder
+var ErrFileNotFound = errors.New("file is not in the shared files folder")
+
+// ErrInvalidFileName - the file name is empty or points outside of its folder
+var ErrInvalidFileName = errors.New("file name is empty or contains path separators")
+
+// ErrInvalidMetahash - the requested metahash does not have the size of a hash
+var ErrInvalidMetahash = errors.New("metahash is not a valid hash")
+
+// ErrNoSearchMatch - an implicit download was requested for a file no search fully located
+var ErrNoSearchMatch = errors.New("no search located every chunk of the requested file")
+
+// ErrNoKeywords - a search was requested without keywords
+var ErrNoKeywords = errors.New("at least one non-empty keyword is required")
+
+// ErrNamingDisabled - a name transaction was requested while the naming chain is not running
+var ErrNamingDisabled = errors.New("name transactions require the gossiper to run in hw3ex2 or proof-of-work mode")
+
+// ErrLightClient - light clients keep only block headers and cannot publish transactions
+var ErrLightClient = errors.New("light clients cannot publish transactions")
+
+// ErrAlreadyShared - a named download was requested for a file this node published itself
+var ErrAlreadyShared = errors.New("file is already shared by this node")
+
+// SendRumor - start mongering a new rumor with the given text. In simple mode the text is
+// broadcast to every known peer instead and no rumor is returned
+func SendRumor(gossiper *core.Gossiper, text string) (*core.RumorMessage, error) {
+	if strings.Compare(text, "") == 0 {
+		return nil, ErrEmptyMessage
+	}
+	knownPeers := gossiper.GetAllKnownPeers()
+
+	if gossiper.SimpleMode {
+		broadcastSimpleMessage(gossiper, text, knownPeers)
+		return nil, nil
+	}
+
+	// Add rumor to list of known rumors
+	gossiper.MongeringIDLock.Lock()
+	gossiper.CurrentMongeringID++
+	newRumor := core.RumorMessage{
+		Origin: gossiper.Name,
+		ID:     gossiper.CurrentMongeringID,
+		Text:   text,
+	}
+	gossiper.MongeringIDLock.Unlock()
+	addRumorToKnownRumors(gossiper, newRumor)
+	updateWant(gossiper, gossiper.Name)
+
+	// Pick a random address and send the rumor
+	if livePeers := gossiper.GetLivePeers(); len(livePeers) > 0 {
+		chosenAddr := helpers.PickRandomInSlice(livePeers)
+		sendRumor(newRumor, gossiper, chosenAddr)
+	}
+	return &newRumor, nil
+}
+
+// SendPrivate - send a private message to the given destination through the next hop to it
+func SendPrivate(gossiper *core.Gossiper, destination string, text string) (*core.PrivateMessage, error) {
+	if strings.Compare(text, "") == 0 {
+		return nil, ErrEmptyMessage
+	}
+	if strings.Compare(destination, gossiper.Name) != 0 && !routeIsKnown(gossiper, destination) {
+		return nil, ErrUnknownDestination
+	}
+	privateMsg := createNewPrivateMessage(gossiper, text, &destination)
+	handlePrivateMessage(gossiper, privateMsg)
+	return privateMsg, nil
+}
+
+// ShareFile - index a file from the shared files folder and, when the naming chain runs, publish
+// its name. The file is returned even if publishing its name fails
+func ShareFile(gossiper *core.Gossiper, fileName string) (*core.FileInformation, error) {
+	if !fileNameIsValid(fileName) {
+		return nil, ErrInvalidFileName
+	}
+	if _, err := os.Stat(filepath.Join(gossiper.Settings.SharedFilesFolder, fileName)); err != nil {
+		return nil, ErrFileNotFound
+	}
+
+	newFile, err := filehandling.HandleFileIndexing(gossiper, fileName)
+	if err != nil {
+		return nil, err
+	}
+	return newFile, publishSharedFile(gossiper, newFile)
+}
+
+// UploadFile - stream an uploaded file into the shared files folder, indexing it on the way, and
+// share it like ShareFile does. A shared file with the same name is replaced
+func UploadFile(gossiper *core.Gossiper, fileName string, content io.Reader) (*core.FileInformation, error) {
+	if !fileNameIsValid(fileName) {
+		return nil, ErrInvalidFileName
+	}
+
+	newFile, err := filehandling.HandleFileUpload(gossiper, fileName, content)
+	if err != nil {
+		return nil, err
+	}
+	return newFile, publishSharedFile(gossiper, newFile)
+}
+
+// Download - download the file with the given metahash and save it under the given name. With a
+// destination the file is requested from it directly, otherwise every chunk of the file must
+// have been located by a previous search
+func Download(gossiper *core.Gossiper, fileName string, destination string, metahash []byte) error {
+	if len(metahash) != constants.HashSize {
+		return ErrInvalidMetahash
+	}
+
+	if strings.Compare(destination, "") == 0 {
+		if !wholeFileFound(gossiper, metahash) {
+			return ErrNoSearchMatch
+		}
+		request := metahash
+		filehandling.HandleClientImplicitDownloadRequest(gossiper, &core.Message{Request: &request})
+		return nil
+	}
+
+	if !fileNameIsValid(fileName) {
+		return ErrInvalidFileName
+	}
+	if !routeIsKnown(gossiper, destination) {
+		return ErrUnknownDestination
+	}
+	request := metahash
+	filehandling.HandleClientDownloadRequest(gossiper,
+		&core.Message{File: &fileName, Destination: &destination, Request: &request})
+	return nil
+}
+
+// OpenFile - open a shared, downloaded or downloading file for reading by its metahash. Reads
+// from a file which is still being downloaded block until the chunks they need arrive, until the
+// context is done or until StreamChunkTimeout
+func OpenFile(ctx context.Context, gossiper *core.Gossiper, metahash []byte) (*filehandling.FileReader, error) {
+	if len(metahash) != constants.HashSize {
+		return nil, ErrInvalidMetahash
+	}
+	var fixedMetahash [constants.HashSize]byte
+	copy(fixedMetahash[:], metahash)
+	return filehandling.OpenFile(ctx, gossiper, fixedMetahash)
+}
+
+// DownloadByName - resolve a name through the confirmed chain and start downloading the file it
+// maps to, from the given destination if any or from the node which published the name.
+// Returns the resolved metahash
+func DownloadByName(gossiper *core.Gossiper, name string, destination string) ([]byte, error) {
+	metahash, holder, err := blockchain.ResolveName(gossiper, name)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Compare(destination, "") != 0 {
+		// the client explicitly chose which node to download from
+		holder = destination
+	}
+	if strings.Compare(holder, gossiper.Name) == 0 {
+		return metahash, ErrAlreadyShared
+	}
+	filehandling.HandleClientNamedDownloadRequest(gossiper, name, metahash, holder)
+	return metahash, nil
+}
+
+// Search - start an expanding-ring search for files matching any of the keywords. A zero budget
+// starts with the default budget and doubles it until enough matches are found
+func Search(gossiper *core.Gossiper, keywords []string, budget uint64) error {
+	if len(keywords) == 0 {
+		return ErrNoKeywords
+	}
+	for _, kw := range keywords {
+		if strings.Compare(kw, "") == 0 || strings.Contains(kw, ",") {
+			return ErrNoKeywords
+		}
+	}
+	joined := strings.Join(keywords, ",")
+	filehandling.HandleClientSearchRequest(gossiper, &core.Message{Keywords: &joined, Budget: &budget})
+	return nil
+}
+
+// TransferName - publish a transaction handing a name owned by this gossiper to another node
+func TransferName(gossiper *core.Gossiper, name string, newOwner string) error {
+	if !gossiper.Naming {
+		return ErrNamingDisabled
+	}
+	return publishBlock(gossiper, blockchain.CreateBlockTransfer(name, newOwner))
+}
+
+// RevokeName - publish a transaction revoking a name owned by this gossiper
+func RevokeName(gossiper *core.Gossiper, name string) error {
+	if !gossiper.Naming {
+		return ErrNamingDisabled
+	}
+	return publishBlock(gossiper, blockchain.CreateBlockRevoke(name))
+}
+
+// publish the name of a newly shared file when the naming chain runs
+func publishSharedFile(gossiper *core.Gossiper, newFile *core.FileInformation) error {
+	if !gossiper.Naming {
+		return nil
+	}
+	return publishBlock(gossiper, blockchain.CreateBlockForSharedFile(gossiper, newFile))
+}
+
+// Validate a block against the local name table and, if valid, hand its transaction over to the
+// consensus in use: either stubbornly send it as a TLC message or submit it to the miners
+func publishBlock(gossiper *core.Gossiper, blockPublish *core.BlockPublish) error {
+	if gossiper.LightClient {
+		return ErrLightClient
+	}
+	if err := blockchain.ValidateTx(gossiper, gossiper.Name, &blockPublish.Transaction); err != nil {
+		return err
+	}
+	if gossiper.Mining != nil {
+		blockchain.SubmitTx(gossiper, blockPublish.Transaction)
+		return nil
+	}
+	go blockchain.StubbornlySendTLC(gossiper, blockPublish, gossiper.StubbornTimeout)
+	return nil
+}
+
+// Send the text as a simple message to all known peers (SIMPLE MODE)
+func broadcastSimpleMessage(gossiper *core.Gossiper, text string, knownPeers []string) {
+	simpleMessageToSend := core.SimpleMessage{
+		OriginalName:  gossiper.Name,
+		RelayPeerAddr: gossiper.Address.String(),
+		Contents:      text,
+	}
+	packetToSend := core.GossipPacket{Simple: &simpleMessageToSend}
+
+	for _, knownAddress := range knownPeers {
+		gossiper.SendPacket(knownAddress, &packetToSend)
+	}
+}
+
+// true if the routing table has a next hop to the given origin
+func routeIsKnown(gossiper *core.Gossiper, origin string) bool {
+	gossiper.DestinationTable.DsdvLock.Lock()
+	_, ok := gossiper.DestinationTable.Dsdv[origin]
+	gossiper.DestinationTable.DsdvLock.Unlock()
+	return ok
+}
+
+// true if a search located every chunk of the file with the given metahash
+func wholeFileFound(gossiper *core.Gossiper, metahash []byte) bool {
+	for _, match := range gossiper.GetFileSearchMatches() {
+		if match.ChunkCount == uint64(len(match.LocationOfChunks)) && bytes.Compare(match.Metahash, metahash) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// false if the file name is empty or could point outside of the folder it is looked up in
+func fileNameIsValid(fileName string) bool {
+	return strings.Compare(fileName, "") != 0 && !strings.ContainsAny(fileName, `/\`) &&
+		fileName != "." && fileName != ".."
+}
@@ -0,0 +1,108 @@
+package gossiper
+
+import (
+	"net"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/core"
+	"github.com/AleksandarHrusanov/Peerster/filehandling"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+	"github.com/dedis/protobuf"
+)
+
+// Accept stream connections from peers on the address of the UDP socket. Each connection carries
+// the data requests and replies sent by one peer; replies to it go over a connection of its own
+func streamListener(gossiper *core.Gossiper) {
+	listener, err := net.Listen("tcp", gossiper.Address.String())
+	if err != nil {
+		// peers fall back to UDP when they cannot connect
+		helpers.GossipLog.Warn("cannot accept stream connections", helpers.F("error", err))
+		return
+	}
+	defer listener.Close()
+	go func() {
+		// unblock Accept when the node stops
+		<-gossiper.Done()
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if gossiper.IsStopping() {
+			return
+		}
+		if err != nil {
+			helpers.GossipLog.Warn("cannot accept stream connection", helpers.F("error", err))
+			time.Sleep(time.Second)
+			continue
+		}
+		if !gossiper.Go(func() { serveStream(gossiper, conn) }) {
+			conn.Close()
+			return
+		}
+	}
+}
+
+// Answer the handshake of a peer and handle the packets it sends until the connection closes
+func serveStream(gossiper *core.Gossiper, conn net.Conn) {
+	defer conn.Close()
+	served := make(chan struct{})
+	defer close(served)
+	go func() {
+		// unblock the reads when the node stops
+		select {
+		case <-gossiper.Done():
+			conn.Close()
+		case <-served:
+		}
+	}()
+	conn.SetDeadline(time.Now().Add(time.Duration(gossiper.Settings.StreamDialTimeout) * time.Second))
+	hello, err := core.ReadStreamHello(conn)
+	if err != nil {
+		helpers.GossipLog.Debug("rejected stream connection", helpers.F("peer", conn.RemoteAddr().String()),
+			helpers.F("error", err))
+		return
+	}
+	answer, err := protobuf.Encode(&core.StreamHello{Name: gossiper.Name, Address: gossiper.Address.String(),
+		Version: core.StreamProtocolVersion})
+	if err != nil {
+		helpers.HandleErrorNonFatal(err)
+		return
+	}
+	if err := core.WriteFrame(conn, answer); err != nil {
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	helpers.GossipLog.Debug("stream connection accepted", helpers.F("peer", hello.Address),
+		helpers.F("origin", hello.Name))
+
+	for {
+		frame, err := core.ReadFrame(conn)
+		if err != nil {
+			return
+		}
+		packetBytes, ok := gossiper.OpenStreamFrame(hello.Address, conn.RemoteAddr().String(), frame)
+		if !ok {
+			continue
+		}
+		gossipPacket := core.GossipPacket{}
+		if err := protobuf.Decode(packetBytes, &gossipPacket); err != nil || !core.UsesStream(&gossipPacket) {
+			// only bulk transfers are sent over streams
+			gossiper.CountMetric(core.MetricDecodeErrors, 1)
+			continue
+		}
+		gossiper.CountPacketReceived(&gossipPacket)
+		gossiper.CountMetric(core.MetricStreamPacketsReceived, 1)
+		// the address given in the hello is not checked without encryption, the connection's is, and
+		// cannot be forged since it completed the TCP handshake
+		if !gossiper.AdmitPacket(conn.RemoteAddr().String(), &gossipPacket, true) {
+			continue
+		}
+
+		if gossipPacket.DataRequest != nil {
+			filehandling.HandlePeerDataRequest(gossiper, gossipPacket.DataRequest)
+		} else {
+			filehandling.HandlePeerDataReply(gossiper, gossipPacket.DataReply)
+		}
+	}
+}
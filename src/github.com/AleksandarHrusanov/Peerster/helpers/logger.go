@@ -0,0 +1,251 @@
+package helpers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogLevel - the severity of a log entry
+type LogLevel int
+
+// The levels of the log entries, from the most verbose to the most severe
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level LogLevel) String() string {
+	if level < LevelDebug || level > LevelError {
+		return "level" + strconv.Itoa(int(level))
+	}
+	return levelNames[level]
+}
+
+// LogFormat - how the log entries are written
+type LogFormat string
+
+// LogFormatLegacy - the fixed-format lines of the course, expected by its test scripts
+const LogFormatLegacy LogFormat = "legacy"
+
+// LogFormatText - one line of text per entry with its fields as key=value pairs
+const LogFormatText LogFormat = "text"
+
+// LogFormatJSON - one JSON object per line
+const LogFormatJSON LogFormat = "json"
+
+// ErrInvalidLogLevel - the log level is not one of debug, info, warn and error
+var ErrInvalidLogLevel = errors.New("log level must be debug, info, warn or error")
+
+// ErrInvalidLogFormat - the log format is not one of legacy, text and json
+var ErrInvalidLogFormat = errors.New("log format must be legacy, text or json")
+
+// ParseLogLevel - parse a log level given on the command line
+func ParseLogLevel(level string) (LogLevel, error) {
+	for i, name := range levelNames {
+		if strings.Compare(strings.ToLower(level), name) == 0 {
+			return LogLevel(i), nil
+		}
+	}
+	return LevelInfo, ErrInvalidLogLevel
+}
+
+// ParseLogFormat - parse a log format given on the command line
+func ParseLogFormat(format string) (LogFormat, error) {
+	switch LogFormat(strings.ToLower(format)) {
+	case LogFormatLegacy, LogFormatText, LogFormatJSON:
+		return LogFormat(strings.ToLower(format)), nil
+	}
+	return LogFormatLegacy, ErrInvalidLogFormat
+}
+
+// Field - a named value attached to a log entry, e.g. the address of a peer or the ID of a message
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F - create a field of a log entry
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger - the logger of a subsystem of the gossiper. All loggers share the configuration set
+// with ConfigureLogging
+type Logger struct {
+	Subsystem string
+}
+
+// The loggers of the subsystems of the gossiper
+var (
+	GossipLog  = &Logger{Subsystem: "gossip"}
+	RoutingLog = &Logger{Subsystem: "routing"}
+	FilesLog   = &Logger{Subsystem: "files"}
+	SearchLog  = &Logger{Subsystem: "search"}
+	ChainLog   = &Logger{Subsystem: "chain"}
+	HTTPLog    = &Logger{Subsystem: "http"}
+)
+
+// entries not tied to a subsystem, e.g. errors handled with HandleErrorNonFatal
+var nodeLog = &Logger{Subsystem: "node"}
+
+type logConfig struct {
+	nodeName   string
+	format     LogFormat
+	level      LogLevel
+	out        io.Writer
+	ConfigLock sync.Mutex
+}
+
+// until configured, entries are written to stdout in the legacy format
+var logging = &logConfig{format: LogFormatLegacy, level: LevelInfo, out: os.Stdout}
+
+// ConfigureLogging - set the name of the node attached to every entry, the format entries are
+// written in, the least severe level written and where entries are written to
+func ConfigureLogging(nodeName string, format LogFormat, level LogLevel, out io.Writer) {
+	logging.ConfigLock.Lock()
+	defer logging.ConfigLock.Unlock()
+	logging.nodeName = nodeName
+	logging.format = format
+	logging.level = level
+	logging.out = out
+}
+
+// LogEnabled - true if entries of the given level are written
+func LogEnabled(level LogLevel) bool {
+	logging.ConfigLock.Lock()
+	defer logging.ConfigLock.Unlock()
+	return level >= logging.level
+}
+
+// LegacyEnabled - true if entries logged with Legacy at the given level are written
+func LegacyEnabled(level LogLevel) bool {
+	logging.ConfigLock.Lock()
+	defer logging.ConfigLock.Unlock()
+	return legacyLevel(level) >= logging.level
+}
+
+// the legacy format writes the course output whatever its level, as the test scripts expect it,
+// so debug entries with a course line are written at info level
+func legacyLevel(level LogLevel) LogLevel {
+	if logging.format == LogFormatLegacy && level < LevelInfo {
+		return LevelInfo
+	}
+	return level
+}
+
+// Debug - log an entry at debug level
+func (l *Logger) Debug(msg string, fields ...Field) {
+	l.write(LevelDebug, "", msg, fields)
+}
+
+// Info - log an entry at info level
+func (l *Logger) Info(msg string, fields ...Field) {
+	l.write(LevelInfo, "", msg, fields)
+}
+
+// Warn - log an entry at warn level
+func (l *Logger) Warn(msg string, fields ...Field) {
+	l.write(LevelWarn, "", msg, fields)
+}
+
+// Error - log an entry at error level
+func (l *Logger) Error(msg string, fields ...Field) {
+	l.write(LevelError, "", msg, fields)
+}
+
+// Legacy - log an entry which the legacy format writes as the given line(s) of the course output,
+// at least at info level
+func (l *Logger) Legacy(level LogLevel, line string, msg string, fields ...Field) {
+	logging.ConfigLock.Lock()
+	level = legacyLevel(level)
+	logging.ConfigLock.Unlock()
+	l.write(level, line, msg, fields)
+}
+
+func (l *Logger) write(level LogLevel, legacyLine string, msg string, fields []Field) {
+	logging.ConfigLock.Lock()
+	defer logging.ConfigLock.Unlock()
+	if level < logging.level {
+		return
+	}
+
+	var entry string
+	switch logging.format {
+	case LogFormatText:
+		entry = fmt.Sprintf("%s %-5s %-7s %s node=%s%s", time.Now().Format("2006-01-02T15:04:05.000Z07:00"),
+			strings.ToUpper(level.String()), l.Subsystem, msg, textValue(logging.nodeName), textFields(fields))
+	case LogFormatJSON:
+		entry = jsonEntry(level, l.Subsystem, logging.nodeName, msg, fields)
+	default:
+		entry = legacyLine
+		if strings.Compare(entry, "") == 0 {
+			// entries without a course line, e.g. errors, are written as plain text
+			entry = msg + textFields(fields)
+		}
+	}
+	fmt.Fprintln(logging.out, entry)
+}
+
+func textFields(fields []Field) string {
+	var buf bytes.Buffer
+	for _, field := range fields {
+		buf.WriteString(" " + field.Key + "=" + textValue(field.Value))
+	}
+	return buf.String()
+}
+
+// values with spaces, quotes or nothing in them are quoted so that the line can be split on spaces
+func textValue(value interface{}) string {
+	text := fmt.Sprint(value)
+	if strings.Compare(text, "") == 0 || strings.ContainsAny(text, " \t\r\n\"=") {
+		return strconv.Quote(text)
+	}
+	return text
+}
+
+// the fixed keys come first, followed by the fields in the order they were given
+func jsonEntry(level LogLevel, subsystem string, nodeName string, msg string, fields []Field) string {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	writeJSONField(&buf, "time", time.Now().Format(time.RFC3339Nano))
+	buf.WriteString(",")
+	writeJSONField(&buf, "level", level.String())
+	buf.WriteString(",")
+	writeJSONField(&buf, "subsystem", subsystem)
+	buf.WriteString(",")
+	writeJSONField(&buf, "node", nodeName)
+	buf.WriteString(",")
+	writeJSONField(&buf, "msg", msg)
+	for _, field := range fields {
+		buf.WriteString(",")
+		writeJSONField(&buf, field.Key, field.Value)
+	}
+	buf.WriteString("}")
+	return buf.String()
+}
+
+func writeJSONField(buf *bytes.Buffer, key string, value interface{}) {
+	keyJSON, _ := json.Marshal(key)
+	if err, ok := value.(error); ok {
+		value = err.Error()
+	}
+	valueJSON, err := json.Marshal(value)
+	if err != nil {
+		valueJSON, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(keyJSON)
+	buf.WriteString(":")
+	buf.Write(valueJSON)
+}
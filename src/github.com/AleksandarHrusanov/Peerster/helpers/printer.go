@@ -7,50 +7,49 @@ import (
 	"strings"
 )
 
+// The functions below log the events of the course output. The legacy log format writes them in
+// the fixed format expected by the test scripts; the trace of the rumor mongering of the first
+// homework is only written at debug level
+
 // PrintOutputSimpleMessageFromClient print on the console
 func PrintOutputSimpleMessageFromClient(messageText string, knownPeers []string) {
-	// Print first line
-	fmt.Printf("CLIENT MESSAGE %s\n", messageText)
-
-	// Print second line
 	stringPeers := CreateStringKnownPeers(knownPeers)
-	fmt.Printf("PEERS %s\n", stringPeers)
+	GossipLog.Legacy(LevelInfo, fmt.Sprintf("CLIENT MESSAGE %s\nPEERS %s", messageText, stringPeers),
+		"client message", F("contents", messageText), F("peers", stringPeers))
 }
 
 // PrintOutputSimpleMessageFromPeer print on the console
 func PrintOutputSimpleMessageFromPeer(messageText string, senderName string,
 	relayAddr string, knownPeers []string) {
-	// Print first line
-	fmt.Printf("SIMPLE MESSAGE origin %s from %s contents %s\n", senderName, relayAddr, messageText)
-
-	// Print second line
 	stringPeers := CreateStringKnownPeers(knownPeers)
-	fmt.Printf("PEERS %s\n", stringPeers)
+	GossipLog.Legacy(LevelDebug, fmt.Sprintf("SIMPLE MESSAGE origin %s from %s contents %s\nPEERS %s",
+		senderName, relayAddr, messageText, stringPeers),
+		"simple message received", F("origin", senderName), F("peer", relayAddr), F("contents", messageText),
+		F("peers", stringPeers))
 }
 
 // PrintOutputRumorFromPeer print on the console
 func PrintOutputRumorFromPeer(origin string, rumorFrom string, id uint32, text string, knownPeers []string) {
-	// Print first line
-	fmt.Printf("RUMOR origin %s from %s ID %d contents %s\n", origin, rumorFrom, id, text)
-
-	// Print second line
-	// stringPeers := CreateStringKnownPeers(knownPeers)
-	// fmt.Println("PEERS " + stringPeers)
+	GossipLog.Legacy(LevelDebug, fmt.Sprintf("RUMOR origin %s from %s ID %d contents %s", origin, rumorFrom, id, text),
+		"rumor received", F("origin", origin), F("peer", rumorFrom), F("id", id), F("contents", text))
 }
 
 // PrintOutputMongering print on the console
 func PrintOutputMongering(withAddr string) {
-	fmt.Printf("MONGERING with %s\n", withAddr)
+	GossipLog.Legacy(LevelDebug, fmt.Sprintf("MONGERING with %s", withAddr),
+		"mongering", F("peer", withAddr))
 }
 
 // PrintOutputInSyncWith print on the console
 func PrintOutputInSyncWith(addr string) {
-	fmt.Printf("IN SYNC WITH %s\n", addr)
+	GossipLog.Legacy(LevelDebug, fmt.Sprintf("IN SYNC WITH %s", addr),
+		"in sync", F("peer", addr))
 }
 
 // PrintOutputFlippedCoin print on the console
 func PrintOutputFlippedCoin(addr string) {
-	fmt.Printf("FLIPPED COIN sending rumor to %s\n", addr)
+	GossipLog.Legacy(LevelDebug, fmt.Sprintf("FLIPPED COIN sending rumor to %s", addr),
+		"flipped coin", F("peer", addr))
 }
 
 // ========================================================
@@ -61,28 +60,32 @@ func PrintOutputFlippedCoin(addr string) {
 
 //PrintOutputUpdatingDSDV print on the console
 func PrintOutputUpdatingDSDV(peerName string, ipPort string) {
-	fmt.Printf("DSDV %s %s\n", peerName, ipPort)
+	RoutingLog.Legacy(LevelInfo, fmt.Sprintf("DSDV %s %s", peerName, ipPort),
+		"route updated", F("origin", peerName), F("peer", ipPort))
 }
 
 //PrintOutputPrivateMessage print to console
 func PrintOutputPrivateMessage(origin string, hopLimit uint32, contents string) {
-	fmt.Printf("PRIVATE origin %s hop-limit %d contents %s\n", origin, hopLimit, contents)
+	GossipLog.Legacy(LevelInfo, fmt.Sprintf("PRIVATE origin %s hop-limit %d contents %s", origin, hopLimit, contents),
+		"private message received", F("origin", origin), F("hop_limit", hopLimit), F("contents", contents))
 }
 
 // PrintDownloadingMetafile print to console
 func PrintDownloadingMetafile(fname string, downloadFrom string) {
-	fmt.Printf("DOWNLOADING metafile of %s from %s\n", fname, downloadFrom)
+	FilesLog.Legacy(LevelInfo, fmt.Sprintf("DOWNLOADING metafile of %s from %s", fname, downloadFrom),
+		"downloading metafile", F("file", fname), F("origin", downloadFrom))
 }
 
 // PrintDownloadingChunk print to console
 func PrintDownloadingChunk(fname string, downloadFrom string, idx uint32) {
-	startFromOne := idx
-	fmt.Printf("DOWNLOADING %s chunk %d from %s\n", fname, startFromOne, downloadFrom)
+	FilesLog.Legacy(LevelInfo, fmt.Sprintf("DOWNLOADING %s chunk %d from %s", fname, idx, downloadFrom),
+		"downloading chunk", F("file", fname), F("chunk", idx), F("origin", downloadFrom))
 }
 
 // PrintReconstructedFile print to console
 func PrintReconstructedFile(fname string) {
-	fmt.Printf("RECONSTRUCTED file %s\n", fname)
+	FilesLog.Legacy(LevelInfo, fmt.Sprintf("RECONSTRUCTED file %s", fname),
+		"file reconstructed", F("file", fname))
 }
 
 // ========================================================
@@ -100,19 +103,23 @@ func PrintFileMatchFound(fname string, peer string, metahash string, chunks []ui
 		chs = append(chs, text)
 	}
 	res := strings.Join(chs, ",")
-	fmt.Printf("FOUND match %s at %s metafile=%s chunks=%s\n", fname, peer, metahash, res)
+	SearchLog.Legacy(LevelInfo, fmt.Sprintf("FOUND match %s at %s metafile=%s chunks=%s", fname, peer, metahash, res),
+		"match found", F("file", fname), F("origin", peer), F("metahash", metahash), F("chunks", res))
 }
 
 func PrintSearchFinished() {
-	fmt.Println("SEARCH FINISHED")
+	SearchLog.Legacy(LevelInfo, "SEARCH FINISHED", "search finished")
 }
 
 func PrintUnconfirmedGossip(origin, name, metahash string, id uint32, size int64) {
-	fmt.Printf("UNCONFIRMED GOSSIP origin %s ID %d file name %s size %d metahash %s\n", origin, id, name, size, metahash)
+	ChainLog.Legacy(LevelInfo, fmt.Sprintf("UNCONFIRMED GOSSIP origin %s ID %d file name %s size %d metahash %s",
+		origin, id, name, size, metahash),
+		"unconfirmed gossip", F("origin", origin), F("id", id), F("file", name), F("size", size), F("metahash", metahash))
 }
 
 func PrintSendingAck(origin string, id uint32) {
-	fmt.Printf("SENDING ACK origin %s ID %d\n", origin, id)
+	ChainLog.Legacy(LevelInfo, fmt.Sprintf("SENDING ACK origin %s ID %d", origin, id),
+		"sending ack", F("origin", origin), F("id", id))
 }
 
 func PrintReBroadcastId(id uint32, witnessesMap map[string]bool) {
@@ -120,9 +127,35 @@ func PrintReBroadcastId(id uint32, witnessesMap map[string]bool) {
 	for w, _ := range witnessesMap {
 		witnessesSlice = append(witnessesSlice, w)
 	}
-	fmt.Printf("RE-BROADCAST ID %d WITNESSES %s\n", id, strings.Join(witnessesSlice, ","))
+	witnesses := strings.Join(witnessesSlice, ",")
+	ChainLog.Legacy(LevelInfo, fmt.Sprintf("RE-BROADCAST ID %d WITNESSES %s", id, witnesses),
+		"re-broadcast", F("id", id), F("witnesses", witnesses))
 }
 
 func PrintConfirmedGossip(origin, name, metahash string, id uint32, size int64) {
-	fmt.Printf("CONFIRMED GOSSIP origin %s ID %d file name %s size %d metahash %s\n", origin, id, name, size, metahash)
+	ChainLog.Legacy(LevelInfo, fmt.Sprintf("CONFIRMED GOSSIP origin %s ID %d file name %s size %d metahash %s",
+		origin, id, name, size, metahash),
+		"confirmed gossip", F("origin", origin), F("id", id), F("file", name), F("size", size), F("metahash", metahash))
+}
+
+func PrintForkDetected(prevHash, blockHash string) {
+	ChainLog.Legacy(LevelInfo, fmt.Sprintf("FORK detected at %s new branch %s", prevHash, blockHash),
+		"fork detected", F("prev_hash", prevHash), F("block", blockHash))
+}
+
+func PrintChainReorg(oldHead, newHead string, rolledBack, applied uint64) {
+	ChainLog.Legacy(LevelInfo, fmt.Sprintf("FORK-REORG from %s to %s rolled back %d applied %d",
+		oldHead, newHead, rolledBack, applied),
+		"chain reorganized", F("old_head", oldHead), F("new_head", newHead), F("rolled_back", rolledBack),
+		F("applied", applied))
+}
+
+func PrintBlockRejected(blockHash string, reason string) {
+	ChainLog.Legacy(LevelWarn, fmt.Sprintf("REJECTED block %s: %s", blockHash, reason),
+		"block rejected", F("block", blockHash), F("reason", reason))
+}
+
+func PrintFoundBlock(blockHash string) {
+	ChainLog.Legacy(LevelInfo, fmt.Sprintf("FOUND-BLOCK %s", blockHash),
+		"found block", F("block", blockHash))
 }
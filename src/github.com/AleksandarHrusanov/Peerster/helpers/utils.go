@@ -1,9 +1,10 @@
 package helpers
 
 import (
-	"fmt"
 	"math/rand"
 	"net"
+	"net/netip"
+	"strconv"
 	"strings"
 )
 
@@ -17,7 +18,7 @@ func HandleErrorFatal(err error) {
 // HandleErrorNonFatal Handle an error without stopping the program
 func HandleErrorNonFatal(err error) {
 	if err != nil {
-		fmt.Println(err)
+		nodeLog.Error(err.Error())
 	}
 }
 
@@ -85,22 +86,45 @@ func PickRandomInSliceDifferentFrom(sx []string, notThisOne string) string {
 	return currentAddr
 }
 
-// IPAddressIsValid Check if a given ip4 address is valid
+// IPAddressIsValid Check if a given address is a valid IPv4 or IPv6 ip:port, e.g. 127.0.0.1:5000 or [::1]:5000.
+// Host names are not valid addresses, see IsPeerName
 func IPAddressIsValid(address string) bool {
-	_, err := net.ResolveUDPAddr("udp4", address)
-	return err == nil
+	_, ok := NormalizeAddress(address)
+	return ok
+}
+
+// NormalizeAddress Write an ip:port address the way the addresses of the packets received are written,
+// so that the same address is always the same string: IPv6 in its shortest form within brackets and
+// IPv4-mapped IPv6 as IPv4
+func NormalizeAddress(address string) (string, bool) {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil || addrPort.Port() == 0 {
+		return "", false
+	}
+	return netip.AddrPortFrom(addrPort.Addr().Unmap(), addrPort.Port()).String(), true
+}
+
+// IsPeerName Check if a given address is a host name and a port, e.g. node-b.example.com:5000, which
+// has to be resolved to reach the peer
+func IsPeerName(address string) bool {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || strings.Compare(host, "") == 0 || net.ParseIP(host) != nil {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n < 65536
 }
 
 // VerifyRemoveDuplicateAddrInSlice Remove duplicate addresses in a slice of strings.
-// It also removes all invalid ip4 addresses from the list
+// It also removes all invalid addresses from the list and normalizes the others
 func VerifyRemoveDuplicateAddrInSlice(sx []string) []string {
 	newList := make([]string, 0)
 	mapElem := make(map[string]bool)
 	for _, address := range sx {
-		_, present := mapElem[address]
-		if !present && IPAddressIsValid(address) {
-			mapElem[address] = true
-			newList = append(newList, address)
+		normalized, valid := NormalizeAddress(address)
+		if _, present := mapElem[normalized]; !present && valid {
+			mapElem[normalized] = true
+			newList = append(newList, normalized)
 		}
 	}
 	return newList
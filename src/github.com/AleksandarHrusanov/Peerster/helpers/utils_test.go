@@ -0,0 +1,63 @@
+package helpers
+
+import (
+	"reflect"
+	"testing"
+)
+
+// every spelling of an address a peer may be given as, or received from, is the same string
+func TestNormalizeAddressSpellings(t *testing.T) {
+	spellings := map[string][]string{
+		"127.0.0.1:5000":      {"127.0.0.1:5000", "[::ffff:127.0.0.1]:5000", "[::FFFF:7f00:1]:5000"},
+		"[::1]:5000":          {"[::1]:5000", "[0:0:0:0:0:0:0:1]:5000", "[0::1]:5000"},
+		"[2001:db8::1]:5000":  {"[2001:DB8::1]:5000", "[2001:0db8:0000::0001]:5000"},
+		"[fe80::1%eth0]:5000": {"[fe80::1%eth0]:5000", "[FE80::1%eth0]:5000"},
+	}
+	for want, addresses := range spellings {
+		for _, address := range addresses {
+			if got, valid := NormalizeAddress(address); !valid || got != want {
+				t.Errorf("%s: got %q, %v, want %q", address, got, valid, want)
+			}
+		}
+	}
+}
+
+func TestNormalizeAddressRejects(t *testing.T) {
+	// host names are resolved elsewhere, see IsPeerName
+	for _, address := range []string{"127.0.0.1", "127.0.0.1:0", "127.0.0.1:65536", "::1:5000", "[::1]",
+		"node-b:5000", "localhost:5000", "", ":5000", "127.0.0.1:-1", "1.2.3:5000"} {
+		if got, valid := NormalizeAddress(address); valid {
+			t.Errorf("%q accepted as %q", address, got)
+		}
+	}
+}
+
+func TestIsPeerName(t *testing.T) {
+	tests := map[string]bool{
+		"node-b.example.com:5000": true,
+		"localhost:5000":          true,
+		"node_b:1":                true,
+		"127.0.0.1:5000":          false, // an address, not a name
+		"[::1]:5000":              false,
+		"node-b":                  false,
+		":5000":                   false,
+		"node-b:0":                false,
+		"node-b:65536":            false,
+		"node-b:http":             false,
+	}
+	for address, want := range tests {
+		if got := IsPeerName(address); got != want {
+			t.Errorf("%s: got %v, want %v", address, got, want)
+		}
+	}
+}
+
+func TestVerifyRemoveDuplicateAddrInSlice(t *testing.T) {
+	// the first spelling of a peer keeps its place, in its normalized form
+	got := VerifyRemoveDuplicateAddrInSlice([]string{"[::ffff:127.0.0.1]:5001", "[::1]:5002", "127.0.0.1:5001",
+		"node-b:5003", "[0::1]:5002", "127.0.0.1:5003"})
+	want := []string{"127.0.0.1:5001", "[::1]:5002", "127.0.0.1:5003"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
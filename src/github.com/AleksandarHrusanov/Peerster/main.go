@@ -1,9 +1,20 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/AleksandarHrusanov/Peerster/blockchain"
+	"github.com/AleksandarHrusanov/Peerster/config"
 	"github.com/AleksandarHrusanov/Peerster/core"
 	"github.com/AleksandarHrusanov/Peerster/gossiper"
 	"github.com/AleksandarHrusanov/Peerster/helpers"
@@ -11,45 +22,280 @@ import (
 )
 
 func main() {
-	UIPortPtr := flag.String("UIPort", "8080",
+	cfg := config.Default()
+	configPtr := flag.String("config", os.Getenv(config.FileEnv),
+		"TOML file with the configuration of the node (default $"+config.FileEnv+"); "+config.EnvPrefix+
+			"<SECTION>_<KEY> environment variables override it, and flags override both")
+	printConfigPtr := flag.Bool("print-config", false,
+		"Print the configuration the node would run with and exit")
+	flag.StringVar(&cfg.API.Port, "UIPort", cfg.API.Port,
 		"Port for the UI client")
-	gossipAddrPtr := flag.String("gossipAddr", "127.0.0.1:5000",
-		"ip:port for the gossiper")
-	namePtr := flag.String("name", "", "name of the gossiper")
-	peersPtr := flag.String("peers", "",
-		"comma separated list of peers of the form ip:port")
-	simplePtr := flag.Bool("simple", false,
+	flag.StringVar(&cfg.Node.GossipAddr, "gossipAddr", cfg.Node.GossipAddr,
+		"ip:port for the gossiper, IPv4 or IPv6, e.g. [::1]:5000; [::]:5000 listens on both")
+	flag.StringVar(&cfg.Node.Name, "name", cfg.Node.Name, "name of the gossiper")
+	flag.Var((*listFlag)(&cfg.Peers.Known), "peers",
+		"comma separated list of peers of the form ip:port or host:port")
+	flag.StringVar(&cfg.Peers.Seeds, "seeds", cfg.Peers.Seeds,
+		"file with the addresses of seed nodes to bootstrap from, one ip:port or host:port per line")
+	flag.StringVar(&cfg.Peers.Discovery, "discovery", cfg.Peers.Discovery,
+		"multicast ip:port on which nodes of the local network announce themselves and discover each other")
+	flag.BoolVar(&cfg.Peers.Exchange, "peerExchange", cfg.Peers.Exchange,
+		"Ask peers for some of their peers while the partial view has room")
+	flag.BoolVar(&cfg.Node.Simple, "simple", cfg.Node.Simple,
 		"run gossiper in simple broadcast mode")
-	antiEntropyPtr := flag.Int("antiEntropy", 10,
+	flag.IntVar(&cfg.Timers.AntiEntropy, "antiEntropy", cfg.Timers.AntiEntropy,
 		"Use the given timeout in seconds for anti-entropy.")
-	routeRumorPtr := flag.Int("rtimer", 0,
+	flag.IntVar(&cfg.Timers.Probe, "probe", cfg.Timers.Probe,
+		"Use the given time period in seconds to probe a peer for failure detection. If 0, peers are never evicted.")
+	flag.IntVar(&cfg.Peers.ViewSize, "viewSize", cfg.Peers.ViewSize,
+		"Know at most the given number of peers directly, exchanging them with other peers. If 0, every peer is known.")
+	flag.IntVar(&cfg.Timers.RouteRumor, "rtimer", cfg.Timers.RouteRumor,
 		"Use the given time period in seconds to send a route rumor.")
-	hw3ex2Ptr := flag.Bool("hw3ex2", false,
+	flag.Var(&consensusFlag{mode: &cfg.Consensus.Mode, value: config.ConsensusHw3ex2}, "hw3ex2",
 		"Run gossiper in mode for hw3ex2")
-	nPtr := flag.Int("N", 1,
+	flag.IntVar(&cfg.Consensus.Nodes, "N", cfg.Consensus.Nodes,
 		"Number of nodes in the network, including current peer.")
-	stubbornTimeoutPtr := flag.Int("stubbornTimeout", 5,
+	flag.IntVar(&cfg.Timers.Stubborn, "stubbornTimeout", cfg.Timers.Stubborn,
 		"Resend tlc message if no majority of acks before that many seconds.")
-	hopLimitPtr := flag.Int("hopLimit", 10,
+	flag.IntVar(&cfg.HopLimits.TLCAck, "hopLimit", cfg.HopLimits.TLCAck,
 		"Hop limit for TLCAck")
+	flag.Var(&consensusFlag{mode: &cfg.Consensus.Mode, value: config.ConsensusPoW}, "pow",
+		"Run the naming chain with proof-of-work consensus instead of hw3ex2")
+	flag.IntVar(&cfg.Consensus.PoWDifficulty, "powDifficulty", cfg.Consensus.PoWDifficulty,
+		"Number of leading zero bits required in the hash of a mined block")
+	flag.BoolVar(&cfg.Consensus.Light, "light", cfg.Consensus.Light,
+		"Keep only block headers and verify name lookups with Merkle proofs from full nodes")
+	flag.StringVar(&cfg.API.Addr, "UIAddr", cfg.API.Addr,
+		"Host or IP address the UI/HTTP API and the client messages listen on")
+	flag.BoolVar(&cfg.API.Auth, "auth", cfg.API.Auth,
+		"Require an API token from the tokens file for every UI/HTTP API request")
+	flag.StringVar(&cfg.API.Tokens, "tokens", cfg.API.Tokens,
+		"File with the API tokens")
+	flag.StringVar(&cfg.API.TLSCert, "tlsCert", cfg.API.TLSCert,
+		"Certificate to serve the UI/HTTP API over HTTPS with")
+	flag.StringVar(&cfg.API.TLSKey, "tlsKey", cfg.API.TLSKey,
+		"Private key of the certificate given with tlsCert")
+	flag.StringVar(&cfg.API.ClientCA, "clientCA", cfg.API.ClientCA,
+		"Accept client certificates signed by this CA (used in combination with tlsCert)")
+	addTokenPtr := flag.String("addToken", "",
+		"Create an API token with the given name in the tokens file, print it and exit")
+	tokenScopePtr := flag.String("tokenScope", "read",
+		"Scope of the token created with addToken: read or full")
+	revokeTokenPtr := flag.String("revokeToken", "",
+		"Revoke the API token with the given name and exit")
+	listTokensPtr := flag.Bool("listTokens", false,
+		"List the API tokens in the tokens file and exit")
+	flag.BoolVar(&cfg.Node.Stream, "stream", cfg.Node.Stream,
+		"Send data requests and replies over TCP to the peers which accept it, on the port of their gossip address")
+	flag.BoolVar(&cfg.Security.Encrypt, "encrypt", cfg.Security.Encrypt,
+		"Encrypt and authenticate the traffic with peers after a handshake using the node keys")
+	flag.StringVar(&cfg.Security.NodeKey, "nodeKey", cfg.Security.NodeKey,
+		"File holding the key of the node, created if missing (default <keys folder><name>.key)")
+	flag.BoolVar(&cfg.Security.AcceptPlaintext, "acceptPlaintext", cfg.Security.AcceptPlaintext,
+		"Accept plaintext from peers which do not encrypt their traffic, and answer them in plaintext")
+	flag.BoolVar(&cfg.Security.RateLimit, "rateLimit", cfg.Security.RateLimit,
+		"Drop the packets of peers sending more of a type than its rate limit, and ban the peers which keep on")
+	flag.IntVar(&cfg.Timers.BanDuration, "banDuration", cfg.Timers.BanDuration,
+		"Seconds the packets of a banned peer are dropped for (used in combination with rateLimit)")
+	flag.StringVar(&cfg.Log.Format, "logFormat", cfg.Log.Format,
+		"Format of the log: legacy (the course output), text or json")
+	flag.StringVar(&cfg.Log.Level, "logLevel", cfg.Log.Level,
+		"Least severe log level written: debug, info, warn or error")
 	flag.Parse()
 
-	// Check that the gossiper has a name
-	if strings.Compare(*namePtr, "") == 0 {
-		panic("Peerster must have a name!")
+	// The configuration file and the environment are overridden by the flags: load them over the
+	// flags, then parse the flags again
+	if err := cfg.Load(*configPtr); err != nil {
+		log.Fatal(err)
+	}
+	flag.Parse()
+
+	if manageTokens(cfg.API.Tokens, *addTokenPtr, *tokenScopePtr, *revokeTokenPtr, *listTokensPtr) {
+		return
 	}
 
-	// Remove eventual duplicate addresses
-	knownPeers := gossiper.CreateSliceKnownPeers(*peersPtr)
-	knownPeers = helpers.VerifyRemoveDuplicateAddrInSlice(knownPeers)
+	if flagIsSet("hw3ex2") && flagIsSet("pow") {
+		panic("Peerster cannot run both hw3ex2 and proof-of-work consensus!")
+	}
+
+	if *printConfigPtr {
+		if err := cfg.Write(os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
+	if *printConfigPtr {
+		return
+	}
+
+	logFormat, _ := helpers.ParseLogFormat(cfg.Log.Format)
+	logLevel, _ := helpers.ParseLogLevel(cfg.Log.Level)
+	helpers.ConfigureLogging(cfg.Node.Name, logFormat, logLevel, os.Stdout)
+
+	// Remove eventual duplicate addresses; the peers given by host name are resolved once the gossiper runs
+	peerNames := make([]string, 0)
+	for _, peer := range cfg.Peers.Known {
+		if helpers.IsPeerName(peer) {
+			peerNames = append(peerNames, peer)
+		}
+	}
+	knownPeers := helpers.VerifyRemoveDuplicateAddrInSlice(cfg.Peers.Known)
+	seeds := make([]string, 0)
+	if strings.Compare(cfg.Peers.Seeds, "") != 0 {
+		var err error
+		seeds, err = gossiper.ReadSeedsFile(cfg.Peers.Seeds)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// Create and start gossiper
-	gossiperPtr := core.NewGossiper(*gossipAddrPtr,
-		*namePtr,
+	gossiperPtr := core.NewGossiper(cfg.Node.GossipAddr,
+		cfg.Node.Name,
 		knownPeers,
-		*UIPortPtr)
+		cfg.API.Addr,
+		cfg.API.Port)
+	gossiperPtr.Settings = cfg.Settings()
+	hw3ex2 := strings.Compare(cfg.Consensus.Mode, config.ConsensusHw3ex2) == 0
+	pow := strings.Compare(cfg.Consensus.Mode, config.ConsensusPoW) == 0
+	gossiperPtr.LightClient = cfg.Consensus.Light
+	gossiperPtr.SimpleMode = cfg.Node.Simple
+	gossiperPtr.Naming = hw3ex2 || pow
+	gossiperPtr.StubbornTimeout = cfg.Timers.Stubborn
+	if cfg.Node.Stream {
+		gossiperPtr.Streams = core.NewSafeStreamTransport()
+	}
+	if cfg.Security.Encrypt {
+		nodeKeyFile := cfg.Security.NodeKey
+		if strings.Compare(nodeKeyFile, "") == 0 {
+			nodeKeyFile = filepath.Join(cfg.Folders.Keys, cfg.Node.Name+".key")
+		}
+		nodeKey, err := core.LoadOrCreateNodeKey(nodeKeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		gossiperPtr.Channels = core.NewSafeSecureChannels(nodeKey, cfg.Security.AcceptPlaintext)
+	}
+	if cfg.Peers.ViewSize > 0 {
+		gossiperPtr.View = core.NewSafePeerView(cfg.Peers.ViewSize)
+	}
+	if cfg.Security.RateLimit {
+		gossiperPtr.Limits = core.NewSafePeerLimits(time.Duration(cfg.Timers.BanDuration) * time.Second)
+	}
+	if pow {
+		gossiperPtr.Mining = blockchain.CreateMiningState(cfg.Consensus.PoWDifficulty)
+	}
+	if err := gossiper.StartDiscovery(gossiperPtr, seeds, peerNames, cfg.Peers.Discovery,
+		cfg.Peers.Exchange && !cfg.Node.Simple); err != nil {
+		log.Fatal(err)
+	}
+
+	// Stop gracefully on the first SIGINT or SIGTERM; a second one kills the node at once
+	ctx, stopSignals := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	node := gossiper.NewNode(gossiperPtr, gossiper.NodeOptions{Simple: cfg.Node.Simple,
+		AntiEntropy: cfg.Timers.AntiEntropy, Probe: cfg.Timers.Probe, RouteRumor: cfg.Timers.RouteRumor,
+		Hw3ex2: hw3ex2, N: cfg.Consensus.Nodes, AckHopLimit: uint32(cfg.HopLimits.TLCAck)})
 
 	// Start server
-	go server.StartServer(gossiperPtr)
-	gossiper.StartGossiper(gossiperPtr, simplePtr, antiEntropyPtr, routeRumorPtr, hw3ex2Ptr, nPtr, stubbornTimeoutPtr, hopLimitPtr)
+	serverConfig := server.Config{Host: cfg.API.Addr, Auth: cfg.API.Auth, TokensFile: cfg.API.Tokens,
+		TLSCert: cfg.API.TLSCert, TLSKey: cfg.API.TLSKey, ClientCA: cfg.API.ClientCA}
+	node.Run(func() { server.StartServer(gossiperPtr, serverConfig) })
+
+	if err := node.Start(ctx); err != nil {
+		log.Fatal(err)
+	}
+	<-ctx.Done()
+	stopSignals()
+	node.Wait()
+}
+
+// listFlag is a comma separated flag setting a list of the configuration
+type listFlag []string
+
+func (l *listFlag) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = gossiper.CreateSliceKnownPeers(value)
+	return nil
+}
+
+// consensusFlag is a boolean flag selecting a consensus mode of the naming chain
+type consensusFlag struct {
+	mode  *string
+	value string
+}
+
+func (c *consensusFlag) String() string {
+	if c == nil || c.mode == nil {
+		return "false"
+	}
+	return strconv.FormatBool(strings.Compare(*c.mode, c.value) == 0)
+}
+
+func (c *consensusFlag) Set(value string) error {
+	set, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	if set {
+		*c.mode = c.value
+	} else if strings.Compare(*c.mode, c.value) == 0 {
+		*c.mode = config.ConsensusNone
+	}
+	return nil
+}
+
+func (c *consensusFlag) IsBoolFlag() bool {
+	return true
+}
+
+// flagIsSet returns true if the flag was given on the command line
+func flagIsSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if strings.Compare(f.Name, name) == 0 {
+			set = true
+		}
+	})
+	return set
+}
+
+// manageTokens runs the token management command given on the command line, if any, and
+// returns true if one was run
+func manageTokens(tokensFile string, addToken string, tokenScope string, revokeToken string, listTokens bool) bool {
+	switch {
+	case strings.Compare(addToken, "") != 0:
+		scope, err := server.ParseScope(tokenScope)
+		if err != nil {
+			log.Fatal(err)
+		}
+		secret, err := server.AddToken(tokensFile, addToken, scope)
+		if err != nil {
+			log.Fatal("Unable to create token: ", err)
+		}
+		fmt.Println(secret)
+	case strings.Compare(revokeToken, "") != 0:
+		if err := server.RevokeToken(tokensFile, revokeToken); err != nil {
+			log.Fatal("Unable to revoke token: ", err)
+		}
+		fmt.Println("REVOKED token " + revokeToken)
+	case listTokens:
+		tokens, err := server.LoadTokens(tokensFile)
+		if err != nil {
+			log.Fatal("Unable to read tokens: ", err)
+		}
+		for _, t := range tokens {
+			fmt.Printf("TOKEN %s scope %s created %s\n", t.Name, t.Scope, t.Created.Format(time.RFC3339))
+		}
+	default:
+		return false
+	}
+	return true
 }
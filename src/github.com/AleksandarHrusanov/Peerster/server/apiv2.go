@@ -0,0 +1,661 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/blockchain"
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/core"
+	"github.com/AleksandarHrusanov/Peerster/filehandling"
+	"github.com/AleksandarHrusanov/Peerster/gossiper"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+	"github.com/gorilla/mux"
+)
+
+// apiV2Prefix - the path every v2 endpoint is served under
+const apiV2Prefix = "/api/v2"
+
+// maxRequestBodySize - the largest JSON request body accepted by the v2 API
+const maxRequestBodySize = 1 << 20
+
+// =====================================================================
+//                     Request and response types
+// =====================================================================
+
+type messageRequest struct {
+	Text string `json:"text"`
+}
+
+type privateMessageRequest struct {
+	Destination string `json:"destination"`
+	Text        string `json:"text"`
+}
+
+type shareRequest struct {
+	FileName string `json:"fileName"`
+}
+
+// A download is either explicit (destination and metahash), implicit from a search
+// match (metahash only) or by name through the chain (name only)
+type downloadRequest struct {
+	FileName    string `json:"fileName"`
+	Destination string `json:"destination"`
+	Metahash    string `json:"metahash"`
+	Name        string `json:"name"`
+}
+
+type searchRequest struct {
+	Keywords []string `json:"keywords"`
+	Budget   uint64   `json:"budget"`
+}
+
+type peerRequest struct {
+	Address string `json:"address"`
+}
+
+type idResponse struct {
+	Name string `json:"name"`
+}
+
+type rumorResponse struct {
+	Origin string `json:"origin"`
+	ID     uint32 `json:"id"`
+	Text   string `json:"text"`
+}
+
+type acceptedResponse struct {
+	Status string `json:"status"`
+}
+
+type privateMessageResponse struct {
+	Origin      string `json:"origin"`
+	Destination string `json:"destination"`
+	Text        string `json:"text"`
+	HopLimit    uint32 `json:"hopLimit"`
+}
+
+type sharedFileResponse struct {
+	FileName string `json:"fileName"`
+	Metahash string `json:"metahash"`
+	Size     int64  `json:"size,omitempty"`
+}
+
+type downloadResponse struct {
+	FileName    string `json:"fileName"`
+	Destination string `json:"destination,omitempty"`
+	Metahash    string `json:"metahash"`
+	Status      string `json:"status"`
+}
+
+type peerTransferResponse struct {
+	Peer    string `json:"peer"`
+	Chunks  int    `json:"chunks"`
+	Bytes   int64  `json:"bytes"`
+	Retries int    `json:"retries"`
+}
+
+// ETASeconds is -1 while the remaining time cannot be estimated, e.g. before the metafile arrived
+type downloadStatsResponse struct {
+	FileName         string                 `json:"fileName"`
+	Metahash         string                 `json:"metahash"`
+	Finished         bool                   `json:"finished"`
+	Failed           bool                   `json:"failed"`
+	ChunksDownloaded int                    `json:"chunksDownloaded"`
+	ChunkCount       int                    `json:"chunkCount"`
+	Bytes            int64                  `json:"bytes"`
+	BytesPerSecond   float64                `json:"bytesPerSecond"`
+	ETASeconds       float64                `json:"etaSeconds"`
+	StartedAt        time.Time              `json:"startedAt"`
+	FinishedAt       *time.Time             `json:"finishedAt,omitempty"`
+	Peers            []peerTransferResponse `json:"peers"`
+}
+
+type searchMatchResponse struct {
+	FileName     string `json:"fileName"`
+	Metahash     string `json:"metahash"`
+	ChunkCount   uint64 `json:"chunkCount"`
+	ChunksFound  int    `json:"chunksFound"`
+	FullyMatched bool   `json:"fullyMatched"`
+}
+
+type blockResponse struct {
+	Hash     string `json:"hash"`
+	PrevHash string `json:"prevHash"`
+	Height   uint64 `json:"height"`
+	Origin   string `json:"origin"`
+	TxType   string `json:"txType"`
+	Name     string `json:"name"`
+	Metahash string `json:"metahash,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	NewOwner string `json:"newOwner,omitempty"`
+}
+
+type chainResponse struct {
+	Head   string            `json:"head"`
+	Blocks []blockResponse   `json:"blocks"`
+	Reorgs []core.ReorgEvent `json:"reorgs"`
+}
+
+type nameHistoryResponse struct {
+	TxType   string `json:"txType"`
+	Origin   string `json:"origin"`
+	Metahash string `json:"metahash,omitempty"`
+	Size     int64  `json:"size,omitempty"`
+	NewOwner string `json:"newOwner,omitempty"`
+}
+
+type nameResponse struct {
+	Name     string                `json:"name"`
+	Owner    string                `json:"owner"`
+	Holder   string                `json:"holder"`
+	Metahash string                `json:"metahash"`
+	Size     int64                 `json:"size"`
+	Revoked  bool                  `json:"revoked"`
+	History  []nameHistoryResponse `json:"history"`
+}
+
+type resolveResponse struct {
+	Name     string `json:"name"`
+	Metahash string `json:"metahash"`
+	Holder   string `json:"holder"`
+}
+
+type routeResponse struct {
+	Origin  string `json:"origin"`
+	NextHop string `json:"nextHop"`
+}
+
+type banResponse struct {
+	Address string    `json:"address"`
+	Until   time.Time `json:"until"`
+}
+
+type memberResponse struct {
+	Address    string    `json:"address"`
+	Identities []string  `json:"identities"` // node key and host name of the peer, if known
+	State      string    `json:"state"`
+	StateSince time.Time `json:"stateSince"`
+	LastHeard  time.Time `json:"lastHeard"` // zero if no packet was received from the peer yet
+}
+
+type statusResponse struct {
+	Name            string `json:"name"`
+	GossipAddress   string `json:"gossipAddress"`
+	Mode            string `json:"mode"`
+	LightClient     bool   `json:"lightClient"`
+	Peers           int    `json:"peers"`
+	Origins         int    `json:"origins"`
+	Rumors          int    `json:"rumors"`
+	SharedFiles     int    `json:"sharedFiles"`
+	ActiveDownloads int    `json:"activeDownloads"`
+	ChainHeight     int    `json:"chainHeight"`
+	NodeKey         string `json:"nodeKey,omitempty"` // empty if the traffic with peers is not encrypted
+}
+
+// =====================================================================
+//                              Errors
+// =====================================================================
+
+// apiError - an error returned to the client as a JSON body with the matching HTTP status
+type apiError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func badRequest(format string, args ...interface{}) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Code: "invalid_request", Message: fmt.Sprintf(format, args...)}
+}
+
+func notFound(format string, args ...interface{}) *apiError {
+	return &apiError{Status: http.StatusNotFound, Code: "not_found", Message: fmt.Sprintf(format, args...)}
+}
+
+// maps the errors returned by the gossiper to API errors
+func serviceError(err error) *apiError {
+	status, code := http.StatusInternalServerError, "internal_error"
+	switch err {
+	case gossiper.ErrEmptyMessage, gossiper.ErrInvalidFileName, gossiper.ErrInvalidMetahash,
+		gossiper.ErrNoKeywords, blockchain.ErrInvalidTx:
+		status, code = http.StatusBadRequest, "invalid_request"
+	case gossiper.ErrUnknownDestination:
+		status, code = http.StatusNotFound, "unknown_destination"
+	case core.ErrUnknownPeer:
+		status, code = http.StatusNotFound, "unknown_peer"
+	case core.ErrUnknownPeerKey:
+		status, code = http.StatusNotFound, "unknown_peer_key"
+	case gossiper.ErrFileNotFound:
+		status, code = http.StatusNotFound, "file_not_found"
+	case filehandling.ErrUnknownFile:
+		status, code = http.StatusNotFound, "unknown_file"
+	case gossiper.ErrNoSearchMatch:
+		status, code = http.StatusNotFound, "no_search_match"
+	case blockchain.ErrNameNotRegistered:
+		status, code = http.StatusNotFound, "name_not_registered"
+	case blockchain.ErrNotOwner:
+		status, code = http.StatusForbidden, "not_owner"
+	case blockchain.ErrNameUnconfirmed:
+		status, code = http.StatusConflict, "name_unconfirmed"
+	case blockchain.ErrNameTaken:
+		status, code = http.StatusConflict, "name_taken"
+	case gossiper.ErrAlreadyShared:
+		status, code = http.StatusConflict, "already_shared"
+	case gossiper.ErrNamingDisabled, gossiper.ErrLightClient:
+		status, code = http.StatusConflict, "unsupported_by_node"
+	case blockchain.ErrNameRevoked:
+		status, code = http.StatusGone, "name_revoked"
+	case blockchain.ErrNoFullNode, blockchain.ErrNoNameProof:
+		status, code = http.StatusServiceUnavailable, "name_unavailable"
+	case filehandling.ErrChunkTimeout:
+		status, code = http.StatusGatewayTimeout, "chunk_timeout"
+	}
+	return &apiError{Status: status, Code: code, Message: err.Error()}
+}
+
+// =====================================================================
+//                             Plumbing
+// =====================================================================
+
+// apiV2Handler - a handler returning the status and body of a successful response, or an error
+type apiV2Handler func(r *http.Request) (int, interface{}, error)
+
+func (h apiV2Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status, body, err := h(r)
+	if err != nil {
+		apiErr, ok := err.(*apiError)
+		if !ok {
+			apiErr = serviceError(err)
+		}
+		writeAPIError(w, apiErr)
+		return
+	}
+	writeJSON(w, status, body)
+}
+
+func writeAPIError(w http.ResponseWriter, apiErr *apiError) {
+	writeJSON(w, apiErr.Status, struct {
+		Error *apiError `json:"error"`
+	}{apiErr})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	bodyJSON, err := json.Marshal(body)
+	if err != nil {
+		helpers.HandleErrorNonFatal(err)
+		http.Error(w, `{"error":{"code":"internal_error","message":"cannot encode response"}}`,
+			http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bodyJSON)
+}
+
+// decodes the JSON body of the request into v, rejecting unknown fields and oversized bodies
+func decodeJSONBody(r *http.Request, v interface{}) error {
+	decoder := json.NewDecoder(io.LimitReader(r.Body, maxRequestBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return badRequest("malformed JSON body: %s", err)
+	}
+	return nil
+}
+
+func decodeMetahash(metahash string) ([]byte, error) {
+	decoded, err := hex.DecodeString(metahash)
+	if err != nil || len(decoded) != constants.HashSize {
+		return nil, badRequest("metahash must be %d hex encoded bytes", constants.HashSize)
+	}
+	return decoded, nil
+}
+
+func registerAPIv2(router *mux.Router, m *handlerMaker) {
+	router.Handle(apiV2Prefix+"/id", apiV2Handler(m.getIDv2)).Methods(http.MethodGet)
+	router.Handle(apiV2Prefix+"/messages", apiV2Handler(m.getMessagesv2)).Methods(http.MethodGet)
+	router.Handle(apiV2Prefix+"/messages", apiV2Handler(m.postMessagev2)).Methods(http.MethodPost)
+	router.Handle(apiV2Prefix+"/private", apiV2Handler(m.getPrivateMessagesv2)).Methods(http.MethodGet)
+	router.Handle(apiV2Prefix+"/private", apiV2Handler(m.postPrivateMessagev2)).Methods(http.MethodPost)
+	router.Handle(apiV2Prefix+"/files", apiV2Handler(m.getFilesv2)).Methods(http.MethodGet)
+	router.Handle(apiV2Prefix+"/files", apiV2Handler(m.postSharev2)).Methods(http.MethodPost)
+	router.HandleFunc(apiV2Prefix+"/files/{metahash}", m.fileContentHandler).Methods(http.MethodGet, http.MethodHead)
+	router.Handle(apiV2Prefix+"/uploads", apiV2Handler(m.postUploadv2)).Methods(http.MethodPost)
+	router.Handle(apiV2Prefix+"/downloads", apiV2Handler(m.getDownloadsv2)).Methods(http.MethodGet)
+	router.Handle(apiV2Prefix+"/downloads", apiV2Handler(m.postDownloadv2)).Methods(http.MethodPost)
+	router.Handle(apiV2Prefix+"/search", apiV2Handler(m.getSearchMatchesv2)).Methods(http.MethodGet)
+	router.Handle(apiV2Prefix+"/search", apiV2Handler(m.postSearchv2)).Methods(http.MethodPost)
+	router.Handle(apiV2Prefix+"/peers", apiV2Handler(m.getPeersv2)).Methods(http.MethodGet)
+	router.Handle(apiV2Prefix+"/peers", apiV2Handler(m.postPeerv2)).Methods(http.MethodPost)
+	router.Handle(apiV2Prefix+"/peers/{address}", apiV2Handler(m.deletePeerv2)).Methods(http.MethodDelete)
+	router.Handle(apiV2Prefix+"/peers/{address}/key", apiV2Handler(m.deletePeerKeyv2)).Methods(http.MethodDelete)
+	router.Handle(apiV2Prefix+"/members", apiV2Handler(m.getMembersv2)).Methods(http.MethodGet)
+	router.Handle(apiV2Prefix+"/origins", apiV2Handler(m.getOriginsv2)).Methods(http.MethodGet)
+	router.Handle(apiV2Prefix+"/chain", apiV2Handler(m.getChainv2)).Methods(http.MethodGet)
+	router.Handle(apiV2Prefix+"/names", apiV2Handler(m.getNamesv2)).Methods(http.MethodGet)
+	router.Handle(apiV2Prefix+"/names/{name}", apiV2Handler(m.getNamev2)).Methods(http.MethodGet)
+	router.Handle(apiV2Prefix+"/resolve/{name}", apiV2Handler(m.getResolvev2)).Methods(http.MethodGet)
+	router.Handle(apiV2Prefix+"/routes", apiV2Handler(m.getRoutesv2)).Methods(http.MethodGet)
+	router.Handle(apiV2Prefix+"/status", apiV2Handler(m.getStatusv2)).Methods(http.MethodGet)
+	router.Handle(apiV2Prefix+"/bans", apiV2Handler(m.getBansv2)).Methods(http.MethodGet)
+	router.HandleFunc(apiV2Prefix+"/events", m.eventsHandler).Methods(http.MethodGet)
+	// the v1 endpoints keep the plain text 404
+	router.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, apiV2Prefix+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		apiV2Handler(func(r *http.Request) (int, interface{}, error) {
+			return 0, nil, notFound("no such endpoint %s", r.URL.Path)
+		}).ServeHTTP(w, r)
+	})
+	router.MethodNotAllowedHandler = apiV2Handler(func(r *http.Request) (int, interface{}, error) {
+		return 0, nil, &apiError{Status: http.StatusMethodNotAllowed, Code: "method_not_allowed",
+			Message: fmt.Sprintf("method %s is not allowed on %s", r.Method, r.URL.Path)}
+	})
+}
+
+// =====================================================================
+//                             Handlers
+// =====================================================================
+
+func (m *handlerMaker) getIDv2(r *http.Request) (int, interface{}, error) {
+	return http.StatusOK, idResponse{Name: m.G.Name}, nil
+}
+
+func (m *handlerMaker) getMessagesv2(r *http.Request) (int, interface{}, error) {
+	rumors := make([]rumorResponse, 0)
+	for _, rumor := range m.G.GetAllNonRouteRumors() {
+		rumors = append(rumors, rumorResponse{Origin: rumor.Origin, ID: rumor.ID, Text: rumor.Text})
+	}
+	return http.StatusOK, rumors, nil
+}
+
+func (m *handlerMaker) postMessagev2(r *http.Request) (int, interface{}, error) {
+	var req messageRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		return 0, nil, err
+	}
+
+	rumor, err := gossiper.SendRumor(m.G, req.Text)
+	if err != nil {
+		return 0, nil, err
+	}
+	if rumor == nil {
+		// simple mode broadcasts the text without creating a rumor
+		return http.StatusAccepted, acceptedResponse{Status: "broadcast"}, nil
+	}
+	return http.StatusCreated, rumorResponse{Origin: rumor.Origin, ID: rumor.ID, Text: rumor.Text}, nil
+}
+
+func (m *handlerMaker) getPrivateMessagesv2(r *http.Request) (int, interface{}, error) {
+	return http.StatusOK, m.G.GetAllPrivateMessagesBetween(), nil
+}
+
+func (m *handlerMaker) postPrivateMessagev2(r *http.Request) (int, interface{}, error) {
+	var req privateMessageRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		return 0, nil, err
+	}
+
+	msg, err := gossiper.SendPrivate(m.G, req.Destination, req.Text)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, privateMessageResponse{Origin: msg.Origin, Destination: msg.Destination,
+		Text: msg.Text, HopLimit: msg.HopLimit}, nil
+}
+
+func (m *handlerMaker) getFilesv2(r *http.Request) (int, interface{}, error) {
+	files := make([]sharedFileResponse, 0)
+	for name, metahash := range m.G.GetAllSharedFilesAndHashes() {
+		files = append(files, sharedFileResponse{FileName: name, Metahash: metahash})
+	}
+	return http.StatusOK, files, nil
+}
+
+func (m *handlerMaker) postSharev2(r *http.Request) (int, interface{}, error) {
+	var req shareRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		return 0, nil, err
+	}
+
+	file, err := gossiper.ShareFile(m.G, req.FileName)
+	if err != nil {
+		return 0, nil, err
+	}
+	return http.StatusCreated, sharedFileResponse{FileName: file.FileName,
+		Metahash: hex.EncodeToString(file.MetaHash[:]), Size: file.Size}, nil
+}
+
+func (m *handlerMaker) getDownloadsv2(r *http.Request) (int, interface{}, error) {
+	downloads := make([]downloadStatsResponse, 0)
+	for _, summary := range m.G.GetDownloadSummaries() {
+		download := downloadStatsResponse{FileName: summary.FileName, Metahash: summary.Metahash,
+			Finished: summary.Finished, Failed: summary.Failed, ChunksDownloaded: summary.ChunksDownloaded, ChunkCount: summary.ChunkCount,
+			Bytes: summary.Bytes, BytesPerSecond: summary.BytesPerSecond, ETASeconds: summary.ETA.Seconds(),
+			StartedAt: summary.Started, Peers: make([]peerTransferResponse, 0, len(summary.Peers))}
+		if summary.ETA < 0 {
+			download.ETASeconds = -1
+		}
+		if summary.Finished {
+			finishedAt := summary.FinishedAt
+			download.FinishedAt = &finishedAt
+		}
+		for peer, stats := range summary.Peers {
+			download.Peers = append(download.Peers, peerTransferResponse{Peer: peer, Chunks: stats.Chunks,
+				Bytes: stats.Bytes, Retries: stats.Retries})
+		}
+		sort.Slice(download.Peers, func(i, j int) bool {
+			return download.Peers[i].Peer < download.Peers[j].Peer
+		})
+		downloads = append(downloads, download)
+	}
+	return http.StatusOK, downloads, nil
+}
+
+func (m *handlerMaker) postDownloadv2(r *http.Request) (int, interface{}, error) {
+	var req downloadRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		return 0, nil, err
+	}
+
+	if strings.Compare(req.Name, "") != 0 {
+		// download by name through the chain
+		if strings.Compare(req.Metahash, "") != 0 || strings.Compare(req.FileName, "") != 0 {
+			return 0, nil, badRequest("name cannot be combined with fileName or metahash")
+		}
+		metahash, err := gossiper.DownloadByName(m.G, req.Name, req.Destination)
+		if err != nil {
+			return 0, nil, err
+		}
+		return http.StatusAccepted, downloadResponse{FileName: req.Name, Destination: req.Destination,
+			Metahash: hex.EncodeToString(metahash), Status: "started"}, nil
+	}
+
+	metahash, err := decodeMetahash(req.Metahash)
+	if err != nil {
+		return 0, nil, err
+	}
+	if err := gossiper.Download(m.G, req.FileName, req.Destination, metahash); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusAccepted, downloadResponse{FileName: req.FileName, Destination: req.Destination,
+		Metahash: req.Metahash, Status: "started"}, nil
+}
+
+func (m *handlerMaker) getSearchMatchesv2(r *http.Request) (int, interface{}, error) {
+	matches := make([]searchMatchResponse, 0)
+	for _, match := range m.G.GetFileSearchMatches() {
+		matches = append(matches, searchMatchResponse{FileName: match.FileName, Metahash: hex.EncodeToString(match.Metahash),
+			ChunkCount: match.ChunkCount, ChunksFound: len(match.LocationOfChunks),
+			FullyMatched: match.ChunkCount == uint64(len(match.LocationOfChunks))})
+	}
+	return http.StatusOK, matches, nil
+}
+
+func (m *handlerMaker) postSearchv2(r *http.Request) (int, interface{}, error) {
+	var req searchRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		return 0, nil, err
+	}
+
+	if err := gossiper.Search(m.G, req.Keywords, req.Budget); err != nil {
+		return 0, nil, err
+	}
+	return http.StatusAccepted, acceptedResponse{Status: "searching"}, nil
+}
+
+func (m *handlerMaker) getPeersv2(r *http.Request) (int, interface{}, error) {
+	return http.StatusOK, m.G.GetAllKnownPeers(), nil
+}
+
+func (m *handlerMaker) postPeerv2(r *http.Request) (int, interface{}, error) {
+	var req peerRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		return 0, nil, err
+	}
+	switch {
+	case helpers.IPAddressIsValid(req.Address):
+		m.G.AddPeer(req.Address)
+	case helpers.IsPeerName(req.Address):
+		m.G.AddPeerNames([]string{req.Address})
+	default:
+		return 0, nil, badRequest("address must be of the form ip:port or host:port")
+	}
+	return http.StatusCreated, m.G.GetAllKnownPeers(), nil
+}
+
+func (m *handlerMaker) deletePeerv2(r *http.Request) (int, interface{}, error) {
+	if err := m.G.RemovePeer(mux.Vars(r)["address"]); err != nil {
+		return 0, nil, serviceError(err)
+	}
+	return http.StatusOK, m.G.GetAllKnownPeers(), nil
+}
+
+func (m *handlerMaker) deletePeerKeyv2(r *http.Request) (int, interface{}, error) {
+	if err := m.G.ForgetPeerKey(mux.Vars(r)["address"]); err != nil {
+		return 0, nil, serviceError(err)
+	}
+	return http.StatusOK, m.G.GetAllKnownPeers(), nil
+}
+
+func (m *handlerMaker) getMembersv2(r *http.Request) (int, interface{}, error) {
+	members := make([]memberResponse, 0)
+	identities := m.G.GetPeerIdentities()
+	for _, member := range m.G.GetMembers() {
+		resp := memberResponse{Address: member.Address, Identities: identities[member.Address],
+			State: string(member.State), StateSince: member.StateSince, LastHeard: member.LastHeard}
+		if resp.Identities == nil {
+			resp.Identities = make([]string, 0)
+		}
+		members = append(members, resp)
+	}
+	return http.StatusOK, members, nil
+}
+
+func (m *handlerMaker) getOriginsv2(r *http.Request) (int, interface{}, error) {
+	return http.StatusOK, m.G.GetAllKnownOrigins(), nil
+}
+
+func (m *handlerMaker) getChainv2(r *http.Request) (int, interface{}, error) {
+	head := m.G.GetChainHead()
+	resp := chainResponse{Head: hex.EncodeToString(head[:]), Blocks: make([]blockResponse, 0),
+		Reorgs: m.G.GetReorgEvents()}
+	if resp.Reorgs == nil {
+		resp.Reorgs = make([]core.ReorgEvent, 0)
+	}
+	for _, block := range m.G.GetCanonicalChain() {
+		tx := block.Block.Transaction
+		resp.Blocks = append(resp.Blocks, blockResponse{Hash: hex.EncodeToString(block.Hash[:]),
+			PrevHash: hex.EncodeToString(block.Header.PrevHash[:]), Height: block.Height, Origin: block.Origin,
+			TxType: tx.Type.String(), Name: tx.Name, Metahash: hex.EncodeToString(tx.MetafileHash), Size: tx.Size,
+			NewOwner: tx.NewOwner})
+	}
+	return http.StatusOK, resp, nil
+}
+
+func toNameResponse(record *core.NameRecord) nameResponse {
+	resp := nameResponse{Name: record.Name, Owner: record.Owner, Holder: record.Holder,
+		Metahash: hex.EncodeToString(record.MetafileHash), Size: record.Size, Revoked: record.Revoked,
+		History: make([]nameHistoryResponse, 0, len(record.History))}
+	for _, entry := range record.History {
+		resp.History = append(resp.History, nameHistoryResponse{TxType: entry.Type.String(), Origin: entry.Origin,
+			Metahash: hex.EncodeToString(entry.MetafileHash), Size: entry.Size, NewOwner: entry.NewOwner})
+	}
+	return resp
+}
+
+func (m *handlerMaker) getNamesv2(r *http.Request) (int, interface{}, error) {
+	names := make([]nameResponse, 0)
+	for _, record := range m.G.GetAllNameRecords() {
+		names = append(names, toNameResponse(&record))
+	}
+	return http.StatusOK, names, nil
+}
+
+func (m *handlerMaker) getNamev2(r *http.Request) (int, interface{}, error) {
+	name := mux.Vars(r)["name"]
+	record := m.G.GetNameRecord(name)
+	if record == nil {
+		return 0, nil, serviceError(blockchain.ErrNameNotRegistered)
+	}
+	return http.StatusOK, toNameResponse(record), nil
+}
+
+func (m *handlerMaker) getResolvev2(r *http.Request) (int, interface{}, error) {
+	name := mux.Vars(r)["name"]
+	metahash, holder, err := blockchain.ResolveName(m.G, name)
+	if err != nil {
+		return 0, nil, serviceError(err)
+	}
+	return http.StatusOK, resolveResponse{Name: name, Metahash: hex.EncodeToString(metahash), Holder: holder}, nil
+}
+
+func (m *handlerMaker) getRoutesv2(r *http.Request) (int, interface{}, error) {
+	routes := make([]routeResponse, 0)
+	for origin, nextHop := range m.G.GetRoutingTable() {
+		routes = append(routes, routeResponse{Origin: origin, NextHop: nextHop})
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Origin < routes[j].Origin
+	})
+	return http.StatusOK, routes, nil
+}
+
+func (m *handlerMaker) getBansv2(r *http.Request) (int, interface{}, error) {
+	bans := make([]banResponse, 0)
+	for _, ban := range m.G.GetBans() {
+		bans = append(bans, banResponse{Address: ban.Address, Until: ban.Until})
+	}
+	return http.StatusOK, bans, nil
+}
+
+func (m *handlerMaker) getStatusv2(r *http.Request) (int, interface{}, error) {
+	metrics := m.G.GetMetricsSnapshot()
+	resp := statusResponse{Name: m.G.Name, GossipAddress: m.G.Address.String(), Mode: "gossip",
+		LightClient: m.G.LightClient, Peers: metrics.KnownPeers, Origins: metrics.RoutingTableSize,
+		Rumors: len(m.G.GetAllNonRouteRumors()), SharedFiles: len(m.G.GetAllFileNames()),
+		ActiveDownloads: metrics.ActiveDownloads, ChainHeight: len(m.G.GetCanonicalChain())}
+	switch {
+	case m.G.SimpleMode:
+		resp.Mode = "simple"
+	case m.G.Mining != nil:
+		resp.Mode = "pow"
+	case m.G.Naming:
+		resp.Mode = "tlc"
+	}
+	if m.G.Channels != nil {
+		resp.NodeKey = m.G.Channels.PublicKey()
+	}
+	return http.StatusOK, resp, nil
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// Config - where the HTTP server of the gossiper listens and how its clients authenticate
+type Config struct {
+	Host       string // host or IP address to listen on; the UI port is appended to it
+	Auth       bool   // require a bearer token (or a client certificate) for every request
+	TokensFile string // file with the tokens accepted when Auth is set
+	TLSCert    string // certificate and key to serve HTTPS with, plain HTTP if empty
+	TLSKey     string
+	ClientCA   string // require client certificates signed by this CA (mutual TLS)
+}
+
+// authenticator - checks the credentials of every request and whether their scope allows it.
+// Requests are authenticated by a bearer token in the Authorization header or, for clients which
+// cannot set headers such as the GUI's event stream, in the access_token query parameter. A
+// verified client certificate gets the scope of the token named like its common name, read-only
+// if there is none
+type authenticator struct {
+	tokens      *tokenStore // nil when bearer tokens are not accepted
+	clientCerts bool
+}
+
+func newAuthenticator(config Config) (*authenticator, error) {
+	a := &authenticator{clientCerts: strings.Compare(config.ClientCA, "") != 0}
+	if config.Auth || a.clientCerts {
+		tokens, err := newTokenStore(config.TokensFile)
+		if err != nil {
+			return nil, err
+		}
+		a.tokens = tokens
+	}
+	return a, nil
+}
+
+// enabled is false when the server accepts every request
+func (a *authenticator) enabled() bool {
+	return a.tokens != nil
+}
+
+func (a *authenticator) wrap(next http.Handler) http.Handler {
+	if !a.enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Compare(r.URL.Path, "/") == 0 {
+			// the GUI page holds no state of the node; its requests are authenticated
+			next.ServeHTTP(w, r)
+			return
+		}
+		scope, ok := a.scope(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="peerster"`)
+			writeAuthError(w, r, &apiError{Status: http.StatusUnauthorized, Code: "unauthorized",
+				Message: "a valid bearer token or client certificate is required"})
+			return
+		}
+		if scope != ScopeFull && r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeAuthError(w, r, &apiError{Status: http.StatusForbidden, Code: "insufficient_scope",
+				Message: "the token only grants read access"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// the scope granted by the credentials of the request, false if they are missing or invalid
+func (a *authenticator) scope(r *http.Request) (TokenScope, bool) {
+	secret := r.URL.Query().Get("access_token")
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		secret = strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	if strings.Compare(secret, "") != 0 {
+		token, ok := a.tokens.lookupSecret(secret)
+		return token.Scope, ok
+	}
+
+	if a.clientCerts && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		commonName := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if token, ok := a.tokens.lookupName(commonName); ok {
+			return token.Scope, true
+		}
+		return ScopeRead, true
+	}
+	return "", false
+}
+
+// the v2 API answers with its JSON errors, the v1 endpoints with plain text
+func writeAuthError(w http.ResponseWriter, r *http.Request, apiErr *apiError) {
+	if strings.HasPrefix(r.URL.Path, apiV2Prefix+"/") {
+		writeAPIError(w, apiErr)
+		return
+	}
+	http.Error(w, apiErr.Message, apiErr.Status)
+}
+
+// the TLS configuration of the server, nil to serve plain HTTP
+func tlsConfig(config Config) (*tls.Config, error) {
+	if strings.Compare(config.TLSCert, "") == 0 && strings.Compare(config.TLSKey, "") == 0 {
+		if strings.Compare(config.ClientCA, "") != 0 {
+			return nil, errors.New("client certificates require a server certificate and key")
+		}
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(config.TLSCert, config.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if strings.Compare(config.ClientCA, "") != 0 {
+		caPEM, err := ioutil.ReadFile(config.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificate found in " + config.ClientCA)
+		}
+		tlsConf.ClientCAs = pool
+		tlsConf.ClientAuth = tls.VerifyClientCertIfGiven
+		if !config.Auth {
+			// without tokens every client must present a certificate
+			tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return tlsConf, nil
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/core"
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+)
+
+// every event type a client can filter the stream on
+var streamableEvents = map[core.EventType]bool{
+	core.EventRumor: true, core.EventPrivate: true, core.EventRoute: true, core.EventSearchMatch: true,
+	core.EventDownloadProgress: true, core.EventChunkReceived: true, core.EventTLCUnconfirmed: true,
+	core.EventTLCConfirmed: true, core.EventPeerAdded: true, core.EventPeerState: true,
+}
+
+// Stream the events of the gossiper as server-sent events. The optional ?types= query parameter
+// is a comma separated list of the event types to receive, all of them by default
+func (m *handlerMaker) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, &apiError{Status: http.StatusInternalServerError, Code: "internal_error",
+			Message: "streaming is not supported"})
+		return
+	}
+
+	wanted := make(map[core.EventType]bool)
+	if types := r.URL.Query().Get("types"); strings.Compare(types, "") != 0 {
+		for _, t := range strings.Split(types, ",") {
+			if !streamableEvents[core.EventType(t)] {
+				writeAPIError(w, badRequest("unknown event type %s", t))
+				return
+			}
+			wanted[core.EventType(t)] = true
+		}
+	}
+
+	id, events := m.G.SubscribeEvents()
+	defer m.G.UnsubscribeEvents(id)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(time.Duration(m.G.Settings.EventKeepAlivePeriod) * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-m.G.Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if len(wanted) > 0 && !wanted[event.Type] {
+				continue
+			}
+			eventJSON, err := json.Marshal(event)
+			if err != nil {
+				helpers.HandleErrorNonFatal(err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, eventJSON)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/gossiper"
+	"github.com/gorilla/mux"
+)
+
+// Serve the content of a shared, downloaded or downloading file by its metahash, honouring range
+// requests. Parts of a file which is still being downloaded are sent as their chunks arrive
+func (m *handlerMaker) fileContentHandler(w http.ResponseWriter, r *http.Request) {
+	metahash, err := decodeMetahash(mux.Vars(r)["metahash"])
+	if err != nil {
+		writeAPIError(w, err.(*apiError))
+		return
+	}
+	file, err := gossiper.OpenFile(r.Context(), m.G, metahash)
+	if err != nil {
+		writeAPIError(w, serviceError(err))
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", file.Name()))
+	http.ServeContent(w, r, file.Name(), time.Time{}, file)
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/AleksandarHrusanov/Peerster/core"
+)
+
+// the help text of every counter besides the packet counters
+var counterHelp = map[core.MetricCounter]string{
+	core.MetricDecodeErrors:           "Packets received from peers which could not be decoded.",
+	core.MetricChunkBytesServed:       "Bytes of chunks and metafiles sent in data replies to peers.",
+	core.MetricChunkBytesReceived:     "Bytes of chunks and metafiles received in data replies from peers.",
+	core.MetricSearchRequestsHandled:  "Search requests from other nodes processed by this node.",
+	core.MetricTLCAcksReceived:        "Acks received for TLC messages of this node.",
+	core.MetricTLCConfirmations:       "TLC messages seen confirmed for the first time.",
+	core.MetricPacketsFragmented:      "Gossip packets too large for a datagram which were sent in fragments.",
+	core.MetricPacketsReassembled:     "Gossip packets reassembled from fragments received from peers.",
+	core.MetricPacketsTooLarge:        "Gossip packets not sent because they exceed the largest fragmented size.",
+	core.MetricFragmentsDropped:       "Fragments received from peers which were invalid or whose packet never completed.",
+	core.MetricStreamPacketsSent:      "Data requests and replies sent to peers over stream connections.",
+	core.MetricStreamPacketsReceived:  "Data requests and replies received from peers over stream connections.",
+	core.MetricHandshakesCompleted:    "Secure channels established with peers.",
+	core.MetricHandshakesFailed:       "Handshakes given up after a peer did not answer them.",
+	core.MetricPacketsRejected:        "Packets from peers dropped because they could not be authenticated.",
+	core.MetricPacketsFromBannedPeers: "Packets dropped because their peer is banned.",
+	core.MetricPeersBanned:            "Peers banned for sending too many packets over the rate limits.",
+	core.MetricPacketLimitsCapped:     "Packets from peers whose search budget or hop limit was lowered to the cap.",
+	core.MetricPeersEvicted:           "Peers evicted from the known peers after the failure detector declared them dead.",
+	core.MetricViewShuffles:           "Shuffles of the partial view answered by peers.",
+	core.MetricPeersDiscovered:        "Peers added from the seeds file, the discovery group or peer exchange.",
+}
+
+// Serve the metrics of the gossiper in the Prometheus text exposition format
+func (m *handlerMaker) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	snapshot := m.G.GetMetricsSnapshot()
+	var out bytes.Buffer
+
+	writeLabeledMetric(&out, "peerster_packets_sent_total", "counter", "Gossip packets sent to peers by type.",
+		"type", snapshot.PacketsSent)
+	writeLabeledMetric(&out, "peerster_packets_received_total", "counter", "Gossip packets received from peers by type.",
+		"type", snapshot.PacketsReceived)
+	writeLabeledMetric(&out, "peerster_packets_rate_limited_total", "counter",
+		"Gossip packets from peers dropped by type because their peer sent too many of them.",
+		"type", snapshot.PacketsRateLimited)
+
+	counters := make([]string, 0, len(counterHelp))
+	for counter := range counterHelp {
+		counters = append(counters, string(counter))
+	}
+	sort.Strings(counters)
+	for _, counter := range counters {
+		writeMetric(&out, counter, "counter", counterHelp[core.MetricCounter(counter)],
+			snapshot.Counters[core.MetricCounter(counter)])
+	}
+
+	writeMetric(&out, "peerster_rumors_stored", "gauge", "Rumors known to this node, route rumors included.",
+		snapshot.RumorsStored)
+	writeMetric(&out, "peerster_mongering_statuses_outstanding", "gauge",
+		"Rumors and TLC messages sent which are still waiting for a status.", snapshot.MongeringStatuses)
+	writeMetric(&out, "peerster_routing_table_size", "gauge", "Origins with a known next hop.",
+		snapshot.RoutingTableSize)
+	writeMetric(&out, "peerster_known_peers", "gauge", "Peers this node gossips with directly.", snapshot.KnownPeers)
+	writeMetric(&out, "peerster_active_downloads", "gauge", "Downloads in progress.", snapshot.ActiveDownloads)
+	writeMetric(&out, "peerster_banned_peers", "gauge", "Peers whose packets are currently dropped.",
+		snapshot.BannedPeers)
+	writeLabeledMetric(&out, "peerster_peers", "gauge", "Peers tracked by the failure detector by state.",
+		"state", snapshot.PeersByState)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(out.Bytes())
+}
+
+func writeMetric(out *bytes.Buffer, name string, metricType string, help string, value interface{}) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, metricType, name, value)
+}
+
+// a metric with one sample per value of its label, sorted by value
+func writeLabeledMetric(out *bytes.Buffer, name string, metricType string, help string, label string,
+	byLabel map[string]uint64) {
+	fmt.Fprintf(out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+	values := make([]string, 0, len(byLabel))
+	for value := range byLabel {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	for _, value := range values {
+		fmt.Fprintf(out, "%s{%s=%q} %d\n", name, label, value, byLabel[value])
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+)
+
+// statusRecorder - remembers the status written by a handler. It keeps the response flushable for
+// the event stream
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// logRequests - log every request served at debug level. Requests are logged before the
+// authentication check, so that rejected ones show up as well
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !helpers.LogEnabled(helpers.LevelDebug) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		helpers.HTTPLog.Debug("request served", helpers.F("method", r.Method), helpers.F("path", r.URL.Path),
+			helpers.F("status", recorder.status), helpers.F("peer", r.RemoteAddr),
+			helpers.F("duration_ms", time.Since(start).Nanoseconds()/int64(time.Millisecond)))
+	})
+}
@@ -1,17 +1,21 @@
 package server
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/AleksandarHrusanov/Peerster/blockchain"
 	"github.com/AleksandarHrusanov/Peerster/core"
+	"github.com/AleksandarHrusanov/Peerster/gossiper"
 	"github.com/AleksandarHrusanov/Peerster/helpers"
 	"github.com/gorilla/mux"
 )
@@ -28,7 +32,9 @@ func (m *handlerMaker) idHandler(w http.ResponseWriter, r *http.Request) {
 	default:
 		gossiperID := goss.Name
 		gossiperIDJson, err := json.Marshal(gossiperID)
-		helpers.HandleErrorFatal(err)
+		if writeServiceError(w, err) {
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -51,7 +57,9 @@ func (m *handlerMaker) messageHandler(w http.ResponseWriter, r *http.Request) {
 		// Return json of rumors
 		msgList := goss.GetAllNonRouteRumors()
 		msgListJSON, err := json.Marshal(msgList)
-		helpers.HandleErrorFatal(err)
+		if writeServiceError(w, err) {
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -59,25 +67,21 @@ func (m *handlerMaker) messageHandler(w http.ResponseWriter, r *http.Request) {
 
 	case http.MethodPost:
 		// Get the message
-		reqBody, err := ioutil.ReadAll(r.Body)
-		helpers.HandleErrorFatal(err)
-		empty := ""
-		zero := uint64(0)
 		text := ""
-		dest := ""
-		fileToShare := ""
-		hashRequest := ""
-		err = json.Unmarshal(reqBody, &text)
-		helpers.HandleErrorFatal(err)
+		if !readJSONBody(w, r, &text) {
+			return
+		}
 
-		// Use the client to send the message to the gossiper
-		core.ClientConnectAndSend(goss.GetLocalAddr(), &text, &dest, &fileToShare, &hashRequest, &empty, &zero)
+		if _, err := gossiper.SendRumor(goss, text); writeServiceError(w, err) {
+			return
+		}
 
 		// Return json of rumors
-		time.Sleep(50 * time.Millisecond)
 		msgList := goss.GetAllRumors()
 		msgListJSON, err := json.Marshal(msgList)
-		helpers.HandleErrorFatal(err)
+		if writeServiceError(w, err) {
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -93,7 +97,9 @@ func (m *handlerMaker) privateMessageHandler(w http.ResponseWriter, r *http.Requ
 		// Return json of private messages with the chosen origin
 		msgList := goss.GetAllPrivateMessagesBetween()
 		msgListJSON, err := json.Marshal(msgList)
-		helpers.HandleErrorFatal(err)
+		if writeServiceError(w, err) {
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -101,26 +107,26 @@ func (m *handlerMaker) privateMessageHandler(w http.ResponseWriter, r *http.Requ
 
 	case http.MethodPost:
 		// Get the message
-		reqBody, err := ioutil.ReadAll(r.Body)
-		helpers.HandleErrorFatal(err)
+		// text, destination
 		var msg []string
-		empty := ""
-		zero := uint64(0)
-		fileToShare := ""
-		hashRequest := ""
-		err = json.Unmarshal(reqBody, &msg)
-		helpers.HandleErrorFatal(err)
+		if !readJSONBody(w, r, &msg) {
+			return
+		}
+		if len(msg) < 2 {
+			http.Error(w, "expected a text and a destination", http.StatusBadRequest)
+			return
+		}
 
-		// Use the client to send the message to the gossiper
-		if strings.Compare(msg[0], "") != 0 && strings.Compare(msg[1], "") != 0 {
-			core.ClientConnectAndSend(goss.GetLocalAddr(), &msg[0], &msg[1], &fileToShare, &hashRequest, &empty, &zero)
+		if _, err := gossiper.SendPrivate(goss, msg[1], msg[0]); writeServiceError(w, err) {
+			return
 		}
 
-		// Return json of rumors
-		time.Sleep(50 * time.Millisecond)
+		// Return json of private messages
 		msgList := goss.GetAllPrivateMessagesBetween()
 		msgListJSON, err := json.Marshal(msgList)
-		helpers.HandleErrorFatal(err)
+		if writeServiceError(w, err) {
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -139,7 +145,9 @@ func (m *handlerMaker) originsHandler(w http.ResponseWriter, r *http.Request) {
 
 		if len(msgKnownOrigins) > 0 {
 			msgKnownOriginsJSON, err := json.Marshal(msgKnownOrigins)
-			helpers.HandleErrorFatal(err)
+			if writeServiceError(w, err) {
+				return
+			}
 
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
@@ -168,7 +176,9 @@ func (m *handlerMaker) confirmedTLCsHandler(w http.ResponseWriter, r *http.Reque
 
 		if len(tlcsToPrint) > 0 {
 			tlcsToPrintJSON, err := json.Marshal(tlcsToPrint)
-			helpers.HandleErrorFatal(err)
+			if writeServiceError(w, err) {
+				return
+			}
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
 			w.Write(tlcsToPrintJSON)
@@ -185,32 +195,30 @@ func (m *handlerMaker) shareFilesHandler(w http.ResponseWriter, r *http.Request)
 		// Return json of knownpeers
 		sharedFiles := goss.GetAllSharedFilesAndHashes()
 		sharedFilesJSON, err := json.Marshal(sharedFiles)
-		helpers.HandleErrorFatal(err)
+		if writeServiceError(w, err) {
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
 		w.Write(sharedFilesJSON)
 
 	case http.MethodPost:
 		// Get the message
-		reqBody, err := ioutil.ReadAll(r.Body)
-		helpers.HandleErrorFatal(err)
-		text := ""
-		dest := ""
-		empty := ""
-		zero := uint64(0)
 		fileToShare := ""
-		hashRequest := ""
-		err = json.Unmarshal(reqBody, &fileToShare)
-		helpers.HandleErrorFatal(err)
+		if !readJSONBody(w, r, &fileToShare) {
+			return
+		}
 
-		// Use the client to send the message to the gossiper
-		core.ClientConnectAndSend(goss.GetLocalAddr(), &text, &dest, &fileToShare, &hashRequest, &empty, &zero)
+		if _, err := gossiper.ShareFile(goss, fileToShare); writeServiceError(w, err) {
+			return
+		}
 
-		// Return json of rumors
-		time.Sleep(50 * time.Millisecond)
+		// Return json of the shared file
 		fileAndHash := fileToShare
 		fileAndHashJSON, err := json.Marshal(fileAndHash)
-		helpers.HandleErrorFatal(err)
+		if writeServiceError(w, err) {
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -224,20 +232,25 @@ func (m *handlerMaker) downloadFilesHandler(w http.ResponseWriter, r *http.Reque
 
 	switch r.Method {
 	case http.MethodPost:
-		reqBody, err := ioutil.ReadAll(r.Body)
-		helpers.HandleErrorFatal(err)
 		// -dest, -file, -request
 		var msg []string
-		txt := ""
-		empty := ""
-		zero := uint64(0)
-		err = json.Unmarshal(reqBody, &msg)
-		helpers.HandleErrorFatal(err)
+		if !readJSONBody(w, r, &msg) {
+			return
+		}
+		if len(msg) < 3 || strings.Compare(msg[0], "") == 0 {
+			http.Error(w, "expected a destination, a file name and a metahash", http.StatusBadRequest)
+			return
+		}
+		metahash, err := hex.DecodeString(msg[2])
+		if err != nil {
+			writeServiceError(w, gossiper.ErrInvalidMetahash)
+			return
+		}
 
-		// Use the client to send the message to the gossiper
-		if strings.Compare(msg[0], "") != 0 && strings.Compare(msg[1], "") != 0 {
-			core.ClientConnectAndSend(goss.GetLocalAddr(), &txt, &msg[0], &msg[1], &msg[2], &empty, &zero)
+		if writeServiceError(w, gossiper.Download(goss, msg[1], msg[0], metahash)) {
+			return
 		}
+		w.WriteHeader(http.StatusOK)
 	}
 }
 
@@ -247,48 +260,165 @@ func (m *handlerMaker) implicitDownloadFilesHandler(w http.ResponseWriter, r *ht
 
 	switch r.Method {
 	case http.MethodPost:
-		reqBody, err := ioutil.ReadAll(r.Body)
-		helpers.HandleErrorFatal(err)
-		// -dest, -file, -request
 		var matchedFile string
-		empty := ""
-		zero := uint64(0)
-		err = json.Unmarshal(reqBody, &matchedFile)
-		helpers.HandleErrorFatal(err)
-		hash := goss.GetMetafileHashByName(matchedFile)
-		// Use the client to send the message to the gossiper
-		if strings.Compare(matchedFile, "") != 0 && strings.Compare(hash, "") != 0 {
-			core.ClientConnectAndSend(goss.GetLocalAddr(), &empty, &empty, &matchedFile, &hash, &empty, &zero)
+		if !readJSONBody(w, r, &matchedFile) {
+			return
+		}
+		metahash, err := hex.DecodeString(goss.GetMetafileHashByName(matchedFile))
+		if err != nil || len(metahash) == 0 {
+			writeServiceError(w, gossiper.ErrNoSearchMatch)
+			return
+		}
+
+		if writeServiceError(w, gossiper.Download(goss, matchedFile, "", metahash)) {
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// Handle resolving a name through the confirmed chain
+func (m *handlerMaker) resolveNameHandler(w http.ResponseWriter, r *http.Request) {
+	goss := m.G
+
+	switch r.Method {
+	case http.MethodGet:
+		name := r.URL.Query().Get("name")
+		metahash, _, err := blockchain.ResolveName(goss, name)
+		if !writeServiceError(w, err) {
+			metahashJSON, err := json.Marshal(hex.EncodeToString(metahash))
+			if writeServiceError(w, err) {
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(metahashJSON)
 		}
 	}
 }
 
+// Handle the canonical chain and its reorganisations
+func (m *handlerMaker) chainHandler(w http.ResponseWriter, r *http.Request) {
+	goss := m.G
+
+	switch r.Method {
+	case http.MethodGet:
+		chain := struct {
+			Blocks []core.ChainBlock
+			Reorgs []core.ReorgEvent
+		}{goss.GetCanonicalChain(), goss.GetReorgEvents()}
+		chainJSON, err := json.Marshal(chain)
+		if writeServiceError(w, err) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(chainJSON)
+	}
+}
+
+// Handle the name table and the history of each name
+func (m *handlerMaker) namesHandler(w http.ResponseWriter, r *http.Request) {
+	goss := m.G
+
+	switch r.Method {
+	case http.MethodGet:
+		var records interface{}
+		if name := r.URL.Query().Get("name"); strings.Compare(name, "") != 0 {
+			record := goss.GetNameRecord(name)
+			if record == nil {
+				http.Error(w, blockchain.ErrNameNotRegistered.Error(), http.StatusNotFound)
+				return
+			}
+			records = record
+		} else {
+			records = goss.GetAllNameRecords()
+		}
+		recordsJSON, err := json.Marshal(records)
+		if writeServiceError(w, err) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(recordsJSON)
+	}
+}
+
+// Handle download by name
+func (m *handlerMaker) namedDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	goss := m.G
+
+	switch r.Method {
+	case http.MethodPost:
+		name := ""
+		if !readJSONBody(w, r, &name) {
+			return
+		}
+
+		metahash, err := gossiper.DownloadByName(goss, name, "")
+		if !writeServiceError(w, err) {
+			metahashJSON, err := json.Marshal(hex.EncodeToString(metahash))
+			if writeServiceError(w, err) {
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			w.Write(metahashJSON)
+		}
+	}
+}
+
+// Write the matching HTTP error for a failed call to the gossiper; returns true if there was an error
+func writeServiceError(w http.ResponseWriter, err error) bool {
+	if err == nil {
+		return false
+	}
+	apiErr := serviceError(err)
+	http.Error(w, apiErr.Message, apiErr.Status)
+	return true
+}
+
+// Decode the JSON body of the request into v; writes a bad request error and returns false on failure
+func readJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	reqBody, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(reqBody, v)
+	}
+	if err != nil {
+		http.Error(w, "malformed JSON body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
 // Handle node requests
 func (m *handlerMaker) searchFile(w http.ResponseWriter, r *http.Request) {
 	goss := m.G
 
 	switch r.Method {
 	case http.MethodPost:
-		reqBody, err := ioutil.ReadAll(r.Body)
-		helpers.HandleErrorFatal(err)
-		// -dest, -file, -request
-		empty := ""
-		zero := uint64(0)
 		keywords := ""
-		err = json.Unmarshal(reqBody, &keywords)
-		helpers.HandleErrorFatal(err)
+		if !readJSONBody(w, r, &keywords) {
+			return
+		}
 
-		// Use the client to send the message to the gossiper
-		if strings.Compare(keywords, "") != 0 {
-			core.ClientConnectAndSend(goss.GetLocalAddr(), &empty, &empty, &empty, &empty, &keywords, &zero)
+		if writeServiceError(w, gossiper.Search(goss, strings.Split(keywords, ","), 0)) {
+			return
 		}
+		w.WriteHeader(http.StatusOK)
 	case http.MethodGet:
 		// Return json of matchedFileNames
 		matchedFiles := goss.GetAllFullyMatchedFilenames()
 
 		if len(matchedFiles) > 0 {
 			matchedFilesJSON, err := json.Marshal(matchedFiles)
-			helpers.HandleErrorFatal(err)
+			if writeServiceError(w, err) {
+				return
+			}
 
 			w.Header().Set("Content-Type", "application/json")
 			w.WriteHeader(http.StatusOK)
@@ -303,19 +433,50 @@ func (m *handlerMaker) nodeHandler(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodPost:
-		reqBody, err := ioutil.ReadAll(r.Body)
-		helpers.HandleErrorFatal(err)
 		newAddress := ""
-		err = json.Unmarshal(reqBody, &newAddress)
-		helpers.HandleErrorFatal(err)
+		if !readJSONBody(w, r, &newAddress) {
+			return
+		}
+		// Add to gossiper knownpeers, resolving host names
+		switch {
+		case helpers.IPAddressIsValid(newAddress):
+			goss.AddPeer(newAddress)
+		case helpers.IsPeerName(newAddress):
+			goss.AddPeerNames([]string{newAddress})
+		default:
+			http.Error(w, "address must be of the form ip:port or host:port", http.StatusBadRequest)
+			return
+		}
+
+		// Return json of knownpeers
+		msgKnownPeers := goss.GetAllKnownPeers()
+		msgKnownPeersJSON, err := json.Marshal(msgKnownPeers)
+		if writeServiceError(w, err) {
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(msgKnownPeersJSON)
+
+	case http.MethodDelete:
+		address := ""
+		if !readJSONBody(w, r, &address) {
+			return
+		}
 
-		// Add to gossiper knownpeers
-		goss.AddPeer(newAddress)
+		// Remove from gossiper knownpeers
+		if err := goss.RemovePeer(address); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
 
 		// Return json of knownpeers
 		msgKnownPeers := goss.GetAllKnownPeers()
 		msgKnownPeersJSON, err := json.Marshal(msgKnownPeers)
-		helpers.HandleErrorFatal(err)
+		if writeServiceError(w, err) {
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -325,7 +486,9 @@ func (m *handlerMaker) nodeHandler(w http.ResponseWriter, r *http.Request) {
 		// Return json of knownpeers
 		msgKnownPeers := goss.GetAllKnownPeers()
 		msgKnownPeersJSON, err := json.Marshal(msgKnownPeers)
-		helpers.HandleErrorFatal(err)
+		if writeServiceError(w, err) {
+			return
+		}
 
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
@@ -333,11 +496,23 @@ func (m *handlerMaker) nodeHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// StartServer start the peer's server
-func StartServer(g *core.Gossiper) {
+// StartServer start the peer's server. Returns once the gossiper stopped and the server shut down
+func StartServer(g *core.Gossiper, config Config) {
 
-	defaultServerPort := ":" + g.GetUIPort() // default ":8080"
+	serverAddr := net.JoinHostPort(config.Host, g.GetUIPort()) // default "127.0.0.1:8080"
 	handlerMaker := &handlerMaker{g}
+	auth, err := newAuthenticator(config)
+	if err != nil {
+		log.Fatal("Unable to load the API tokens: ", err)
+	}
+	tlsConf, err := tlsConfig(config)
+	if err != nil {
+		log.Fatal("Unable to set up TLS: ", err)
+	}
+	if !auth.enabled() && !isLoopbackHost(config.Host) {
+		helpers.HTTPLog.Warn("the HTTP API is reachable from the network without authentication",
+			helpers.F("address", serverAddr))
+	}
 
 	router := mux.NewRouter().StrictSlash(true)
 	router.HandleFunc("/", homeHandler)
@@ -351,7 +526,41 @@ func StartServer(g *core.Gossiper) {
 	router.HandleFunc("/implicit_download", handlerMaker.implicitDownloadFilesHandler)
 	router.HandleFunc("/search", handlerMaker.searchFile)
 	router.HandleFunc("/confirmed_tlcs", handlerMaker.confirmedTLCsHandler)
+	router.HandleFunc("/resolve", handlerMaker.resolveNameHandler)
+	router.HandleFunc("/named_download", handlerMaker.namedDownloadHandler)
+	router.HandleFunc("/names", handlerMaker.namesHandler)
+	router.HandleFunc("/chain", handlerMaker.chainHandler)
+	router.HandleFunc("/metrics", handlerMaker.metricsHandler).Methods(http.MethodGet)
+	registerAPIv2(router, handlerMaker)
+
+	// Listen for http requests and serve them until the gossiper stops
+	server := &http.Server{Addr: serverAddr, Handler: logRequests(auth.wrap(router)), TLSConfig: tlsConf}
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-g.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(g.Settings.ShutdownTimeout)*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+	if tlsConf != nil {
+		// the certificate and key are already loaded in the TLS configuration
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	// the requests in progress are still served until Shutdown returns
+	<-shutdownDone
+}
 
-	// Listen for http requests and serve them
-	log.Fatal(http.ListenAndServe(defaultServerPort, router))
+// true if the host only accepts connections from this machine
+func isLoopbackHost(host string) bool {
+	if strings.Compare(host, "localhost") == 0 {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
 }
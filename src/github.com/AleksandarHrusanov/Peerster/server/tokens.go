@@ -0,0 +1,212 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/AleksandarHrusanov/Peerster/helpers"
+)
+
+// TokenScope - what the holder of an API token is allowed to do
+type TokenScope string
+
+// ScopeRead - the holder can only read the state of the node (GET and HEAD requests)
+const ScopeRead TokenScope = "read"
+
+// ScopeFull - the holder has full control of the node
+const ScopeFull TokenScope = "full"
+
+// APIToken - a named API token. Only the hash of its secret is stored
+type APIToken struct {
+	Name    string     `json:"name"`
+	Hash    string     `json:"hash"`
+	Scope   TokenScope `json:"scope"`
+	Created time.Time  `json:"created"`
+}
+
+// ErrInvalidScope - the scope of a token is neither read nor full
+var ErrInvalidScope = errors.New("token scope must be read or full")
+
+// ErrTokenExists - a token with the same name is already in the tokens file
+var ErrTokenExists = errors.New("a token with this name already exists")
+
+// ErrUnknownToken - no token with the given name is in the tokens file
+var ErrUnknownToken = errors.New("no token with this name exists")
+
+// ErrInvalidTokenName - the name of a token is empty or contains white space
+var ErrInvalidTokenName = errors.New("token name must be non-empty and contain no white space")
+
+// ParseScope - parse the scope of a token given on the command line
+func ParseScope(scope string) (TokenScope, error) {
+	switch TokenScope(scope) {
+	case ScopeRead, ScopeFull:
+		return TokenScope(scope), nil
+	}
+	return "", ErrInvalidScope
+}
+
+// LoadTokens - read the tokens stored in the tokens file, sorted by name. A missing file holds no tokens
+func LoadTokens(path string) ([]APIToken, error) {
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return []APIToken{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]APIToken, 0)
+	if err := json.Unmarshal(content, &tokens); err != nil {
+		return nil, err
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Name < tokens[j].Name
+	})
+	return tokens, nil
+}
+
+// AddToken - create a token with the given name and scope in the tokens file and return its
+// secret, which is not stored and cannot be shown again
+func AddToken(path string, name string, scope TokenScope) (string, error) {
+	if strings.Compare(name, "") == 0 || strings.ContainsAny(name, " \t\r\n") {
+		return "", ErrInvalidTokenName
+	}
+	if _, err := ParseScope(string(scope)); err != nil {
+		return "", err
+	}
+	tokens, err := LoadTokens(path)
+	if err != nil {
+		return "", err
+	}
+	for _, t := range tokens {
+		if strings.Compare(t.Name, name) == 0 {
+			return "", ErrTokenExists
+		}
+	}
+
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(secretBytes)
+	tokens = append(tokens, APIToken{Name: name, Hash: hashSecret(secret), Scope: scope, Created: time.Now()})
+	return secret, saveTokens(path, tokens)
+}
+
+// RevokeToken - remove the token with the given name from the tokens file. A running server stops
+// accepting it with its next request
+func RevokeToken(path string, name string) error {
+	tokens, err := LoadTokens(path)
+	if err != nil {
+		return err
+	}
+	kept := make([]APIToken, 0, len(tokens))
+	for _, t := range tokens {
+		if strings.Compare(t.Name, name) != 0 {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) == len(tokens) {
+		return ErrUnknownToken
+	}
+	return saveTokens(path, kept)
+}
+
+// writes the tokens to a temporary file readable by the owner only, then moves it into place
+func saveTokens(path string, tokens []APIToken) error {
+	content, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), ".tokens-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.Write(content)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), path)
+}
+
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+// tokenStore - the tokens accepted by the server, reloaded whenever the tokens file changes so that
+// tokens can be added and revoked while the gossiper runs
+type tokenStore struct {
+	path      string
+	modTime   time.Time
+	byHash    map[string]APIToken
+	byName    map[string]APIToken
+	StoreLock sync.Mutex
+}
+
+func newTokenStore(path string) (*tokenStore, error) {
+	store := &tokenStore{path: path}
+	if err := store.reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// reloads the tokens if the file changed since they were last read; must be called with the lock held
+func (s *tokenStore) reload() error {
+	info, err := os.Stat(s.path)
+	modTime := time.Time{}
+	if err == nil {
+		modTime = info.ModTime()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if s.byHash != nil && modTime.Equal(s.modTime) {
+		return nil
+	}
+
+	tokens, err := LoadTokens(s.path)
+	if err != nil {
+		return err
+	}
+	s.byHash = make(map[string]APIToken, len(tokens))
+	s.byName = make(map[string]APIToken, len(tokens))
+	for _, t := range tokens {
+		s.byHash[t.Hash] = t
+		s.byName[t.Name] = t
+	}
+	s.modTime = modTime
+	return nil
+}
+
+// returns the token with the given secret. If the tokens file cannot be read the tokens read last
+// stay in use
+func (s *tokenStore) lookupSecret(secret string) (APIToken, bool) {
+	s.StoreLock.Lock()
+	defer s.StoreLock.Unlock()
+	helpers.HandleErrorNonFatal(s.reload())
+	token, ok := s.byHash[hashSecret(secret)]
+	return token, ok
+}
+
+// returns the token with the given name, used to grant a scope to client certificates
+func (s *tokenStore) lookupName(name string) (APIToken, bool) {
+	s.StoreLock.Lock()
+	defer s.StoreLock.Unlock()
+	helpers.HandleErrorNonFatal(s.reload())
+	token, ok := s.byName[name]
+	return token, ok
+}
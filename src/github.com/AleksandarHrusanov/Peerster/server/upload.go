@@ -0,0 +1,101 @@
+package server
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/AleksandarHrusanov/Peerster/constants"
+	"github.com/AleksandarHrusanov/Peerster/gossiper"
+)
+
+// the longest file name accepted in the fileName part of an upload
+const maxUploadFileNameSize = 255
+
+var errUploadTooLarge = errors.New("upload exceeds the maximum file size")
+
+type uploadResponse struct {
+	FileName   string `json:"fileName"`
+	Metahash   string `json:"metahash"`
+	ChunkCount uint64 `json:"chunkCount"`
+	Size       int64  `json:"size"`
+}
+
+// uploadReader - reads the file part of an upload, failing once more than MaxUploadSize bytes
+// were read and remembering any error of the client connection
+type uploadReader struct {
+	part      io.Reader
+	remaining int64
+	err       error
+}
+
+func (u *uploadReader) Read(p []byte) (int, error) {
+	if u.remaining <= 0 {
+		// the upload may end exactly at the limit
+		var probe [1]byte
+		if n, err := u.part.Read(probe[:]); n == 0 && err == io.EOF {
+			return 0, io.EOF
+		}
+		u.err = errUploadTooLarge
+		return 0, u.err
+	}
+	if int64(len(p)) > u.remaining {
+		p = p[:u.remaining]
+	}
+	n, err := u.part.Read(p)
+	u.remaining -= int64(n)
+	if err != nil && err != io.EOF {
+		u.err = err
+	}
+	return n, err
+}
+
+// Upload a file as a multipart/form-data body with a "file" part, optionally preceded by a
+// "fileName" part overriding the name of the uploaded file. The file is streamed to disk and
+// indexed as it arrives
+func (m *handlerMaker) postUploadv2(r *http.Request) (int, interface{}, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return 0, nil, badRequest("expected a multipart/form-data body: %s", err)
+	}
+
+	fileName := ""
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, nil, badRequest("malformed multipart body: %s", err)
+		}
+
+		switch part.FormName() {
+		case "fileName":
+			value, err := ioutil.ReadAll(io.LimitReader(part, maxUploadFileNameSize+1))
+			if err != nil || len(value) > maxUploadFileNameSize {
+				return 0, nil, badRequest("fileName must be at most %d bytes", maxUploadFileNameSize)
+			}
+			fileName = string(value)
+		case "file":
+			if strings.Compare(fileName, "") == 0 {
+				fileName = part.FileName()
+			}
+			upload := &uploadReader{part: part, remaining: constants.MaxUploadSize}
+			file, err := gossiper.UploadFile(m.G, fileName, upload)
+			if upload.err == errUploadTooLarge {
+				return 0, nil, &apiError{Status: http.StatusRequestEntityTooLarge, Code: "upload_too_large",
+					Message: errUploadTooLarge.Error()}
+			} else if upload.err != nil {
+				return 0, nil, badRequest("upload interrupted: %s", upload.err)
+			} else if err != nil {
+				return 0, nil, err
+			}
+			return http.StatusCreated, uploadResponse{FileName: file.FileName,
+				Metahash: hex.EncodeToString(file.MetaHash[:]), ChunkCount: file.ChunksCount, Size: file.Size}, nil
+		}
+	}
+	return 0, nil, badRequest("missing file part")
+}